@@ -67,6 +67,8 @@ func ChannelType2APIType(channelType int) (int, bool) {
 		apiType = constant.APITypeJimeng
 	case constant.ChannelTypeFlux:
 		apiType = constant.APITypeFlux
+	case constant.ChannelTypeTemplate:
+		apiType = constant.APITypeTemplate
 	}
 	if apiType == -1 {
 		return constant.APITypeOpenAI, false
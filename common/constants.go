@@ -70,6 +70,8 @@ var EmailLoginAuthServerList = []string{
 
 var DebugEnabled bool
 var MemoryCacheEnabled bool
+var MetricsEnabled bool
+var MetricsToken string
 
 var LogConsumeEnabled = true
 var LogErrorVisibleToUserEnabled = true
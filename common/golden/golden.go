@@ -0,0 +1,56 @@
+// Package golden 提供 golden 文件测试的公共方法，只应在测试中引用
+package golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 使用 go test <包路径> -update 重新生成对应包的 golden 文件
+var update = flag.Bool("update", false, "update golden files")
+
+// Dir 存放测试输入和 golden 文件的目录，相对于测试所在的包
+type Dir string
+
+// Read 读取测试输入文件
+func (d Dir) Read(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(string(d), name))
+	if err != nil {
+		t.Fatalf("read fixture %s: %v", name, err)
+	}
+	return data
+}
+
+// Assert 比较输出与 golden 文件，带 -update 参数运行时改为写入 golden 文件
+func (d Dir) Assert(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join(string(d), name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("write golden %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden %s: %v (run with -update to create it)", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+// MarshalJSON 把结果格式化为带缩进的 JSON，用于写入 golden 文件
+func MarshalJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return append(data, '\n')
+}
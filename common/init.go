@@ -73,6 +73,9 @@ func InitEnv() {
 	// Initialize variables from constants.go that were using environment variables
 	DebugEnabled = os.Getenv("DEBUG") == "true"
 	MemoryCacheEnabled = os.Getenv("MEMORY_CACHE_ENABLED") == "true"
+	// Prometheus 指标，设置 METRICS_TOKEN 后需要携带 Bearer Token 访问
+	MetricsEnabled = os.Getenv("METRICS_ENABLED") == "true"
+	MetricsToken = os.Getenv("METRICS_TOKEN")
 	IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
 
 	// Parse requestInterval and set RequestInterval
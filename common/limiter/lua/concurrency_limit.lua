@@ -0,0 +1,25 @@
+-- 并发请求数限流器，以有序集合记录进行中的请求
+-- KEYS: 各限流对象的唯一标识
+-- ARGV[1]: 请求唯一标识
+-- ARGV[2]: 请求记录的过期时间（秒），防止进程异常退出后无法释放
+-- ARGV[3...]: 各限流对象的限制
+
+local member = ARGV[1]
+local stale = tonumber(ARGV[2])
+
+-- 获取当前时间（Redis服务器时间）
+local now = tonumber(redis.call('TIME')[1])
+
+for i, key in ipairs(KEYS) do
+    redis.call('ZREMRANGEBYSCORE', key, '-inf', now - stale)
+    -- 返回第一个超出限制的对象序号
+    if redis.call('ZCARD', key) >= tonumber(ARGV[2 + i]) then
+        return i
+    end
+end
+
+for _, key in ipairs(KEYS) do
+    redis.call('ZADD', key, now, member)
+    redis.call('EXPIRE', key, stale)
+end
+return 0
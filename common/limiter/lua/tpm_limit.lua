@@ -0,0 +1,37 @@
+-- 滑动窗口 token 数限流器，按秒分桶累计 token 数
+-- KEYS: 各限流对象的唯一标识
+-- ARGV[1]: 本次消耗的 token 数
+-- ARGV[2]: 窗口长度（秒）
+-- ARGV[3]: 是否检查限制，1 为检查，0 为只累计
+-- ARGV[4...]: 各限流对象的限制
+
+local amount = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local check = ARGV[3] == '1'
+
+-- 获取当前时间（Redis服务器时间）
+local now = tonumber(redis.call('TIME')[1])
+
+if check then
+    for i, key in ipairs(KEYS) do
+        local used = 0
+        local buckets = redis.call('HGETALL', key)
+        for j = 1, #buckets, 2 do
+            if tonumber(buckets[j]) <= now - window then
+                redis.call('HDEL', key, buckets[j])
+            else
+                used = used + tonumber(buckets[j + 1])
+            end
+        end
+        -- 返回第一个超出限制的对象序号
+        if used + amount > tonumber(ARGV[3 + i]) then
+            return i
+        end
+    end
+end
+
+for _, key in ipairs(KEYS) do
+    redis.call('HINCRBY', key, now, amount)
+    redis.call('EXPIRE', key, window)
+end
+return 0
@@ -0,0 +1,61 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//go:embed lua/tpm_limit.lua
+var tpmLimitLua string
+
+//go:embed lua/concurrency_limit.lua
+var concurrencyLimitLua string
+
+var (
+	tpmLimitScript         = redis.NewScript(tpmLimitLua)
+	concurrencyLimitScript = redis.NewScript(concurrencyLimitLua)
+)
+
+// ConsumeTokens 在滑动窗口内累计 token 数。check 为 true 时先检查各 key 的限制，
+// 任一超出则不累计并返回其下标，否则返回 -1
+func ConsumeTokens(ctx context.Context, rdb *redis.Client, keys []string, limits []int, amount int, windowSeconds int64, check bool) (int, error) {
+	checkFlag := 0
+	if check {
+		checkFlag = 1
+	}
+	args := []interface{}{amount, windowSeconds, checkFlag}
+	for _, limit := range limits {
+		args = append(args, limit)
+	}
+	result, err := tpmLimitScript.Run(ctx, rdb, keys, args...).Int()
+	if err != nil {
+		return -1, fmt.Errorf("tpm limit failed: %w", err)
+	}
+	return result - 1, nil
+}
+
+// AcquireConcurrency 为请求占用各 key 的并发名额，任一超出限制则不占用并返回其下标，否则返回 -1
+func AcquireConcurrency(ctx context.Context, rdb *redis.Client, keys []string, limits []int, member string, staleSeconds int64) (int, error) {
+	args := []interface{}{member, staleSeconds}
+	for _, limit := range limits {
+		args = append(args, limit)
+	}
+	result, err := concurrencyLimitScript.Run(ctx, rdb, keys, args...).Int()
+	if err != nil {
+		return -1, fmt.Errorf("concurrency limit failed: %w", err)
+	}
+	return result - 1, nil
+}
+
+// ReleaseConcurrency 释放请求占用的并发名额
+func ReleaseConcurrency(ctx context.Context, rdb *redis.Client, keys []string, member string) error {
+	pipe := rdb.Pipeline()
+	for _, key := range keys {
+		pipe.ZRem(ctx, key, member)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
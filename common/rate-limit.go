@@ -41,6 +41,17 @@ func (l *InMemoryRateLimiter) clearExpiredItems() {
 	}
 }
 
+// Release 撤销最近一次通过的请求记录，用于占用的名额最终没有使用的情况
+func (l *InMemoryRateLimiter) Release(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok || len(*queue) == 0 {
+		return
+	}
+	*queue = (*queue)[:len(*queue)-1]
+}
+
 // Request parameter duration's unit is seconds
 func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration int64) bool {
 	l.mutex.Lock()
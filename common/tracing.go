@@ -0,0 +1,89 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "one-api"
+
+var TracingEnabled bool
+
+// TracingPropagateUpstream 是否将 traceparent 透传给上游渠道
+var TracingPropagateUpstream bool
+
+// InitTracing 初始化 OpenTelemetry，使用 OTLP/HTTP 导出，
+// 导出地址等配置通过标准环境变量 OTEL_EXPORTER_OTLP_ENDPOINT 等设置
+func InitTracing() (func(context.Context) error, error) {
+	TracingEnabled = os.Getenv("OTEL_ENABLED") == "true"
+	TracingPropagateUpstream = os.Getenv("OTEL_PROPAGATE_UPSTREAM") == "true"
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !TracingEnabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(GetEnvOrDefaultString("OTEL_SERVICE_NAME", "new-api")),
+		semconv.ServiceVersion(Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	SysLog("OpenTelemetry tracing enabled")
+	return provider.Shutdown, nil
+}
+
+// StartSpan 以当前请求的上下文为父级创建 span，调用方负责结束 span
+func StartSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := otel.Tracer(tracerName).Start(c.Request.Context(), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// StartRequestSpan 创建 span 并将其设置为当前请求的上下文，后续创建的 span 都作为其子级，
+// 返回的函数用于结束 span 并恢复原来的上下文
+func StartRequestSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) (trace.Span, func()) {
+	request := c.Request
+	ctx, span := otel.Tracer(tracerName).Start(request.Context(), name, trace.WithAttributes(attrs...))
+	c.Request = request.WithContext(ctx)
+	return span, func() {
+		span.End()
+		c.Request = c.Request.WithContext(request.Context())
+	}
+}
+
+// EndSpan 结束 span，err 不为空时标记为失败
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectTraceHeaders 开启透传时将当前 trace 上下文写入上游请求头
+func InjectTraceHeaders(ctx context.Context, header http.Header) {
+	if !TracingEnabled || !TracingPropagateUpstream {
+		return
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
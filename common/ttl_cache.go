@@ -0,0 +1,71 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// TTLCache 带过期时间和数量上限的内存缓存，未启用 Redis 时用于保存短期数据。
+// 写入新键且达到上限时先清理过期项，仍然超出上限时随机淘汰
+type TTLCache[V any] struct {
+	mu    sync.Mutex
+	items map[string]ttlCacheItem[V]
+}
+
+type ttlCacheItem[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+func NewTTLCache[V any]() *TTLCache[V] {
+	return &TTLCache[V]{items: make(map[string]ttlCacheItem[V])}
+}
+
+// Get 读取未过期的值，已过期的值在读取时删除
+func (c *TTLCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	if time.Now().After(item.expiresAt) {
+		delete(c.items, key)
+		var zero V
+		return zero, false
+	}
+	return item.value, true
+}
+
+// Set 写入值，maxEntries 不大于 0 时不限制数量，覆盖已有的键不会触发淘汰
+func (c *TTLCache[V]) Set(key string, value V, ttl time.Duration, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; !ok && maxEntries > 0 && len(c.items) >= maxEntries {
+		now := time.Now()
+		for k, item := range c.items {
+			if now.After(item.expiresAt) {
+				delete(c.items, k)
+			}
+		}
+		// 仍然超出上限时随机淘汰
+		for k := range c.items {
+			if len(c.items) < maxEntries {
+				break
+			}
+			delete(c.items, k)
+		}
+	}
+	c.items[key] = ttlCacheItem[V]{
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
+
+// Len 返回当前保存的数量，包括尚未清理的过期项
+func (c *TTLCache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
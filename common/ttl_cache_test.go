@@ -0,0 +1,44 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCacheExpire(t *testing.T) {
+	cache := NewTTLCache[string]()
+	cache.Set("a", "1", time.Hour, 0)
+	cache.Set("b", "2", -time.Second, 0)
+	if v, ok := cache.Get("a"); !ok || v != "1" {
+		t.Errorf("got %q %v, want 1 true", v, ok)
+	}
+	// 过期的值读取时删除
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expired value returned")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("len %d, want 1", cache.Len())
+	}
+}
+
+func TestTTLCacheMaxEntries(t *testing.T) {
+	cache := NewTTLCache[int]()
+	cache.Set("expired", 0, -time.Second, 3)
+	cache.Set("a", 1, time.Hour, 3)
+	cache.Set("b", 2, time.Hour, 3)
+	// 达到上限时优先清理过期项
+	cache.Set("c", 3, time.Hour, 3)
+	if _, ok := cache.Get("a"); !ok || cache.Len() != 3 {
+		t.Errorf("expired entry should be evicted first, len %d", cache.Len())
+	}
+	// 覆盖已有的键不触发淘汰
+	cache.Set("a", 10, time.Hour, 3)
+	if v, _ := cache.Get("a"); v != 10 || cache.Len() != 3 {
+		t.Errorf("overwrite evicted entries, len %d", cache.Len())
+	}
+	// 仍然超出上限时随机淘汰，数量不超过上限
+	cache.Set("d", 4, time.Hour, 3)
+	if _, ok := cache.Get("d"); !ok || cache.Len() != 3 {
+		t.Errorf("new entry missing or len %d exceeds limit", cache.Len())
+	}
+}
@@ -32,5 +32,6 @@ const (
 	APITypeCoze
 	APITypeJimeng
 	APITypeFlux
+	APITypeTemplate
 	APITypeDummy // this one is only for count, do not add any channel after this
 )
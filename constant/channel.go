@@ -51,6 +51,7 @@ const (
 	ChannelTypeJimeng         = 51
 	ChannelTypeVeo3           = 52
 	ChannelTypeFlux           = 53
+	ChannelTypeTemplate       = 54
 	ChannelTypeDummy          // this one is only for count, do not add any channel after this
 
 )
@@ -110,4 +111,5 @@ var ChannelBaseURLs = []string{
 	"https://visual.volcengineapi.com",          //51
 	"https://gptproto.com",                      //52
 	"https://api.bfl.ai",                        //53
+	"",                                          //54
 }
@@ -7,14 +7,19 @@ const (
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
 
 	/* token related keys */
-	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
-	ContextKeyTokenKey               ContextKey = "token_key"
-	ContextKeyTokenId                ContextKey = "token_id"
-	ContextKeyTokenGroup             ContextKey = "token_group"
-	ContextKeyTokenAllowIps          ContextKey = "allow_ips"
-	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
-	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
-	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
+	ContextKeyTokenUnlimited             ContextKey = "token_unlimited_quota"
+	ContextKeyTokenKey                   ContextKey = "token_key"
+	ContextKeyTokenId                    ContextKey = "token_id"
+	ContextKeyTokenGroup                 ContextKey = "token_group"
+	ContextKeyTokenAllowIps              ContextKey = "allow_ips"
+	ContextKeyTokenSpecificChannelId     ContextKey = "specific_channel_id"
+	ContextKeyTokenModelLimitEnabled     ContextKey = "token_model_limit_enabled"
+	ContextKeyTokenModelLimit            ContextKey = "token_model_limit"
+	ContextKeyTokenHedgeEnabled          ContextKey = "token_hedge_enabled"
+	ContextKeyTokenResponseCacheDisabled ContextKey = "token_response_cache_disabled"
+	ContextKeyTokenBudgetEnabled         ContextKey = "token_budget_enabled"
+	ContextKeyTokenOrganizationId        ContextKey = "token_organization_id"
+	ContextKeyTokenModelFallback         ContextKey = "token_model_fallback"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -32,6 +37,22 @@ const (
 	ContextKeyChannelMultiKeyIndex     ContextKey = "channel_multi_key_index"
 	ContextKeyChannelKey               ContextKey = "channel_key"
 
+	/* hedge related keys */
+	ContextKeyHedgeAttempt ContextKey = "hedge_attempt"
+
+	/* model fallback related keys */
+	ContextKeyModelFallbackFrom ContextKey = "model_fallback_from"
+
+	/* sticky routing related keys */
+	ContextKeyStickyRoutingHash    ContextKey = "sticky_routing_hash"
+	ContextKeyStickyRoutingBinding ContextKey = "sticky_routing_binding"
+	ContextKeyStickyRoutingHit     ContextKey = "sticky_routing_hit"
+	ContextKeyStickyRoutingPending ContextKey = "sticky_routing_pending"
+
+	/* traffic mirror related keys */
+	ContextKeyRelayUsage          ContextKey = "relay_usage"
+	ContextKeyTrafficMirrorShadow ContextKey = "traffic_mirror_shadow"
+
 	/* user related keys */
 	ContextKeyUserId      ContextKey = "id"
 	ContextKeyUserSetting ContextKey = "user_setting"
@@ -3,6 +3,7 @@ package constant
 type MultiKeyMode string
 
 const (
-	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
-	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	MultiKeyModeRandom           MultiKeyMode = "random"             // 随机
+	MultiKeyModePolling          MultiKeyMode = "polling"            // 轮询
+	MultiKeyModeLeastRateLimited MultiKeyMode = "least_rate_limited" // 最久未被上游限流的 Key 优先
 )
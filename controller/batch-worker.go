@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+)
+
+// RunBatchWorker 后台执行批处理。每个请求都以提交批处理的令牌交给 handler（即 HTTP 服务本身）处理，
+// 与普通请求一样经过鉴权、限流、渠道分发和重试，并按批处理倍率计费
+func RunBatchWorker(handler http.Handler) {
+	for {
+		setting := operation_setting.GetBatchSetting()
+		if setting.Enabled {
+			batches, err := model.GetUnfinishedBatches()
+			if err != nil {
+				common.SysError("failed to get unfinished batches: " + err.Error())
+			}
+			for _, batch := range batches {
+				processBatch(handler, batch)
+			}
+		}
+		interval := setting.PollIntervalSeconds
+		if interval <= 0 {
+			interval = 10
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+}
+
+func processBatch(handler http.Handler, batch *model.Batch) {
+	if batch.Status == model.BatchStatusCancelling {
+		finishBatch(batch, model.BatchStatusCancelled)
+		return
+	}
+	if batch.Status != model.BatchStatusFinalizing && common.GetTimestamp() > batch.ExpiresAt {
+		finishBatch(batch, model.BatchStatusExpired)
+		return
+	}
+	if batch.Status == model.BatchStatusFinalizing {
+		finishBatch(batch, model.BatchStatusCompleted)
+		return
+	}
+
+	lines, batchErrors := loadBatchLines(batch)
+	if batchErrors != nil {
+		failBatch(batch, batchErrors)
+		return
+	}
+	if batch.Status == model.BatchStatusValidating {
+		now := common.GetTimestamp()
+		err := batch.Update(map[string]interface{}{
+			"status":         model.BatchStatusInProgress,
+			"in_progress_at": now,
+			"total_count":    len(lines),
+		})
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to start batch %s: %s", batch.BatchId, err.Error()))
+			return
+		}
+		batch.Status = model.BatchStatusInProgress
+		batch.InProgressAt = now
+		batch.TotalCount = len(lines)
+	}
+
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		failBatch(batch, newBatchErrors("token_not_found", "the token used to create the batch no longer exists", nil))
+		return
+	}
+	if !runBatchLines(handler, batch, token, lines) {
+		// 批处理被取消或已过期，由下一轮处理
+		return
+	}
+	if err = batch.Update(map[string]interface{}{
+		"status":        model.BatchStatusFinalizing,
+		"finalizing_at": common.GetTimestamp(),
+	}); err != nil {
+		common.SysError(fmt.Sprintf("failed to finalize batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	finishBatch(batch, model.BatchStatusCompleted)
+}
+
+// runBatchLines 并发执行尚未执行的请求，全部执行完毕时返回 true
+func runBatchLines(handler http.Handler, batch *model.Batch, token *model.Token, lines []dto.BatchRequestInput) bool {
+	done, err := model.GetBatchResultLineIndexes(batch.BatchId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get results of batch %s: %s", batch.BatchId, err.Error()))
+		return false
+	}
+	setting := operation_setting.GetBatchSetting()
+	concurrency := setting.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				executeBatchLine(handler, batch, token, index, lines[index])
+			}
+		}()
+	}
+	finished := true
+	for index := range lines {
+		if done[index] {
+			continue
+		}
+		// 每个请求执行前检查批处理是否被取消、过期或功能被关闭
+		status, err := model.GetBatchStatus(batch.Id)
+		if err != nil || status != model.BatchStatusInProgress || common.GetTimestamp() > batch.ExpiresAt || !setting.Enabled {
+			finished = false
+			break
+		}
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	return finished
+}
+
+func executeBatchLine(handler http.Handler, batch *model.Batch, token *model.Token, index int, line dto.BatchRequestInput) {
+	output := dto.BatchRequestOutput{
+		Id:       "batch_req_" + common.GetUUID(),
+		CustomId: line.CustomId,
+	}
+	statusCode := 0
+	body, err := batchRequestBody(line.Body)
+	if err != nil {
+		output.Error = &dto.BatchResponseError{Code: "invalid_request", Message: err.Error()}
+	} else {
+		ctx := relaycommon.WithBatchId(context.Background(), batch.BatchId)
+		request, _ := http.NewRequestWithContext(ctx, http.MethodPost, line.Url, bytes.NewReader(body))
+		request.Header.Set("Authorization", "Bearer sk-"+token.Key)
+		request.Header.Set("Content-Type", "application/json")
+		// 使用提交批处理时的客户端 IP，以通过令牌的 IP 白名单
+		request.RemoteAddr = net.JoinHostPort(batch.ClientIp, "0")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		statusCode = recorder.Code
+		responseBody := recorder.Body.Bytes()
+		if !json.Valid(responseBody) {
+			responseBody, _ = common.Marshal(string(responseBody))
+		}
+		output.Response = &dto.BatchResponse{
+			StatusCode: statusCode,
+			RequestId:  recorder.Header().Get(common.RequestIdKey),
+			Body:       responseBody,
+		}
+	}
+	data, _ := common.Marshal(output)
+	result := &model.BatchResult{
+		BatchId:    batch.BatchId,
+		LineIndex:  index,
+		StatusCode: statusCode,
+		Output:     data,
+	}
+	if err = model.SaveBatchResult(batch, result, statusCode == http.StatusOK); err != nil {
+		common.SysError(fmt.Sprintf("failed to save result of batch %s line %d: %s", batch.BatchId, index+1, err.Error()))
+	}
+}
+
+// batchRequestBody 批处理不支持流式输出，移除请求中的 stream 参数
+func batchRequestBody(body json.RawMessage) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := common.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	delete(request, "stream")
+	delete(request, "stream_options")
+	return common.Marshal(request)
+}
+
+func newBatchErrors(code string, message string, line *int) *dto.BatchErrors {
+	return &dto.BatchErrors{
+		Object: "list",
+		Data: []dto.BatchError{{
+			Code:    code,
+			Message: message,
+			Line:    line,
+		}},
+	}
+}
+
+// loadBatchLines 读取并校验输入文件
+func loadBatchLines(batch *model.Batch) ([]dto.BatchRequestInput, *dto.BatchErrors) {
+	file, err := model.GetUserFileById(batch.UserId, batch.InputFileId)
+	if err != nil {
+		return nil, newBatchErrors("invalid_input_file", "input file not found", nil)
+	}
+	batchErrors := &dto.BatchErrors{Object: "list"}
+	addError := func(code string, message string, lineNumber int) {
+		// 只记录前 100 个错误
+		if len(batchErrors.Data) < 100 {
+			batchErrors.Data = append(batchErrors.Data, dto.BatchError{Code: code, Message: message, Line: common.GetPointer(lineNumber)})
+		}
+	}
+	customIds := make(map[string]bool)
+	lines := make([]dto.BatchRequestInput, 0)
+	for i, rawLine := range bytes.Split(file.Content, []byte("\n")) {
+		rawLine = bytes.TrimSpace(rawLine)
+		if len(rawLine) == 0 {
+			continue
+		}
+		lineNumber := i + 1
+		var line dto.BatchRequestInput
+		if err := common.Unmarshal(rawLine, &line); err != nil {
+			addError("invalid_json_line", "line is not valid json", lineNumber)
+			continue
+		}
+		if line.CustomId == "" || customIds[line.CustomId] {
+			addError("duplicate_custom_id", "custom_id is missing or duplicated", lineNumber)
+			continue
+		}
+		customIds[line.CustomId] = true
+		if line.Method != http.MethodPost {
+			addError("invalid_method", "method must be POST", lineNumber)
+			continue
+		}
+		if line.Url != batch.Endpoint {
+			addError("mismatched_endpoint", fmt.Sprintf("url must be %s", batch.Endpoint), lineNumber)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(batchErrors.Data) > 0 {
+		return nil, batchErrors
+	}
+	if len(lines) == 0 {
+		return nil, newBatchErrors("empty_file", "input file contains no requests", nil)
+	}
+	if maxRequests := operation_setting.GetBatchSetting().MaxRequestsPerBatch; maxRequests > 0 && len(lines) > maxRequests {
+		return nil, newBatchErrors("too_many_requests", fmt.Sprintf("batch contains more than %d requests", maxRequests), nil)
+	}
+	return lines, nil
+}
+
+func failBatch(batch *model.Batch, batchErrors *dto.BatchErrors) {
+	errorsJson, _ := common.Marshal(batchErrors)
+	err := batch.Update(map[string]interface{}{
+		"status":    model.BatchStatusFailed,
+		"failed_at": common.GetTimestamp(),
+		"errors":    string(errorsJson),
+	})
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+}
+
+// finishBatch 汇总已执行的结果生成输出文件和错误文件，并将批处理设置为最终状态
+func finishBatch(batch *model.Batch, status string) {
+	results, err := model.GetBatchResults(batch.BatchId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get results of batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	var output, errorOutput bytes.Buffer
+	for _, result := range results {
+		if result.StatusCode == http.StatusOK {
+			output.Write(result.Output)
+			output.WriteByte('\n')
+		} else {
+			errorOutput.Write(result.Output)
+			errorOutput.WriteByte('\n')
+		}
+	}
+	now := common.GetTimestamp()
+	fields := map[string]interface{}{"status": status}
+	switch status {
+	case model.BatchStatusCompleted:
+		fields["completed_at"] = now
+	case model.BatchStatusCancelled:
+		fields["cancelled_at"] = now
+	case model.BatchStatusExpired:
+		fields["expired_at"] = now
+	}
+	files := make([]*model.File, 0, 2)
+	if output.Len() > 0 {
+		file := newBatchOutputFile(batch, "output", output.Bytes(), now)
+		files = append(files, file)
+		fields["output_file_id"] = file.FileId
+	}
+	if errorOutput.Len() > 0 {
+		file := newBatchOutputFile(batch, "error", errorOutput.Bytes(), now)
+		files = append(files, file)
+		fields["error_file_id"] = file.FileId
+	}
+	if err = model.FinishBatch(batch, files, fields); err != nil {
+		common.SysError(fmt.Sprintf("failed to finish batch %s: %s", batch.BatchId, err.Error()))
+		return
+	}
+	common.SysLog(fmt.Sprintf("batch %s %s, %d results", batch.BatchId, status, len(results)))
+}
+
+func newBatchOutputFile(batch *model.Batch, kind string, content []byte, now int64) *model.File {
+	return &model.File{
+		FileId:    "file-" + common.GetUUID(),
+		UserId:    batch.UserId,
+		Filename:  fmt.Sprintf("%s_%s.jsonl", batch.BatchId, kind),
+		Purpose:   model.FilePurposeBatchOutput,
+		Bytes:     int64(len(content)),
+		Content:   content,
+		CreatedAt: now,
+	}
+}
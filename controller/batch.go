@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const batchCompletionWindow = "24h"
+
+// 批处理支持的接口
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/embeddings":       true,
+}
+
+func batchApiError(c *gin.Context, statusCode int, code string, message string) {
+	errorType := "invalid_request_error"
+	if statusCode >= http.StatusInternalServerError {
+		errorType = "new_api_error"
+	}
+	c.JSON(statusCode, gin.H{
+		"error": dto.OpenAIError{
+			Message: message,
+			Type:    errorType,
+			Code:    code,
+		},
+	})
+}
+
+func checkBatchEnabled(c *gin.Context) bool {
+	if !operation_setting.GetBatchSetting().Enabled {
+		batchApiError(c, http.StatusNotImplemented, "api_not_implemented", "batch api is not enabled")
+		return false
+	}
+	return true
+}
+
+func handleBatchModelError(c *gin.Context, err error, notFoundMessage string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		batchApiError(c, http.StatusNotFound, "not_found", notFoundMessage)
+		return
+	}
+	common.LogError(c, "batch api database error: "+err.Error())
+	batchApiError(c, http.StatusInternalServerError, "database_error", "database error")
+}
+
+func UploadFile(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose != model.FilePurposeBatch {
+		batchApiError(c, http.StatusBadRequest, "invalid_purpose", "only purpose 'batch' is supported")
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "missing_file", "file is required")
+		return
+	}
+	maxFileBytes := operation_setting.GetBatchSetting().MaxFileBytes
+	if maxFileBytes > 0 && fileHeader.Size > int64(maxFileBytes) {
+		batchApiError(c, http.StatusBadRequest, "file_too_large", fmt.Sprintf("file size exceeds the limit of %d bytes", maxFileBytes))
+		return
+	}
+	reader, err := fileHeader.Open()
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	file := &model.File{
+		FileId:    "file-" + common.GetUUID(),
+		UserId:    c.GetInt("id"),
+		Filename:  fileHeader.Filename,
+		Purpose:   purpose,
+		Bytes:     int64(len(content)),
+		Content:   content,
+		CreatedAt: common.GetTimestamp(),
+	}
+	if err = file.Insert(); err != nil {
+		handleBatchModelError(c, err, "")
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+func ListFiles(c *gin.Context) {
+	files, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"))
+	if err != nil {
+		handleBatchModelError(c, err, "")
+		return
+	}
+	data := make([]dto.OpenAIFile, 0, len(files))
+	for _, file := range files {
+		data = append(data, file.ToOpenAIFile())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func RetrieveFile(c *gin.Context) {
+	file, err := model.GetUserFileById(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		handleBatchModelError(c, err, "file not found")
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+func RetrieveFileContent(c *gin.Context) {
+	file, err := model.GetUserFileById(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		handleBatchModelError(c, err, "file not found")
+		return
+	}
+	c.Data(http.StatusOK, "application/octet-stream", file.Content)
+}
+
+func DeleteFile(c *gin.Context) {
+	fileId := c.Param("id")
+	if err := model.DeleteUserFileById(c.GetInt("id"), fileId); err != nil {
+		handleBatchModelError(c, err, "file not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      fileId,
+		"object":  "file",
+		"deleted": true,
+	})
+}
+
+func CreateBatch(c *gin.Context) {
+	if !checkBatchEnabled(c) {
+		return
+	}
+	var request dto.CreateBatchRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		batchApiError(c, http.StatusBadRequest, "invalid_endpoint", "endpoint must be one of /v1/chat/completions, /v1/embeddings")
+		return
+	}
+	if request.CompletionWindow != batchCompletionWindow {
+		batchApiError(c, http.StatusBadRequest, "invalid_completion_window", "completion_window must be 24h")
+		return
+	}
+	userId := c.GetInt("id")
+	inputFile, err := model.GetUserFileById(userId, request.InputFileId)
+	if err != nil {
+		handleBatchModelError(c, err, "input file not found")
+		return
+	}
+	if inputFile.Purpose != model.FilePurposeBatch {
+		batchApiError(c, http.StatusBadRequest, "invalid_input_file", "input file purpose must be batch")
+		return
+	}
+	metadata := ""
+	if len(request.Metadata) > 0 {
+		metadataJson, _ := common.Marshal(request.Metadata)
+		metadata = string(metadataJson)
+	}
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          "batch_" + common.GetUUID(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		ClientIp:         c.ClientIP(),
+		Endpoint:         request.Endpoint,
+		InputFileId:      request.InputFileId,
+		CompletionWindow: request.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		Metadata:         metadata,
+		CreatedAt:        now,
+		ExpiresAt:        now + 24*3600,
+	}
+	if err = batch.Insert(); err != nil {
+		handleBatchModelError(c, err, "")
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch, err := model.GetUserBatchById(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		handleBatchModelError(c, err, "batch not found")
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
+
+func ListBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	// 多取一条用于判断是否还有下一页
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		handleBatchModelError(c, err, "batch not found")
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]dto.OpenAIBatch, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, batch.ToOpenAIBatch())
+	}
+	var firstId, lastId *string
+	if len(data) > 0 {
+		firstId = &data[0].Id
+		lastId = &data[len(data)-1].Id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
+
+func CancelBatch(c *gin.Context) {
+	batch, err := model.GetUserBatchById(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		handleBatchModelError(c, err, "batch not found")
+		return
+	}
+	if err = model.CancelBatch(batch); err != nil {
+		handleBatchModelError(c, err, "batch not found")
+		return
+	}
+	if batch.Status != model.BatchStatusCancelling {
+		batchApiError(c, http.StatusConflict, "invalid_batch_status", fmt.Sprintf("cannot cancel a batch with status %s", batch.Status))
+		return
+	}
+	c.JSON(http.StatusOK, batch.ToOpenAIBatch())
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CircuitBreakerRequest struct {
+	ChannelId int    `json:"channel_id"`
+	ModelName string `json:"model_name"`
+	Action    string `json:"action"` // reset | trip
+}
+
+func GetCircuitBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetAllCircuitBreakers(),
+	})
+}
+
+func UpdateCircuitBreaker(c *gin.Context) {
+	req := CircuitBreakerRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.ChannelId == 0 || req.ModelName == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	switch req.Action {
+	case "reset":
+		model.ResetCircuitBreaker(req.ChannelId, req.ModelName)
+	case "trip":
+		model.TripCircuitBreaker(req.ChannelId, req.ModelName)
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持的操作: " + req.Action,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// probeHalfOpenCircuitBreakers 对半开状态的熔断器发起渠道测试请求，根据结果关闭或重新打开
+func probeHalfOpenCircuitBreakers() {
+	for _, breaker := range model.GetHalfOpenCircuitBreakersForProbe(30 * time.Second) {
+		channel, err := model.CacheGetChannel(breaker.ChannelId)
+		if err != nil {
+			continue
+		}
+		result := testChannel(channel, breaker.ModelName)
+		if result.localErr != nil {
+			continue
+		}
+		if result.newAPIError != nil {
+			if service.ShouldCountCircuitBreakerFailure(result.newAPIError) {
+				model.RecordCircuitBreakerFailure(channel.Id, breaker.ModelName, result.newAPIError.Error())
+			}
+			continue
+		}
+		common.SysLog(fmt.Sprintf("渠道 #%d 的模型 %s 半开探测成功", channel.Id, breaker.ModelName))
+		model.RecordCircuitBreakerSuccess(channel.Id, breaker.ModelName)
+	}
+}
+
+func AutomaticallyProbeCircuitBreakers() {
+	for {
+		time.Sleep(10 * time.Second)
+		probeHalfOpenCircuitBreakers()
+	}
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChannelKeyHealth 多 Key 渠道中单个 Key 的状态和统计，Key 只返回脱敏后的内容
+type ChannelKeyHealth struct {
+	Index  int    `json:"index"`
+	Key    string `json:"key"`
+	Status int    `json:"status"`
+	// 是否处于调度规则的休息时段
+	Resting bool `json:"resting"`
+	model.ChannelKeyStatsSnapshot
+}
+
+// maskChannelKey 只保留 Key 的首尾各 4 个字符
+func maskChannelKey(key string) string {
+	if len(key) <= 12 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
+
+func getMultiKeyChannel(c *gin.Context) (*model.Channel, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		common.ApiError(c, err)
+		return nil, false
+	}
+	if !channel.ChannelInfo.IsMultiKey {
+		common.ApiErrorMsg(c, "该渠道不是多 Key 渠道")
+		return nil, false
+	}
+	return channel, true
+}
+
+// GetChannelKeys 返回多 Key 渠道中每个 Key 的状态、统计和限流设置
+func GetChannelKeys(c *gin.Context) {
+	channel, ok := getMultiKeyChannel(c)
+	if !ok {
+		return
+	}
+	keys := make([]ChannelKeyHealth, 0, channel.ChannelInfo.MultiKeySize)
+	for i := 0; ; i++ {
+		key, ok := channel.GetKeyByIndex(i)
+		if !ok {
+			break
+		}
+		status := common.ChannelStatusEnabled
+		if s, ok := channel.ChannelInfo.MultiKeyStatusList[i]; ok {
+			status = s
+		}
+		keys = append(keys, ChannelKeyHealth{
+			Index:                   i,
+			Key:                     maskChannelKey(key),
+			Status:                  status,
+			Resting:                 model.IsChannelKeyResting(channel, i),
+			ChannelKeyStatsSnapshot: model.GetChannelKeyStats(channel.Id, key),
+		})
+	}
+	setting := channel.GetSetting()
+	common.ApiSuccess(c, gin.H{
+		"channel_id":     channel.Id,
+		"status":         channel.Status,
+		"multi_key_mode": channel.ChannelInfo.MultiKeyMode,
+		"key_rpm_limit":  setting.KeyRPMLimit,
+		"key_tpm_limit":  setting.KeyTPMLimit,
+		"key_schedules":  setting.KeySchedules,
+		"keys":           keys,
+	})
+}
+
+// RetestChannelKeys 立即重新测试渠道中被自动禁用的 Key
+func RetestChannelKeys(c *gin.Context) {
+	channel, ok := getMultiKeyChannel(c)
+	if !ok {
+		return
+	}
+	tested, enabled := retestChannelKeys(channel)
+	common.ApiSuccess(c, gin.H{
+		"tested":  tested,
+		"enabled": enabled,
+	})
+}
+
+// retestChannelKeys 测试被自动禁用的 Key，测试通过的重新启用，返回测试数和启用数
+func retestChannelKeys(channel *model.Channel) (int, int) {
+	tested, enabled := 0, 0
+	for index, status := range channel.ChannelInfo.MultiKeyStatusList {
+		if status != common.ChannelStatusAutoDisabled {
+			continue
+		}
+		tested++
+		result := testChannelWithKey(channel, "", index)
+		time.Sleep(common.RequestInterval)
+		if result.localErr != nil || result.newAPIError != nil {
+			continue
+		}
+		if err := model.EnableChannelKey(channel.Id, index); err != nil {
+			common.SysError(fmt.Sprintf("failed to enable key #%d of channel #%d: %s", index, channel.Id, err.Error()))
+			continue
+		}
+		enabled++
+		common.SysLog(fmt.Sprintf("渠道 #%d 的 Key #%d 测试通过，已重新启用", channel.Id, index))
+	}
+	return tested, enabled
+}
+
+func retestAllChannelKeys() {
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		common.SysError("failed to get channels for key retest: " + err.Error())
+		return
+	}
+	for _, channel := range channels {
+		if !channel.ChannelInfo.IsMultiKey || len(channel.ChannelInfo.MultiKeyStatusList) == 0 {
+			continue
+		}
+		// 手动禁用的渠道不测试
+		if channel.Status == common.ChannelStatusManuallyDisabled {
+			continue
+		}
+		retestChannelKeys(channel)
+	}
+}
+
+// AutomaticallyRetestChannelKeys 定时重新测试多 Key 渠道中被自动禁用的 Key
+func AutomaticallyRetestChannelKeys() {
+	for {
+		interval := operation_setting.GetChannelKeySetting().RetestIntervalMinutes
+		if interval <= 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(time.Duration(interval) * time.Minute)
+		if operation_setting.GetChannelKeySetting().RetestIntervalMinutes <= 0 {
+			continue
+		}
+		retestAllChannelKeys()
+	}
+}
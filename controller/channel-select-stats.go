@@ -0,0 +1,17 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelSelectStats 获取自适应渠道选择使用的实时统计
+func GetChannelSelectStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetAllChannelSelectStats(),
+	})
+}
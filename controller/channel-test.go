@@ -38,6 +38,11 @@ type testResult struct {
 }
 
 func testChannel(channel *model.Channel, testModel string) testResult {
+	return testChannelWithKey(channel, testModel, -1)
+}
+
+// testChannelWithKey 测试渠道，keyIndex 不小于 0 时使用多 Key 渠道中指定的 Key（包括已被禁用的 Key）
+func testChannelWithKey(channel *model.Channel, testModel string, keyIndex int) testResult {
 	tik := time.Now()
 	if channel.Type == constant.ChannelTypeMidjourney {
 		return testResult{
@@ -133,6 +138,18 @@ func testChannel(channel *model.Channel, testModel string) testResult {
 			newAPIError: newAPIError,
 		}
 	}
+	if keyIndex >= 0 {
+		key, ok := channel.GetKeyByIndex(keyIndex)
+		if !ok {
+			return testResult{
+				context:     c,
+				localErr:    fmt.Errorf("key #%d not found", keyIndex),
+				newAPIError: nil,
+			}
+		}
+		common.SetContextKey(c, constant.ContextKeyChannelKey, key)
+		common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, keyIndex)
+	}
 
 	info := relaycommon.GenRelayInfo(c)
 
@@ -425,7 +442,7 @@ func testAllChannels(notify bool) error {
 
 			// disable channel
 			if isChannelEnabled && shouldBanChannel && channel.GetAutoBan() {
-				go processChannelError(result.context, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(result.context, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+				go processChannelError(result.context, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(result.context, constant.ContextKeyChannelKey), channel.GetAutoBan()), "", newAPIError)
 			}
 
 			// enable channel
@@ -7,6 +7,7 @@ import (
 	"one-api/common"
 	"one-api/constant"
 	"one-api/model"
+	"one-api/relay/channel/template"
 	"strconv"
 	"strings"
 
@@ -356,6 +357,9 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 	if err := channel.ValidateSettings(); err != nil {
 		return fmt.Errorf("渠道额外设置[channel setting] 格式错误：%s", err.Error())
 	}
+	if err := model.ValidateChannelSchedules(channel.GetSetting()); err != nil {
+		return fmt.Errorf("渠道调度规则错误：%s", err.Error())
+	}
 
 	// 如果是添加操作，检查 channel 和 key 是否为空
 	if isAdd {
@@ -387,6 +391,13 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 		}
 	}
 
+	// 模板渠道校验模板和路径
+	if channel.Type == constant.ChannelTypeTemplate {
+		if err := template.ValidateSettings(channel.GetSetting().Template); err != nil {
+			return fmt.Errorf("模板渠道设置错误：%s", err.Error())
+		}
+	}
+
 	return nil
 }
 
@@ -914,3 +925,18 @@ func CopyChannel(c *gin.Context) {
 	// success
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": gin.H{"id": clone.Id}})
 }
+
+// PreviewTemplateChannel 用样例请求和上游响应预览模板渠道的转换结果
+func PreviewTemplateChannel(c *gin.Context) {
+	var req template.PreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	result, err := template.Preview(&req)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
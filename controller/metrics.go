@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsHandler = promhttp.HandlerFor(service.MetricsRegistry, promhttp.HandlerOpts{})
+
+// Metrics 输出 Prometheus 格式的监控指标，设置了 METRICS_TOKEN 时需要携带对应的 Bearer Token
+func Metrics(c *gin.Context) {
+	if common.MetricsToken != "" && c.GetHeader("Authorization") != "Bearer "+common.MetricsToken {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	metricsHandler.ServeHTTP(c.Writer, c.Request)
+}
@@ -163,7 +163,7 @@ func UpdateMidjourneyTaskBulk() {
 					common.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
 				} else {
 					if shouldReturnQuota {
-						err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
+						err = model.RefundTaskQuota(task.UserId, task.OrganizationId, task.Quota, task.MjId)
 						if err != nil {
 							common.LogError(ctx, "fail to increase user quota: "+err.Error())
 						}
@@ -120,6 +120,15 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "TieredPrice":
+		err = ratio_setting.CheckTieredPrice(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	case "ModelRequestRateLimitGroup":
 		err = setting.CheckModelRequestRateLimitGroup(option.Value)
 		if err != nil {
@@ -129,6 +138,15 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "TPMLimitGroup":
+		err = setting.CheckTPMLimitGroup(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	case "console_setting.api_info":
 		err = console_setting.ValidateConsoleSettings(option.Value, "ApiInfo")
 		if err != nil {
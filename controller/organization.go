@@ -0,0 +1,463 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 组织邀请的有效期
+const organizationInvitationValidSeconds = 7 * 24 * 3600
+
+// getOrganizationMembership 获取路径中的组织以及当前用户在组织中的成员信息
+func getOrganizationMembership(c *gin.Context, requireManager bool) (*model.Organization, *model.OrganizationMember, bool) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		common.ApiErrorMsg(c, "组织不存在")
+		return nil, nil, false
+	}
+	member, err := model.GetOrganizationMember(org.Id, c.GetInt("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "不是该组织的成员")
+		return nil, nil, false
+	}
+	if requireManager && !member.IsManager() {
+		common.ApiErrorMsg(c, "需要组织所有者或管理员权限")
+		return nil, nil, false
+	}
+	org.Role = member.Role
+	return org, member, true
+}
+
+// canManageOrganizationMember 所有者可以管理所有成员，管理员只能管理普通成员
+func canManageOrganizationMember(operator *model.OrganizationMember, target *model.OrganizationMember) bool {
+	if target.Role == model.OrganizationRoleOwner {
+		return false
+	}
+	if operator.Role == model.OrganizationRoleOwner {
+		return true
+	}
+	return operator.Role == model.OrganizationRoleAdmin && target.Role == model.OrganizationRoleMember
+}
+
+func GetSelfOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, orgs)
+}
+
+func GetOrganization(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c, false)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"organization": org,
+			"member":       member,
+		},
+	})
+}
+
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+func validateOrganizationName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && len(name) <= 64
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	name, ok := validateOrganizationName(req.Name)
+	if !ok {
+		common.ApiErrorMsg(c, "组织名称不能为空且不能超过 64 个字符")
+		return
+	}
+	org := &model.Organization{
+		Name:    name,
+		OwnerId: c.GetInt("id"),
+	}
+	if err := org.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	org.Role = model.OrganizationRoleOwner
+	common.ApiSuccess(c, org)
+}
+
+func UpdateOrganization(c *gin.Context) {
+	org, _, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	name, ok := validateOrganizationName(req.Name)
+	if !ok {
+		common.ApiErrorMsg(c, "组织名称不能为空且不能超过 64 个字符")
+		return
+	}
+	org.Name = name
+	if err := org.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func DeleteOrganization(c *gin.Context) {
+	org, member, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	if member.Role != model.OrganizationRoleOwner {
+		common.ApiErrorMsg(c, "只有组织所有者可以删除组织")
+		return
+	}
+	if err := org.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if org.Quota > 0 {
+		model.RecordLog(org.OwnerId, model.LogTypeManage, fmt.Sprintf("删除组织 %s，退还组织额度 %s", org.Name, common.LogQuota(org.Quota)))
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	org, _, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, members)
+}
+
+type OrganizationMemberRequest struct {
+	UserId         int    `json:"user_id"`
+	Role           string `json:"role"`
+	SpendCap       int    `json:"spend_cap"`
+	ResetUsedQuota bool   `json:"reset_used_quota"`
+}
+
+func UpdateOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	var req OrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.SpendCap < 0 {
+		common.ApiErrorMsg(c, "消费上限不能为负数")
+		return
+	}
+	target, err := model.GetOrganizationMember(org.Id, req.UserId)
+	if err != nil {
+		common.ApiErrorMsg(c, "成员不存在")
+		return
+	}
+	if target.Role == model.OrganizationRoleOwner {
+		// 所有者的角色不可修改，只能由所有者自己设置消费上限
+		if operator.Role != model.OrganizationRoleOwner {
+			common.ApiErrorMsg(c, "无权修改该成员")
+			return
+		}
+	} else {
+		if !canManageOrganizationMember(operator, target) {
+			common.ApiErrorMsg(c, "无权修改该成员")
+			return
+		}
+		if !model.IsValidOrganizationRole(req.Role) {
+			common.ApiErrorMsg(c, "无效的角色")
+			return
+		}
+		if req.Role == model.OrganizationRoleAdmin && operator.Role != model.OrganizationRoleOwner {
+			common.ApiErrorMsg(c, "只有组织所有者可以设置管理员")
+			return
+		}
+		target.Role = req.Role
+	}
+	target.SpendCap = req.SpendCap
+	if err = target.Update(req.ResetUsedQuota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, target)
+}
+
+// RemoveOrganizationMember 移除成员，成员也可以自己退出组织
+func RemoveOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrganizationMembership(c, false)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	target, err := model.GetOrganizationMember(org.Id, userId)
+	if err != nil {
+		common.ApiErrorMsg(c, "成员不存在")
+		return
+	}
+	if target.Role == model.OrganizationRoleOwner {
+		common.ApiErrorMsg(c, "组织所有者不能退出组织")
+		return
+	}
+	if target.UserId != operator.UserId && !canManageOrganizationMember(operator, target) {
+		common.ApiErrorMsg(c, "无权移除该成员")
+		return
+	}
+	if err = target.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+type OrganizationInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func InviteOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	var req OrganizationInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := common.Validate.Var(req.Email, "required,email"); err != nil {
+		common.ApiErrorMsg(c, "无效的邮箱地址")
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrganizationRoleMember
+	}
+	if !model.IsValidOrganizationRole(req.Role) {
+		common.ApiErrorMsg(c, "无效的角色")
+		return
+	}
+	if req.Role == model.OrganizationRoleAdmin && operator.Role != model.OrganizationRoleOwner {
+		common.ApiErrorMsg(c, "只有组织所有者可以邀请管理员")
+		return
+	}
+	now := common.GetTimestamp()
+	invitation := &model.OrganizationInvitation{
+		OrganizationId: org.Id,
+		Email:          req.Email,
+		Role:           req.Role,
+		Code:           common.GetRandomString(32),
+		InviterId:      operator.UserId,
+		Status:         model.OrganizationInvitationStatusPending,
+		CreatedTime:    now,
+		ExpiredTime:    now + organizationInvitationValidSeconds,
+	}
+	if err := invitation.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	link := fmt.Sprintf("%s/organization/join?code=%s", setting.ServerAddress, invitation.Code)
+	subject := fmt.Sprintf("%s组织邀请", common.SystemName)
+	content := fmt.Sprintf("<p>您好，您被邀请加入%s上的组织「%s」。</p>"+
+		"<p>请使用该邮箱对应的账户登录后，点击 <a href='%s'>此处</a> 接受邀请。</p>"+
+		"<p>如果链接无法点击，请尝试点击下面的链接或将其复制到浏览器中打开：<br> %s </p>"+
+		"<p>邀请 7 天内有效，如果不认识邀请人，请忽略。</p>", common.SystemName, org.Name, link, link)
+	if err := common.SendEmail(subject, req.Email, content); err != nil {
+		_ = model.RevokeOrganizationInvitation(org.Id, invitation.Id)
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, invitation)
+}
+
+func GetOrganizationInvitations(c *gin.Context) {
+	org, _, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	invitations, err := model.GetOrganizationInvitations(org.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, invitations)
+}
+
+func RevokeOrganizationInvitation(c *gin.Context) {
+	org, _, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	invitationId, _ := strconv.Atoi(c.Param("invitation_id"))
+	if err := model.RevokeOrganizationInvitation(org.Id, invitationId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+type JoinOrganizationRequest struct {
+	Code string `json:"code"`
+}
+
+func JoinOrganization(c *gin.Context) {
+	var req JoinOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	userId := c.GetInt("id")
+	email, err := model.GetUserEmail(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	member, err := model.AcceptOrganizationInvitation(req.Code, userId, email)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, member)
+}
+
+type OrganizationTransferRequest struct {
+	UserId    int    `json:"user_id"`
+	Quota     int    `json:"quota"`
+	Direction string `json:"direction"` // to_member：组织转给成员；to_organization：成员转入组织
+}
+
+// TransferOrganizationQuota 在组织钱包和成员个人额度之间转移额度。
+// 所有者和管理员可以双向转移，普通成员只能把自己的额度转入组织
+func TransferOrganizationQuota(c *gin.Context) {
+	org, operator, ok := getOrganizationMembership(c, false)
+	if !ok {
+		return
+	}
+	var req OrganizationTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Direction != "to_member" && req.Direction != "to_organization" {
+		common.ApiErrorMsg(c, "无效的转移方向")
+		return
+	}
+	toMember := req.Direction == "to_member"
+	if !operator.IsManager() && (toMember || req.UserId != operator.UserId) {
+		common.ApiErrorMsg(c, "需要组织所有者或管理员权限")
+		return
+	}
+	if _, err := model.GetOrganizationMember(org.Id, req.UserId); err != nil {
+		common.ApiErrorMsg(c, "成员不存在")
+		return
+	}
+	if err := model.TransferOrganizationQuota(org.Id, req.UserId, req.Quota, toMember); err != nil {
+		common.ApiErrorMsg(c, "划转失败 "+err.Error())
+		return
+	}
+	if toMember {
+		model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("组织 %s 向你转入额度 %s", org.Name, common.LogQuota(req.Quota)))
+	} else {
+		model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("向组织 %s 转出额度 %s", org.Name, common.LogQuota(req.Quota)))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "划转成功",
+	})
+}
+
+func GetOrganizationUsage(c *gin.Context) {
+	org, _, ok := getOrganizationMembership(c, true)
+	if !ok {
+		return
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	usages, err := model.GetOrganizationUsage(org.Id, startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, usages)
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	orgs, total, err := model.GetAllOrganizations(pageInfo, c.Query("keyword"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(orgs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+type ManageOrganizationRequest struct {
+	Status *int `json:"status"`
+	Quota  int  `json:"quota"` // 调整组织钱包额度，负数为扣除
+}
+
+// ManageOrganization 管理员启用、禁用组织或调整组织钱包额度
+func ManageOrganization(c *gin.Context) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		common.ApiErrorMsg(c, "组织不存在")
+		return
+	}
+	var req ManageOrganizationRequest
+	if err = c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Status != nil {
+		if *req.Status != model.OrganizationStatusEnabled && *req.Status != model.OrganizationStatusDisabled {
+			common.ApiErrorMsg(c, "无效的状态")
+			return
+		}
+		org.Status = *req.Status
+		if err = org.Update(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if req.Quota != 0 {
+		if err = model.AdjustOrganizationQuota(org.Id, req.Quota); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		org.Quota += req.Quota
+		model.RecordLog(org.OwnerId, model.LogTypeManage, fmt.Sprintf("管理员调整组织 %s 的额度 %s", org.Name, common.LogQuota(req.Quota)))
+	}
+	common.ApiSuccess(c, org)
+}
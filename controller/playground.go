@@ -8,6 +8,7 @@ import (
 	"one-api/dto"
 	"one-api/middleware"
 	"one-api/model"
+	"one-api/relay"
 	"one-api/setting"
 	"one-api/types"
 	"time"
@@ -73,6 +74,9 @@ func Playground(c *gin.Context) {
 		Group:  group,
 	}
 	_ = middleware.SetupContextForToken(c, tempToken)
+	if relay.ServeResponseCache(c) {
+		return
+	}
 	_, newAPIError = getChannel(c, group, playgroundRequest.Model, 0)
 	if newAPIError != nil {
 		return
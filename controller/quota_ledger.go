@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ledgerReconcileRunning atomic.Bool
+	lastReconcileReport    *model.LedgerReconcileReport
+	lastReconcileLock      sync.RWMutex
+)
+
+func GetQuotaLedgers(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	accountId, _ := strconv.Atoi(c.Query("account_id"))
+	ledgers, total, err := model.GetQuotaLedgers(c.Query("account_type"), accountId, c.Query("type"), c.Query("reference"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(ledgers)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetSelfQuotaLedgers(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	ledgers, total, err := model.GetQuotaLedgers(model.LedgerAccountUser, c.GetInt("id"), c.Query("type"), c.Query("reference"), pageInfo)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(ledgers)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetLedgerReconcileReport 获取本节点最近一次对账的结果
+func GetLedgerReconcileReport(c *gin.Context) {
+	lastReconcileLock.RLock()
+	report := lastReconcileReport
+	lastReconcileLock.RUnlock()
+	common.ApiSuccess(c, report)
+}
+
+// ReconcileQuotaLedger 立即执行一次对账
+func ReconcileQuotaLedger(c *gin.Context) {
+	report, err := reconcileQuotaLedger()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, report)
+}
+
+func reconcileQuotaLedger() (*model.LedgerReconcileReport, error) {
+	if !ledgerReconcileRunning.CompareAndSwap(false, true) {
+		return nil, errors.New("对账正在进行中，请稍后再试")
+	}
+	defer ledgerReconcileRunning.Store(false)
+
+	report, err := model.ReconcileQuotaLedger()
+	if err != nil {
+		return nil, err
+	}
+	lastReconcileLock.Lock()
+	lastReconcileReport = report
+	lastReconcileLock.Unlock()
+
+	if len(report.Drifts) > 0 {
+		common.SysError(fmt.Sprintf("quota ledger reconciliation found %d drifts", len(report.Drifts)))
+		for _, drift := range report.Drifts {
+			common.SysError(fmt.Sprintf("quota ledger drift: %s #%d balance=%d ledger_balance=%d chain_gap=%d",
+				drift.AccountType, drift.AccountId, drift.Balance, drift.LedgerBalance, drift.ChainGap))
+		}
+	} else {
+		common.SysLog(fmt.Sprintf("quota ledger reconciliation finished, checked %v accounts", report.Checked))
+	}
+	return report, nil
+}
+
+// AutomaticallyReconcileQuotaLedger 定时核对余额与账本
+func AutomaticallyReconcileQuotaLedger() {
+	for {
+		interval := operation_setting.GetQuotaLedgerSetting().ReconcileIntervalMinutes
+		if interval <= 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(time.Duration(interval) * time.Minute)
+		if operation_setting.GetQuotaLedgerSetting().ReconcileIntervalMinutes <= 0 {
+			continue
+		}
+		if _, err := reconcileQuotaLedger(); err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+		}
+	}
+}
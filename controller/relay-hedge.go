@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type hedgeResult struct {
+	attempt *helper.HedgeAttempt
+	err     *types.NewAPIError
+}
+
+func shouldHedge(c *gin.Context) bool {
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	return operation_setting.GetHedgeSetting().IsHedgeEnabledFor(c.GetString("group"), common.GetContextKeyBool(c, constant.ContextKeyTokenHedgeEnabled))
+}
+
+// selectHedgeChannel 在主请求使用的分组中选择一个与主渠道不同的渠道用于对冲请求，
+// 优先使用粘性路由绑定的渠道。选择结果只写入对冲请求的上下文 hc
+func selectHedgeChannel(hc *gin.Context, primary *model.Channel) *model.Channel {
+	usingGroup := common.GetContextKeyString(hc, constant.ContextKeyUsingGroup)
+	originalModel := hc.GetString("original_model")
+	if channel, _, ok := service.GetStickyChannel(hc, usingGroup, originalModel); ok && channel.Id != primary.Id {
+		return channel
+	}
+	// auto 分组使用主请求实际选中的分组
+	group := usingGroup
+	if autoGroup := hc.GetString("auto_group"); autoGroup != "" {
+		group = autoGroup
+	}
+	for i := 0; i < 3; i++ {
+		channel, _, err := model.CacheGetRandomSatisfiedChannel(hc, group, originalModel, 0)
+		if err != nil || channel == nil {
+			return nil
+		}
+		if channel.Id != primary.Id {
+			return channel
+		}
+	}
+	return nil
+}
+
+// hedgeRequest 先向主渠道发起请求，超过对冲延迟仍未输出首个 token 时向第二个渠道发起相同请求，
+// 先输出的请求胜出并返回给客户端，另一个请求被取消且不计费。
+// 返回值只包含主渠道的错误，对冲渠道的错误在此处理
+func hedgeRequest(c *gin.Context, channel *model.Channel, handler func(*gin.Context) *types.NewAPIError) *types.NewAPIError {
+	originalModel := c.GetString("original_model")
+	requestBody, _ := common.GetRequestBody(c)
+	race := helper.NewHedgeRace(c.Writer)
+	results := make(chan hedgeResult, 2)
+	run := func(hc *gin.Context, attempt *helper.HedgeAttempt, done func()) {
+		hc.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		go func() {
+			defer done()
+			defer attempt.Cancel()
+			err := handler(hc)
+			// 竞速失败被取消的请求产生的错误不计入渠道错误
+			if winner := race.Winner(); winner != nil && winner != attempt {
+				err = nil
+			}
+			results <- hedgeResult{attempt: attempt, err: err}
+		}()
+	}
+
+	// TPM 累计状态需在复制上下文之前创建，主请求和对冲请求共享，避免重复累计
+	service.InitTPMReservation(c)
+	primaryCtx, primary := race.NewAttempt(c, channel.Id, false)
+	run(primaryCtx, primary, func() {})
+	pending := 1
+
+	var hedge *helper.HedgeAttempt
+	var hedgeChannel *model.Channel
+	var hedgeCtx *gin.Context
+	var primaryErr, hedgeErr *types.NewAPIError
+
+	delayMs := operation_setting.GetHedgeSetting().DelayMs
+	timer := time.NewTimer(time.Duration(delayMs) * time.Millisecond)
+	defer timer.Stop()
+	timerChan := timer.C
+	decided := race.Decided()
+
+	for pending > 0 {
+		select {
+		case <-decided:
+			timerChan = nil
+			decided = nil
+		case <-timerChan:
+			timerChan = nil
+			// 先创建对冲请求的上下文再选择渠道，选择过程不修改主请求的上下文
+			hedgeCtx, hedge = race.NewAttempt(c, 0, true)
+			hedgeChannel = selectHedgeChannel(hedgeCtx, channel)
+			if hedgeChannel == nil {
+				hedge.Discard()
+				hedge = nil
+				continue
+			}
+			hedge.SetChannelId(hedgeChannel.Id)
+			if newAPIError := middleware.SetupContextForSelectedChannel(hedgeCtx, hedgeChannel, originalModel); newAPIError != nil {
+				hedge.Discard()
+				hedge = nil
+				continue
+			}
+			addUsedChannel(c, hedgeChannel.Id)
+			addUsedChannel(hedgeCtx, hedgeChannel.Id)
+			model.AcquireCircuitBreakerProbe(hedgeChannel.Id, originalModel)
+			common.LogInfo(c, fmt.Sprintf("渠道 #%d 超过 %dms 未输出首个 token，发起对冲请求至渠道 #%d", channel.Id, delayMs, hedgeChannel.Id))
+			run(hedgeCtx, hedge, model.TrackChannelOutstanding(hedgeChannel.Id, originalModel))
+			pending++
+		case result := <-results:
+			pending--
+			if result.attempt == primary {
+				primaryErr = result.err
+				// 主渠道在对冲前就已结束，无需再发起对冲请求
+				timerChan = nil
+			} else {
+				hedgeErr = result.err
+			}
+		}
+	}
+
+	// 用量和选中的渠道记录在胜出请求的上下文中，复制回原始上下文供流量镜像对比和粘性路由绑定
+	winnerCtx := primaryCtx
+	if winner := race.Winner(); hedge != nil && winner == hedge {
+		winnerCtx = hedgeCtx
+	}
+	for _, key := range []constant.ContextKey{constant.ContextKeyRelayUsage, constant.ContextKeyStickyRoutingPending} {
+		if value, ok := common.GetContextKey(winnerCtx, key); ok {
+			common.SetContextKey(c, key, value)
+		}
+	}
+
+	if hedgeErr != nil {
+		go processChannelError(c, *types.NewChannelError(hedgeChannel.Id, hedgeChannel.Type, hedgeChannel.Name, hedgeChannel.ChannelInfo.IsMultiKey, common.GetContextKeyString(hedgeCtx, constant.ContextKeyChannelKey), hedgeChannel.GetAutoBan()), originalModel, hedgeErr)
+	}
+	if winner := race.Winner(); hedge != nil && winner == hedge {
+		// 对冲渠道胜出，主渠道在此之前的错误在此处理，被取消的主渠道归还熔断器探测名额
+		if primaryErr != nil {
+			go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), originalModel, primaryErr)
+		} else {
+			model.ReleaseCircuitBreakerProbe(channel.Id, originalModel)
+		}
+		return nil
+	}
+	if hedge != nil && hedgeErr == nil {
+		// 竞速失败被取消的对冲渠道没有结果，归还熔断器探测名额
+		model.ReleaseCircuitBreakerProbe(hedgeChannel.Id, originalModel)
+	}
+	return primaryErr
+}
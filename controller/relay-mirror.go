@@ -0,0 +1,378 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/relay"
+	relayconstant "one-api/relay/constant"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+var trafficMirrorInflight int64
+
+type trafficMirrorWindow struct {
+	start time.Time
+	count int
+}
+
+var (
+	trafficMirrorWindows     = make(map[int]*trafficMirrorWindow)
+	trafficMirrorWindowsLock sync.Mutex
+)
+
+type shadowResult struct {
+	status    int
+	latencyMs int64
+	usage     *dto.Usage
+	output    string
+	err       error
+}
+
+// trafficMirror 一次请求的流量镜像：影子请求与主请求并发执行，两者都结束后写入对比记录
+type trafficMirror struct {
+	c             *gin.Context
+	channel       *model.Channel
+	shadowChannel *model.Channel
+	modelName     string
+	isStream      bool
+	start         time.Time
+	capture       *mirrorCaptureWriter
+	shadowDone    chan shadowResult
+}
+
+// mirrorCaptureWriter 捕获主请求写给客户端的内容，超过上限的部分丢弃
+type mirrorCaptureWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+func (w *mirrorCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *mirrorCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *mirrorCaptureWriter) capture(data []byte) {
+	if w.body.Len()+len(data) <= w.limit {
+		w.body.Write(data)
+	}
+}
+
+// acquireTrafficMirror 占用影子请求的并发和每分钟名额，返回的函数用于释放并发名额
+func acquireTrafficMirror(target operation_setting.TrafficMirrorTarget) (func(), bool) {
+	maxConcurrent := int64(operation_setting.GetTrafficMirrorSetting().MaxConcurrent)
+	if atomic.AddInt64(&trafficMirrorInflight, 1) > maxConcurrent && maxConcurrent > 0 {
+		atomic.AddInt64(&trafficMirrorInflight, -1)
+		return nil, false
+	}
+	release := func() {
+		atomic.AddInt64(&trafficMirrorInflight, -1)
+	}
+	if target.MaxRPM > 0 {
+		trafficMirrorWindowsLock.Lock()
+		defer trafficMirrorWindowsLock.Unlock()
+		window, ok := trafficMirrorWindows[target.ChannelId]
+		now := time.Now()
+		if !ok || now.Sub(window.start) >= time.Minute {
+			window = &trafficMirrorWindow{start: now}
+			trafficMirrorWindows[target.ChannelId] = window
+		}
+		if window.count >= target.MaxRPM {
+			release()
+			return nil, false
+		}
+		window.count++
+	}
+	return release, true
+}
+
+// selectTrafficMirrorTarget 按配置顺序选出本次请求要镜像到的影子渠道，每个请求最多镜像一次
+func selectTrafficMirrorTarget(primary *model.Channel, modelName string) (*model.Channel, func()) {
+	for _, target := range operation_setting.GetTrafficMirrorSetting().Targets {
+		if target.ChannelId == primary.Id || target.Percent <= 0 {
+			continue
+		}
+		if len(target.Models) > 0 && !common.StringsContains(target.Models, modelName) {
+			continue
+		}
+		if rand.Float64()*100 >= target.Percent {
+			continue
+		}
+		release, ok := acquireTrafficMirror(target)
+		if !ok {
+			continue
+		}
+		channel, err := model.CacheGetChannel(target.ChannelId)
+		if err != nil || (len(target.Models) == 0 && !common.StringsContains(channel.GetModels(), modelName)) {
+			release()
+			continue
+		}
+		return channel, release
+	}
+	return nil, nil
+}
+
+// startTrafficMirror 按比例把对话请求镜像到影子渠道，只在首次尝试时镜像。未镜像时返回 nil
+func startTrafficMirror(c *gin.Context, relayMode int, channel *model.Channel) *trafficMirror {
+	setting := operation_setting.GetTrafficMirrorSetting()
+	if !setting.Enabled || relayMode != relayconstant.RelayModeChatCompletions {
+		return nil
+	}
+	if len(c.GetStringSlice("use_channel")) > 1 {
+		return nil
+	}
+	modelName := c.GetString("original_model")
+	shadowChannel, release := selectTrafficMirrorTarget(channel, modelName)
+	if shadowChannel == nil {
+		return nil
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		release()
+		return nil
+	}
+	var request dto.GeneralOpenAIRequest
+	if err = common.Unmarshal(requestBody, &request); err != nil {
+		release()
+		return nil
+	}
+
+	timeout := time.Duration(setting.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// 影子请求使用独立的上下文，不受客户端断开影响，响应写入丢弃的记录器
+	recorder := httptest.NewRecorder()
+	sc, _ := gin.CreateTestContext(recorder)
+	sc.Keys = c.Copy().Keys
+	sc.Request = c.Request.Clone(ctx)
+	// 影子请求不参与粘性路由绑定
+	common.SetContextKey(sc, constant.ContextKeyStickyRoutingHash, "")
+
+	mirror := &trafficMirror{
+		c:             c,
+		channel:       channel,
+		shadowChannel: shadowChannel,
+		modelName:     modelName,
+		isStream:      request.Stream,
+		start:         time.Now(),
+		shadowDone:    make(chan shadowResult, 1),
+	}
+	if setting.RecordOutput {
+		mirror.capture = &mirrorCaptureWriter{ResponseWriter: c.Writer, limit: 1 << 20}
+		c.Writer = mirror.capture
+	}
+	gopool.Go(func() {
+		defer cancel()
+		defer release()
+		start := time.Now()
+		result := runShadowRequest(sc, shadowChannel, modelName, requestBody)
+		if result.err == nil {
+			result.output = extractMirrorOutput(recorder.Body.Bytes())
+		}
+		result.latencyMs = time.Since(start).Milliseconds()
+		mirror.shadowDone <- result
+	})
+	return mirror
+}
+
+// runShadowRequest 通过与主请求相同的转发流程向影子渠道发送请求，
+// 上下文中标记为影子请求后不预扣费、不结算也不写入响应缓存，因此不会向用户计费
+func runShadowRequest(sc *gin.Context, channel *model.Channel, modelName string, requestBody []byte) shadowResult {
+	sc.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	if newAPIError := middleware.SetupContextForSelectedChannel(sc, channel, modelName); newAPIError != nil {
+		return shadowResult{err: newAPIError}
+	}
+	common.SetContextKey(sc, constant.ContextKeyTrafficMirrorShadow, true)
+	if newAPIError := relay.TextHelper(sc); newAPIError != nil {
+		return shadowResult{status: newAPIError.StatusCode, err: newAPIError}
+	}
+	result := shadowResult{status: http.StatusOK}
+	if usage, ok := common.GetContextKeyType[*dto.Usage](sc, constant.ContextKeyRelayUsage); ok {
+		result.usage = usage
+	}
+	return result
+}
+
+// complete 记录主请求的结果，等待影子请求结束后写入对比记录，不阻塞主请求返回
+func (m *trafficMirror) complete(newAPIError *types.NewAPIError) {
+	if m == nil {
+		return
+	}
+	c := m.c
+	primaryStatus := http.StatusOK
+	if newAPIError != nil {
+		primaryStatus = newAPIError.StatusCode
+	}
+	log := &model.TrafficMirrorLog{
+		RequestId:        c.GetString(common.RequestIdKey),
+		UserId:           c.GetInt("id"),
+		ModelName:        m.modelName,
+		IsStream:         m.isStream,
+		PrimaryChannelId: m.channel.Id,
+		PrimaryStatus:    primaryStatus,
+		PrimaryLatencyMs: time.Since(m.start).Milliseconds(),
+		ShadowChannelId:  m.shadowChannel.Id,
+	}
+	if usage, ok := common.GetContextKeyType[*dto.Usage](c, constant.ContextKeyRelayUsage); ok && usage != nil {
+		log.PrimaryPromptTokens = usage.PromptTokens
+		log.PrimaryCompletionTokens = usage.CompletionTokens
+	}
+	var primaryOutput []byte
+	if m.capture != nil {
+		c.Writer = m.capture.ResponseWriter
+		primaryOutput = m.capture.body.Bytes()
+	}
+	recordOutput := m.capture != nil
+	maxOutputChars := operation_setting.GetTrafficMirrorSetting().MaxOutputChars
+	gopool.Go(func() {
+		result := <-m.shadowDone
+		log.ShadowStatus = result.status
+		log.ShadowLatencyMs = result.latencyMs
+		if result.err != nil {
+			if log.ShadowStatus == 0 {
+				log.ShadowStatus = http.StatusInternalServerError
+			}
+			log.ShadowError = result.err.Error()
+			if log.ShadowError == "" {
+				log.ShadowError = http.StatusText(log.ShadowStatus)
+			}
+		}
+		if result.usage != nil {
+			log.ShadowPromptTokens = result.usage.PromptTokens
+			log.ShadowCompletionTokens = result.usage.CompletionTokens
+		}
+		if recordOutput && log.PrimaryStatus == http.StatusOK && result.err == nil {
+			primaryText := extractMirrorOutput(primaryOutput)
+			log.OutputSimilarity = outputSimilarity(primaryText, result.output)
+			log.PrimaryOutput = truncateRunes(primaryText, maxOutputChars)
+			log.ShadowOutput = truncateRunes(result.output, maxOutputChars)
+		}
+		model.RecordTrafficMirrorLog(log)
+	})
+}
+
+// extractMirrorOutput 从非流式响应的 choices[0].message.content 或流式响应的增量内容中提取输出文本
+func extractMirrorOutput(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return ""
+	}
+	if trimmed[0] == '{' {
+		var response dto.OpenAITextResponse
+		if err := common.Unmarshal(trimmed, &response); err != nil || len(response.Choices) == 0 {
+			return ""
+		}
+		return response.Choices[0].Message.StringContent()
+	}
+	var builder strings.Builder
+	for _, line := range strings.Split(string(trimmed), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		if err := common.Unmarshal([]byte(data), &chunk); err != nil || len(chunk.Choices) == 0 {
+			continue
+		}
+		builder.WriteString(chunk.Choices[0].Delta.GetContentString())
+	}
+	return builder.String()
+}
+
+// outputSimilarity 用字符二元组的 Jaccard 系数粗略衡量两段输出的相似度
+func outputSimilarity(a string, b string) float64 {
+	if a == b {
+		return 1
+	}
+	setA := runeBigrams(a)
+	setB := runeBigrams(b)
+	if len(setA) == 0 || len(setB) == 0 {
+		return 0
+	}
+	intersection := 0
+	for gram := range setA {
+		if _, ok := setB[gram]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(setA)+len(setB)-intersection)
+}
+
+func runeBigrams(s string) map[string]struct{} {
+	runes := []rune(s)
+	grams := make(map[string]struct{})
+	if len(runes) == 1 {
+		grams[s] = struct{}{}
+	}
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])] = struct{}{}
+	}
+	return grams
+}
+
+func truncateRunes(s string, max int) string {
+	if max <= 0 {
+		return s
+	}
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
+
+func GetTrafficMirrorLogs(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	shadowChannelId, _ := strconv.Atoi(c.Query("shadow_channel_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	logs, total, err := model.GetTrafficMirrorLogs(shadowChannelId, c.Query("model_name"), startTimestamp, endTimestamp, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetTrafficMirrorSummary(c *gin.Context) {
+	shadowChannelId, _ := strconv.Atoi(c.Query("shadow_channel_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	summary, err := model.GetTrafficMirrorSummary(shadowChannelId, c.Query("model_name"), startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, summary)
+}
@@ -22,6 +22,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 func relayHandler(c *gin.Context, relayMode int) *types.NewAPIError {
@@ -47,7 +49,8 @@ func relayHandler(c *gin.Context, relayMode int) *types.NewAPIError {
 		err = relay.TextHelper(c)
 	}
 
-	if constant2.ErrorLogEnabled && err != nil {
+	// 竞速失败被取消的对冲请求不记录错误日志
+	if constant2.ErrorLogEnabled && err != nil && !helper.IsHedgeLoser(c) {
 		// 保存错误日志到mysql中
 		userId := c.GetInt("id")
 		tokenName := c.GetString("token_name")
@@ -74,28 +77,12 @@ func Relay(c *gin.Context) {
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
-	var newAPIError *types.NewAPIError
 
-	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
-		if err != nil {
-			common.LogError(c, err.Error())
-			newAPIError = err
-			break
-		}
-
-		newAPIError = relayRequest(c, relayMode, channel)
-
-		if newAPIError == nil {
-			return // 成功处理请求，直接返回
-		}
-
-		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
-
-		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
-			break
-		}
-	}
+	// 只有对话和补全请求可以降级到其他模型，请求由各渠道适配器转换
+	allowFallback := relayMode == relayconstant.RelayModeChatCompletions || relayMode == relayconstant.RelayModeCompletions
+	newAPIError := relayWithFallback(c, group, originalModel, allowFallback, func(channel *model.Channel) *types.NewAPIError {
+		return relayRequest(c, relayMode, channel)
+	})
 	useChannel := c.GetStringSlice("use_channel")
 	if len(useChannel) > 1 {
 		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
@@ -114,6 +101,47 @@ func Relay(c *gin.Context) {
 	}
 }
 
+// relayWithRetry 使用指定模型转发请求，失败时按重试次数更换渠道。
+// selectFirst 为 true 时首次尝试也重新选择渠道，而不是使用分发中间件选好的渠道
+func relayWithRetry(c *gin.Context, group string, modelName string, selectFirst bool, attempt func(*model.Channel) *types.NewAPIError) *types.NewAPIError {
+	var newAPIError *types.NewAPIError
+	for i := 0; i <= common.RetryTimes; i++ {
+		if i > 0 {
+			service.RecordRelayRetryMetrics(modelName, group, relayconstant.RelayModeName(c.Request.URL.Path))
+		}
+		var channel *model.Channel
+		var err *types.NewAPIError
+		if i == 0 && selectFirst {
+			channel, err = selectChannel(c, group, modelName, i)
+		} else {
+			channel, err = getChannel(c, group, modelName, i)
+		}
+		if err != nil {
+			common.LogError(c, err.Error())
+			newAPIError = err
+			break
+		}
+
+		model.AcquireCircuitBreakerProbe(channel.Id, modelName)
+		endAttemptSpan := startRelayAttemptSpan(c, channel, i)
+		newAPIError = attempt(channel)
+		endAttemptSpan(newAPIError)
+		recordRelayAttemptMetrics(c, channel, modelName, newAPIError)
+
+		if newAPIError == nil {
+			service.BindStickyRouting(c, modelName)
+			return nil // 成功处理请求，直接返回
+		}
+
+		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), modelName, newAPIError)
+
+		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
+			break
+		}
+	}
+	return newAPIError
+}
+
 var upgrader = websocket.Upgrader{
 	Subprotocols: []string{"realtime"}, // WS 握手支持的协议，如果有使用 Sec-WebSocket-Protocol，则必须在此声明对应的 Protocol TODO add other protocol
 	CheckOrigin: func(r *http.Request) bool {
@@ -140,6 +168,9 @@ func WssRelay(c *gin.Context) {
 	var newAPIError *types.NewAPIError
 
 	for i := 0; i <= common.RetryTimes; i++ {
+		if i > 0 {
+			service.RecordRelayRetryMetrics(originalModel, group, relayconstant.RelayModeName(c.Request.URL.Path))
+		}
 		channel, err := getChannel(c, group, originalModel, i)
 		if err != nil {
 			common.LogError(c, err.Error())
@@ -147,13 +178,18 @@ func WssRelay(c *gin.Context) {
 			break
 		}
 
+		model.AcquireCircuitBreakerProbe(channel.Id, originalModel)
+		endAttemptSpan := startRelayAttemptSpan(c, channel, i)
 		newAPIError = wssRequest(c, ws, relayMode, channel)
+		endAttemptSpan(newAPIError)
+		recordRelayAttemptMetrics(c, channel, originalModel, newAPIError)
 
 		if newAPIError == nil {
+			service.BindStickyRouting(c, originalModel)
 			return // 成功处理请求，直接返回
 		}
 
-		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), originalModel, newAPIError)
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
 			break
@@ -179,28 +215,10 @@ func RelayClaude(c *gin.Context) {
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
-	var newAPIError *types.NewAPIError
-
-	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
-		if err != nil {
-			common.LogError(c, err.Error())
-			newAPIError = err
-			break
-		}
-
-		newAPIError = claudeRequest(c, channel)
 
-		if newAPIError == nil {
-			return // 成功处理请求，直接返回
-		}
-
-		go processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
-
-		if !shouldRetry(c, newAPIError, common.RetryTimes-i) {
-			break
-		}
-	}
+	newAPIError := relayWithFallback(c, group, originalModel, true, func(channel *model.Channel) *types.NewAPIError {
+		return claudeRequest(c, channel)
+	})
 	useChannel := c.GetStringSlice("use_channel")
 	if len(useChannel) > 1 {
 		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
@@ -218,13 +236,25 @@ func RelayClaude(c *gin.Context) {
 
 func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *types.NewAPIError {
 	addUsedChannel(c, channel.Id)
-	requestBody, _ := common.GetRequestBody(c)
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-	return relayHandler(c, relayMode)
+	defer model.TrackChannelOutstanding(channel.Id, c.GetString("original_model"))()
+	mirror := startTrafficMirror(c, relayMode, channel)
+	var newAPIError *types.NewAPIError
+	if (relayMode == relayconstant.RelayModeChatCompletions || relayMode == relayconstant.RelayModeCompletions) && shouldHedge(c) {
+		newAPIError = hedgeRequest(c, channel, func(hc *gin.Context) *types.NewAPIError {
+			return relayHandler(hc, relayMode)
+		})
+	} else {
+		requestBody, _ := common.GetRequestBody(c)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		newAPIError = relayHandler(c, relayMode)
+	}
+	mirror.complete(newAPIError)
+	return newAPIError
 }
 
 func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *model.Channel) *types.NewAPIError {
 	addUsedChannel(c, channel.Id)
+	defer model.TrackChannelOutstanding(channel.Id, c.GetString("original_model"))()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return relay.WssHelper(c, ws)
@@ -232,11 +262,42 @@ func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *mode
 
 func claudeRequest(c *gin.Context, channel *model.Channel) *types.NewAPIError {
 	addUsedChannel(c, channel.Id)
+	defer model.TrackChannelOutstanding(channel.Id, c.GetString("original_model"))()
+	if shouldHedge(c) {
+		return hedgeRequest(c, channel, relay.ClaudeHelper)
+	}
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return relay.ClaudeHelper(c)
 }
 
+func recordRelayAttemptMetrics(c *gin.Context, channel *model.Channel, modelName string, newAPIError *types.NewAPIError) {
+	relayModeName := relayconstant.RelayModeName(c.Request.URL.Path)
+	if newAPIError == nil {
+		service.RecordRelayAttemptMetrics(channel.Id, modelName, c.GetString("group"), relayModeName, http.StatusOK, "")
+		return
+	}
+	service.RecordRelayAttemptMetrics(channel.Id, modelName, c.GetString("group"), relayModeName, newAPIError.StatusCode, string(newAPIError.ErrorType))
+}
+
+// startRelayAttemptSpan 为每次渠道尝试创建 span，后续的 span 都作为其子级，返回的函数用于记录结果并结束 span
+func startRelayAttemptSpan(c *gin.Context, channel *model.Channel, retry int) func(*types.NewAPIError) {
+	span, end := common.StartRequestSpan(c, "relay.attempt",
+		attribute.Int("relay.retry", retry),
+		attribute.Int("channel.id", channel.Id),
+		attribute.Int("channel.type", channel.Type),
+		attribute.String("model", c.GetString("original_model")),
+	)
+	return func(newAPIError *types.NewAPIError) {
+		if newAPIError != nil {
+			span.RecordError(newAPIError)
+			span.SetStatus(codes.Error, newAPIError.Error())
+			span.SetAttributes(attribute.Int("http.status_code", newAPIError.StatusCode), attribute.String("error.type", string(newAPIError.ErrorType)))
+		}
+		end()
+	}
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -257,7 +318,12 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, selectGroup, err := model.CacheGetRandomSatisfiedChannel(c, group, originalModel, retryCount)
+	return selectChannel(c, group, originalModel, retryCount)
+}
+
+// selectChannel 按分组和模型选择渠道，并把渠道信息写入上下文
+func selectChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, *types.NewAPIError) {
+	channel, selectGroup, err := middleware.CacheGetChannelWithAffinity(c, group, originalModel, retryCount)
 	if err != nil {
 		if group == "auto" {
 			return nil, types.NewError(errors.New(fmt.Sprintf("获取自动分组下模型 %s 的可用渠道失败: %s", originalModel, err.Error())), types.ErrorCodeGetChannelFailed)
@@ -317,10 +383,18 @@ func shouldRetry(c *gin.Context, openaiErr *types.NewAPIError, retryTimes int) b
 	return true
 }
 
-func processChannelError(c *gin.Context, channelError types.ChannelError, err *types.NewAPIError) {
+func processChannelError(c *gin.Context, channelError types.ChannelError, modelName string, err *types.NewAPIError) {
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
 	common.LogError(c, fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
+	model.RecordChannelSelectError(channelError.ChannelId, modelName, err.StatusCode)
+	// 首次尝试的渠道信息不含多 Key 标记，由 RecordChannelKeyError 根据渠道判断
+	model.RecordChannelKeyError(channelError.ChannelId, channelError.UsingKey, err.StatusCode, err.Error())
+	if service.ShouldCountCircuitBreakerFailure(err) {
+		model.RecordCircuitBreakerFailure(channelError.ChannelId, modelName, err.Error())
+	} else {
+		model.ReleaseCircuitBreakerProbe(channelError.ChannelId, modelName)
+	}
 	if service.ShouldDisableChannel(channelError.ChannelId, err) && channelError.AutoBan {
 		service.DisableChannel(channelError, err.Error())
 	}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/service"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// relayWithFallback 先使用当前模型转发，渠道都失败后按降级链依次改用备选模型。
+// 分发中间件已经降级时从降级后的模型继续
+func relayWithFallback(c *gin.Context, group string, originalModel string, allowFallback bool, attempt func(*model.Channel) *types.NewAPIError) *types.NewAPIError {
+	if !allowFallback {
+		return relayWithRetry(c, group, originalModel, false, attempt)
+	}
+	requestModel := common.GetContextKeyString(c, constant.ContextKeyModelFallbackFrom)
+	if requestModel == "" {
+		requestModel = originalModel
+	}
+	chain := service.GetModelFallbackChain(c, group, requestModel)
+	start := 0
+	for i, modelName := range chain {
+		if modelName == originalModel {
+			start = i
+			break
+		}
+	}
+	newAPIError := relayWithRetry(c, group, originalModel, false, attempt)
+	for _, modelName := range chain[start+1:] {
+		if !shouldFallback(c, newAPIError) {
+			break
+		}
+		common.LogWarn(c, fmt.Sprintf("模型 %s 请求失败，改用备选模型 %s", c.GetString("original_model"), modelName))
+		service.SetModelFallback(c, requestModel, modelName)
+		newAPIError = relayWithRetry(c, group, modelName, true, attempt)
+	}
+	if newAPIError != nil {
+		c.Writer.Header().Del("X-Fallback-Model")
+	}
+	return newAPIError
+}
+
+// shouldFallback 当前模型的渠道都失败后是否改用备选模型，已经开始输出响应或请求本身有误时不降级
+func shouldFallback(c *gin.Context, newAPIError *types.NewAPIError) bool {
+	if newAPIError == nil || c.Writer.Written() {
+		return false
+	}
+	if newAPIError.GetErrorCode() == types.ErrorCodeGetChannelFailed {
+		return true
+	}
+	return shouldRetry(c, newAPIError, 1)
+}
@@ -93,7 +93,7 @@ func UpdateTaskBulk() {
 
 				// 补偿用户配额（无论数据库更新是否成功都要补偿）
 				if task.Quota > 0 {
-					err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
+					err = model.RefundTaskQuota(task.UserId, task.OrganizationId, task.Quota, task.TaskID)
 					if err != nil {
 						common.LogError(ctx, fmt.Sprintf("Failed to increase user quota for timeout task %d: %v", task.ID, err))
 					} else {
@@ -206,7 +206,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			} else {
 				quota := task.Quota
 				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota, false)
+					err = model.RefundTaskQuota(task.UserId, task.OrganizationId, quota, task.TaskID)
 					if err != nil {
 						common.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
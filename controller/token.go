@@ -61,6 +61,43 @@ func GetToken(c *gin.Context) {
 	return
 }
 
+// GetTokenBudget 获取令牌当前周期的预算用量
+func GetTokenBudget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt("id")
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	token, err := model.GetTokenByIds(id, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	usage, err := model.GetTokenBudgetUsage(token.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	modelUsage := make(map[string]int)
+	for modelName := range token.GetBudgetModelQuotas() {
+		modelUsage[modelName] = usage[modelName]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"budget_enabled":      token.BudgetEnabled,
+			"budget_period":       token.BudgetPeriod,
+			"budget_quota":        token.BudgetQuota,
+			"budget_model_quotas": token.GetBudgetModelQuotas(),
+			"used_quota":          usage[""],
+			"model_used_quota":    modelUsage,
+			"reset_time":          token.BudgetResetTime,
+		},
+	})
+}
+
 func GetTokenStatus(c *gin.Context) {
 	tokenId := c.GetInt("token_id")
 	userId := c.GetInt("id")
@@ -96,6 +133,23 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if err = token.ValidateBudget(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = token.ValidateModelFallback(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !validateTokenOrganization(c, token.OrganizationId) {
+		return
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -106,18 +160,30 @@ func AddToken(c *gin.Context) {
 		return
 	}
 	cleanToken := model.Token{
-		UserId:             c.GetInt("id"),
-		Name:               token.Name,
-		Key:                key,
-		CreatedTime:        common.GetTimestamp(),
-		AccessedTime:       common.GetTimestamp(),
-		ExpiredTime:        token.ExpiredTime,
-		RemainQuota:        token.RemainQuota,
-		UnlimitedQuota:     token.UnlimitedQuota,
-		ModelLimitsEnabled: token.ModelLimitsEnabled,
-		ModelLimits:        token.ModelLimits,
-		AllowIps:           token.AllowIps,
-		Group:              token.Group,
+		UserId:                c.GetInt("id"),
+		Name:                  token.Name,
+		Key:                   key,
+		CreatedTime:           common.GetTimestamp(),
+		AccessedTime:          common.GetTimestamp(),
+		ExpiredTime:           token.ExpiredTime,
+		RemainQuota:           token.RemainQuota,
+		UnlimitedQuota:        token.UnlimitedQuota,
+		ModelLimitsEnabled:    token.ModelLimitsEnabled,
+		ModelLimits:           token.ModelLimits,
+		AllowIps:              token.AllowIps,
+		Group:                 token.Group,
+		HedgeEnabled:          token.HedgeEnabled,
+		ResponseCacheDisabled: token.ResponseCacheDisabled,
+		BudgetEnabled:         token.BudgetEnabled,
+		BudgetQuota:           token.BudgetQuota,
+		BudgetPeriod:          token.BudgetPeriod,
+		BudgetAnchor:          token.BudgetAnchor,
+		BudgetModelQuotas:     token.BudgetModelQuotas,
+		OrganizationId:        token.OrganizationId,
+		ModelFallback:         token.ModelFallback,
+	}
+	if cleanToken.BudgetEnabled {
+		cleanToken.InitBudgetResetTime()
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -131,6 +197,21 @@ func AddToken(c *gin.Context) {
 	return
 }
 
+// validateTokenOrganization 令牌只能关联到用户已加入的组织
+func validateTokenOrganization(c *gin.Context, orgId int) bool {
+	if orgId == 0 {
+		return true
+	}
+	if _, err := model.GetOrganizationMember(orgId, c.GetInt("id")); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不是该组织的成员，无法使用组织额度",
+		})
+		return false
+	}
+	return true
+}
+
 func DeleteToken(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
 	userId := c.GetInt("id")
@@ -186,6 +267,26 @@ func UpdateToken(c *gin.Context) {
 	if statusOnly != "" {
 		cleanToken.Status = token.Status
 	} else {
+		if err = token.ValidateBudget(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if err = token.ValidateModelFallback(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if !validateTokenOrganization(c, token.OrganizationId) {
+			return
+		}
+		// 新启用预算或修改了周期时重新计算下次重置时间
+		budgetPeriodChanged := token.BudgetEnabled && (!cleanToken.BudgetEnabled ||
+			cleanToken.BudgetPeriod != token.BudgetPeriod || cleanToken.BudgetAnchor != token.BudgetAnchor)
 		// If you add more fields, please also update token.Update()
 		cleanToken.Name = token.Name
 		cleanToken.ExpiredTime = token.ExpiredTime
@@ -196,6 +297,18 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.GroupInfo = token.GroupInfo
+		cleanToken.HedgeEnabled = token.HedgeEnabled
+		cleanToken.ResponseCacheDisabled = token.ResponseCacheDisabled
+		cleanToken.BudgetEnabled = token.BudgetEnabled
+		cleanToken.BudgetQuota = token.BudgetQuota
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		cleanToken.BudgetAnchor = token.BudgetAnchor
+		cleanToken.BudgetModelQuotas = token.BudgetModelQuotas
+		cleanToken.OrganizationId = token.OrganizationId
+		cleanToken.ModelFallback = token.ModelFallback
+		if budgetPeriodChanged {
+			cleanToken.InitBudgetResetTime()
+		}
 	}
 	err = cleanToken.Update()
 	if err != nil {
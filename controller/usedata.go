@@ -77,3 +77,16 @@ func GetUserQuotaDates(c *gin.Context) {
 	})
 	return
 }
+
+func GetChannelCostReport(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	modelName := c.Query("model_name")
+	report, err := model.GetChannelCostReport(startTimestamp, endTimestamp, channelId, modelName)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, report)
+}
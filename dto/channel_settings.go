@@ -6,4 +6,51 @@ type ChannelSettings struct {
 	Proxy             string `json:"proxy"`
 	RPMLimit          int    `json:"rpm_limit"`
 	UserRPMLimit      int    `json:"user_rpm_limit"`
+	// 多 Key 渠道中每个 Key 每分钟的请求数和 token 数上限，0 表示不限制
+	KeyRPMLimit int `json:"key_rpm_limit,omitempty"`
+	KeyTPMLimit int `json:"key_tpm_limit,omitempty"`
+	// 上游成本，未设置时不统计该渠道的成本
+	CostRatio      float64            `json:"cost_ratio,omitempty"`       // 成本相对于用户价格（不含分组倍率）的比例
+	ModelCostRatio map[string]float64 `json:"model_cost_ratio,omitempty"` // 按模型设置的成本比例，优先于 CostRatio
+	ModelCostPrice map[string]float64 `json:"model_cost_price,omitempty"` // 按模型设置的每次请求成本（美元），优先于成本比例
+	// 模板渠道的请求和响应转换规则
+	Template *TemplateSettings `json:"template,omitempty"`
+	// 时间窗口调度规则，为空时始终参与渠道选择
+	Schedule *ChannelSchedule `json:"schedule,omitempty"`
+	// 多 Key 渠道中按 Key 下标设置的休息规则，窗口外的 Key 不会被选中（忽略其中的窗口外优先级和权重）
+	KeySchedules map[int]*ChannelSchedule `json:"key_schedules,omitempty"`
+}
+
+// ChannelSchedule 时间窗口调度规则，满足 Windows 或 Cron 中任意一条即处于窗口内
+type ChannelSchedule struct {
+	Timezone string                  `json:"timezone,omitempty"` // IANA 时区，如 Asia/Shanghai，为空时使用服务器时区
+	Windows  []ChannelScheduleWindow `json:"windows,omitempty"`
+	Cron     []string                `json:"cron,omitempty"` // 5 段 cron 表达式（分 时 日 月 周），当前分钟匹配时处于窗口内
+	// 窗口外使用的优先级和权重，都未设置时窗口外不参与渠道选择
+	OutsidePriority *int64 `json:"outside_priority,omitempty"`
+	OutsideWeight   *int   `json:"outside_weight,omitempty"`
+}
+
+type ChannelScheduleWindow struct {
+	Weekdays []int  `json:"weekdays,omitempty"` // 星期几生效，0 表示周日，为空时每天生效
+	Start    string `json:"start"`              // 开始时间 HH:MM（包含）
+	End      string `json:"end"`                // 结束时间 HH:MM（不包含），不大于开始时间时跨越午夜，24:00 表示当天结束
+}
+
+// TemplateSettings 模板渠道设置。模板中的字符串可以引用变量和 JSON 路径，见 relay/channel/template
+type TemplateSettings struct {
+	URL          string            `json:"url,omitempty"`           // 请求地址模板，为空时与 OpenAI 渠道相同
+	StreamURL    string            `json:"stream_url,omitempty"`    // 流式请求地址模板，为空时使用 URL
+	Headers      map[string]string `json:"headers,omitempty"`       // 请求头模板，为空时使用 Bearer 鉴权
+	Request      any               `json:"request,omitempty"`       // 请求体模板，以 OpenAI 请求为输入，为空时原样转发
+	Response     any               `json:"response,omitempty"`      // 非流式响应模板，输出 OpenAI 响应，为空时认为上游兼容 OpenAI
+	StreamChunk  any               `json:"stream_chunk,omitempty"`  // 流式数据块模板，输出 OpenAI 流式数据块，为空时认为上游兼容 OpenAI
+	Usage        TemplateUsage     `json:"usage,omitempty"`         // 上游响应或数据块中用量字段的路径
+	ErrorMessage string            `json:"error_message,omitempty"` // 上游响应中错误信息的路径
+}
+
+type TemplateUsage struct {
+	PromptTokens     string `json:"prompt_tokens,omitempty"`
+	CompletionTokens string `json:"completion_tokens,omitempty"`
+	TotalTokens      string `json:"total_tokens,omitempty"`
 }
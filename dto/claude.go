@@ -17,18 +17,25 @@ type ClaudeMediaMessage struct {
 	Source       *ClaudeMessageSource `json:"source,omitempty"`
 	Usage        *ClaudeUsage         `json:"usage,omitempty"`
 	StopReason   *string              `json:"stop_reason,omitempty"`
+	StopSequence *string              `json:"stop_sequence,omitempty"`
 	PartialJson  *string              `json:"partial_json,omitempty"`
 	Role         string               `json:"role,omitempty"`
-	Thinking     string               `json:"thinking,omitempty"`
+	Thinking     *string              `json:"thinking,omitempty"`
 	Signature    string               `json:"signature,omitempty"`
 	Delta        string               `json:"delta,omitempty"`
 	CacheControl json.RawMessage      `json:"cache_control,omitempty"`
+	// citations 在 document 块中为 {"enabled": true}，在 text 块中为引用列表
+	Citations any    `json:"citations,omitempty"`
+	Citation  any    `json:"citation,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Context   string `json:"context,omitempty"`
 	// tool_calls
 	Id        string `json:"id,omitempty"`
 	Name      string `json:"name,omitempty"`
 	Input     any    `json:"input,omitempty"`
 	Content   any    `json:"content,omitempty"`
 	ToolUseId string `json:"tool_use_id,omitempty"`
+	IsError   *bool  `json:"is_error,omitempty"`
 }
 
 func (c *ClaudeMediaMessage) SetText(s string) {
@@ -42,6 +49,17 @@ func (c *ClaudeMediaMessage) GetText() string {
 	return *c.Text
 }
 
+func (c *ClaudeMediaMessage) SetThinking(s string) {
+	c.Thinking = &s
+}
+
+func (c *ClaudeMediaMessage) GetThinking() string {
+	if c.Thinking == nil {
+		return ""
+	}
+	return *c.Thinking
+}
+
 func (c *ClaudeMediaMessage) IsStringContent() bool {
 	if c.Content == nil {
 		return false
@@ -98,6 +116,8 @@ type ClaudeMessageSource struct {
 	MediaType string `json:"media_type,omitempty"`
 	Data      any    `json:"data,omitempty"`
 	Url       string `json:"url,omitempty"`
+	// document 块 source.type 为 content 时的内容块
+	Content any `json:"content,omitempty"`
 }
 
 type ClaudeMessage struct {
@@ -174,6 +194,24 @@ type ClaudeWebSearchUserLocation struct {
 	City     string `json:"city,omitempty"`
 }
 
+// ClaudeWebSearchCitation 网页搜索结果引用，由 OpenAI 的 url_citation 转换而来
+type ClaudeWebSearchCitation struct {
+	Type           string `json:"type"`
+	Url            string `json:"url"`
+	Title          string `json:"title"`
+	CitedText      string `json:"cited_text"`
+	EncryptedIndex string `json:"encrypted_index"`
+}
+
+// ClaudeWebSearchResult 服务端网页搜索结果中的一项，搜索失败时 content 为 web_search_tool_result_error 对象
+type ClaudeWebSearchResult struct {
+	Type      string `json:"type"`
+	Url       string `json:"url,omitempty"`
+	Title     string `json:"title,omitempty"`
+	PageAge   string `json:"page_age,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
 type ClaudeToolChoice struct {
 	Type                   string `json:"type"`
 	Name                   string `json:"name,omitempty"`
@@ -196,6 +234,8 @@ type ClaudeRequest struct {
 	Tools      any       `json:"tools,omitempty"`
 	ToolChoice any       `json:"tool_choice,omitempty"`
 	Thinking   *Thinking `json:"thinking,omitempty"`
+	// ServiceTier 请求的服务等级，如 auto、standard_only
+	ServiceTier string `json:"service_tier,omitempty"`
 }
 
 // AddTool 添加工具到请求中
@@ -302,6 +342,7 @@ type ClaudeResponse struct {
 	Content      []ClaudeMediaMessage `json:"content,omitempty"`
 	Completion   string               `json:"completion,omitempty"`
 	StopReason   string               `json:"stop_reason,omitempty"`
+	StopSequence *string              `json:"stop_sequence,omitempty"`
 	Model        string               `json:"model,omitempty"`
 	Error        *types.ClaudeError   `json:"error,omitempty"`
 	Usage        *ClaudeUsage         `json:"usage,omitempty"`
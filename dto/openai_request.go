@@ -47,6 +47,7 @@ type GeneralOpenAIRequest struct {
 	Tools               []ToolCallRequest `json:"tools,omitempty"`
 	ToolChoice          any               `json:"tool_choice,omitempty"`
 	User                string            `json:"user,omitempty"`
+	ServiceTier         string            `json:"service_tier,omitempty"`
 	LogProbs            bool              `json:"logprobs,omitempty"`
 	TopLogProbs         int               `json:"top_logprobs,omitempty"`
 	Dimensions          int               `json:"dimensions,omitempty"`
@@ -120,6 +121,7 @@ type Message struct {
 	Reasoning        string          `json:"reasoning,omitempty"`
 	ToolCalls        json.RawMessage `json:"tool_calls,omitempty"`
 	ToolCallId       string          `json:"tool_call_id,omitempty"`
+	Annotations      []Annotation    `json:"annotations,omitempty"`
 	parsedContent    []MediaContent
 	//parsedStringContent *string
 }
@@ -210,6 +212,19 @@ type MessageVideoUrl struct {
 	Url string `json:"url"`
 }
 
+// Annotation 回复中的注释，目前只有网页搜索产生的 url_citation
+type Annotation struct {
+	Type        string       `json:"type"`
+	UrlCitation *UrlCitation `json:"url_citation,omitempty"`
+}
+
+type UrlCitation struct {
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	Url        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+}
+
 const (
 	ContentTypeText       = "text"
 	ContentTypeImageURL   = "image_url"
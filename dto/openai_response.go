@@ -23,6 +23,8 @@ type OpenAITextResponseChoice struct {
 	Index        int `json:"index"`
 	Message      `json:"message"`
 	FinishReason string `json:"finish_reason"`
+	// vLLM 等上游在命中停止序列时返回该停止序列
+	StopReason any `json:"stop_reason,omitempty"`
 }
 
 type OpenAITextResponse struct {
@@ -65,6 +67,7 @@ type ChatCompletionsStreamResponseChoice struct {
 	Delta        ChatCompletionsStreamResponseChoiceDelta `json:"delta,omitempty"`
 	Logprobs     *any                                     `json:"logprobs"`
 	FinishReason *string                                  `json:"finish_reason"`
+	StopReason   any                                      `json:"stop_reason,omitempty"`
 	Index        int                                      `json:"index"`
 }
 
@@ -74,6 +77,7 @@ type ChatCompletionsStreamResponseChoiceDelta struct {
 	Reasoning        *string            `json:"reasoning,omitempty"`
 	Role             string             `json:"role,omitempty"`
 	ToolCalls        []ToolCallResponse `json:"tool_calls,omitempty"`
+	Annotations      []Annotation       `json:"annotations,omitempty"`
 }
 
 func (c *ChatCompletionsStreamResponseChoiceDelta) SetContentString(s string) {
@@ -238,15 +242,21 @@ type OpenAIResponsesResponse struct {
 }
 
 type IncompleteDetails struct {
-	Reasoning string `json:"reasoning"`
+	Reason string `json:"reason"`
 }
 
 type ResponsesOutput struct {
 	Type    string                   `json:"type"`
 	ID      string                   `json:"id"`
 	Status  string                   `json:"status"`
-	Role    string                   `json:"role"`
-	Content []ResponsesOutputContent `json:"content"`
+	Role    string                   `json:"role,omitempty"`
+	Content []ResponsesOutputContent `json:"content,omitempty"`
+	// function_call
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// reasoning
+	Summary []ResponsesOutputContent `json:"summary,omitempty"`
 }
 
 type ResponsesOutputContent struct {
@@ -269,10 +279,37 @@ const (
 	ResponsesOutputTypeItemDone  = "response.output_item.done"
 )
 
+const (
+	ResponsesStreamTypeCreated                = "response.created"
+	ResponsesStreamTypeInProgress             = "response.in_progress"
+	ResponsesStreamTypeCompleted              = "response.completed"
+	ResponsesStreamTypeIncomplete             = "response.incomplete"
+	ResponsesStreamTypeContentPartAdded       = "response.content_part.added"
+	ResponsesStreamTypeContentPartDone        = "response.content_part.done"
+	ResponsesStreamTypeOutputTextDelta        = "response.output_text.delta"
+	ResponsesStreamTypeOutputTextDone         = "response.output_text.done"
+	ResponsesStreamTypeReasoningSummaryDelta  = "response.reasoning_summary_text.delta"
+	ResponsesStreamTypeReasoningSummaryDone   = "response.reasoning_summary_text.done"
+	ResponsesStreamTypeFunctionArgumentsDelta = "response.function_call_arguments.delta"
+	ResponsesStreamTypeFunctionArgumentsDone  = "response.function_call_arguments.done"
+	ResponsesStreamTypeError                  = "error"
+)
+
 // ResponsesStreamResponse 用于处理 /v1/responses 流式响应
 type ResponsesStreamResponse struct {
-	Type     string                   `json:"type"`
-	Response *OpenAIResponsesResponse `json:"response,omitempty"`
-	Delta    string                   `json:"delta,omitempty"`
-	Item     *ResponsesOutput         `json:"item,omitempty"`
+	Type           string                   `json:"type"`
+	SequenceNumber int                      `json:"sequence_number"`
+	Response       *OpenAIResponsesResponse `json:"response,omitempty"`
+	Delta          string                   `json:"delta,omitempty"`
+	Item           *ResponsesOutput         `json:"item,omitempty"`
+	ItemId         string                   `json:"item_id,omitempty"`
+	OutputIndex    *int                     `json:"output_index,omitempty"`
+	ContentIndex   *int                     `json:"content_index,omitempty"`
+	SummaryIndex   *int                     `json:"summary_index,omitempty"`
+	Part           *ResponsesOutputContent  `json:"part,omitempty"`
+	Text           string                   `json:"text,omitempty"`
+	Arguments      string                   `json:"arguments,omitempty"`
+	// error 事件的错误码和错误信息
+	Code    any    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -55,6 +56,14 @@ func main() {
 		}
 	}()
 
+	shutdownTracing, err := common.InitTracing()
+	if err != nil {
+		common.FatalLog("failed to initialize tracing: " + err.Error())
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
+
 	if common.RedisEnabled {
 		// for compatibility with old versions
 		common.MemoryCacheEnabled = true
@@ -101,6 +110,10 @@ func main() {
 		}
 		go controller.AutomaticallyTestChannels(frequency)
 	}
+	if common.IsMasterNode {
+		// 熔断器半开探测只在主节点运行，避免多节点重复探测
+		go controller.AutomaticallyProbeCircuitBreakers()
+	}
 	if common.IsMasterNode && constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateMidjourneyTaskBulk()
@@ -161,6 +174,17 @@ func main() {
 	server.Use(sessions.Sessions("session", store))
 
 	router.SetRouter(server, buildFS, indexPage)
+	if common.IsMasterNode {
+		gopool.Go(func() {
+			controller.RunBatchWorker(server)
+		})
+		// 令牌周期预算重置
+		go model.SyncTokenBudgets(60)
+		// 额度账本定时对账
+		go controller.AutomaticallyReconcileQuotaLedger()
+		// 多 Key 渠道自动禁用的 Key 定时重新测试
+		go controller.AutomaticallyRetestChannelKeys()
+	}
 	var port = os.Getenv("PORT")
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
@@ -222,5 +246,8 @@ func InitResources() error {
 	// Initialize timeout monitor
 	model.InitTimeoutMonitor()
 
+	// Initialize circuit breaker
+	model.InitCircuitBreaker()
+
 	return nil
 }
@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/model"
 	"strconv"
 	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func validUserInfo(username string, role int) bool {
@@ -173,6 +175,8 @@ func WssAuth(c *gin.Context) {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span := common.StartSpan(c, "middleware.TokenAuth")
+		defer span.End()
 		// 先检测是否为ws
 		if c.Request.Header.Get("Sec-WebSocket-Protocol") != "" {
 			// Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1
@@ -250,6 +254,8 @@ func TokenAuth() func(c *gin.Context) {
 		if err != nil {
 			return
 		}
+		span.SetAttributes(attribute.Int("user.id", token.UserId), attribute.Int("token.id", token.Id))
+		span.End()
 		c.Next()
 	}
 }
@@ -273,6 +279,11 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 		c.Set("token_model_limit_enabled", false)
 	}
 	c.Set("allow_ips", token.GetIpLimitsMap())
+	common.SetContextKey(c, constant.ContextKeyTokenHedgeEnabled, token.HedgeEnabled)
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCacheDisabled, token.ResponseCacheDisabled)
+	common.SetContextKey(c, constant.ContextKeyTokenBudgetEnabled, token.BudgetEnabled)
+	common.SetContextKey(c, constant.ContextKeyTokenOrganizationId, token.OrganizationId)
+	common.SetContextKey(c, constant.ContextKeyTokenModelFallback, token.GetModelFallbackMap())
 
 	// 设置令牌分组信息（支持多分组模式）
 	if token.GroupInfo.IsMultiGroup && len(token.GroupInfo.MultiGroupList) > 0 {
@@ -0,0 +1,328 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// channelQueueWaiter 一个排队中的请求
+type channelQueueWaiter struct {
+	userId  int
+	group   string
+	ready   chan struct{}
+	granted bool
+}
+
+// channelQueue 单个渠道的等待队列。队列保存在当前节点内存中，各节点按用户轮询分配名额，
+// 名额本身仍通过渠道 RPM 限流器（Redis 或内存）在所有节点间共享
+type channelQueue struct {
+	channelId  int
+	mutex      sync.Mutex
+	maxCount   int
+	users      []int
+	waiters    map[int][]*channelQueueWaiter
+	groupSizes map[string]int
+	size       int
+	cursor     int
+	running    bool
+}
+
+var (
+	channelQueues     = make(map[int]*channelQueue)
+	channelQueuesLock sync.Mutex
+)
+
+func getChannelQueue(channelId int) *channelQueue {
+	channelQueuesLock.Lock()
+	defer channelQueuesLock.Unlock()
+	queue, ok := channelQueues[channelId]
+	if !ok {
+		queue = &channelQueue{
+			channelId:  channelId,
+			waiters:    make(map[int][]*channelQueueWaiter),
+			groupSizes: make(map[string]int),
+		}
+		channelQueues[channelId] = queue
+	}
+	return queue
+}
+
+// tryAcquireChannelSlot 尝试占用渠道一分钟窗口内的一个请求名额
+func tryAcquireChannelSlot(channelId int, maxCount int) (bool, error) {
+	duration := int64(60)
+	if common.RedisEnabled {
+		ctx := context.Background()
+		key := fmt.Sprintf("channelRateLimit:%s:%d", ChannelRequestRateLimitMark, channelId)
+		allowed, err := checkRedisRateLimit(ctx, common.RDB, key, maxCount, duration)
+		if err != nil || !allowed {
+			return false, err
+		}
+		recordRedisRequest(ctx, common.RDB, key, maxCount)
+		return true, nil
+	}
+	inMemoryRateLimiter.Init(time.Duration(duration) * time.Second)
+	key := fmt.Sprintf("%s:%d", ChannelRequestRateLimitMark, channelId)
+	return inMemoryRateLimiter.Request(key, maxCount, duration), nil
+}
+
+// releaseChannelSlot 退还 tryAcquireChannelSlot 占用的名额
+func releaseChannelSlot(channelId int) {
+	if common.RedisEnabled {
+		key := fmt.Sprintf("channelRateLimit:%s:%d", ChannelRequestRateLimitMark, channelId)
+		// 请求记录从列表头部写入，最新的记录在头部
+		if err := common.RDB.LPop(context.Background(), key).Err(); err != nil && !errors.Is(err, redis.Nil) {
+			common.SysError(fmt.Sprintf("channel #%d failed to release slot: %s", channelId, err.Error()))
+		}
+		return
+	}
+	inMemoryRateLimiter.Release(fmt.Sprintf("%s:%d", ChannelRequestRateLimitMark, channelId))
+}
+
+func (q *channelQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size
+}
+
+// enqueue 加入队列，队列已满时返回 nil
+func (q *channelQueue) enqueue(userId int, group string, maxCount int) *channelQueueWaiter {
+	setting := operation_setting.GetChannelQueueSetting()
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.size >= setting.MaxQueueSize {
+		return nil
+	}
+	if groupMax := setting.GetGroupMaxQueueSize(group); groupMax > 0 && q.groupSizes[group] >= groupMax {
+		return nil
+	}
+	waiter := &channelQueueWaiter{
+		userId: userId,
+		group:  group,
+		ready:  make(chan struct{}),
+	}
+	if len(q.waiters[userId]) == 0 {
+		q.users = append(q.users, userId)
+	}
+	q.waiters[userId] = append(q.waiters[userId], waiter)
+	q.groupSizes[group]++
+	q.size++
+	q.maxCount = maxCount
+	if !q.running {
+		q.running = true
+		go q.dispatch()
+	}
+	return waiter
+}
+
+// leave 请求离开队列，已获得名额时返回 false
+func (q *channelQueue) leave(waiter *channelQueueWaiter) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if waiter.granted {
+		return false
+	}
+	waiters := q.waiters[waiter.userId]
+	for i, w := range waiters {
+		if w == waiter {
+			q.removeLocked(waiter.userId, i)
+			break
+		}
+	}
+	return true
+}
+
+func (q *channelQueue) removeLocked(userId int, index int) {
+	waiters := q.waiters[userId]
+	q.groupSizes[waiters[index].group]--
+	if q.groupSizes[waiters[index].group] <= 0 {
+		delete(q.groupSizes, waiters[index].group)
+	}
+	q.size--
+	waiters = append(waiters[:index], waiters[index+1:]...)
+	if len(waiters) > 0 {
+		q.waiters[userId] = waiters
+		return
+	}
+	delete(q.waiters, userId)
+	for i, id := range q.users {
+		if id == userId {
+			q.users = append(q.users[:i], q.users[i+1:]...)
+			if i < q.cursor {
+				q.cursor--
+			}
+			break
+		}
+	}
+}
+
+// popNextLocked 按用户轮询取出下一个请求，同一用户的请求按先后顺序
+func (q *channelQueue) popNextLocked() *channelQueueWaiter {
+	if len(q.users) == 0 {
+		return nil
+	}
+	if q.cursor >= len(q.users) {
+		q.cursor = 0
+	}
+	userId := q.users[q.cursor]
+	waiter := q.waiters[userId][0]
+	remaining := len(q.waiters[userId])
+	q.removeLocked(userId, 0)
+	if remaining > 1 {
+		// 该用户仍有排队请求，轮到下一个用户
+		q.cursor++
+	}
+	return waiter
+}
+
+// dispatch 渠道有余量时依次放行排队中的请求，队列为空时退出。
+// 占用名额时持有队列锁，保证占到的名额一定分配给仍在排队的请求，不会因请求同时离开而浪费
+func (q *channelQueue) dispatch() {
+	for {
+		q.mutex.Lock()
+		if q.size == 0 {
+			q.running = false
+			q.mutex.Unlock()
+			return
+		}
+		granted, err := tryAcquireChannelSlot(q.channelId, q.maxCount)
+		if err != nil {
+			common.SysError(fmt.Sprintf("channel #%d queue failed to acquire slot: %s", q.channelId, err.Error()))
+		}
+		if granted {
+			waiter := q.popNextLocked()
+			waiter.granted = true
+			close(waiter.ready)
+		}
+		q.mutex.Unlock()
+
+		if !granted {
+			interval := operation_setting.GetChannelQueueSetting().PollIntervalMilliseconds
+			if interval <= 0 {
+				interval = 200
+			}
+			time.Sleep(time.Duration(interval) * time.Millisecond)
+		}
+	}
+}
+
+// findSpillOverChannel 在同分组下寻找有余量的其他渠道，找到时已为其占用名额，
+// 不再使用时需通过 releaseSpillOverChannel 退还
+func findSpillOverChannel(c *gin.Context, channelId int) *model.Channel {
+	if _, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId); ok {
+		return nil
+	}
+	group := c.GetString("auto_group")
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	}
+	for _, channel := range model.CacheGetSatisfiedChannels(group, c.GetString("original_model")) {
+		if channel.Id == channelId {
+			continue
+		}
+		maxCount := channel.GetSetting().RPMLimit
+		if maxCount <= 0 {
+			return channel
+		}
+		// 其他渠道也有排队请求时不插队
+		if getChannelQueue(channel.Id).Len() > 0 {
+			continue
+		}
+		if granted, _ := tryAcquireChannelSlot(channel.Id, maxCount); granted {
+			return channel
+		}
+	}
+	return nil
+}
+
+// releaseSpillOverChannel 退还 findSpillOverChannel 为渠道占用的名额
+func releaseSpillOverChannel(channel *model.Channel) {
+	if channel.GetSetting().RPMLimit > 0 {
+		releaseChannelSlot(channel.Id)
+	}
+}
+
+// channelQueueRateLimitHandler 渠道 RPM 达到上限时排队等待，而不是立即拒绝
+func channelQueueRateLimitHandler(c *gin.Context, maxCount int) {
+	channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId)
+	if channelId == 0 {
+		return
+	}
+	setting := operation_setting.GetChannelQueueSetting()
+	queue := getChannelQueue(channelId)
+
+	// 没有排队请求时直接尝试占用名额
+	if queue.Len() == 0 {
+		granted, err := tryAcquireChannelSlot(channelId, maxCount)
+		if err != nil {
+			fmt.Println("检查渠道请求数限制失败:", err.Error())
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "channel_rate_limit_check_failed")
+			return
+		}
+		if granted {
+			return
+		}
+	}
+
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	maxWaitSeconds := setting.GetMaxWaitSeconds(group)
+	if maxWaitSeconds <= 0 {
+		abortWithOpenAiMessage(c, http.StatusTooManyRequests, "请求排队中, 请稍后重试")
+		return
+	}
+	waiter := queue.enqueue(c.GetInt("id"), group, maxCount)
+	if waiter == nil {
+		abortWithOpenAiMessage(c, http.StatusTooManyRequests, "渠道排队请求已满, 请稍后重试")
+		return
+	}
+
+	timeout := time.NewTimer(time.Duration(maxWaitSeconds) * time.Second)
+	defer timeout.Stop()
+	spillOverTicker := time.NewTicker(time.Second)
+	defer spillOverTicker.Stop()
+	for {
+		select {
+		case <-waiter.ready:
+			return
+		case <-timeout.C:
+			if queue.leave(waiter) {
+				abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("渠道请求排队超过 %d 秒, 请稍后重试", maxWaitSeconds))
+			}
+			return
+		case <-c.Request.Context().Done():
+			if queue.leave(waiter) {
+				c.Abort()
+			}
+			return
+		case <-spillOverTicker.C:
+			if !setting.SpillOverEnabled {
+				continue
+			}
+			channel := findSpillOverChannel(c, channelId)
+			if channel == nil {
+				continue
+			}
+			if !queue.leave(waiter) {
+				// 原渠道已放行，退还溢出渠道的名额
+				releaseSpillOverChannel(channel)
+				return
+			}
+			if newAPIError := SetupContextForSelectedChannel(c, channel, c.GetString("original_model")); newAPIError != nil {
+				abortWithOpenAiMessage(c, http.StatusServiceUnavailable, newAPIError.Error())
+				return
+			}
+			common.LogInfo(c, fmt.Sprintf("channel #%d is saturated, spilled over to channel #%d", channelId, channel.Id))
+			return
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"one-api/common"
 	"one-api/constant"
 	"one-api/dto"
+	"one-api/setting/operation_setting"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -178,6 +179,12 @@ func ChannelRateLimit() func(c *gin.Context) {
 		duration := int64(60)
 		maxCount := channelSetting.RPMLimit
 
+		// 启用排队时达到上限的请求进入渠道等待队列
+		if operation_setting.GetChannelQueueSetting().Enabled {
+			channelQueueRateLimitHandler(c, maxCount)
+			return
+		}
+
 		// 根据存储类型选择并执行限流处理器（复用现有逻辑）
 		if common.RedisEnabled {
 			redisChannelRateLimitHandler(duration, maxCount)(c)
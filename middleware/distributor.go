@@ -8,6 +8,7 @@ import (
 	"one-api/constant"
 	"one-api/dto"
 	"one-api/model"
+	"one-api/relay"
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
 	"one-api/setting"
@@ -19,6 +20,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ModelRequest struct {
@@ -28,11 +30,17 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span := common.StartSpan(c, "middleware.Distribute")
+		// span 在进入后续处理前结束，不使用 defer，提前返回的分支各自结束 span
+		abort := func(statusCode int, message string) {
+			abortWithOpenAiMessage(c, statusCode, message)
+			common.EndSpan(span, errors.New(message))
+		}
 		allowIpsMap := common.GetContextKeyStringMap(c, constant.ContextKeyTokenAllowIps)
 		if len(allowIpsMap) != 0 {
 			clientIp := c.ClientIP()
 			if _, ok := allowIpsMap[clientIp]; !ok {
-				abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 不在令牌允许访问的列表中")
+				abort(http.StatusForbidden, "您的 IP 不在令牌允许访问的列表中")
 				return
 			}
 		}
@@ -40,7 +48,7 @@ func Distribute() func(c *gin.Context) {
 		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
 		if err != nil {
-			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
+			abort(http.StatusBadRequest, "Invalid request, "+err.Error())
 			return
 		}
 		userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
@@ -53,13 +61,13 @@ func Distribute() func(c *gin.Context) {
 			// 单分组模式：检查分组可用性
 			// check common.UserUsableGroups[userGroup]
 			if _, ok := setting.GetUserUsableGroups(userGroup)[tokenGroup]; !ok {
-				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("令牌分组 %s 已被禁用", tokenGroup))
+				abort(http.StatusForbidden, fmt.Sprintf("令牌分组 %s 已被禁用", tokenGroup))
 				return
 			}
 			// check group in common.GroupRatio
 			if !ratio_setting.ContainsGroupRatio(tokenGroup) {
 				if tokenGroup != "auto" {
-					abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("分组 %s 已被弃用", tokenGroup))
+					abort(http.StatusForbidden, fmt.Sprintf("分组 %s 已被弃用", tokenGroup))
 					return
 				}
 			}
@@ -67,18 +75,22 @@ func Distribute() func(c *gin.Context) {
 		}
 		common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
 		if ok {
+			if serveResponseCache(c, modelRequest) {
+				span.End()
+				return
+			}
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
-				abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的渠道 Id")
+				abort(http.StatusBadRequest, "无效的渠道 Id")
 				return
 			}
 			channel, err = model.GetChannelById(id, true)
 			if err != nil {
-				abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的渠道 Id")
+				abort(http.StatusBadRequest, "无效的渠道 Id")
 				return
 			}
 			if channel.Status != common.ChannelStatusEnabled {
-				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
+				abort(http.StatusForbidden, "该渠道已被禁用")
 				return
 			}
 		} else {
@@ -95,17 +107,21 @@ func Distribute() func(c *gin.Context) {
 				}
 				if tokenModelLimit != nil {
 					if _, ok := tokenModelLimit[modelRequest.Model]; !ok {
-						abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
+						abort(http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
 						return
 					}
 				} else {
 					// token model limit is empty, all models are not allowed
-					abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问任何模型")
+					abort(http.StatusForbidden, "该令牌无权访问任何模型")
 					return
 				}
 			}
 
 			if shouldSelectChannel {
+				if serveResponseCache(c, modelRequest) {
+					span.End()
+					return
+				}
 				var selectGroup string
 
 				// 获取多分组信息
@@ -114,8 +130,7 @@ func Distribute() func(c *gin.Context) {
 				if isMultiGroup == true && allGroups != nil {
 					// 多分组模式：依次尝试每个分组
 					groupList := allGroups.([]string)
-					var lastErr error
-
+					var tryGroups []string
 					for _, tryGroup := range groupList {
 						// 检查分组可用性
 						if _, ok := setting.GetUserUsableGroups(userGroup)[tryGroup]; !ok {
@@ -130,15 +145,18 @@ func Distribute() func(c *gin.Context) {
 								continue
 							}
 						}
+						tryGroups = append(tryGroups, tryGroup)
+					}
 
-						channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, tryGroup, modelRequest.Model, 0)
-						if err == nil && channel != nil {
-							// 成功找到可用渠道，更新使用的分组
-							userGroup = tryGroup
-							common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
-							break
-						}
-						lastErr = err
+					var usingGroup string
+					var lastErr error
+					channel, selectGroup, usingGroup, lastErr = selectChannelWithFallback(c, tryGroups, modelRequest)
+					if lastErr == nil && channel != nil {
+						// 成功找到可用渠道，更新使用的分组
+						userGroup = usingGroup
+						common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
+					} else {
+						channel = nil
 					}
 
 					// 如果所有分组都失败了
@@ -147,12 +165,12 @@ func Distribute() func(c *gin.Context) {
 						if lastErr != nil {
 							message = fmt.Sprintf("令牌的所有分组下对于模型 %s 都无可用渠道", modelRequest.Model)
 						}
-						abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message)
+						abort(http.StatusServiceUnavailable, message)
 						return
 					}
 				} else {
 					// 单分组模式：原有逻辑
-					channel, selectGroup, err = model.CacheGetRandomSatisfiedChannel(c, userGroup, modelRequest.Model, 0)
+					channel, selectGroup, _, err = selectChannelWithFallback(c, []string{userGroup}, modelRequest)
 					if err != nil {
 						showGroup := userGroup
 						if userGroup == "auto" {
@@ -165,11 +183,11 @@ func Distribute() func(c *gin.Context) {
 							message = "数据库一致性已被破坏，请联系管理员"
 						}
 						// 如果错误，而且渠道为空，说明是没有可用渠道
-						abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message)
+						abort(http.StatusServiceUnavailable, message)
 						return
 					}
 					if channel == nil {
-						abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道（数据库一致性已被破坏）", userGroup, modelRequest.Model))
+						abort(http.StatusServiceUnavailable, fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道（数据库一致性已被破坏）", userGroup, modelRequest.Model))
 						return
 					}
 				}
@@ -177,10 +195,29 @@ func Distribute() func(c *gin.Context) {
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		if channel != nil {
+			span.SetAttributes(attribute.Int("channel.id", channel.Id), attribute.Int("channel.type", channel.Type))
+		}
+		span.SetAttributes(attribute.String("model", modelRequest.Model), attribute.String("group", common.GetContextKeyString(c, constant.ContextKeyUsingGroup)))
+		span.End()
 		c.Next()
 	}
 }
 
+// serveResponseCache 选择渠道之前查询响应缓存，命中时直接返回响应，不再选择渠道和请求上游
+func serveResponseCache(c *gin.Context, modelRequest *ModelRequest) bool {
+	// 演练场在控制器中设置临时令牌和分组后再查询
+	if strings.HasPrefix(c.Request.URL.Path, "/pg") {
+		return false
+	}
+	c.Set("original_model", modelRequest.Model)
+	if !relay.ServeResponseCache(c) {
+		return false
+	}
+	c.Abort()
+	return true
+}
+
 func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	var modelRequest ModelRequest
 	shouldSelectChannel := true
@@ -330,6 +367,45 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	return &modelRequest, shouldSelectChannel, nil
 }
 
+// CacheGetChannelWithAffinity 首次选择时优先使用粘性路由绑定的渠道，未绑定、绑定的渠道不可用或重试时按常规策略选择
+func CacheGetChannelWithAffinity(c *gin.Context, group string, modelName string, retry int) (*model.Channel, string, error) {
+	if retry == 0 {
+		if channel, selectGroup, ok := service.GetStickyChannel(c, group, modelName); ok {
+			return channel, selectGroup, nil
+		}
+	}
+	return model.CacheGetRandomSatisfiedChannel(c, group, modelName, retry)
+}
+
+// selectChannelWithFallback 依次在各分组中为请求模型选择渠道，都没有可用渠道时按各分组的降级链选择备选模型，
+// 分组优先于降级链中的顺序。选中备选模型时会更新 modelRequest.Model。
+// 返回选中的渠道、auto 分组实际选中的分组和使用的分组，失败时返回最后一次选择的结果
+func selectChannelWithFallback(c *gin.Context, groups []string, modelRequest *ModelRequest) (channel *model.Channel, selectGroup string, usingGroup string, err error) {
+	for _, usingGroup = range groups {
+		channel, selectGroup, err = CacheGetChannelWithAffinity(c, usingGroup, modelRequest.Model, 0)
+		if err == nil && channel != nil {
+			return channel, selectGroup, usingGroup, nil
+		}
+	}
+	if !service.IsModelFallbackPath(c.Request.URL.Path) {
+		return
+	}
+	// 请求模型无可用渠道时按降级链选择备选模型
+	requestModel := modelRequest.Model
+	for _, group := range groups {
+		for _, fallbackModel := range service.GetModelFallbackChain(c, group, requestModel)[1:] {
+			fallbackChannel, fallbackGroup, fallbackErr := CacheGetChannelWithAffinity(c, group, fallbackModel, 0)
+			if fallbackErr == nil && fallbackChannel != nil {
+				common.LogWarn(c, fmt.Sprintf("模型 %s 无可用渠道，改用备选模型 %s", requestModel, fallbackModel))
+				service.SetModelFallback(c, requestModel, fallbackModel)
+				modelRequest.Model = fallbackModel
+				return fallbackChannel, fallbackGroup, group, nil
+			}
+		}
+	}
+	return
+}
+
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) *types.NewAPIError {
 	c.Set("original_model", modelName) // for retry
 	if channel == nil {
@@ -348,13 +424,22 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
 	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
 
-	key, index, newAPIError := channel.GetNextEnabledKey()
-	if newAPIError != nil {
-		return newAPIError
+	key, index, stickyHit := service.GetStickyChannelKey(c, channel)
+	if !stickyHit {
+		var newAPIError *types.NewAPIError
+		key, index, newAPIError = channel.GetNextEnabledKey()
+		if newAPIError != nil {
+			return newAPIError
+		}
 	}
+	common.SetContextKey(c, constant.ContextKeyStickyRoutingHit, stickyHit)
+	service.PrepareStickyRouting(c, channel.Id, index)
 	if channel.ChannelInfo.IsMultiKey {
 		common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, true)
 		common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, index)
+		model.RecordChannelKeyRequest(channel.Id, key)
+	} else {
+		common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, false)
 	}
 	// c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	common.SetContextKey(c, constant.ContextKeyChannelKey, key)
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit 令牌与用户的并发请求数限制，TPM 限制在预扣费时按预估的输入 token 数检查
+func ConcurrencyLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		release, newAPIError := service.AcquireConcurrency(c)
+		if newAPIError != nil {
+			abortWithOpenAiMessage(c, newAPIError.StatusCode, newAPIError.Error())
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"one-api/common"
+	"sort"
 	"strings"
 	"sync"
 
@@ -57,106 +58,78 @@ func GetAllEnableAbilities() []Ability {
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
-
-	var priorities []int
-	err := DB.Model(&Ability{}).
-		Select("DISTINCT(priority)").
-		Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true).
-		Order("priority DESC").              // 按优先级降序排序
-		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
+// getEnabledChannels 数据库模式下一次查询读取分组和模型下所有启用的渠道
+func getEnabledChannels(group string, model string) ([]*Channel, error) {
+	channelIdQuery := DB.Model(&Ability{}).Select("channel_id").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
+	var channels []*Channel
+	err := DB.Where("id IN (?)", channelIdQuery).Find(&channels).Error
+	return channels, err
+}
 
+// getSatisfiedChannels 数据库模式下的 CacheGetSatisfiedChannels，过滤和排序与内存缓存模式一致
+func getSatisfiedChannels(group string, model string) []*Channel {
+	allChannels, err := getEnabledChannels(group, model)
 	if err != nil {
-		// 处理错误
-		return 0, err
-	}
-
-	if len(priorities) == 0 {
-		// 如果没有查询到优先级，则返回错误
-		return 0, errors.New("数据库一致性被破坏")
-	}
-
-	// 确定要使用的优先级
-	var priorityToUse int
-	if retry >= len(priorities) {
-		// 如果重试次数大于优先级数，则使用最小的优先级
-		priorityToUse = priorities[len(priorities)-1]
-	} else {
-		priorityToUse = priorities[retry]
+		common.SysError(fmt.Sprintf("failed to get channels of group %s model %s: %s", group, model, err.Error()))
+		return nil
 	}
-	return priorityToUse, nil
-}
-
-func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(commonGroupCol+" = ? and model = ? and enabled = ?", group, model, true)
-	channelQuery := DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = (?)", group, model, true, maxPrioritySubQuery)
-	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
-		if err != nil {
-			return nil, err
-		} else {
-			channelQuery = DB.Where(commonGroupCol+" = ? and model = ? and enabled = ? and priority = ?", group, model, true, priority)
+	channels := make([]*Channel, 0, len(allChannels))
+	for _, channel := range allChannels {
+		if IsChannelTempDisabled(channel.Id, model) || !IsCircuitBreakerAllowed(channel.Id, model) {
+			continue
+		}
+		if IsChannelSchedulable(channel) && !IsChannelKeysSaturated(channel) {
+			channels = append(channels, channel)
 		}
 	}
-
-	return channelQuery, nil
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].GetSchedulePriority() > channels[j].GetSchedulePriority()
+	})
+	return channels
 }
 
+// GetRandomSatisfiedChannel 数据库模式下的渠道选择，一次查询读取分组和模型下所有启用的渠道，
+// 之后的过滤和选择与内存缓存模式一致
 func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
-	var abilities []Ability
-
-	var err error = nil
-	channelQuery, err := getChannelQuery(group, model, retry)
+	allChannels, err := getEnabledChannels(group, model)
 	if err != nil {
 		return nil, err
 	}
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
-	} else {
-		err = channelQuery.Order("weight DESC").Find(&abilities).Error
+	// 调度窗口外的渠道不参与选择，不受下面的兜底逻辑影响
+	channels := make([]*Channel, 0, len(allChannels))
+	for _, channel := range allChannels {
+		if IsChannelSchedulable(channel) {
+			channels = append(channels, channel)
+		}
 	}
-	if err != nil {
-		return nil, err
+	if len(channels) == 0 {
+		return nil, errors.New("channel not found")
 	}
-	channel := Channel{}
-	if len(abilities) > 0 {
-		// 先尝试从未被临时禁用的渠道中选择
-		var enabledAbilities []Ability
-		for _, ability_ := range abilities {
-			if !IsChannelTempDisabled(ability_.ChannelId, model) {
-				enabledAbilities = append(enabledAbilities, ability_)
-			}
+
+	// 先尝试从未被临时禁用、熔断或所有 Key 都达到限流的渠道中选择
+	var enabledChannels []*Channel
+	for _, channel := range channels {
+		if IsChannelTempDisabled(channel.Id, model) || !IsCircuitBreakerAllowed(channel.Id, model) || IsChannelKeysSaturated(channel) {
+			continue
 		}
+		enabledChannels = append(enabledChannels, channel)
+	}
 
-		// 如果所有渠道都被禁用，则使用原始列表（忽略禁用状态）
-		abilitiesToUse := enabledAbilities
-		if len(enabledAbilities) == 0 {
-			abilitiesToUse = abilities
-			if common.DebugEnabled {
-				common.SysLog(fmt.Sprintf("所有渠道都被临时禁用，忽略禁用状态进行选择，分组: %s, 模型: %s", group, model))
+	// 如果所有渠道都被禁用，则使用原始列表（忽略禁用状态），熔断器打开的渠道仍然排除
+	if len(enabledChannels) == 0 {
+		for _, channel := range channels {
+			if !IsCircuitBreakerOpen(channel.Id, model) {
+				enabledChannels = append(enabledChannels, channel)
 			}
 		}
-
-		// Randomly choose one
-		weightSum := uint(0)
-		for _, ability_ := range abilitiesToUse {
-			weightSum += ability_.Weight + 10
+		if len(enabledChannels) == 0 {
+			return nil, errors.New("所有渠道均已熔断")
 		}
-		// Randomly choose one
-		weight := common.GetRandomInt(int(weightSum))
-		for _, ability_ := range abilitiesToUse {
-			weight -= int(ability_.Weight) + 10
-			//log.Printf("weight: %d, ability weight: %d", weight, *ability_.Weight)
-			if weight <= 0 {
-				channel.Id = ability_.ChannelId
-				break
-			}
+		if common.DebugEnabled {
+			common.SysLog(fmt.Sprintf("所有渠道都被临时禁用，忽略禁用状态进行选择，分组: %s, 模型: %s", group, model))
 		}
-	} else {
-		return nil, errors.New("channel not found")
 	}
-	err = DB.First(&channel, "id = ?", channel.Id).Error
-	return &channel, err
+	return selectChannelByPriority(group, model, enabledChannels, retry)
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -0,0 +1,290 @@
+package model
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"one-api/dto"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// File 通过 /v1/files 上传的文件以及批处理生成的输出文件，内容直接保存在数据库中
+type File struct {
+	Id        int    `json:"id"`
+	FileId    string `json:"file_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes     int64  `json:"bytes"`
+	Content   []byte `json:"-"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+type Batch struct {
+	Id               int    `json:"id"`
+	BatchId          string `json:"batch_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	ClientIp         string `json:"client_ip" gorm:"type:varchar(64)"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(16);index"`
+	Errors           string `json:"errors" gorm:"type:text"`
+	Metadata         string `json:"metadata" gorm:"type:text"`
+	TotalCount       int    `json:"total_count"`
+	CompletedCount   int    `json:"completed_count"`
+	FailedCount      int    `json:"failed_count"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+// BatchResult 批处理中单个请求的执行结果，批处理结束时汇总为输出文件后删除
+type BatchResult struct {
+	Id         int    `json:"id"`
+	BatchId    string `json:"batch_id" gorm:"type:varchar(64);index"`
+	LineIndex  int    `json:"line_index"`
+	StatusCode int    `json:"status_code"`
+	Output     []byte `json:"-"`
+}
+
+func (file *File) ToOpenAIFile() dto.OpenAIFile {
+	return dto.OpenAIFile{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    "processed",
+	}
+}
+
+func (batch *Batch) ToOpenAIBatch() dto.OpenAIBatch {
+	timestamp := func(t int64) *int64 {
+		if t == 0 {
+			return nil
+		}
+		return &t
+	}
+	fileId := func(id string) *string {
+		if id == "" {
+			return nil
+		}
+		return &id
+	}
+	openAIBatch := dto.OpenAIBatch{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     fileId(batch.OutputFileId),
+		ErrorFileId:      fileId(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     timestamp(batch.InProgressAt),
+		ExpiresAt:        timestamp(batch.ExpiresAt),
+		FinalizingAt:     timestamp(batch.FinalizingAt),
+		CompletedAt:      timestamp(batch.CompletedAt),
+		FailedAt:         timestamp(batch.FailedAt),
+		ExpiredAt:        timestamp(batch.ExpiredAt),
+		CancellingAt:     timestamp(batch.CancellingAt),
+		CancelledAt:      timestamp(batch.CancelledAt),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.TotalCount,
+			Completed: batch.CompletedCount,
+			Failed:    batch.FailedCount,
+		},
+	}
+	if batch.Errors != "" {
+		var errors dto.BatchErrors
+		if err := json.Unmarshal([]byte(batch.Errors), &errors); err == nil {
+			openAIBatch.Errors = &errors
+		}
+	}
+	if batch.Metadata != "" {
+		_ = json.Unmarshal([]byte(batch.Metadata), &openAIBatch.Metadata)
+	}
+	return openAIBatch
+}
+
+// IsFinished 批处理是否已结束，结束后不再执行请求
+func (batch *Batch) IsFinished() bool {
+	switch batch.Status {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (file *File) Insert() error {
+	return DB.Create(file).Error
+}
+
+func GetUserFileById(userId int, fileId string) (*File, error) {
+	var file File
+	err := DB.Where("user_id = ? and file_id = ?", userId, fileId).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetUserFiles 获取用户的文件列表，不包含文件内容
+func GetUserFiles(userId int, purpose string) ([]*File, error) {
+	var files []*File
+	query := DB.Omit("content").Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	err := query.Order("id desc").Find(&files).Error
+	return files, err
+}
+
+func DeleteUserFileById(userId int, fileId string) error {
+	result := DB.Where("user_id = ? and file_id = ?", userId, fileId).Delete(&File{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (batch *Batch) Insert() error {
+	return DB.Create(batch).Error
+}
+
+func GetUserBatchById(userId int, batchId string) (*Batch, error) {
+	var batch Batch
+	err := DB.Where("user_id = ? and batch_id = ?", userId, batchId).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetUserBatches 按创建时间倒序分页获取用户的批处理，after 为上一页最后一个批处理的 id
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	query := DB.Where("user_id = ?", userId)
+	if after != "" {
+		afterBatch, err := GetUserBatchById(userId, after)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id < ?", afterBatch.Id)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatches 获取所有未结束的批处理，供后台任务执行
+func GetUnfinishedBatches() ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status in ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).
+		Order("id asc").Find(&batches).Error
+	return batches, err
+}
+
+func GetBatchStatus(id int) (string, error) {
+	var status string
+	err := DB.Model(&Batch{}).Where("id = ?", id).Select("status").Scan(&status).Error
+	return status, err
+}
+
+func (batch *Batch) Update(fields map[string]interface{}) error {
+	return DB.Model(batch).Updates(fields).Error
+}
+
+// CancelBatch 仅在批处理未结束时将其标记为取消中，由后台任务完成取消
+func CancelBatch(batch *Batch) error {
+	now := common.GetTimestamp()
+	result := DB.Model(&Batch{}).
+		Where("id = ? and status in ?", batch.Id, []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]interface{}{"status": BatchStatusCancelling, "cancelling_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		batch.Status = BatchStatusCancelling
+		batch.CancellingAt = now
+	}
+	return nil
+}
+
+// SaveBatchResult 保存单个请求的执行结果并累加批处理的计数
+func SaveBatchResult(batch *Batch, result *BatchResult, success bool) error {
+	column := "failed_count"
+	if success {
+		column = "completed_count"
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(result).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Batch{}).Where("id = ?", batch.Id).Update(column, gorm.Expr(column+" + ?", 1)).Error
+	})
+}
+
+// GetBatchResultLineIndexes 获取已执行的请求行号，用于重启后跳过已完成的请求
+func GetBatchResultLineIndexes(batchId string) (map[int]bool, error) {
+	var lineIndexes []int
+	err := DB.Model(&BatchResult{}).Where("batch_id = ?", batchId).Pluck("line_index", &lineIndexes).Error
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(lineIndexes))
+	for _, lineIndex := range lineIndexes {
+		done[lineIndex] = true
+	}
+	return done, nil
+}
+
+func GetBatchResults(batchId string) ([]*BatchResult, error) {
+	var results []*BatchResult
+	err := DB.Where("batch_id = ?", batchId).Order("line_index asc").Find(&results).Error
+	return results, err
+}
+
+// FinishBatch 保存输出文件并更新批处理的最终状态，同时删除中间结果
+func FinishBatch(batch *Batch, files []*File, fields map[string]interface{}) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, file := range files {
+			if err := tx.Create(file).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(batch).Updates(fields).Error; err != nil {
+			return err
+		}
+		return tx.Where("batch_id = ?", batch.BatchId).Delete(&BatchResult{}).Error
+	})
+}
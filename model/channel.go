@@ -12,6 +12,7 @@ import (
 	"one-api/types"
 	"strings"
 	"sync"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -101,17 +102,7 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		return "", 0, types.NewError(errors.New("no keys available"), types.ErrorCodeChannelNoAvailableKey)
 	}
 
-	statusList := channel.ChannelInfo.MultiKeyStatusList
-	// helper to get key status, default to enabled when missing
-	getStatus := func(idx int) int {
-		if statusList == nil {
-			return common.ChannelStatusEnabled
-		}
-		if status, ok := statusList[idx]; ok {
-			return status
-		}
-		return common.ChannelStatusEnabled
-	}
+	getStatus := channel.getKeyStatus
 
 	// Collect indexes of enabled keys
 	enabledIdx := make([]int, 0, len(keys))
@@ -125,7 +116,48 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		return keys[0], 0, nil
 	}
 
+	// 跳过处于休息时段或达到单 Key 限流的 Key，全部不可用时仍从所有启用的 Key 中选择
+	rules := getChannelKeyRules(channel)
+	if rules.hasLimits() {
+		now := time.Now()
+		available := func(idx int) bool {
+			return isChannelKeyInSchedule(channel, idx, now) &&
+				isChannelKeyWithinLimit(channel.Id, rules.keyHashes[idx], rules.rpmLimit, rules.tpmLimit)
+		}
+		availableIdx := make([]int, 0, len(enabledIdx))
+		for _, idx := range enabledIdx {
+			if available(idx) {
+				availableIdx = append(availableIdx, idx)
+			}
+		}
+		if len(availableIdx) > 0 {
+			enabledIdx = availableIdx
+			getStatus = func(idx int) int {
+				if !available(idx) {
+					return common.ChannelStatusAutoDisabled
+				}
+				return channel.getKeyStatus(idx)
+			}
+		}
+	}
+
 	switch channel.ChannelInfo.MultiKeyMode {
+	case constant.MultiKeyModeLeastRateLimited:
+		// 选择最久未被上游限流的 Key，从未限流的 Key 优先，相同时随机选择
+		candidates := make([]int, 0, len(enabledIdx))
+		var oldest time.Time
+		for _, idx := range enabledIdx {
+			lastRateLimited := getChannelKeyLastRateLimited(channel.Id, channelKeyHash(keys[idx]))
+			if len(candidates) == 0 || lastRateLimited.Before(oldest) {
+				candidates = candidates[:0]
+				oldest = lastRateLimited
+			}
+			if lastRateLimited.Equal(oldest) {
+				candidates = append(candidates, idx)
+			}
+		}
+		selectedIdx := candidates[rand.Intn(len(candidates))]
+		return keys[selectedIdx], selectedIdx, nil
 	case constant.MultiKeyModeRandom:
 		// Randomly pick one enabled key
 		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
@@ -172,6 +204,38 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 	}
 }
 
+// getKeyStatus 返回 Key 的状态，状态列表中没有记录时为启用
+func (channel *Channel) getKeyStatus(index int) int {
+	if status, ok := channel.ChannelInfo.MultiKeyStatusList[index]; ok {
+		return status
+	}
+	return common.ChannelStatusEnabled
+}
+
+// GetKeyByIndex 返回指定下标的密钥，不检查密钥状态
+func (channel *Channel) GetKeyByIndex(index int) (string, bool) {
+	keys := channel.getKeys()
+	if index < 0 || index >= len(keys) {
+		return "", false
+	}
+	return keys[index], true
+}
+
+// GetEnabledKeyByIndex 返回指定下标的密钥，下标越界、密钥已被禁用或处于休息时段时返回 false
+func (channel *Channel) GetEnabledKeyByIndex(index int) (string, bool) {
+	if !channel.ChannelInfo.IsMultiKey {
+		return channel.Key, index == 0
+	}
+	keys := channel.getKeys()
+	if index < 0 || index >= len(keys) {
+		return "", false
+	}
+	if channel.getKeyStatus(index) != common.ChannelStatusEnabled || !isChannelKeyInSchedule(channel, index, time.Now()) {
+		return "", false
+	}
+	return keys[index], true
+}
+
 func (channel *Channel) SaveChannelInfo() error {
 	return DB.Model(channel).Update("channel_info", channel.ChannelInfo).Error
 }
@@ -619,6 +683,48 @@ func UpdateChannelStatus(channelId int, usingKey string, status int, reason stri
 	return true
 }
 
+// EnableChannelKey 重新启用多 Key 渠道中被自动禁用的 Key，渠道因所有 Key 被禁用而自动禁用时一并启用
+func EnableChannelKey(channelId int, keyIndex int) error {
+	channelStatusLock.Lock()
+	defer channelStatusLock.Unlock()
+
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		return err
+	}
+	if !channel.ChannelInfo.IsMultiKey {
+		return errors.New("channel is not in multi-key mode")
+	}
+	if channel.getKeyStatus(keyIndex) != common.ChannelStatusAutoDisabled {
+		return nil
+	}
+	delete(channel.ChannelInfo.MultiKeyStatusList, keyIndex)
+	channelEnabled := false
+	if channel.Status == common.ChannelStatusAutoDisabled {
+		channel.Status = common.ChannelStatusEnabled
+		channelEnabled = true
+	}
+	if err = channel.Save(); err != nil {
+		return err
+	}
+	if channelEnabled {
+		if err = UpdateAbilityStatus(channelId, true); err != nil {
+			common.SysError("failed to update ability status: " + err.Error())
+		}
+	}
+	if channelEnabled {
+		// 自动禁用的渠道不在分组模型索引中，需要重新加载缓存才能被选中
+		InitChannelCache()
+	} else if common.MemoryCacheEnabled {
+		channelSyncLock.Lock()
+		if channelCache, ok := channelsIDM[channelId]; ok {
+			delete(channelCache.ChannelInfo.MultiKeyStatusList, keyIndex)
+		}
+		channelSyncLock.Unlock()
+	}
+	return nil
+}
+
 func EnableChannelByTag(tag string) error {
 	err := DB.Model(&Channel{}).Where("tag = ?", tag).Update("status", common.ChannelStatusEnabled).Error
 	if err != nil {
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"one-api/common"
 	"one-api/setting"
+	"one-api/setting/operation_setting"
 	"sort"
 	"strings"
 	"sync"
@@ -27,6 +28,10 @@ func InitChannelCache() {
 	DB.Find(&channels)
 	for _, channel := range channels {
 		newChannelId2channel[channel.Id] = channel
+		if channel.ChannelInfo.IsMultiKey {
+			// 同步时解析单 Key 限流设置，选择渠道时直接使用
+			getChannelKeyRules(channel)
+		}
 	}
 	var abilities []*Ability
 	DB.Find(&abilities)
@@ -115,13 +120,69 @@ func CacheGetRandomSatisfiedChannel(c *gin.Context, group string, model string,
 	return channel, selectGroup, nil
 }
 
-func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func normalizeAbilityModel(model string) string {
 	if strings.HasPrefix(model, "gpt-4-gizmo") {
-		model = "gpt-4-gizmo-*"
+		return "gpt-4-gizmo-*"
 	}
 	if strings.HasPrefix(model, "gpt-4o-gizmo") {
-		model = "gpt-4o-gizmo-*"
+		return "gpt-4o-gizmo-*"
+	}
+	return model
+}
+
+// CacheGetSatisfiedChannels 获取分组下支持该模型的全部可用渠道（排除临时禁用、熔断和处于调度窗口外的渠道），按优先级从高到低排列。
+// 未启用内存缓存时从数据库读取
+func CacheGetSatisfiedChannels(group string, model string) []*Channel {
+	if !common.MemoryCacheEnabled {
+		return getSatisfiedChannels(group, model)
+	}
+	model = normalizeAbilityModel(model)
+
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	channels := make([]*Channel, 0, len(group2model2channels[group][model]))
+	for _, channelId := range group2model2channels[group][model] {
+		if IsChannelTempDisabled(channelId, model) || !IsCircuitBreakerAllowed(channelId, model) {
+			continue
+		}
+		if channel, ok := channelsIDM[channelId]; ok && IsChannelSchedulable(channel) && !IsChannelKeysSaturated(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].GetSchedulePriority() > channels[j].GetSchedulePriority()
+	})
+	return channels
+}
+
+// IsChannelSatisfied 检查渠道是否仍可用于分组下的模型（已启用、未被临时禁用或熔断且处于调度窗口内）
+func IsChannelSatisfied(group string, model string, channelId int) bool {
+	model = normalizeAbilityModel(model)
+	if IsChannelTempDisabled(channelId, model) || !IsCircuitBreakerAllowed(channelId, model) {
+		return false
+	}
+	if !common.MemoryCacheEnabled {
+		var count int64
+		err := DB.Model(&Ability{}).Where(commonGroupCol+" = ? and model = ? and channel_id = ? and enabled = ?", group, model, channelId, true).Count(&count).Error
+		if err != nil || count == 0 {
+			return false
+		}
+		channel, err := GetChannelById(channelId, false)
+		return err == nil && IsChannelSchedulable(channel)
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	for _, id := range group2model2channels[group][model] {
+		if id == channelId {
+			channel, ok := channelsIDM[channelId]
+			return ok && IsChannelSchedulable(channel) && !IsChannelKeysSaturated(channel)
+		}
 	}
+	return false
+}
+
+func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+	model = normalizeAbilityModel(model)
 
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
@@ -130,45 +191,75 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
-	channels := group2model2channels[group][model]
+	// 调度窗口外的渠道不参与选择，不受下面的兜底逻辑影响
+	channels := make([]int, 0, len(group2model2channels[group][model]))
+	for _, channelId := range group2model2channels[group][model] {
+		if channel, ok := channelsIDM[channelId]; ok && !IsChannelSchedulable(channel) {
+			continue
+		}
+		channels = append(channels, channelId)
+	}
 
 	if len(channels) == 0 {
 		return nil, errors.New("channel not found")
 	}
 
-	if len(channels) == 1 {
-		if channel, ok := channelsIDM[channels[0]]; ok {
-			return channel, nil
-		}
-		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channels[0])
-	}
-
-	// 过滤掉被临时禁用的渠道
+	// 过滤掉被临时禁用、熔断或所有 Key 都达到限流的渠道
 	var enabledChannels []int
 	for _, channelId := range channels {
-		if !IsChannelTempDisabled(channelId, model) {
-			enabledChannels = append(enabledChannels, channelId)
+		if IsChannelTempDisabled(channelId, model) || !IsCircuitBreakerAllowed(channelId, model) {
+			continue
 		}
+		if channel, ok := channelsIDM[channelId]; ok && IsChannelKeysSaturated(channel) {
+			continue
+		}
+		enabledChannels = append(enabledChannels, channelId)
 	}
 
-	// 如果所有渠道都被禁用，使用原始列表（保持兜底逻辑）
+	// 如果所有渠道都被禁用，使用原始列表（保持兜底逻辑），熔断器打开的渠道仍然排除，
+	// 否则只有一个渠道的模型永远无法熔断
 	if len(enabledChannels) == 0 {
+		for _, channelId := range channels {
+			if !IsCircuitBreakerOpen(channelId, model) {
+				enabledChannels = append(enabledChannels, channelId)
+			}
+		}
+		if len(enabledChannels) == 0 {
+			return nil, errors.New("所有渠道均已熔断")
+		}
 		if common.DebugEnabled {
 			common.SysLog(fmt.Sprintf("所有渠道都被临时禁用，忽略禁用状态，分组: %s, 模型: %s", group, model))
 		}
-		enabledChannels = channels
 	}
 
 	// 使用过滤后的列表进行后续处理
 	channels = enabledChannels
 
-	uniquePriorities := make(map[int]bool)
+	candidates := make([]*Channel, 0, len(channels))
 	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			uniquePriorities[int(channel.GetPriority())] = true
-		} else {
+		channel, ok := channelsIDM[channelId]
+		if !ok {
 			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
 		}
+		candidates = append(candidates, channel)
+	}
+	return selectChannelByPriority(group, model, candidates, retry)
+}
+
+// selectChannelByPriority 按重试次数选择优先级档位（调度窗口外的优先级同样生效），
+// 在该档位内先按分组或渠道标签配置的自适应策略选择，未配置策略时按权重随机选择。
+// 内存缓存和数据库模式共用，channels 为已过滤的候选渠道
+func selectChannelByPriority(group string, model string, channels []*Channel, retry int) (*Channel, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	if len(channels) == 1 {
+		return channels[0], nil
+	}
+
+	uniquePriorities := make(map[int]bool)
+	for _, channel := range channels {
+		uniquePriorities[int(channel.GetSchedulePriority())] = true
 	}
 	var sortedUniquePriorities []int
 	for priority := range uniquePriorities {
@@ -182,33 +273,34 @@ func getRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	targetPriority := int64(sortedUniquePriorities[retry])
 
 	// get the priority for the given retry number
-	var targetChannels []*Channel
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			if channel.GetPriority() == targetPriority {
-				targetChannels = append(targetChannels, channel)
-			}
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+	var channelsToUse []*Channel
+	for _, channel := range channels {
+		if channel.GetSchedulePriority() == targetPriority {
+			channelsToUse = append(channelsToUse, channel)
 		}
 	}
 
-	// 使用目标优先级的所有渠道（已经在前面过滤了禁用的渠道）
-	channelsToUse := targetChannels
+	// 自适应选择：按分组或渠道标签配置的策略，使用实时统计动态加权
+	if len(channelsToUse) > 1 {
+		strategy := operation_setting.GetChannelSelectSetting().GetStrategy(group, getChannelTags(channelsToUse))
+		if channel := selectAdaptiveChannel(strategy, model, channelsToUse); channel != nil {
+			return channel, nil
+		}
+	}
 
 	// 平滑系数
 	smoothingFactor := 10
 	// Calculate the total weight of all channels up to endIdx
 	totalWeight := 0
 	for _, channel := range channelsToUse {
-		totalWeight += channel.GetWeight() + smoothingFactor
+		totalWeight += channel.GetScheduleWeight() + smoothingFactor
 	}
 	// Generate a random value in the range [0, totalWeight)
 	randomWeight := rand.Intn(totalWeight)
 
 	// Find a channel based on its weight
 	for _, channel := range channelsToUse {
-		randomWeight -= channel.GetWeight() + smoothingFactor
+		randomWeight -= channel.GetScheduleWeight() + smoothingFactor
 		if randomWeight < 0 {
 			return channel, nil
 		}
@@ -0,0 +1,409 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"one-api/common"
+	"one-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// 熔断器状态
+const (
+	CircuitStateClosed   = "closed"
+	CircuitStateOpen     = "open"
+	CircuitStateHalfOpen = "half_open"
+)
+
+// Redis 中保存所有熔断器状态的 hash，field 为 channelId:modelName
+const circuitBreakerRedisKey = "channel_circuit_breaker"
+
+// 半开探测名额的最长占用时间，请求异常结束未归还名额时到期自动释放
+const circuitBreakerProbeLease = 2 * time.Minute
+
+// ChannelCircuitBreaker 渠道+模型熔断器
+type ChannelCircuitBreaker struct {
+	ChannelId           int
+	ModelName           string
+	State               string
+	ConsecutiveFailures int
+	WindowStart         time.Time
+	WindowRequests      int
+	WindowFailures      int
+	OpenedAt            time.Time
+	HalfOpenSuccesses   int
+	LastProbeTime       time.Time
+	Reason              string
+	UpdatedAt           int64       // 状态变更时间（毫秒），用于多节点同步时判断新旧
+	probeLeases         []time.Time // 半开状态下正在进行的真实请求探测的开始时间
+	mu                  sync.Mutex
+}
+
+// CircuitBreakerSnapshot 熔断器状态快照，用于管理接口和 Redis 同步
+type CircuitBreakerSnapshot struct {
+	ChannelId           int    `json:"channel_id"`
+	ModelName           string `json:"model_name"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	WindowRequests      int    `json:"window_requests"`
+	WindowFailures      int    `json:"window_failures"`
+	OpenedAt            int64  `json:"opened_at"`
+	HalfOpenSuccesses   int    `json:"half_open_successes"`
+	ProbesInFlight      int    `json:"probes_in_flight"`
+	Reason              string `json:"reason"`
+	UpdatedAt           int64  `json:"updated_at"`
+}
+
+var circuitBreakers sync.Map // map[channelId:modelName]*ChannelCircuitBreaker
+
+func getCircuitBreaker(channelId int, modelName string) *ChannelCircuitBreaker {
+	key := getMonitorKey(channelId, modelName)
+	if value, ok := circuitBreakers.Load(key); ok {
+		return value.(*ChannelCircuitBreaker)
+	}
+	value, _ := circuitBreakers.LoadOrStore(key, &ChannelCircuitBreaker{
+		ChannelId:   channelId,
+		ModelName:   modelName,
+		State:       CircuitStateClosed,
+		WindowStart: time.Now(),
+	})
+	return value.(*ChannelCircuitBreaker)
+}
+
+func (b *ChannelCircuitBreaker) snapshotLocked() CircuitBreakerSnapshot {
+	openedAt := int64(0)
+	if !b.OpenedAt.IsZero() {
+		openedAt = b.OpenedAt.Unix()
+	}
+	return CircuitBreakerSnapshot{
+		ChannelId:           b.ChannelId,
+		ModelName:           b.ModelName,
+		State:               b.State,
+		ConsecutiveFailures: b.ConsecutiveFailures,
+		WindowRequests:      b.WindowRequests,
+		WindowFailures:      b.WindowFailures,
+		OpenedAt:            openedAt,
+		HalfOpenSuccesses:   b.HalfOpenSuccesses,
+		ProbesInFlight:      len(b.probeLeases),
+		Reason:              b.Reason,
+		UpdatedAt:           b.UpdatedAt,
+	}
+}
+
+// transitionLocked 切换状态并在启用 Redis 时广播，调用方需持有锁。
+// 日志在锁外异步写入，避免写库变慢时阻塞渠道选择
+func (b *ChannelCircuitBreaker) transitionLocked(state string, reason string) {
+	if b.State == state {
+		return
+	}
+	oldState := b.State
+	b.State = state
+	b.Reason = reason
+	b.UpdatedAt = time.Now().UnixMilli()
+	b.HalfOpenSuccesses = 0
+	b.probeLeases = nil
+	switch state {
+	case CircuitStateOpen:
+		b.OpenedAt = time.Now()
+	case CircuitStateClosed:
+		b.ConsecutiveFailures = 0
+		b.resetWindowLocked(time.Now())
+	}
+
+	message := fmt.Sprintf("渠道 #%d 的模型 %s 熔断器状态 %s -> %s", b.ChannelId, b.ModelName, oldState, state)
+	if reason != "" {
+		message += "，原因：" + reason
+	}
+	gopool.Go(func() {
+		common.SysLog(message)
+		if state != CircuitStateHalfOpen {
+			RecordLog(0, LogTypeSystem, message)
+		}
+	})
+
+	if common.RedisEnabled {
+		snapshot := b.snapshotLocked()
+		go saveCircuitBreakerToRedis(snapshot)
+	}
+}
+
+func (b *ChannelCircuitBreaker) resetWindowLocked(now time.Time) {
+	b.WindowStart = now
+	b.WindowRequests = 0
+	b.WindowFailures = 0
+}
+
+// refreshLocked 处理窗口滚动以及打开状态到期转入半开
+func (b *ChannelCircuitBreaker) refreshLocked(setting *operation_setting.CircuitBreakerSetting) {
+	now := time.Now()
+	window := time.Duration(setting.WindowSeconds) * time.Second
+	if window > 0 && now.Sub(b.WindowStart) >= window {
+		b.resetWindowLocked(now)
+	}
+	if b.State == CircuitStateOpen && now.Sub(b.OpenedAt) >= time.Duration(setting.OpenSeconds)*time.Second {
+		b.transitionLocked(CircuitStateHalfOpen, "熔断时间到期，进入半开探测")
+	}
+	for len(b.probeLeases) > 0 && now.Sub(b.probeLeases[0]) >= circuitBreakerProbeLease {
+		b.probeLeases = b.probeLeases[1:]
+	}
+}
+
+func (b *ChannelCircuitBreaker) releaseProbeLocked() {
+	if len(b.probeLeases) > 0 {
+		b.probeLeases = b.probeLeases[1:]
+	}
+}
+
+// IsCircuitBreakerAllowed 判断渠道+模型当前是否允许接收流量，只读不占用名额。
+// 半开状态下仅在探测名额未用完时放行，名额由实际发起请求时的 AcquireCircuitBreakerProbe 占用
+func IsCircuitBreakerAllowed(channelId int, modelName string) bool {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled {
+		return true
+	}
+	value, ok := circuitBreakers.Load(getMonitorKey(channelId, modelName))
+	if !ok {
+		return true
+	}
+	b := value.(*ChannelCircuitBreaker)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked(setting)
+	switch b.State {
+	case CircuitStateOpen:
+		return false
+	case CircuitStateHalfOpen:
+		return len(b.probeLeases) < setting.HalfOpenMaxProbes
+	}
+	return true
+}
+
+// IsCircuitBreakerOpen 熔断器是否处于打开状态，所有渠道都不可用时的兜底选择同样排除这些渠道
+func IsCircuitBreakerOpen(channelId int, modelName string) bool {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled {
+		return false
+	}
+	value, ok := circuitBreakers.Load(getMonitorKey(channelId, modelName))
+	if !ok {
+		return false
+	}
+	b := value.(*ChannelCircuitBreaker)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked(setting)
+	return b.State == CircuitStateOpen
+}
+
+// AcquireCircuitBreakerProbe 向渠道实际发起请求时调用，半开状态下占用一个探测名额，
+// 名额在记录成功或失败时归还
+func AcquireCircuitBreakerProbe(channelId int, modelName string) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled {
+		return
+	}
+	value, ok := circuitBreakers.Load(getMonitorKey(channelId, modelName))
+	if !ok {
+		return
+	}
+	b := value.(*ChannelCircuitBreaker)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked(setting)
+	if b.State == CircuitStateHalfOpen {
+		b.probeLeases = append(b.probeLeases, time.Now())
+	}
+}
+
+// ReleaseCircuitBreakerProbe 请求未产生可计入熔断器的结果时（如被取消）归还探测名额
+func ReleaseCircuitBreakerProbe(channelId int, modelName string) {
+	value, ok := circuitBreakers.Load(getMonitorKey(channelId, modelName))
+	if !ok {
+		return
+	}
+	b := value.(*ChannelCircuitBreaker)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.releaseProbeLocked()
+}
+
+// RecordCircuitBreakerSuccess 记录一次成功请求
+func RecordCircuitBreakerSuccess(channelId int, modelName string) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || channelId == 0 || modelName == "" {
+		return
+	}
+	value, ok := circuitBreakers.Load(getMonitorKey(channelId, modelName))
+	if !ok {
+		// 没有失败记录的渠道无需创建熔断器
+		return
+	}
+	b := value.(*ChannelCircuitBreaker)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked(setting)
+	b.releaseProbeLocked()
+	b.ConsecutiveFailures = 0
+	b.WindowRequests++
+	if b.State == CircuitStateHalfOpen {
+		b.HalfOpenSuccesses++
+		if b.HalfOpenSuccesses >= setting.HalfOpenSuccesses {
+			b.transitionLocked(CircuitStateClosed, "半开探测成功")
+		}
+	}
+}
+
+// RecordCircuitBreakerFailure 记录一次上游失败，满足条件时打开熔断器
+func RecordCircuitBreakerFailure(channelId int, modelName string, reason string) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || channelId == 0 || modelName == "" {
+		return
+	}
+	b := getCircuitBreaker(channelId, modelName)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked(setting)
+	b.releaseProbeLocked()
+	b.ConsecutiveFailures++
+	b.WindowRequests++
+	b.WindowFailures++
+
+	switch b.State {
+	case CircuitStateHalfOpen:
+		b.transitionLocked(CircuitStateOpen, "半开探测失败: "+reason)
+	case CircuitStateClosed:
+		if setting.ConsecutiveFailures > 0 && b.ConsecutiveFailures >= setting.ConsecutiveFailures {
+			b.transitionLocked(CircuitStateOpen, fmt.Sprintf("连续失败 %d 次: %s", b.ConsecutiveFailures, reason))
+			return
+		}
+		if setting.ErrorRatioThreshold > 0 && b.WindowRequests >= setting.MinRequests {
+			ratio := float64(b.WindowFailures) / float64(b.WindowRequests)
+			if ratio >= setting.ErrorRatioThreshold {
+				b.transitionLocked(CircuitStateOpen, fmt.Sprintf("错误率 %.2f 超过阈值 %.2f: %s", ratio, setting.ErrorRatioThreshold, reason))
+			}
+		}
+	}
+}
+
+// GetAllCircuitBreakers 获取所有熔断器状态
+func GetAllCircuitBreakers() []CircuitBreakerSnapshot {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	result := make([]CircuitBreakerSnapshot, 0)
+	circuitBreakers.Range(func(key, value interface{}) bool {
+		b := value.(*ChannelCircuitBreaker)
+		b.mu.Lock()
+		b.refreshLocked(setting)
+		result = append(result, b.snapshotLocked())
+		b.mu.Unlock()
+		return true
+	})
+	return result
+}
+
+// ResetCircuitBreaker 手动关闭熔断器
+func ResetCircuitBreaker(channelId int, modelName string) {
+	b := getCircuitBreaker(channelId, modelName)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transitionLocked(CircuitStateClosed, "管理员手动重置")
+}
+
+// TripCircuitBreaker 手动打开熔断器
+func TripCircuitBreaker(channelId int, modelName string) {
+	b := getCircuitBreaker(channelId, modelName)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.transitionLocked(CircuitStateOpen, "管理员手动熔断")
+}
+
+// GetHalfOpenCircuitBreakersForProbe 获取需要主动探测的半开熔断器，并记录探测时间避免重复探测
+func GetHalfOpenCircuitBreakersForProbe(interval time.Duration) []CircuitBreakerSnapshot {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	result := make([]CircuitBreakerSnapshot, 0)
+	if !setting.Enabled || !setting.SyntheticProbeEnabled {
+		return result
+	}
+	circuitBreakers.Range(func(key, value interface{}) bool {
+		b := value.(*ChannelCircuitBreaker)
+		b.mu.Lock()
+		b.refreshLocked(setting)
+		if b.State == CircuitStateHalfOpen && time.Since(b.LastProbeTime) >= interval {
+			b.LastProbeTime = time.Now()
+			result = append(result, b.snapshotLocked())
+		}
+		b.mu.Unlock()
+		return true
+	})
+	return result
+}
+
+func saveCircuitBreakerToRedis(snapshot CircuitBreakerSnapshot) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return
+	}
+	field := getMonitorKey(snapshot.ChannelId, snapshot.ModelName)
+	if err := common.RDB.HSet(context.Background(), circuitBreakerRedisKey, field, string(data)).Err(); err != nil {
+		common.SysError("failed to save circuit breaker to redis: " + err.Error())
+	}
+}
+
+// syncCircuitBreakersFromRedis 从 Redis 拉取其他节点的状态变更，较新的状态覆盖本地
+func syncCircuitBreakersFromRedis() {
+	values, err := common.RDB.HGetAll(context.Background(), circuitBreakerRedisKey).Result()
+	if err != nil {
+		common.SysError("failed to sync circuit breakers from redis: " + err.Error())
+		return
+	}
+	expiredBefore := time.Now().Add(-time.Hour).UnixMilli()
+	for field, data := range values {
+		var snapshot CircuitBreakerSnapshot
+		if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+			continue
+		}
+		// 已关闭且长时间无变化的记录直接清理
+		if snapshot.State == CircuitStateClosed && snapshot.UpdatedAt < expiredBefore {
+			common.RDB.HDel(context.Background(), circuitBreakerRedisKey, field)
+			continue
+		}
+		b := getCircuitBreaker(snapshot.ChannelId, snapshot.ModelName)
+		b.mu.Lock()
+		if snapshot.UpdatedAt > b.UpdatedAt {
+			b.State = snapshot.State
+			b.Reason = snapshot.Reason
+			b.UpdatedAt = snapshot.UpdatedAt
+			b.HalfOpenSuccesses = 0
+			if snapshot.OpenedAt > 0 {
+				b.OpenedAt = time.Unix(snapshot.OpenedAt, 0)
+			}
+			if snapshot.State == CircuitStateClosed {
+				b.ConsecutiveFailures = 0
+				b.resetWindowLocked(time.Now())
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// InitCircuitBreaker 启动熔断器状态同步
+func InitCircuitBreaker() {
+	if !common.RedisEnabled {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if operation_setting.GetCircuitBreakerSetting().Enabled {
+				syncCircuitBreakersFromRedis()
+			}
+		}
+	}()
+	common.SysLog("渠道熔断器状态同步已启动")
+}
@@ -0,0 +1,272 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"one-api/common"
+)
+
+// 多 Key 渠道的单 Key 限流窗口
+const channelKeyRateLimitWindow = time.Minute
+
+// channelKeyRules 多 Key 渠道的单 Key 限流设置和各个 Key 的哈希，同步渠道缓存时解析，
+// 按渠道密钥和设置的原始内容缓存，变化时重新解析，避免每次选择渠道都解析设置和拆分密钥
+type channelKeyRules struct {
+	rawKey       string
+	rawSetting   string
+	rpmLimit     int
+	tpmLimit     int
+	hasSchedules bool
+	keyHashes    []string
+}
+
+var channelKeyRulesCache sync.Map // map[channelId]*channelKeyRules
+
+func getChannelKeyRules(channel *Channel) *channelKeyRules {
+	rawSetting := ""
+	if channel.Setting != nil {
+		rawSetting = *channel.Setting
+	}
+	if value, ok := channelKeyRulesCache.Load(channel.Id); ok {
+		if rules := value.(*channelKeyRules); rules.rawKey == channel.Key && rules.rawSetting == rawSetting {
+			return rules
+		}
+	}
+	setting := channel.GetSetting()
+	rules := &channelKeyRules{
+		rawKey:       channel.Key,
+		rawSetting:   rawSetting,
+		rpmLimit:     setting.KeyRPMLimit,
+		tpmLimit:     setting.KeyTPMLimit,
+		hasSchedules: len(setting.KeySchedules) > 0,
+	}
+	for _, key := range channel.getKeys() {
+		rules.keyHashes = append(rules.keyHashes, channelKeyHash(key))
+	}
+	channelKeyRulesCache.Store(channel.Id, rules)
+	return rules
+}
+
+// hasLimits 是否设置了单 Key 限流或 Key 调度规则
+func (r *channelKeyRules) hasLimits() bool {
+	return r.rpmLimit > 0 || r.tpmLimit > 0 || r.hasSchedules
+}
+
+// channelKeyHash 统计按 Key 的哈希保存，Key 被编辑或调整顺序后统计仍对应原来的 Key
+func channelKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ChannelKeyStats 多 Key 渠道中单个 Key 的运行统计，保存在内存中，重启后清零
+type ChannelKeyStats struct {
+	ChannelId       int
+	KeyHash         string
+	Requests        int64
+	Errors          int64
+	RateLimited     int64 // 上游返回 429 的次数
+	LastError       string
+	LastErrorAt     time.Time
+	LastRateLimited time.Time
+	QuotaUsed       int64
+	windowStart     time.Time
+	windowRequests  int
+	windowTokens    int
+	mu              sync.Mutex
+}
+
+// ChannelKeyStatsSnapshot 统计快照，供管理接口展示
+type ChannelKeyStatsSnapshot struct {
+	Requests        int64  `json:"requests"`
+	Errors          int64  `json:"errors"`
+	RateLimited     int64  `json:"rate_limited"`
+	LastError       string `json:"last_error"`
+	LastErrorAt     int64  `json:"last_error_at"`
+	LastRateLimited int64  `json:"last_rate_limited_at"`
+	QuotaUsed       int64  `json:"quota_used"`
+	WindowRequests  int    `json:"window_requests"` // 当前分钟内的请求数
+	WindowTokens    int    `json:"window_tokens"`   // 当前分钟内的 token 数
+}
+
+var channelKeyStats sync.Map // map[channelId:keyHash]*ChannelKeyStats
+
+func getChannelKeyStats(channelId int, keyHash string) *ChannelKeyStats {
+	key := fmt.Sprintf("%d:%s", channelId, keyHash)
+	if value, ok := channelKeyStats.Load(key); ok {
+		return value.(*ChannelKeyStats)
+	}
+	value, _ := channelKeyStats.LoadOrStore(key, &ChannelKeyStats{
+		ChannelId: channelId,
+		KeyHash:   keyHash,
+	})
+	return value.(*ChannelKeyStats)
+}
+
+func loadChannelKeyStats(channelId int, keyHash string) (*ChannelKeyStats, bool) {
+	value, ok := channelKeyStats.Load(fmt.Sprintf("%d:%s", channelId, keyHash))
+	if !ok {
+		return nil, false
+	}
+	return value.(*ChannelKeyStats), true
+}
+
+// rollWindow 窗口过期时重新计数，调用方需持有锁
+func (s *ChannelKeyStats) rollWindow(now time.Time) {
+	if now.Sub(s.windowStart) >= channelKeyRateLimitWindow {
+		s.windowStart = now
+		s.windowRequests = 0
+		s.windowTokens = 0
+	}
+}
+
+func (s *ChannelKeyStats) snapshot() ChannelKeyStatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollWindow(time.Now())
+	snapshot := ChannelKeyStatsSnapshot{
+		Requests:       s.Requests,
+		Errors:         s.Errors,
+		RateLimited:    s.RateLimited,
+		LastError:      s.LastError,
+		QuotaUsed:      s.QuotaUsed,
+		WindowRequests: s.windowRequests,
+		WindowTokens:   s.windowTokens,
+	}
+	if !s.LastErrorAt.IsZero() {
+		snapshot.LastErrorAt = s.LastErrorAt.Unix()
+	}
+	if !s.LastRateLimited.IsZero() {
+		snapshot.LastRateLimited = s.LastRateLimited.Unix()
+	}
+	return snapshot
+}
+
+// withinLimit 当前窗口内的请求数和 token 数是否都未达到上限，上限为 0 表示不限制
+func (s *ChannelKeyStats) withinLimit(rpm int, tpm int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollWindow(time.Now())
+	if rpm > 0 && s.windowRequests >= rpm {
+		return false
+	}
+	if tpm > 0 && s.windowTokens >= tpm {
+		return false
+	}
+	return true
+}
+
+// RecordChannelKeyRequest 记录一次选中该 Key 的请求，请求失败时由 RecordChannelKeyError 从限流窗口中扣除
+func RecordChannelKeyRequest(channelId int, key string) {
+	stats := getChannelKeyStats(channelId, channelKeyHash(key))
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.rollWindow(time.Now())
+	stats.Requests++
+	stats.windowRequests++
+}
+
+// RecordChannelKeyUsage 记录请求完成后该 Key 消耗的 token 数和额度
+func RecordChannelKeyUsage(channelId int, key string, tokens int, quota int) {
+	if channelId == 0 || key == "" {
+		return
+	}
+	stats := getChannelKeyStats(channelId, channelKeyHash(key))
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.rollWindow(time.Now())
+	stats.windowTokens += tokens
+	stats.QuotaUsed += int64(quota)
+}
+
+// RecordChannelKeyError 记录使用该 Key 的一次失败请求，429 额外记录限流时间。
+// 失败的请求会被重试到其他渠道或 Key，不再占用该 Key 的限流窗口
+func RecordChannelKeyError(channelId int, usingKey string, statusCode int, message string) {
+	if usingKey == "" || !isMultiKeyChannel(channelId) {
+		return
+	}
+	stats := getChannelKeyStats(channelId, channelKeyHash(usingKey))
+	now := time.Now()
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.rollWindow(now)
+	if stats.windowRequests > 0 {
+		stats.windowRequests--
+	}
+	stats.Errors++
+	stats.LastError = message
+	stats.LastErrorAt = now
+	if statusCode == http.StatusTooManyRequests {
+		stats.RateLimited++
+		stats.LastRateLimited = now
+	}
+}
+
+func isMultiKeyChannel(channelId int) bool {
+	channel, err := CacheGetChannel(channelId)
+	if err != nil {
+		// 渠道可能刚被自动禁用，缓存中查不到
+		channel, err = GetChannelById(channelId, true)
+		if err != nil {
+			return false
+		}
+	}
+	return channel.ChannelInfo.IsMultiKey
+}
+
+// GetChannelKeyStats 获取渠道某个 Key 的统计快照
+func GetChannelKeyStats(channelId int, key string) ChannelKeyStatsSnapshot {
+	stats, ok := loadChannelKeyStats(channelId, channelKeyHash(key))
+	if !ok {
+		return ChannelKeyStatsSnapshot{}
+	}
+	return stats.snapshot()
+}
+
+// isChannelKeyWithinLimit 检查 Key 是否未达到渠道设置的单 Key 限流
+func isChannelKeyWithinLimit(channelId int, keyHash string, rpm int, tpm int) bool {
+	if rpm <= 0 && tpm <= 0 {
+		return true
+	}
+	stats, ok := loadChannelKeyStats(channelId, keyHash)
+	if !ok {
+		return true
+	}
+	return stats.withinLimit(rpm, tpm)
+}
+
+// getChannelKeyLastRateLimited 返回 Key 最近一次被上游限流的时间，从未限流时为零值
+func getChannelKeyLastRateLimited(channelId int, keyHash string) time.Time {
+	stats, ok := loadChannelKeyStats(channelId, keyHash)
+	if !ok {
+		return time.Time{}
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	return stats.LastRateLimited
+}
+
+// IsChannelKeysSaturated 多 Key 渠道的所有可用 Key 都达到单 Key 限流或处于休息时段时返回 true，选择渠道时跳过该渠道
+func IsChannelKeysSaturated(channel *Channel) bool {
+	if !channel.ChannelInfo.IsMultiKey {
+		return false
+	}
+	rules := getChannelKeyRules(channel)
+	if !rules.hasLimits() {
+		return false
+	}
+	now := time.Now()
+	for i, keyHash := range rules.keyHashes {
+		if channel.getKeyStatus(i) != common.ChannelStatusEnabled || !isChannelKeyInSchedule(channel, i, now) {
+			continue
+		}
+		if isChannelKeyWithinLimit(channel.Id, keyHash, rules.rpmLimit, rules.tpmLimit) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,312 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// channelSchedule 解析后的时间窗口调度规则
+type channelSchedule struct {
+	location        *time.Location
+	windows         []scheduleWindow
+	crons           []cronExpr
+	outsidePriority *int64
+	outsideWeight   *int
+}
+
+type scheduleWindow struct {
+	weekdays uint8 // 按位表示星期几，0 表示每天
+	start    int   // 从零点开始的分钟数
+	end      int
+}
+
+// cronExpr 5 段 cron 表达式，每段按位记录匹配的取值
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// channelScheduleRules 渠道及其各个 Key 的调度规则，按渠道设置的原始内容缓存，设置变化时重新解析
+type channelScheduleRules struct {
+	raw          string
+	schedule     *channelSchedule
+	keySchedules map[int]*channelSchedule
+}
+
+var channelScheduleCache sync.Map // map[channelId]*channelScheduleRules
+
+func getChannelScheduleRules(channel *Channel) *channelScheduleRules {
+	raw := ""
+	if channel.Setting != nil {
+		raw = *channel.Setting
+	}
+	if value, ok := channelScheduleCache.Load(channel.Id); ok {
+		if rules := value.(*channelScheduleRules); rules.raw == raw {
+			return rules
+		}
+	}
+	rules := &channelScheduleRules{raw: raw}
+	if raw != "" {
+		setting := channel.GetSetting()
+		var err error
+		if rules.schedule, err = parseChannelSchedule(setting.Schedule); err != nil {
+			common.SysError(fmt.Sprintf("渠道 #%d 调度规则无效，已忽略: %s", channel.Id, err.Error()))
+			rules.schedule = nil
+		}
+		for keyIndex, keySchedule := range setting.KeySchedules {
+			schedule, err := parseChannelSchedule(keySchedule)
+			if err != nil {
+				common.SysError(fmt.Sprintf("渠道 #%d Key %d 调度规则无效，已忽略: %s", channel.Id, keyIndex, err.Error()))
+				continue
+			}
+			if schedule == nil {
+				continue
+			}
+			if rules.keySchedules == nil {
+				rules.keySchedules = make(map[int]*channelSchedule)
+			}
+			rules.keySchedules[keyIndex] = schedule
+		}
+	}
+	channelScheduleCache.Store(channel.Id, rules)
+	return rules
+}
+
+// ValidateChannelSchedules 校验渠道设置中的调度规则
+func ValidateChannelSchedules(setting dto.ChannelSettings) error {
+	if _, err := parseChannelSchedule(setting.Schedule); err != nil {
+		return err
+	}
+	for keyIndex, keySchedule := range setting.KeySchedules {
+		if keyIndex < 0 {
+			return fmt.Errorf("Key 下标 %d 无效", keyIndex)
+		}
+		if _, err := parseChannelSchedule(keySchedule); err != nil {
+			return fmt.Errorf("Key %d: %s", keyIndex, err.Error())
+		}
+	}
+	return nil
+}
+
+// parseChannelSchedule 解析调度规则，没有设置任何窗口时返回 nil，表示始终处于窗口内
+func parseChannelSchedule(schedule *dto.ChannelSchedule) (*channelSchedule, error) {
+	if schedule == nil || (len(schedule.Windows) == 0 && len(schedule.Cron) == 0) {
+		return nil, nil
+	}
+	parsed := &channelSchedule{
+		location:        time.Local,
+		outsidePriority: schedule.OutsidePriority,
+		outsideWeight:   schedule.OutsideWeight,
+	}
+	if schedule.Timezone != "" {
+		location, err := time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("时区 %s 无效", schedule.Timezone)
+		}
+		parsed.location = location
+	}
+	if schedule.OutsideWeight != nil && *schedule.OutsideWeight < 0 {
+		return nil, errors.New("窗口外权重不能小于 0")
+	}
+	for _, window := range schedule.Windows {
+		start, err := parseScheduleClock(window.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseScheduleClock(window.End)
+		if err != nil {
+			return nil, err
+		}
+		if start == 24*60 {
+			return nil, errors.New("开始时间不能为 24:00")
+		}
+		var weekdays uint8
+		for _, weekday := range window.Weekdays {
+			if weekday < 0 || weekday > 6 {
+				return nil, fmt.Errorf("星期 %d 无效，取值为 0-6", weekday)
+			}
+			weekdays |= 1 << weekday
+		}
+		parsed.windows = append(parsed.windows, scheduleWindow{weekdays: weekdays, start: start, end: end})
+	}
+	for _, expr := range schedule.Cron {
+		cron, err := parseCronExpr(expr)
+		if err != nil {
+			return nil, fmt.Errorf("cron 表达式 %q 无效: %s", expr, err.Error())
+		}
+		parsed.crons = append(parsed.crons, cron)
+	}
+	return parsed, nil
+}
+
+func parseScheduleClock(clock string) (int, error) {
+	hour, minute, ok := strings.Cut(clock, ":")
+	h, err1 := strconv.Atoi(hour)
+	m, err2 := strconv.Atoi(minute)
+	if !ok || err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("时间 %q 无效，格式为 HH:MM", clock)
+	}
+	return h*60 + m, nil
+}
+
+// active 判断给定时间是否处于窗口内
+func (s *channelSchedule) active(now time.Time) bool {
+	now = now.In(s.location)
+	for _, window := range s.windows {
+		if window.contains(now) {
+			return true
+		}
+	}
+	for _, cron := range s.crons {
+		if cron.match(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w scheduleWindow) contains(now time.Time) bool {
+	minute := now.Hour()*60 + now.Minute()
+	weekday := int(now.Weekday())
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end && w.onWeekday(weekday)
+	}
+	// 跨越午夜的窗口，午夜之后的部分属于前一天的窗口
+	if minute >= w.start {
+		return w.onWeekday(weekday)
+	}
+	if minute < w.end {
+		return w.onWeekday((weekday + 6) % 7)
+	}
+	return false
+}
+
+func (w scheduleWindow) onWeekday(weekday int) bool {
+	return w.weekdays == 0 || w.weekdays&(1<<weekday) != 0
+}
+
+func parseCronExpr(expr string) (cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronExpr{}, errors.New("需要 5 段：分 时 日 月 周")
+	}
+	var cron cronExpr
+	var err error
+	if cron.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronExpr{}, err
+	}
+	if cron.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronExpr{}, err
+	}
+	if cron.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronExpr{}, err
+	}
+	if cron.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronExpr{}, err
+	}
+	// 周允许使用 7 表示周日
+	if cron.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronExpr{}, err
+	}
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+	cron.domAny = fields[2] == "*"
+	cron.dowAny = fields[4] == "*"
+	return cron, nil
+}
+
+// parseCronField 解析 cron 的一段，支持 *、a、a-b、*/n、a-b/n 以及逗号分隔的组合
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("步长 %q 无效", stepPart)
+			}
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			startPart, endPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(startPart); err != nil {
+				return 0, fmt.Errorf("取值 %q 无效", rangePart)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(endPart); err != nil {
+					return 0, fmt.Errorf("取值 %q 无效", rangePart)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("取值 %q 超出范围 %d-%d", rangePart, min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (c cronExpr) match(now time.Time) bool {
+	if c.minute&(1<<now.Minute()) == 0 || c.hour&(1<<now.Hour()) == 0 || c.month&(1<<int(now.Month())) == 0 {
+		return false
+	}
+	domMatch := c.dom&(1<<now.Day()) != 0
+	dowMatch := c.dow&(1<<int(now.Weekday())) != 0
+	// 与标准 cron 一致：日和周都有限制时满足其一即可
+	if !c.domAny && !c.dowAny {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// IsChannelSchedulable 渠道当前是否参与渠道选择：处于窗口内，或设置了窗口外的优先级或权重
+func IsChannelSchedulable(channel *Channel) bool {
+	schedule := getChannelScheduleRules(channel).schedule
+	if schedule == nil || schedule.active(time.Now()) {
+		return true
+	}
+	return schedule.outsidePriority != nil || schedule.outsideWeight != nil
+}
+
+// GetSchedulePriority 返回按调度规则生效的优先级，窗口外且设置了窗口外优先级时使用该优先级
+func (channel *Channel) GetSchedulePriority() int64 {
+	schedule := getChannelScheduleRules(channel).schedule
+	if schedule == nil || schedule.outsidePriority == nil || schedule.active(time.Now()) {
+		return channel.GetPriority()
+	}
+	return *schedule.outsidePriority
+}
+
+// GetScheduleWeight 返回按调度规则生效的权重，窗口外且设置了窗口外权重时使用该权重
+func (channel *Channel) GetScheduleWeight() int {
+	schedule := getChannelScheduleRules(channel).schedule
+	if schedule == nil || schedule.outsideWeight == nil || schedule.active(time.Now()) {
+		return channel.GetWeight()
+	}
+	return *schedule.outsideWeight
+}
+
+// isChannelKeyInSchedule Key 当前是否处于可用窗口内，未设置规则的 Key 始终可用
+func isChannelKeyInSchedule(channel *Channel, keyIndex int, now time.Time) bool {
+	schedule, ok := getChannelScheduleRules(channel).keySchedules[keyIndex]
+	return !ok || schedule.active(now)
+}
+
+// IsChannelKeyResting Key 当前是否处于休息时段
+func IsChannelKeyResting(channel *Channel, keyIndex int) bool {
+	return !isChannelKeyInSchedule(channel, keyIndex, time.Now())
+}
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"one-api/setting/operation_setting"
+)
+
+// ChannelSelectStats 渠道+模型的实时运行统计，用于自适应渠道选择
+type ChannelSelectStats struct {
+	ChannelId     int
+	ModelName     string
+	EWMAFRT       float64 // 首字响应时间 EWMA（毫秒），0 表示尚无样本
+	ErrorRate     float64 // 错误率 EWMA
+	RateLimitRate float64 // 429 比例 EWMA
+	Samples       int64   // 已记录的请求结果数
+	LastUpdated   time.Time
+	outstanding   int64 // 进行中的请求数
+	mu            sync.RWMutex
+}
+
+// ChannelSelectStatsSnapshot 统计快照，供管理接口展示
+type ChannelSelectStatsSnapshot struct {
+	ChannelId     int     `json:"channel_id"`
+	ModelName     string  `json:"model_name"`
+	EWMAFRT       float64 `json:"ewma_frt_ms"`
+	ErrorRate     float64 `json:"error_rate"`
+	RateLimitRate float64 `json:"rate_limit_rate"`
+	Samples       int64   `json:"samples"`
+	Outstanding   int64   `json:"outstanding"`
+	LastUpdated   int64   `json:"last_updated"`
+}
+
+var channelSelectStats sync.Map // map[channelId:modelName]*ChannelSelectStats
+
+func getChannelSelectStats(channelId int, modelName string) *ChannelSelectStats {
+	key := getMonitorKey(channelId, modelName)
+	if value, ok := channelSelectStats.Load(key); ok {
+		return value.(*ChannelSelectStats)
+	}
+	value, _ := channelSelectStats.LoadOrStore(key, &ChannelSelectStats{
+		ChannelId: channelId,
+		ModelName: modelName,
+	})
+	return value.(*ChannelSelectStats)
+}
+
+func getEWMAAlpha() float64 {
+	alpha := operation_setting.GetChannelSelectSetting().EWMAAlpha
+	if alpha <= 0 || alpha > 1 {
+		return 0.3
+	}
+	return alpha
+}
+
+func (s *ChannelSelectStats) record(frtMs int64, isError bool, isRateLimited bool) {
+	alpha := getEWMAAlpha()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errValue, rateLimitValue := 0.0, 0.0
+	if isError {
+		errValue = 1
+	}
+	if isRateLimited {
+		rateLimitValue = 1
+	}
+	if s.Samples == 0 {
+		s.ErrorRate = errValue
+		s.RateLimitRate = rateLimitValue
+	} else {
+		s.ErrorRate = alpha*errValue + (1-alpha)*s.ErrorRate
+		s.RateLimitRate = alpha*rateLimitValue + (1-alpha)*s.RateLimitRate
+	}
+	// 只有成功且 FRT 有效的请求才计入延迟
+	if !isError && frtMs > 0 {
+		if s.EWMAFRT == 0 {
+			s.EWMAFRT = float64(frtMs)
+		} else {
+			s.EWMAFRT = alpha*float64(frtMs) + (1-alpha)*s.EWMAFRT
+		}
+	}
+	s.Samples++
+	s.LastUpdated = time.Now()
+}
+
+func (s *ChannelSelectStats) snapshot() ChannelSelectStatsSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return ChannelSelectStatsSnapshot{
+		ChannelId:     s.ChannelId,
+		ModelName:     s.ModelName,
+		EWMAFRT:       s.EWMAFRT,
+		ErrorRate:     s.ErrorRate,
+		RateLimitRate: s.RateLimitRate,
+		Samples:       s.Samples,
+		Outstanding:   atomic.LoadInt64(&s.outstanding),
+		LastUpdated:   s.LastUpdated.Unix(),
+	}
+}
+
+// RecordChannelSelectSuccess 记录一次成功请求的首字响应时间（毫秒）
+func RecordChannelSelectSuccess(channelId int, modelName string, frtMs int64) {
+	if channelId == 0 || modelName == "" {
+		return
+	}
+	getChannelSelectStats(channelId, modelName).record(frtMs, false, false)
+}
+
+// RecordChannelSelectError 记录一次失败请求，429 额外计入限流比例
+func RecordChannelSelectError(channelId int, modelName string, statusCode int) {
+	if channelId == 0 || modelName == "" {
+		return
+	}
+	getChannelSelectStats(channelId, modelName).record(-1, true, statusCode == http.StatusTooManyRequests)
+}
+
+// TrackChannelOutstanding 增加进行中的请求计数，返回的函数用于在请求结束时释放
+func TrackChannelOutstanding(channelId int, modelName string) func() {
+	if channelId == 0 || modelName == "" {
+		return func() {}
+	}
+	stats := getChannelSelectStats(channelId, modelName)
+	atomic.AddInt64(&stats.outstanding, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&stats.outstanding, -1)
+		})
+	}
+}
+
+// GetAllChannelSelectStats 获取所有渠道+模型的统计快照
+func GetAllChannelSelectStats() []ChannelSelectStatsSnapshot {
+	result := make([]ChannelSelectStatsSnapshot, 0)
+	channelSelectStats.Range(func(key, value interface{}) bool {
+		result = append(result, value.(*ChannelSelectStats).snapshot())
+		return true
+	})
+	return result
+}
+
+func getChannelTags(channels []*Channel) []string {
+	tags := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		tags = append(tags, channel.GetTag())
+	}
+	return tags
+}
+
+// selectAdaptiveChannel 按自适应策略在同优先级渠道中选择，返回 nil 表示使用静态权重
+func selectAdaptiveChannel(strategy string, modelName string, channels []*Channel) *Channel {
+	switch strategy {
+	case operation_setting.ChannelSelectStrategyEWMALatency:
+		return selectByEWMALatency(modelName, channels)
+	case operation_setting.ChannelSelectStrategyLeastOutstanding:
+		return selectByLeastOutstanding(modelName, channels)
+	}
+	return nil
+}
+
+// penaltyFactor 根据错误率和 429 比例计算惩罚倍数
+func penaltyFactor(s ChannelSelectStatsSnapshot) float64 {
+	setting := operation_setting.GetChannelSelectSetting()
+	return 1 + s.ErrorRate*setting.ErrorPenalty + s.RateLimitRate*setting.RateLimitPenalty
+}
+
+// selectByEWMALatency 以 (权重+平滑) / (延迟 * 惩罚) 为概率加权随机选择，
+// 没有延迟样本的渠道按当前最快渠道计算，保证新渠道能获得流量
+func selectByEWMALatency(modelName string, channels []*Channel) *Channel {
+	snapshots := make([]ChannelSelectStatsSnapshot, len(channels))
+	minFRT := math.MaxFloat64
+	for i, channel := range channels {
+		snapshots[i] = getChannelSelectStats(channel.Id, modelName).snapshot()
+		if snapshots[i].EWMAFRT > 0 && snapshots[i].EWMAFRT < minFRT {
+			minFRT = snapshots[i].EWMAFRT
+		}
+	}
+	if minFRT == math.MaxFloat64 {
+		minFRT = 1000
+	}
+
+	scores := make([]float64, len(channels))
+	totalScore := 0.0
+	for i, channel := range channels {
+		frt := snapshots[i].EWMAFRT
+		if frt <= 0 {
+			frt = minFRT
+		}
+		scores[i] = float64(channel.GetScheduleWeight()+10) / (frt * penaltyFactor(snapshots[i]))
+		totalScore += scores[i]
+	}
+	if totalScore <= 0 {
+		return nil
+	}
+
+	randomScore := rand.Float64() * totalScore
+	for i, channel := range channels {
+		randomScore -= scores[i]
+		if randomScore < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+// selectByLeastOutstanding 选择 (进行中请求数+1) * 惩罚 / (权重+平滑) 最小的渠道，相同时随机
+func selectByLeastOutstanding(modelName string, channels []*Channel) *Channel {
+	var best []*Channel
+	bestCost := math.MaxFloat64
+	for _, channel := range channels {
+		snapshot := getChannelSelectStats(channel.Id, modelName).snapshot()
+		cost := float64(snapshot.Outstanding+1) * penaltyFactor(snapshot) / float64(channel.GetScheduleWeight()+10)
+		if cost < bestCost {
+			bestCost = cost
+			best = []*Channel{channel}
+		} else if cost == bestCost {
+			best = append(best, channel)
+		}
+	}
+	if len(best) == 0 {
+		return nil
+	}
+	return best[rand.Intn(len(best))]
+}
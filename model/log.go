@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"one-api/common"
+	"one-api/constant"
 	"os"
 	"strconv"
 	"strings"
@@ -35,6 +36,8 @@ type Log struct {
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	RequestId        string `json:"request_id" gorm:"index;default:''"`
+	OrganizationId   int    `json:"organization_id" gorm:"default:0;index"`
+	UpstreamCost     int    `json:"upstream_cost" gorm:"default:0"` // 渠道上游成本（额度单位），未设置成本时为 0
 	Other            string `json:"other"`
 }
 
@@ -138,6 +141,8 @@ type RecordConsumeLogParams struct {
 	IsStream         bool                   `json:"is_stream"`
 	Group            string                 `json:"group"`
 	Other            map[string]interface{} `json:"other"`
+	UpstreamCost     int                    `json:"upstream_cost"`
+	HasUpstreamCost  bool                   `json:"has_upstream_cost"` // 渠道是否设置了上游成本
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
@@ -166,6 +171,8 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 		Group:            params.Group,
 		Ip:               c.ClientIP(),
 		RequestId:        c.GetString(common.RequestIdKey),
+		OrganizationId:   common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId),
+		UpstreamCost:     params.UpstreamCost,
 		Other:            otherStr,
 	}
 	err := LOG_DB.Create(log).Error
@@ -175,6 +182,7 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 	if common.DataExportEnabled {
 		gopool.Go(func() {
 			LogQuotaData(userId, username, params.ModelName, params.Quota, common.GetTimestamp(), params.PromptTokens+params.CompletionTokens)
+			LogChannelQuotaData(params.ChannelId, params.ModelName, params.Quota, params.UpstreamCost, params.HasUpstreamCost, common.GetTimestamp())
 		})
 	}
 }
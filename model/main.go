@@ -241,6 +241,11 @@ func migrateDB() error {
 	err := DB.AutoMigrate(
 		&Channel{},
 		&Token{},
+		&TokenBudgetUsage{},
+		&Organization{},
+		&OrganizationMember{},
+		&OrganizationInvitation{},
+		&QuotaLedger{},
 		&User{},
 		&Option{},
 		&Redemption{},
@@ -249,8 +254,13 @@ func migrateDB() error {
 		&Midjourney{},
 		&TopUp{},
 		&QuotaData{},
+		&ChannelQuotaData{},
+		&TrafficMirrorLog{},
 		&Task{},
 		&Setup{},
+		&File{},
+		&Batch{},
+		&BatchResult{},
 	)
 	if err != nil {
 		return err
@@ -267,6 +277,11 @@ func migrateDBFast() error {
 	}{
 		{&Channel{}, "Channel"},
 		{&Token{}, "Token"},
+		{&TokenBudgetUsage{}, "TokenBudgetUsage"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&OrganizationInvitation{}, "OrganizationInvitation"},
+		{&QuotaLedger{}, "QuotaLedger"},
 		{&User{}, "User"},
 		{&Option{}, "Option"},
 		{&Redemption{}, "Redemption"},
@@ -275,8 +290,13 @@ func migrateDBFast() error {
 		{&Midjourney{}, "Midjourney"},
 		{&TopUp{}, "TopUp"},
 		{&QuotaData{}, "QuotaData"},
+		{&ChannelQuotaData{}, "ChannelQuotaData"},
+		{&TrafficMirrorLog{}, "TrafficMirrorLog"},
 		{&Task{}, "Task"},
 		{&Setup{}, "Setup"},
+		{&File{}, "File"},
+		{&Batch{}, "Batch"},
+		{&BatchResult{}, "BatchResult"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -113,9 +113,16 @@ func InitOptionMap() {
 	common.OptionMap["ModelRequestRateLimitDurationMinutes"] = strconv.Itoa(setting.ModelRequestRateLimitDurationMinutes)
 	common.OptionMap["ModelRequestRateLimitSuccessCount"] = strconv.Itoa(setting.ModelRequestRateLimitSuccessCount)
 	common.OptionMap["ModelRequestRateLimitGroup"] = setting.ModelRequestRateLimitGroup2JSONString()
+	common.OptionMap["TokenTPMLimit"] = strconv.Itoa(setting.TokenTPMLimit)
+	common.OptionMap["UserTPMLimit"] = strconv.Itoa(setting.UserTPMLimit)
+	common.OptionMap["TokenConcurrencyLimit"] = strconv.Itoa(setting.TokenConcurrencyLimit)
+	common.OptionMap["UserConcurrencyLimit"] = strconv.Itoa(setting.UserConcurrencyLimit)
+	common.OptionMap["TPMLimitGroup"] = setting.TPMLimitGroup2JSONString()
 	common.OptionMap["ModelRatio"] = ratio_setting.ModelRatio2JSONString()
 	common.OptionMap["ModelPrice"] = ratio_setting.ModelPrice2JSONString()
 	common.OptionMap["CacheRatio"] = ratio_setting.CacheRatio2JSONString()
+	common.OptionMap["CacheHitRatio"] = ratio_setting.CacheHitRatio2JSONString()
+	common.OptionMap["TieredPrice"] = ratio_setting.TieredPrice2JSONString()
 	common.OptionMap["GroupRatio"] = ratio_setting.GroupRatio2JSONString()
 	common.OptionMap["GroupGroupRatio"] = ratio_setting.GroupGroupRatio2JSONString()
 	common.OptionMap["UserUsableGroups"] = setting.UserUsableGroups2JSONString()
@@ -139,6 +146,7 @@ func InitOptionMap() {
 	common.OptionMap["DemoSiteEnabled"] = strconv.FormatBool(operation_setting.DemoSiteEnabled)
 	common.OptionMap["SelfUseModeEnabled"] = strconv.FormatBool(operation_setting.SelfUseModeEnabled)
 	common.OptionMap["ModelRequestRateLimitEnabled"] = strconv.FormatBool(setting.ModelRequestRateLimitEnabled)
+	common.OptionMap["TPMLimitEnabled"] = strconv.FormatBool(setting.TPMLimitEnabled)
 	common.OptionMap["CheckSensitiveOnPromptEnabled"] = strconv.FormatBool(setting.CheckSensitiveOnPromptEnabled)
 	common.OptionMap["StopOnSensitiveEnabled"] = strconv.FormatBool(setting.StopOnSensitiveEnabled)
 	common.OptionMap["SensitiveWords"] = setting.SensitiveWordsToString()
@@ -282,6 +290,8 @@ func updateOptionMap(key string, value string) (err error) {
 			setting.CheckSensitiveOnPromptEnabled = boolValue
 		case "ModelRequestRateLimitEnabled":
 			setting.ModelRequestRateLimitEnabled = boolValue
+		case "TPMLimitEnabled":
+			setting.TPMLimitEnabled = boolValue
 		case "StopOnSensitiveEnabled":
 			setting.StopOnSensitiveEnabled = boolValue
 		case "SMTPSSLEnabled":
@@ -400,6 +410,16 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.ModelRequestRateLimitSuccessCount, _ = strconv.Atoi(value)
 	case "ModelRequestRateLimitGroup":
 		err = setting.UpdateModelRequestRateLimitGroupByJSONString(value)
+	case "TokenTPMLimit":
+		setting.TokenTPMLimit, _ = strconv.Atoi(value)
+	case "UserTPMLimit":
+		setting.UserTPMLimit, _ = strconv.Atoi(value)
+	case "TokenConcurrencyLimit":
+		setting.TokenConcurrencyLimit, _ = strconv.Atoi(value)
+	case "UserConcurrencyLimit":
+		setting.UserConcurrencyLimit, _ = strconv.Atoi(value)
+	case "TPMLimitGroup":
+		err = setting.UpdateTPMLimitGroupByJSONString(value)
 	case "RetryTimes":
 		common.RetryTimes, _ = strconv.Atoi(value)
 	case "DataExportInterval":
@@ -420,6 +440,10 @@ func updateOptionMap(key string, value string) (err error) {
 		err = ratio_setting.UpdateModelPriceByJSONString(value)
 	case "CacheRatio":
 		err = ratio_setting.UpdateCacheRatioByJSONString(value)
+	case "CacheHitRatio":
+		err = ratio_setting.UpdateCacheHitRatioByJSONString(value)
+	case "TieredPrice":
+		err = ratio_setting.UpdateTieredPriceByJSONString(value)
 	case "ModelDescription":
 		err = ratio_setting.UpdateModelDescriptionByJSONString(value)
 	case "ModelDocumentationURL":
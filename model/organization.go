@@ -0,0 +1,433 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// 组织成员角色
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleAdmin  = "admin"
+	OrganizationRoleMember = "member"
+)
+
+const (
+	OrganizationStatusEnabled  = 1
+	OrganizationStatusDisabled = 2
+)
+
+const (
+	OrganizationInvitationStatusPending  = 1
+	OrganizationInvitationStatusAccepted = 2
+	OrganizationInvitationStatusRevoked  = 3
+)
+
+// Organization 组织，成员的组织令牌共用组织钱包中的额度
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);index"`
+	OwnerId     int    `json:"owner_id" gorm:"index"`
+	Status      int    `json:"status" gorm:"default:1"`
+	Quota       int    `json:"quota" gorm:"default:0"`      // 组织钱包剩余额度
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"` // 组织钱包已用额度
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+
+	// 当前用户在组织中的角色，不存入数据库
+	Role string `json:"role,omitempty" gorm:"-:all"`
+}
+
+// OrganizationMember 组织成员，SpendCap 为成员可从组织钱包消费的上限，0 表示不限制
+type OrganizationMember struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"uniqueIndex:idx_organization_member"`
+	UserId         int    `json:"user_id" gorm:"uniqueIndex:idx_organization_member;index"`
+	Role           string `json:"role" gorm:"type:varchar(16)"`
+	SpendCap       int    `json:"spend_cap" gorm:"default:0"`
+	UsedQuota      int    `json:"used_quota" gorm:"default:0"` // 成员从组织钱包消费的额度
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+
+	Username string `json:"username" gorm:"-:migration;->"`
+	Email    string `json:"email" gorm:"-:migration;->"`
+}
+
+// OrganizationInvitation 通过邮件发送的组织邀请
+type OrganizationInvitation struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"index"`
+	Email          string `json:"email" gorm:"type:varchar(255);index"`
+	Role           string `json:"role" gorm:"type:varchar(16)"`
+	Code           string `json:"-" gorm:"type:char(32);uniqueIndex"`
+	InviterId      int    `json:"inviter_id"`
+	Status         int    `json:"status" gorm:"default:1"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+	ExpiredTime    int64  `json:"expired_time" gorm:"bigint"`
+}
+
+// OrganizationUsage 组织用量，按成员和模型汇总
+type OrganizationUsage struct {
+	UserId           int    `json:"user_id"`
+	Username         string `json:"username"`
+	ModelName        string `json:"model_name"`
+	Quota            int    `json:"quota"`
+	Count            int    `json:"count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+func IsValidOrganizationRole(role string) bool {
+	return role == OrganizationRoleAdmin || role == OrganizationRoleMember
+}
+
+// IsManager 所有者和管理员可以管理组织
+func (member *OrganizationMember) IsManager() bool {
+	return member.Role == OrganizationRoleOwner || member.Role == OrganizationRoleAdmin
+}
+
+// Insert 创建组织，创建者成为所有者
+func (org *Organization) Insert() error {
+	org.CreatedTime = common.GetTimestamp()
+	org.Status = OrganizationStatusEnabled
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrganizationId: org.Id,
+			UserId:         org.OwnerId,
+			Role:           OrganizationRoleOwner,
+			CreatedTime:    org.CreatedTime,
+		}).Error
+	})
+}
+
+func (org *Organization) Update() error {
+	return DB.Model(org).Select("name", "status").Updates(org).Error
+}
+
+// Delete 删除组织，钱包中剩余的额度退还给所有者
+func (org *Organization) Delete() error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", org.Id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", org.Id).Delete(&OrganizationInvitation{}).Error; err != nil {
+			return err
+		}
+		// 以事务中读取的余额为准，避免删除期间仍有消费
+		if err := tx.Model(&Organization{}).Where("id = ?", org.Id).Select("quota").Scan(&org.Quota).Error; err != nil {
+			return err
+		}
+		if org.Quota > 0 {
+			reason := LedgerReason{Type: LedgerTypeOrgDelete, Reference: strconv.Itoa(org.Id)}
+			if _, err := changeBalance(tx, LedgerAccountOrganization, org.Id, nil, ledgerItem{reason: reason, delta: -org.Quota}); err != nil {
+				return err
+			}
+			if _, err := changeBalance(tx, LedgerAccountUser, org.OwnerId, nil, ledgerItem{reason: reason, delta: org.Quota}); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(org).Error
+	})
+	if err == nil && org.Quota > 0 {
+		_ = invalidateUserCache(org.OwnerId)
+	}
+	return err
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	org := &Organization{}
+	err := DB.First(org, "id = ?", id).Error
+	return org, err
+}
+
+func GetAllOrganizations(pageInfo *common.PageInfo, keyword string) (orgs []*Organization, total int64, err error) {
+	tx := DB.Model(&Organization{})
+	if keyword != "" {
+		tx = tx.Where("name LIKE ? OR id = ?", "%"+keyword+"%", common.String2Int(keyword))
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&orgs).Error
+	return orgs, total, err
+}
+
+// GetUserOrganizations 获取用户加入的组织，并填充用户在组织中的角色
+func GetUserOrganizations(userId int) ([]*Organization, error) {
+	var members []*OrganizationMember
+	if err := DB.Where("user_id = ?", userId).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[int]string, len(members))
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		roles[member.OrganizationId] = member.Role
+		ids = append(ids, member.OrganizationId)
+	}
+	orgs := make([]*Organization, 0)
+	if len(ids) == 0 {
+		return orgs, nil
+	}
+	if err := DB.Where("id IN ?", ids).Order("id desc").Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		org.Role = roles[org.Id]
+	}
+	return orgs, nil
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	member := &OrganizationMember{}
+	err := DB.Where("organization_id = ? AND user_id = ?", orgId, userId).First(member).Error
+	return member, err
+}
+
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Table("organization_members").
+		Select("organization_members.*, users.username, users.email").
+		Joins("left join users on users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", orgId).
+		Order("organization_members.id asc").
+		Find(&members).Error
+	return members, err
+}
+
+func (member *OrganizationMember) Update(resetUsedQuota bool) error {
+	fields := map[string]interface{}{
+		"role":      member.Role,
+		"spend_cap": member.SpendCap,
+	}
+	if resetUsedQuota {
+		fields["used_quota"] = 0
+		member.UsedQuota = 0
+	}
+	return DB.Model(&OrganizationMember{}).Where("id = ?", member.Id).Updates(fields).Error
+}
+
+func (member *OrganizationMember) Delete() error {
+	return DB.Delete(member).Error
+}
+
+func (invitation *OrganizationInvitation) Insert() error {
+	return DB.Create(invitation).Error
+}
+
+func GetOrganizationInvitations(orgId int) ([]*OrganizationInvitation, error) {
+	var invitations []*OrganizationInvitation
+	err := DB.Where("organization_id = ? AND status = ?", orgId, OrganizationInvitationStatusPending).
+		Order("id desc").Find(&invitations).Error
+	return invitations, err
+}
+
+func RevokeOrganizationInvitation(orgId int, id int) error {
+	result := DB.Model(&OrganizationInvitation{}).
+		Where("id = ? AND organization_id = ? AND status = ?", id, orgId, OrganizationInvitationStatusPending).
+		Update("status", OrganizationInvitationStatusRevoked)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请不存在或已失效")
+	}
+	return nil
+}
+
+// AcceptOrganizationInvitation 接受邀请加入组织，邀请只对被邀请的邮箱有效
+func AcceptOrganizationInvitation(code string, userId int, email string) (*OrganizationMember, error) {
+	var member *OrganizationMember
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		invitation := &OrganizationInvitation{}
+		if err := tx.Where("code = ?", code).First(invitation).Error; err != nil {
+			return errors.New("邀请不存在")
+		}
+		if invitation.Status != OrganizationInvitationStatusPending || invitation.ExpiredTime < common.GetTimestamp() {
+			return errors.New("邀请已失效")
+		}
+		if email == "" || invitation.Email != email {
+			return errors.New("该邀请不属于当前账户的邮箱")
+		}
+		var count int64
+		if err := tx.Model(&OrganizationMember{}).Where("organization_id = ? AND user_id = ?", invitation.OrganizationId, userId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("已经是该组织的成员")
+		}
+		result := tx.Model(&OrganizationInvitation{}).Where("id = ? AND status = ?", invitation.Id, OrganizationInvitationStatusPending).
+			Update("status", OrganizationInvitationStatusAccepted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("邀请已失效")
+		}
+		member = &OrganizationMember{
+			OrganizationId: invitation.OrganizationId,
+			UserId:         userId,
+			Role:           invitation.Role,
+			CreatedTime:    common.GetTimestamp(),
+		}
+		return tx.Create(member).Error
+	})
+	return member, err
+}
+
+// GetOrganizationAvailableQuota 获取成员可从组织钱包使用的额度，受成员消费上限约束
+func GetOrganizationAvailableQuota(orgId int, userId int) (int, error) {
+	org, err := GetOrganizationById(orgId)
+	if err != nil {
+		return 0, errors.New("令牌所属的组织不存在")
+	}
+	if org.Status != OrganizationStatusEnabled {
+		return 0, errors.New("令牌所属的组织已被禁用")
+	}
+	member, err := GetOrganizationMember(orgId, userId)
+	if err != nil {
+		return 0, errors.New("已不是令牌所属组织的成员")
+	}
+	quota := org.Quota
+	if member.SpendCap > 0 && member.SpendCap-member.UsedQuota < quota {
+		quota = member.SpendCap - member.UsedQuota
+	}
+	return quota, nil
+}
+
+// DecreaseOrganizationQuota 从组织钱包扣除额度并计入成员的消费，quota 为负数时为退还
+func DecreaseOrganizationQuota(orgId int, userId int, quota int, reason LedgerReason) error {
+	if quota == 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		_, err := changeBalance(tx, LedgerAccountOrganization, orgId, map[string]interface{}{
+			"used_quota": gorm.Expr("used_quota + ?", quota),
+		}, ledgerItem{reason: reason, delta: -quota})
+		if err != nil {
+			return err
+		}
+		return tx.Model(&OrganizationMember{}).Where("organization_id = ? AND user_id = ?", orgId, userId).
+			Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+	})
+}
+
+var (
+	ErrOrganizationSpendCapExceeded = errors.New("已超出成员在组织中的消费上限")
+	ErrOrganizationQuotaNotEnough   = errors.New("组织额度不足")
+)
+
+// ReserveOrganizationQuota 预扣组织钱包额度，成员消费上限在同一个条件更新中校验，组织余额不足时回滚，
+// 避免并发请求各自读取可用额度后同时扣费超出上限
+func ReserveOrganizationQuota(orgId int, userId int, quota int, reason LedgerReason) error {
+	if quota <= 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ? AND (spend_cap <= 0 OR used_quota + ? <= spend_cap)", orgId, userId, quota).
+			Update("used_quota", gorm.Expr("used_quota + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrganizationSpendCapExceeded
+		}
+		balance, err := changeBalance(tx, LedgerAccountOrganization, orgId, map[string]interface{}{
+			"used_quota": gorm.Expr("used_quota + ?", quota),
+		}, ledgerItem{reason: reason, delta: -quota})
+		if err != nil {
+			return err
+		}
+		if balance < 0 {
+			return ErrOrganizationQuotaNotEnough
+		}
+		return nil
+	})
+}
+
+// TransferOrganizationQuota 在组织钱包和成员个人额度之间转移额度，toMember 为 true 时从组织转给成员
+func TransferOrganizationQuota(orgId int, userId int, quota int, toMember bool) error {
+	if quota <= 0 {
+		return errors.New("转移额度必须大于 0")
+	}
+	orgDelta := quota
+	if toMember {
+		orgDelta = -quota
+	}
+	reason := LedgerReason{Type: LedgerTypeOrgTransfer, Reference: strconv.Itoa(orgId)}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		// 更新后余额为负说明余额不足，回滚事务，避免并发转移导致余额为负
+		balance, err := changeBalance(tx, LedgerAccountOrganization, orgId, nil, ledgerItem{reason: LedgerReason{
+			Type: LedgerTypeOrgTransfer, Reference: strconv.Itoa(userId)}, delta: orgDelta})
+		if err != nil {
+			return err
+		}
+		if balance < 0 {
+			return errors.New("组织额度不足")
+		}
+		balance, err = changeBalance(tx, LedgerAccountUser, userId, nil, ledgerItem{reason: reason, delta: -orgDelta})
+		if err != nil {
+			return err
+		}
+		if balance < 0 {
+			return errors.New("成员额度不足")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		if err := invalidateUserCache(userId); err != nil {
+			common.SysError("failed to invalidate user cache: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// AdjustOrganizationQuota 管理员调整组织钱包额度，delta 为负数时扣除
+func AdjustOrganizationQuota(orgId int, delta int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		balance, err := changeBalance(tx, LedgerAccountOrganization, orgId, nil, ledgerItem{reason: LedgerReason{Type: LedgerTypeAdmin}, delta: delta})
+		if err != nil {
+			return errors.New("组织不存在")
+		}
+		if balance < 0 {
+			return errors.New("组织额度不足")
+		}
+		return nil
+	})
+}
+
+// GetOrganizationUsage 按成员和模型汇总组织令牌的消费日志
+func GetOrganizationUsage(orgId int, startTimestamp int64, endTimestamp int64) ([]*OrganizationUsage, error) {
+	tx := LOG_DB.Table("logs").
+		Select("user_id, username, model_name, sum(quota) as quota, count(*) as count, "+
+			"sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens").
+		Where("organization_id = ? AND type = ?", orgId, LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	var usages []*OrganizationUsage
+	err := tx.Group("user_id, username, model_name").Order("quota desc").Scan(&usages).Error
+	return usages, err
+}
+
+// RefundTaskQuota 退还异步任务的额度，组织令牌提交的任务退还到提交时付费的组织钱包
+func RefundTaskQuota(userId int, organizationId int, quota int, taskId string) error {
+	reason := LedgerReason{Type: LedgerTypeTaskRefund, Reference: taskId}
+	if organizationId != 0 {
+		return DecreaseOrganizationQuota(organizationId, userId, -quota, reason)
+	}
+	return IncreaseUserQuota(userId, quota, false, reason)
+}
@@ -21,6 +21,8 @@ type Pricing struct {
 	DocumentationURL       string                  `json:"documentation_url"`
 	EnableGroup            []string                `json:"enable_groups"`
 	SupportedEndpointTypes []constant.EndpointType `json:"supported_endpoint_types"`
+	// 分档计费规则，按顺序匹配
+	TieredPrices []ratio_setting.TieredPriceRule `json:"tiered_prices,omitempty"`
 }
 
 var (
@@ -122,6 +124,7 @@ func updatePricing() {
 			pricing.ModelRatio = modelRatio
 			pricing.CompletionRatio = ratio_setting.GetCompletionRatio(model)
 			pricing.QuotaType = 0
+			pricing.TieredPrices = ratio_setting.GetTieredPriceRules(model)
 		}
 		// 获取模型描述
 		description, _ := ratio_setting.GetModelDescription(model)
@@ -0,0 +1,328 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 账本的账户类型
+const (
+	LedgerAccountUser         = "user"
+	LedgerAccountToken        = "token"
+	LedgerAccountOrganization = "organization"
+)
+
+// 余额变动类型
+const (
+	LedgerTypeConsume     = "consume"      // 请求扣费，包括预扣和补扣
+	LedgerTypeRefund      = "refund"       // 请求退还，包括预扣退还和多扣退还
+	LedgerTypeTaskRefund  = "task_refund"  // 异步任务失败退还
+	LedgerTypeTopUp       = "topup"        // 在线充值
+	LedgerTypeRedemption  = "redemption"   // 兑换码
+	LedgerTypeRegister    = "register"     // 注册赠送
+	LedgerTypeInvite      = "invite"       // 使用邀请码赠送
+	LedgerTypeAffTransfer = "aff_transfer" // 邀请额度划转
+	LedgerTypeAdmin       = "admin"        // 管理员修改
+	LedgerTypeTokenEdit   = "token_edit"   // 用户设置令牌额度
+	LedgerTypeOrgTransfer = "org_transfer" // 组织钱包与成员之间的划转
+	LedgerTypeOrgDelete   = "org_delete"   // 删除组织退还额度
+)
+
+// QuotaLedger 余额变动账本，只追加不修改。BalanceBefore 和 BalanceAfter 为变动前后的数据库余额
+type QuotaLedger struct {
+	Id            int    `json:"id"`
+	AccountType   string `json:"account_type" gorm:"type:varchar(16);index:idx_quota_ledger_account,priority:1"`
+	AccountId     int    `json:"account_id" gorm:"index:idx_quota_ledger_account,priority:2"`
+	Type          string `json:"type" gorm:"type:varchar(32);index"`
+	Delta         int    `json:"delta"`
+	BalanceBefore int    `json:"balance_before"`
+	BalanceAfter  int    `json:"balance_after"`
+	Reference     string `json:"reference" gorm:"type:varchar(128);index"` // 请求 id、任务 id、订单号等
+	Remark        string `json:"remark" gorm:"type:varchar(255)"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// LedgerReason 余额变动的原因
+type LedgerReason struct {
+	Type      string
+	Reference string
+	Remark    string
+}
+
+// ledgerItem 一笔待写入账本的变动
+type ledgerItem struct {
+	reason LedgerReason
+	delta  int
+}
+
+type ledgerAccount struct {
+	table  string
+	column string
+}
+
+var ledgerAccounts = map[string]ledgerAccount{
+	LedgerAccountUser:         {table: "users", column: "quota"},
+	LedgerAccountToken:        {table: "tokens", column: "remain_quota"},
+	LedgerAccountOrganization: {table: "organizations", column: "quota"},
+}
+
+// changeBalance 在事务中按变动额更新余额并写入账本，返回更新后的余额。
+// 余额以更新后在同一事务中读取的值为准，updates 为需要一同更新的其他字段
+func changeBalance(tx *gorm.DB, accountType string, accountId int, updates map[string]interface{}, items ...ledgerItem) (int, error) {
+	account := ledgerAccounts[accountType]
+	delta := 0
+	for _, item := range items {
+		delta += item.delta
+	}
+	if updates == nil {
+		updates = make(map[string]interface{}, 1)
+	}
+	updates[account.column] = gorm.Expr(account.column+" + ?", delta)
+	result := tx.Table(account.table).Where("id = ?", accountId).Updates(updates)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, fmt.Errorf("%s %d not found", accountType, accountId)
+	}
+	var balance int
+	if err := tx.Table(account.table).Where("id = ?", accountId).Select(account.column).Scan(&balance).Error; err != nil {
+		return 0, err
+	}
+	running := balance - delta
+	now := common.GetTimestamp()
+	ledgers := make([]QuotaLedger, 0, len(items))
+	for _, item := range items {
+		ledgers = append(ledgers, QuotaLedger{
+			AccountType:   accountType,
+			AccountId:     accountId,
+			Type:          item.reason.Type,
+			Delta:         item.delta,
+			BalanceBefore: running,
+			BalanceAfter:  running + item.delta,
+			Reference:     item.reason.Reference,
+			Remark:        item.reason.Remark,
+			CreatedAt:     now,
+		})
+		running += item.delta
+	}
+	if len(ledgers) > 0 {
+		if err := tx.Create(&ledgers).Error; err != nil {
+			return 0, err
+		}
+	}
+	return balance, nil
+}
+
+// recordLedger 余额被直接设置时记录变动，balanceBefore 为设置前的余额
+func recordLedger(tx *gorm.DB, accountType string, accountId int, balanceBefore int, balanceAfter int, reason LedgerReason) error {
+	if balanceBefore == balanceAfter {
+		return nil
+	}
+	return tx.Create(&QuotaLedger{
+		AccountType:   accountType,
+		AccountId:     accountId,
+		Type:          reason.Type,
+		Delta:         balanceAfter - balanceBefore,
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  balanceAfter,
+		Reference:     reason.Reference,
+		Remark:        reason.Remark,
+		CreatedAt:     common.GetTimestamp(),
+	}).Error
+}
+
+// ChangeBalance 更新余额并写入账本
+func ChangeBalance(accountType string, accountId int, delta int, reason LedgerReason) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		_, err := changeBalance(tx, accountType, accountId, nil, ledgerItem{reason: reason, delta: delta})
+		return err
+	})
+}
+
+func GetQuotaLedgers(accountType string, accountId int, ledgerType string, reference string, pageInfo *common.PageInfo) (ledgers []*QuotaLedger, total int64, err error) {
+	tx := DB.Model(&QuotaLedger{})
+	if accountType != "" {
+		tx = tx.Where("account_type = ?", accountType)
+	}
+	if accountId != 0 {
+		tx = tx.Where("account_id = ?", accountId)
+	}
+	if ledgerType != "" {
+		tx = tx.Where("type = ?", ledgerType)
+	}
+	if reference != "" {
+		tx = tx.Where("reference = ?", reference)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&ledgers).Error
+	return ledgers, total, err
+}
+
+// LedgerDrift 账户余额与账本不一致
+type LedgerDrift struct {
+	AccountType   string `json:"account_type"`
+	AccountId     int    `json:"account_id"`
+	Balance       int    `json:"balance"`        // 数据库中的当前余额
+	LedgerBalance int    `json:"ledger_balance"` // 账本最后一笔记录的变动后余额
+	ChainGap      int64  `json:"chain_gap"`      // 账本记录之间未记录的变动总额，0 表示账本连续
+}
+
+// LedgerReconcileReport 对账结果
+type LedgerReconcileReport struct {
+	StartedAt  int64          `json:"started_at"`
+	FinishedAt int64          `json:"finished_at"`
+	Checked    map[string]int `json:"checked"` // 账户类型 -> 有账本记录的账户数
+	Drifts     []*LedgerDrift `json:"drifts"`
+}
+
+type ledgerSummary struct {
+	AccountId int
+	DeltaSum  int64
+	FirstId   int
+	LastId    int
+}
+
+const ledgerReconcileBatchSize = 500
+
+// ReconcileQuotaLedger 核对用户、令牌和组织的余额与账本：当前余额应等于最后一笔记录的变动后余额，
+// 且各笔变动之和应等于首尾余额之差。账本启用前没有变动记录的账户不参与核对
+func ReconcileQuotaLedger() (*LedgerReconcileReport, error) {
+	report := &LedgerReconcileReport{
+		StartedAt: common.GetTimestamp(),
+		Checked:   make(map[string]int),
+		Drifts:    make([]*LedgerDrift, 0),
+	}
+	for _, accountType := range []string{LedgerAccountUser, LedgerAccountToken, LedgerAccountOrganization} {
+		if err := reconcileLedgerAccounts(accountType, report); err != nil {
+			return nil, err
+		}
+	}
+	report.FinishedAt = common.GetTimestamp()
+	return report, nil
+}
+
+func reconcileLedgerAccounts(accountType string, report *LedgerReconcileReport) error {
+	account := ledgerAccounts[accountType]
+	lastId := 0
+	for {
+		var balances []struct {
+			Id      int
+			Balance int
+		}
+		err := DB.Table(account.table).Select("id, "+account.column+" as balance").
+			Where("id > ?", lastId).Order("id asc").Limit(ledgerReconcileBatchSize).Scan(&balances).Error
+		if err != nil {
+			return err
+		}
+		if len(balances) == 0 {
+			return nil
+		}
+		lastId = balances[len(balances)-1].Id
+		ids := make([]int, 0, len(balances))
+		for _, balance := range balances {
+			ids = append(ids, balance.Id)
+		}
+		summaries, entries, err := getLedgerSummaries(accountType, ids)
+		if err != nil {
+			return err
+		}
+		for _, balance := range balances {
+			summary, ok := summaries[balance.Id]
+			if !ok {
+				continue
+			}
+			report.Checked[accountType]++
+			drift := checkLedgerDrift(accountType, balance.Id, balance.Balance, summary, entries)
+			if drift == nil {
+				continue
+			}
+			// 对账期间可能有新的变动，单独复核一次
+			if drift, err = recheckLedgerDrift(accountType, balance.Id); err != nil {
+				return err
+			}
+			if drift != nil {
+				report.Drifts = append(report.Drifts, drift)
+			}
+		}
+	}
+}
+
+func getLedgerSummaries(accountType string, ids []int) (map[int]ledgerSummary, map[int]QuotaLedger, error) {
+	var summaries []ledgerSummary
+	err := DB.Model(&QuotaLedger{}).
+		Select("account_id, sum(delta) as delta_sum, min(id) as first_id, max(id) as last_id").
+		Where("account_type = ? AND account_id IN ?", accountType, ids).
+		Group("account_id").Scan(&summaries).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	result := make(map[int]ledgerSummary, len(summaries))
+	entryIds := make([]int, 0, len(summaries)*2)
+	for _, summary := range summaries {
+		result[summary.AccountId] = summary
+		entryIds = append(entryIds, summary.FirstId, summary.LastId)
+	}
+	entries := make(map[int]QuotaLedger, len(entryIds))
+	if len(entryIds) == 0 {
+		return result, entries, nil
+	}
+	var ledgers []QuotaLedger
+	if err = DB.Where("id IN ?", entryIds).Find(&ledgers).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, ledger := range ledgers {
+		entries[ledger.Id] = ledger
+	}
+	return result, entries, nil
+}
+
+func checkLedgerDrift(accountType string, accountId int, balance int, summary ledgerSummary, entries map[int]QuotaLedger) *LedgerDrift {
+	first, last := entries[summary.FirstId], entries[summary.LastId]
+	chainGap := int64(last.BalanceAfter-first.BalanceBefore) - summary.DeltaSum
+	if balance == last.BalanceAfter && chainGap == 0 {
+		return nil
+	}
+	return &LedgerDrift{
+		AccountType:   accountType,
+		AccountId:     accountId,
+		Balance:       balance,
+		LedgerBalance: last.BalanceAfter,
+		ChainGap:      chainGap,
+	}
+}
+
+func recheckLedgerDrift(accountType string, accountId int) (drift *LedgerDrift, err error) {
+	account := ledgerAccounts[accountType]
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var balance int
+		err := tx.Set("gorm:query_option", "FOR UPDATE").Table(account.table).Where("id = ?", accountId).
+			Select(account.column).Scan(&balance).Error
+		if err != nil {
+			return err
+		}
+		var summary ledgerSummary
+		err = tx.Model(&QuotaLedger{}).
+			Select("account_id, sum(delta) as delta_sum, min(id) as first_id, max(id) as last_id").
+			Where("account_type = ? AND account_id = ?", accountType, accountId).
+			Group("account_id").Scan(&summary).Error
+		if err != nil {
+			return err
+		}
+		var ledgers []QuotaLedger
+		if err = tx.Where("id IN ?", []int{summary.FirstId, summary.LastId}).Find(&ledgers).Error; err != nil {
+			return err
+		}
+		entries := make(map[int]QuotaLedger, len(ledgers))
+		for _, ledger := range ledgers {
+			entries[ledger.Id] = ledger
+		}
+		drift = checkLedgerDrift(accountType, accountId, balance, summary, entries)
+		return nil
+	})
+	return drift, err
+}
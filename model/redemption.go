@@ -135,7 +135,10 @@ func Redeem(key string, userId int) (quota int, err error) {
 		if redemption.ExpiredTime != 0 && redemption.ExpiredTime < common.GetTimestamp() {
 			return errors.New("该兑换码已过期")
 		}
-		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+		_, err = changeBalance(tx, LedgerAccountUser, userId, nil, ledgerItem{
+			reason: LedgerReason{Type: LedgerTypeRedemption, Reference: strconv.Itoa(redemption.Id)},
+			delta:  redemption.Quota,
+		})
 		if err != nil {
 			return err
 		}
@@ -21,23 +21,25 @@ const (
 )
 
 type Task struct {
-	ID         int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
-	CreatedAt  int64                 `json:"created_at" gorm:"index"`
-	UpdatedAt  int64                 `json:"updated_at"`
-	TaskID     string                `json:"task_id" gorm:"type:varchar(50);index"`  // 第三方id，不一定有/ song id\ Task id
-	Platform   constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
-	UserId     int                   `json:"user_id" gorm:"index"`
-	TokenId    int                   `json:"token_id" gorm:"index"`
-	ChannelId  int                   `json:"channel_id" gorm:"index"`
-	Quota      int                   `json:"quota"`
-	Action     string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
-	Status     TaskStatus            `json:"status" gorm:"type:varchar(20);index"` // 任务状态
-	FailReason string                `json:"fail_reason"`
-	SubmitTime int64                 `json:"submit_time" gorm:"index"`
-	StartTime  int64                 `json:"start_time" gorm:"index"`
-	FinishTime int64                 `json:"finish_time" gorm:"index"`
-	Progress   string                `json:"progress" gorm:"type:varchar(20);index"`
-	Properties Properties            `json:"properties" gorm:"type:json"`
+	ID        int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
+	CreatedAt int64                 `json:"created_at" gorm:"index"`
+	UpdatedAt int64                 `json:"updated_at"`
+	TaskID    string                `json:"task_id" gorm:"type:varchar(50);index"`  // 第三方id，不一定有/ song id\ Task id
+	Platform  constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
+	UserId    int                   `json:"user_id" gorm:"index"`
+	TokenId   int                   `json:"token_id" gorm:"index"`
+	// 付费的组织，非 0 时任务失败的额度退还到该组织钱包
+	OrganizationId int        `json:"organization_id" gorm:"default:0"`
+	ChannelId      int        `json:"channel_id" gorm:"index"`
+	Quota          int        `json:"quota"`
+	Action         string     `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
+	Status         TaskStatus `json:"status" gorm:"type:varchar(20);index"` // 任务状态
+	FailReason     string     `json:"fail_reason"`
+	SubmitTime     int64      `json:"submit_time" gorm:"index"`
+	StartTime      int64      `json:"start_time" gorm:"index"`
+	FinishTime     int64      `json:"finish_time" gorm:"index"`
+	Progress       string     `json:"progress" gorm:"type:varchar(20);index"`
+	Properties     Properties `json:"properties" gorm:"type:json"`
 
 	Data json.RawMessage `json:"data" gorm:"type:json"`
 }
@@ -86,13 +88,14 @@ type SyncTaskQueryParams struct {
 
 func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.TaskRelayInfo) *Task {
 	t := &Task{
-		UserId:     relayInfo.UserId,
-		TokenId:    relayInfo.TokenId,
-		SubmitTime: time.Now().Unix(),
-		Status:     TaskStatusNotStart,
-		Progress:   "0%",
-		ChannelId:  relayInfo.ChannelId,
-		Platform:   platform,
+		UserId:         relayInfo.UserId,
+		TokenId:        relayInfo.TokenId,
+		OrganizationId: relayInfo.OrganizationId,
+		SubmitTime:     time.Now().Unix(),
+		Status:         TaskStatusNotStart,
+		Progress:       "0%",
+		ChannelId:      relayInfo.ChannelId,
+		Platform:       platform,
 	}
 	return t
 }
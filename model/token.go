@@ -39,23 +39,34 @@ func (t *TokenGroupInfo) Scan(value interface{}) error {
 }
 
 type Token struct {
-	Id                 int            `json:"id"`
-	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status             int            `json:"status" gorm:"default:1"`
-	Name               string         `json:"name" gorm:"index" `
-	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
-	AccessedTime       int64          `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime        int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota        int            `json:"remain_quota" gorm:"default:0"`
-	UnlimitedQuota     bool           `json:"unlimited_quota"`
-	ModelLimitsEnabled bool           `json:"model_limits_enabled"`
-	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
-	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
-	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
-	Group              string         `json:"group" gorm:"default:''"`     // 单分组模式（向后兼容）
-	GroupInfo          TokenGroupInfo `json:"group_info" gorm:"type:json"` // 多分组信息
-	DeletedAt          gorm.DeletedAt `gorm:"index"`
+	Id                    int            `json:"id"`
+	UserId                int            `json:"user_id" gorm:"index"`
+	Key                   string         `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Status                int            `json:"status" gorm:"default:1"`
+	Name                  string         `json:"name" gorm:"index" `
+	CreatedTime           int64          `json:"created_time" gorm:"bigint"`
+	AccessedTime          int64          `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime           int64          `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota           int            `json:"remain_quota" gorm:"default:0"`
+	UnlimitedQuota        bool           `json:"unlimited_quota"`
+	ModelLimitsEnabled    bool           `json:"model_limits_enabled"`
+	ModelLimits           string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
+	AllowIps              *string        `json:"allow_ips" gorm:"default:''"`
+	UsedQuota             int            `json:"used_quota" gorm:"default:0"` // used quota
+	Group                 string         `json:"group" gorm:"default:''"`     // 单分组模式（向后兼容）
+	GroupInfo             TokenGroupInfo `json:"group_info" gorm:"type:json"` // 多分组信息
+	HedgeEnabled          bool           `json:"hedge_enabled"`               // 是否启用对冲请求
+	ResponseCacheDisabled bool           `json:"response_cache_disabled"`     // 是否关闭响应缓存
+	// 周期预算，与令牌额度和用户余额同时生效
+	BudgetEnabled     bool           `json:"budget_enabled"`                                   // 是否启用周期预算
+	BudgetQuota       int            `json:"budget_quota" gorm:"default:0"`                    // 每个周期的总预算，0 表示只限制模型预算
+	BudgetPeriod      string         `json:"budget_period" gorm:"type:varchar(16);default:''"` // 重置周期：daily、weekly、monthly
+	BudgetAnchor      int64          `json:"budget_anchor" gorm:"bigint;default:0"`            // 周期起点，按该时间点的时刻、星期或日期重置
+	BudgetModelQuotas string         `json:"budget_model_quotas" gorm:"type:text"`             // 按模型拆分的每周期预算，JSON：模型 -> 额度
+	BudgetResetTime   int64          `json:"budget_reset_time" gorm:"bigint;default:0;index"`  // 下次重置时间
+	OrganizationId    int            `json:"organization_id" gorm:"default:0;index"`           // 所属组织，非 0 时从组织钱包扣费
+	ModelFallback     string         `json:"model_fallback" gorm:"type:text"`                  // 模型降级链，JSON：模型 -> 备选模型列表，优先于分组设置
+	DeletedAt         gorm.DeletedAt `gorm:"index"`
 
 	// 附加信息，不存入数据库
 	GroupInfoSerialization string `json:"-"`
@@ -290,9 +301,12 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 }
 
 func (token *Token) Insert() error {
-	var err error
-	err = DB.Create(token).Error
-	return err
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(token).Error; err != nil {
+			return err
+		}
+		return recordLedger(tx, LedgerAccountToken, token.Id, 0, token.RemainQuota, LedgerReason{Type: LedgerTypeTokenEdit})
+	})
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
@@ -307,8 +321,20 @@ func (token *Token) Update() (err error) {
 			})
 		}
 	}()
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "group_info").Updates(token).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var remainQuota int
+		if err := tx.Model(&Token{}).Where("id = ?", token.Id).Select("remain_quota").Scan(&remainQuota).Error; err != nil {
+			return err
+		}
+		err := tx.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
+			"model_limits_enabled", "model_limits", "allow_ips", "group", "group_info", "hedge_enabled", "response_cache_disabled",
+			"budget_enabled", "budget_quota", "budget_period", "budget_anchor", "budget_model_quotas", "budget_reset_time",
+			"organization_id", "model_fallback").Updates(token).Error
+		if err != nil {
+			return err
+		}
+		return recordLedger(tx, LedgerAccountToken, token.Id, remainQuota, token.RemainQuota, LedgerReason{Type: LedgerTypeTokenEdit})
+	})
 	return err
 }
 
@@ -362,6 +388,36 @@ func (token *Token) GetModelLimitsMap() map[string]bool {
 	return limitsMap
 }
 
+// GetModelFallbackMap 解析模型降级链，模型 -> 备选模型列表
+func (token *Token) GetModelFallbackMap() map[string][]string {
+	chains := make(map[string][]string)
+	if token.ModelFallback == "" {
+		return chains
+	}
+	if err := json.Unmarshal([]byte(token.ModelFallback), &chains); err != nil {
+		common.SysError("failed to unmarshal token model fallback: " + err.Error())
+	}
+	return chains
+}
+
+func (token *Token) ValidateModelFallback() error {
+	if token.ModelFallback == "" {
+		return nil
+	}
+	chains := make(map[string][]string)
+	if err := json.Unmarshal([]byte(token.ModelFallback), &chains); err != nil {
+		return errors.New("模型降级链格式错误：" + err.Error())
+	}
+	for modelName, chain := range chains {
+		for _, fallback := range chain {
+			if fallback == "" || fallback == modelName {
+				return fmt.Errorf("模型 %s 的备选模型无效", modelName)
+			}
+		}
+	}
+	return nil
+}
+
 func DisableModelLimits(tokenId int) error {
 	token, err := GetTokenById(tokenId)
 	if err != nil {
@@ -385,7 +441,7 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, key string, quota int) (err error) {
+func IncreaseTokenQuota(id int, key string, quota int, reason LedgerReason) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -398,24 +454,28 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 		})
 	}
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
+		addNewLedgerRecord(BatchUpdateTypeTokenQuota, id, quota, reason)
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	return increaseTokenQuota(id, ledgerItem{reason: reason, delta: quota})
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
+// increaseTokenQuota 按各笔变动之和更新令牌剩余额度和已用额度，并写入账本
+func increaseTokenQuota(id int, items ...ledgerItem) (err error) {
+	quota := 0
+	for _, item := range items {
+		quota += item.delta
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		_, err := changeBalance(tx, LedgerAccountToken, id, map[string]interface{}{
 			"used_quota":    gorm.Expr("used_quota - ?", quota),
 			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+		}, items...)
+		return err
+	})
 }
 
-func DecreaseTokenQuota(id int, key string, quota int) (err error) {
+func DecreaseTokenQuota(id int, key string, quota int, reason LedgerReason) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -428,21 +488,10 @@ func DecreaseTokenQuota(id int, key string, quota int) (err error) {
 		})
 	}
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
+		addNewLedgerRecord(BatchUpdateTypeTokenQuota, id, -quota, reason)
 		return nil
 	}
-	return decreaseTokenQuota(id, quota)
-}
-
-func decreaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
-			"used_quota":    gorm.Expr("used_quota + ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+	return increaseTokenQuota(id, ledgerItem{reason: reason, delta: -quota})
 }
 
 // CountUserTokens returns total number of tokens for the given user, used for pagination
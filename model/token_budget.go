@@ -0,0 +1,265 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 令牌预算的重置周期
+const (
+	TokenBudgetPeriodDaily   = "daily"
+	TokenBudgetPeriodWeekly  = "weekly"
+	TokenBudgetPeriodMonthly = "monthly"
+)
+
+// TokenBudgetUsage 令牌在当前预算周期内的用量，Model 为空表示总用量，周期重置时删除
+type TokenBudgetUsage struct {
+	Id        int    `json:"id"`
+	TokenId   int    `json:"token_id" gorm:"uniqueIndex:idx_token_budget_model"`
+	Model     string `json:"model" gorm:"type:varchar(255);uniqueIndex:idx_token_budget_model"`
+	UsedQuota int    `json:"used_quota" gorm:"default:0"`
+}
+
+// GetBudgetModelQuotas 解析按模型拆分的预算，模型 -> 每周期额度
+func (token *Token) GetBudgetModelQuotas() map[string]int {
+	quotas := make(map[string]int)
+	if token.BudgetModelQuotas == "" {
+		return quotas
+	}
+	if err := json.Unmarshal([]byte(token.BudgetModelQuotas), &quotas); err != nil {
+		common.SysError(fmt.Sprintf("failed to unmarshal budget model quotas of token %d: %s", token.Id, err.Error()))
+	}
+	return quotas
+}
+
+// ValidateBudget 校验令牌的预算配置
+func (token *Token) ValidateBudget() error {
+	if !token.BudgetEnabled {
+		return nil
+	}
+	switch token.BudgetPeriod {
+	case TokenBudgetPeriodDaily, TokenBudgetPeriodWeekly, TokenBudgetPeriodMonthly:
+	default:
+		return errors.New("预算周期只能为 daily、weekly 或 monthly")
+	}
+	if token.BudgetQuota < 0 {
+		return errors.New("预算额度不能为负数")
+	}
+	quotas := make(map[string]int)
+	if token.BudgetModelQuotas != "" {
+		if err := json.Unmarshal([]byte(token.BudgetModelQuotas), &quotas); err != nil {
+			return errors.New("模型预算格式错误：" + err.Error())
+		}
+	}
+	for modelName, quota := range quotas {
+		if modelName == "" || quota <= 0 {
+			return fmt.Errorf("模型 %s 的预算额度必须大于 0", modelName)
+		}
+	}
+	if token.BudgetQuota == 0 && len(quotas) == 0 {
+		return errors.New("请设置预算额度或模型预算")
+	}
+	return nil
+}
+
+// InitBudgetResetTime 未指定周期起点时从当前时间开始，并计算下次重置时间
+func (token *Token) InitBudgetResetTime() {
+	now := common.GetTimestamp()
+	if token.BudgetAnchor == 0 {
+		token.BudgetAnchor = now
+	}
+	token.BudgetResetTime = NextTokenBudgetResetTime(token.BudgetPeriod, token.BudgetAnchor, now)
+}
+
+// addBudgetPeriods 从周期起点向后推移 n 个周期，按月推移时日期超出当月天数则取当月最后一天
+func addBudgetPeriods(anchor time.Time, period string, n int) time.Time {
+	switch period {
+	case TokenBudgetPeriodWeekly:
+		return anchor.AddDate(0, 0, 7*n)
+	case TokenBudgetPeriodMonthly:
+		firstOfMonth := time.Date(anchor.Year(), anchor.Month()+time.Month(n), 1,
+			anchor.Hour(), anchor.Minute(), anchor.Second(), 0, anchor.Location())
+		lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+		day := anchor.Day()
+		if day > lastDay {
+			day = lastDay
+		}
+		return firstOfMonth.AddDate(0, 0, day-1)
+	default:
+		return anchor.AddDate(0, 0, n)
+	}
+}
+
+// NextTokenBudgetResetTime 计算 now 之后的下一次重置时间，重置时刻与周期起点对齐
+func NextTokenBudgetResetTime(period string, anchor int64, now int64) int64 {
+	anchorTime := time.Unix(anchor, 0)
+	if anchor > now {
+		return anchor
+	}
+	// 先按平均周期长度估算，再逐个周期修正
+	var estimate int
+	switch period {
+	case TokenBudgetPeriodWeekly:
+		estimate = int((now - anchor) / (7 * 24 * 3600))
+	case TokenBudgetPeriodMonthly:
+		nowTime := time.Unix(now, 0)
+		estimate = (nowTime.Year()-anchorTime.Year())*12 + int(nowTime.Month()-anchorTime.Month()) - 1
+	default:
+		estimate = int((now - anchor) / (24 * 3600))
+	}
+	if estimate < 0 {
+		estimate = 0
+	}
+	for n := estimate; ; n++ {
+		next := addBudgetPeriods(anchorTime, period, n)
+		if next.Unix() > now {
+			return next.Unix()
+		}
+	}
+}
+
+// GetTokenBudgetUsage 获取令牌当前周期的用量，键为模型名，空字符串为总用量
+func GetTokenBudgetUsage(tokenId int) (map[string]int, error) {
+	var usages []TokenBudgetUsage
+	if err := DB.Where("token_id = ?", tokenId).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(usages))
+	for _, usage := range usages {
+		result[usage.Model] = usage.UsedQuota
+	}
+	return result, nil
+}
+
+// tokenBudgetLimit 预占时需要检查的一项预算，limited 为 false 时只累加不限制
+type tokenBudgetLimit struct {
+	model   string
+	quota   int
+	limited bool
+}
+
+// ReserveTokenBudget 预占令牌当前周期的预算，用量累加与预算判断在同一条条件 UPDATE 中完成，
+// 并发请求不会同时通过检查。budgetQuota 不大于 0 时总用量只累加不限制；modelName 不为空时
+// 同时按 modelQuota 限制该模型的用量。预算不足时不累加任何用量，返回超出预算的模型名，空字符串为总预算
+func ReserveTokenBudget(tokenId int, quota int, budgetQuota int, modelName string, modelQuota int) (string, bool, error) {
+	limits := []tokenBudgetLimit{{model: "", quota: budgetQuota, limited: budgetQuota > 0}}
+	if modelName != "" {
+		limits = append(limits, tokenBudgetLimit{model: modelName, quota: modelQuota, limited: true})
+	}
+	exceeded := ""
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, limit := range limits {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&TokenBudgetUsage{TokenId: tokenId, Model: limit.model}).Error
+			if err != nil {
+				return err
+			}
+			query := tx.Model(&TokenBudgetUsage{}).Where("token_id = ? and model = ?", tokenId, limit.model)
+			if limit.limited {
+				query = query.Where("used_quota + ? <= ?", quota, limit.quota)
+			}
+			result := query.Update("used_quota", gorm.Expr("used_quota + ?", quota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				exceeded = limit.model
+				return errTokenBudgetExceeded
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errTokenBudgetExceeded) {
+		return exceeded, false, nil
+	}
+	return "", err == nil, err
+}
+
+var errTokenBudgetExceeded = errors.New("token budget exceeded")
+
+// IncreaseTokenBudgetUsage 累加令牌当前周期的用量，quota 为负数时退还多预占的用量，models 为需要单独统计的模型
+func IncreaseTokenBudgetUsage(tokenId int, quota int, models ...string) error {
+	if quota < 0 {
+		// 预占后周期可能已重置，退还时用量不能减为负数
+		return DB.Model(&TokenBudgetUsage{}).Where("token_id = ? and model in ?", tokenId, append([]string{""}, models...)).
+			Update("used_quota", gorm.Expr("case when used_quota + ? < 0 then 0 else used_quota + ? end", quota, quota)).Error
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, modelName := range append([]string{""}, models...) {
+			usage := TokenBudgetUsage{TokenId: tokenId, Model: modelName, UsedQuota: quota}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "token_id"}, {Name: "model"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{"used_quota": gorm.Expr("used_quota + ?", quota)}),
+			}).Create(&usage).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ResetTokenBudget 重置令牌的周期用量并推进下次重置时间。以原重置时间作为条件，多个节点同时重置时只有一个生效
+func ResetTokenBudget(token *Token) error {
+	now := common.GetTimestamp()
+	if token.BudgetResetTime > now {
+		return nil
+	}
+	nextResetTime := NextTokenBudgetResetTime(token.BudgetPeriod, token.BudgetAnchor, now)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Token{}).Where("id = ? and budget_reset_time = ?", token.Id, token.BudgetResetTime).
+			Update("budget_reset_time", nextResetTime)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 已被其他节点重置
+			return nil
+		}
+		return tx.Where("token_id = ?", token.Id).Delete(&TokenBudgetUsage{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	token.BudgetResetTime = nextResetTime
+	if common.RedisEnabled {
+		if err := cacheSetTokenField(token.Key, constant.TokenFiledBudgetResetTime, strconv.FormatInt(nextResetTime, 10)); err != nil {
+			common.SysError("failed to update token budget cache: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// ResetDueTokenBudgets 重置所有已到重置时间的令牌预算
+func ResetDueTokenBudgets() {
+	var tokens []*Token
+	err := DB.Where("budget_enabled = ? and budget_reset_time <= ?", true, common.GetTimestamp()).Find(&tokens).Error
+	if err != nil {
+		common.SysError("failed to get tokens with due budget: " + err.Error())
+		return
+	}
+	for _, token := range tokens {
+		if err := ResetTokenBudget(token); err != nil {
+			common.SysError(fmt.Sprintf("failed to reset budget of token %d: %s", token.Id, err.Error()))
+		}
+	}
+	if len(tokens) > 0 {
+		common.SysLog(fmt.Sprintf("reset budget of %d tokens", len(tokens)))
+	}
+}
+
+// SyncTokenBudgets 定时重置到期的令牌预算
+func SyncTokenBudgets(frequency int) {
+	for {
+		ResetDueTokenBudgets()
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
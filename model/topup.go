@@ -82,7 +82,10 @@ func Recharge(referenceId string, customerId string) (err error) {
 		}
 
 		quota = topUp.Money * common.QuotaPerUnit
-		err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Updates(map[string]interface{}{"stripe_customer": customerId, "quota": gorm.Expr("quota + ?", quota)}).Error
+		_, err = changeBalance(tx, LedgerAccountUser, topUp.UserId, map[string]interface{}{"stripe_customer": customerId}, ledgerItem{
+			reason: LedgerReason{Type: LedgerTypeTopUp, Reference: topUp.TradeNo},
+			delta:  int(quota),
+		})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// TrafficMirrorLog 流量镜像的对比记录：同一请求在主渠道和影子渠道上的延迟、状态和用量。
+// 影子请求不计费，也不影响渠道状态
+type TrafficMirrorLog struct {
+	Id                      int     `json:"id"`
+	CreatedAt               int64   `json:"created_at" gorm:"bigint;index"`
+	RequestId               string  `json:"request_id" gorm:"type:varchar(64);default:''"`
+	UserId                  int     `json:"user_id" gorm:"index"`
+	ModelName               string  `json:"model_name" gorm:"index;size:64;default:''"`
+	IsStream                bool    `json:"is_stream"`
+	PrimaryChannelId        int     `json:"primary_channel_id"`
+	PrimaryStatus           int     `json:"primary_status"`
+	PrimaryLatencyMs        int64   `json:"primary_latency_ms"`
+	PrimaryPromptTokens     int     `json:"primary_prompt_tokens"`
+	PrimaryCompletionTokens int     `json:"primary_completion_tokens"`
+	ShadowChannelId         int     `json:"shadow_channel_id" gorm:"index"`
+	ShadowStatus            int     `json:"shadow_status"`
+	ShadowLatencyMs         int64   `json:"shadow_latency_ms"`
+	ShadowPromptTokens      int     `json:"shadow_prompt_tokens"`
+	ShadowCompletionTokens  int     `json:"shadow_completion_tokens"`
+	ShadowError             string  `json:"shadow_error" gorm:"type:text"`
+	PrimaryOutput           string  `json:"primary_output" gorm:"type:text"`
+	ShadowOutput            string  `json:"shadow_output" gorm:"type:text"`
+	OutputSimilarity        float64 `json:"output_similarity"` // 输出的相似度（0-1），未记录输出时为 0
+}
+
+// TrafficMirrorSummary 按影子渠道和模型汇总的对比结果
+type TrafficMirrorSummary struct {
+	ShadowChannelId         int     `json:"shadow_channel_id"`
+	ShadowChannelName       string  `json:"shadow_channel_name"`
+	ModelName               string  `json:"model_name"`
+	Count                   int     `json:"count"`
+	PrimaryErrors           int     `json:"primary_errors"`
+	ShadowErrors            int     `json:"shadow_errors"`
+	PrimaryAvgLatencyMs     float64 `json:"primary_avg_latency_ms"`
+	ShadowAvgLatencyMs      float64 `json:"shadow_avg_latency_ms"`
+	PrimaryCompletionTokens int     `json:"primary_completion_tokens"`
+	ShadowCompletionTokens  int     `json:"shadow_completion_tokens"`
+	AvgOutputSimilarity     float64 `json:"avg_output_similarity"`
+}
+
+func RecordTrafficMirrorLog(log *TrafficMirrorLog) {
+	log.CreatedAt = common.GetTimestamp()
+	if err := DB.Create(log).Error; err != nil {
+		common.SysError("failed to record traffic mirror log: " + err.Error())
+	}
+}
+
+func trafficMirrorQuery(shadowChannelId int, modelName string, startTime int64, endTime int64) *gorm.DB {
+	tx := DB.Model(&TrafficMirrorLog{})
+	if shadowChannelId != 0 {
+		tx = tx.Where("shadow_channel_id = ?", shadowChannelId)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if startTime != 0 {
+		tx = tx.Where("created_at >= ?", startTime)
+	}
+	if endTime != 0 {
+		tx = tx.Where("created_at <= ?", endTime)
+	}
+	return tx
+}
+
+func GetTrafficMirrorLogs(shadowChannelId int, modelName string, startTime int64, endTime int64, startIdx int, num int) ([]*TrafficMirrorLog, int64, error) {
+	var logs []*TrafficMirrorLog
+	var total int64
+	tx := trafficMirrorQuery(shadowChannelId, modelName, startTime, endTime)
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, total, err
+}
+
+// GetTrafficMirrorSummary 按影子渠道和模型汇总对比记录，相似度只统计记录了输出的请求
+func GetTrafficMirrorSummary(shadowChannelId int, modelName string, startTime int64, endTime int64) ([]*TrafficMirrorSummary, error) {
+	var summaries []*TrafficMirrorSummary
+	err := trafficMirrorQuery(shadowChannelId, modelName, startTime, endTime).
+		Select("shadow_channel_id, model_name, count(*) as count, " +
+			"sum(case when primary_status <> 200 then 1 else 0 end) as primary_errors, " +
+			"sum(case when shadow_status <> 200 then 1 else 0 end) as shadow_errors, " +
+			"avg(primary_latency_ms) as primary_avg_latency_ms, " +
+			"avg(shadow_latency_ms) as shadow_avg_latency_ms, " +
+			"sum(primary_completion_tokens) as primary_completion_tokens, " +
+			"sum(shadow_completion_tokens) as shadow_completion_tokens, " +
+			"coalesce(avg(case when primary_output <> '' or shadow_output <> '' then output_similarity end), 0) as avg_output_similarity").
+		Group("shadow_channel_id, model_name").
+		Scan(&summaries).Error
+	if err != nil {
+		return nil, err
+	}
+	channelIds := make([]int, 0, len(summaries))
+	for _, summary := range summaries {
+		channelIds = append(channelIds, summary.ShadowChannelId)
+	}
+	if len(channelIds) > 0 {
+		var channels []*Channel
+		if err = DB.Select("id, name").Where("id IN ?", channelIds).Find(&channels).Error; err != nil {
+			return nil, err
+		}
+		channelNames := make(map[int]string, len(channels))
+		for _, channel := range channels {
+			channelNames[channel.Id] = channel.Name
+		}
+		for _, summary := range summaries {
+			summary.ShadowChannelName = channelNames[summary.ShadowChannelId]
+		}
+	}
+	return summaries, nil
+}
@@ -105,6 +105,7 @@ func SaveQuotaDataCache() {
 		}
 	}
 	CacheQuotaData = make(map[string]*QuotaData)
+	SaveChannelQuotaDataCache()
 	common.SysLog(fmt.Sprintf("保存数据看板数据成功，共保存%d条数据", size))
 }
 
@@ -151,3 +152,137 @@ func GetQuotaUserRank(startTime int64, endTime int64) (rank []*QuotaUserRank, er
 	err = DB.Table("quota_data").Select("user_id, username, sum(count) as count, sum(quota) as quota, sum(token_used) as token_used").Where("created_at >= ? and created_at <= ?", startTime, endTime).Group("user_id, username").Order("sum(quota) desc").Limit(10).Find(&userRanks).Error
 	return userRanks, err
 }
+
+// ChannelQuotaData 渠道按模型、小时汇总的收入和上游成本
+type ChannelQuotaData struct {
+	Id          int    `json:"id"`
+	ChannelId   int    `json:"channel_id" gorm:"index:idx_cqd_channel_model,priority:1"`
+	ModelName   string `json:"model_name" gorm:"index:idx_cqd_channel_model,priority:2;size:64;default:''"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+	Count       int    `json:"count" gorm:"default:0"`
+	Quota       int    `json:"quota" gorm:"default:0"`
+	Cost        int    `json:"cost" gorm:"default:0"`
+	CostedQuota int    `json:"costed_quota" gorm:"default:0"` // 设置了上游成本的请求的收入，用于计算利润
+}
+
+var CacheChannelQuotaData = make(map[string]*ChannelQuotaData)
+var CacheChannelQuotaDataLock = sync.Mutex{}
+
+func LogChannelQuotaData(channelId int, modelName string, quota int, cost int, hasCost bool, createdAt int64) {
+	// 只精确到小时
+	createdAt = createdAt - (createdAt % 3600)
+	costedQuota := 0
+	if hasCost {
+		costedQuota = quota
+	}
+
+	CacheChannelQuotaDataLock.Lock()
+	defer CacheChannelQuotaDataLock.Unlock()
+	key := fmt.Sprintf("%d-%s-%d", channelId, modelName, createdAt)
+	channelQuotaData, ok := CacheChannelQuotaData[key]
+	if !ok {
+		channelQuotaData = &ChannelQuotaData{
+			ChannelId: channelId,
+			ModelName: modelName,
+			CreatedAt: createdAt,
+		}
+		CacheChannelQuotaData[key] = channelQuotaData
+	}
+	channelQuotaData.Count += 1
+	channelQuotaData.Quota += quota
+	channelQuotaData.Cost += cost
+	channelQuotaData.CostedQuota += costedQuota
+}
+
+func SaveChannelQuotaDataCache() {
+	CacheChannelQuotaDataLock.Lock()
+	defer CacheChannelQuotaDataLock.Unlock()
+	for _, data := range CacheChannelQuotaData {
+		result := DB.Model(&ChannelQuotaData{}).Where("channel_id = ? and model_name = ? and created_at = ?",
+			data.ChannelId, data.ModelName, data.CreatedAt).Updates(map[string]interface{}{
+			"count":        gorm.Expr("count + ?", data.Count),
+			"quota":        gorm.Expr("quota + ?", data.Quota),
+			"cost":         gorm.Expr("cost + ?", data.Cost),
+			"costed_quota": gorm.Expr("costed_quota + ?", data.CostedQuota),
+		})
+		if result.Error != nil {
+			common.SysLog(fmt.Sprintf("SaveChannelQuotaDataCache error: %s", result.Error))
+			continue
+		}
+		if result.RowsAffected == 0 {
+			if err := DB.Create(data).Error; err != nil {
+				common.SysLog(fmt.Sprintf("SaveChannelQuotaDataCache error: %s", err))
+			}
+		}
+	}
+	CacheChannelQuotaData = make(map[string]*ChannelQuotaData)
+}
+
+// ChannelCostReport 渠道按模型、日期汇总的收入、成本和利润
+type ChannelCostReport struct {
+	Date        string  `json:"date"`
+	ChannelId   int     `json:"channel_id"`
+	ChannelName string  `json:"channel_name"`
+	ModelName   string  `json:"model_name"`
+	Count       int     `json:"count"`
+	Quota       int     `json:"quota"`        // 收入
+	Cost        int     `json:"cost"`         // 上游成本
+	CostedQuota int     `json:"costed_quota"` // 设置了上游成本的请求的收入
+	Margin      int     `json:"margin"`       // 利润，只统计设置了上游成本的请求
+	MarginRate  float64 `json:"margin_rate"`  // 利润率
+}
+
+// GetChannelCostReport 按渠道、模型和日期（服务器时区）汇总收入与成本，channelId 为 0 或 modelName 为空时不过滤
+func GetChannelCostReport(startTime int64, endTime int64, channelId int, modelName string) ([]*ChannelCostReport, error) {
+	var datas []*ChannelQuotaData
+	tx := DB.Model(&ChannelQuotaData{}).Where("created_at >= ? and created_at <= ?", startTime, endTime)
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name = ?", modelName)
+	}
+	if err := tx.Order("created_at asc").Find(&datas).Error; err != nil {
+		return nil, err
+	}
+	reports := make([]*ChannelCostReport, 0)
+	reportMap := make(map[string]*ChannelCostReport)
+	channelIds := make([]int, 0)
+	for _, data := range datas {
+		date := time.Unix(data.CreatedAt, 0).Format("2006-01-02")
+		key := fmt.Sprintf("%s-%d-%s", date, data.ChannelId, data.ModelName)
+		report, ok := reportMap[key]
+		if !ok {
+			report = &ChannelCostReport{
+				Date:      date,
+				ChannelId: data.ChannelId,
+				ModelName: data.ModelName,
+			}
+			reportMap[key] = report
+			reports = append(reports, report)
+			channelIds = append(channelIds, data.ChannelId)
+		}
+		report.Count += data.Count
+		report.Quota += data.Quota
+		report.Cost += data.Cost
+		report.CostedQuota += data.CostedQuota
+	}
+	channelNames := make(map[int]string)
+	if len(channelIds) > 0 {
+		var channels []*Channel
+		if err := DB.Select("id, name").Where("id IN ?", channelIds).Find(&channels).Error; err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			channelNames[channel.Id] = channel.Name
+		}
+	}
+	for _, report := range reports {
+		report.ChannelName = channelNames[report.ChannelId]
+		report.Margin = report.CostedQuota - report.Cost
+		if report.CostedQuota > 0 {
+			report.MarginRate = float64(report.Margin) / float64(report.CostedQuota)
+		}
+	}
+	return reports, nil
+}
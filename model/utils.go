@@ -22,10 +22,14 @@ const (
 var batchUpdateStores []map[int]int
 var batchUpdateLocks []sync.Mutex
 
+// 余额变动的账本记录，与合并后的变动额一同写入
+var batchUpdateLedgerStores []map[int][]ledgerItem
+
 func init() {
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateStores = append(batchUpdateStores, make(map[int]int))
 		batchUpdateLocks = append(batchUpdateLocks, sync.Mutex{})
+		batchUpdateLedgerStores = append(batchUpdateLedgerStores, make(map[int][]ledgerItem))
 	}
 }
 
@@ -48,6 +52,13 @@ func addNewRecord(type_ int, id int, value int) {
 	}
 }
 
+func addNewLedgerRecord(type_ int, id int, value int, reason LedgerReason) {
+	batchUpdateLocks[type_].Lock()
+	defer batchUpdateLocks[type_].Unlock()
+	batchUpdateStores[type_][id] += value
+	batchUpdateLedgerStores[type_][id] = append(batchUpdateLedgerStores[type_][id], ledgerItem{reason: reason, delta: value})
+}
+
 func batchUpdate() {
 	// check if there's any data to update
 	hasData := false
@@ -70,17 +81,22 @@ func batchUpdate() {
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int)
+		ledgerStore := batchUpdateLedgerStores[i]
+		batchUpdateLedgerStores[i] = make(map[int][]ledgerItem)
 		batchUpdateLocks[i].Unlock()
 		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err := DB.Transaction(func(tx *gorm.DB) error {
+					_, err := changeBalance(tx, LedgerAccountUser, key, nil, ledgerStore[key]...)
+					return err
+				})
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
 				}
 			case BatchUpdateTypeTokenQuota:
-				err := increaseTokenQuota(key, value)
+				err := increaseTokenQuota(key, ledgerStore[key]...)
 				if err != nil {
 					common.SysError("failed to batch update token quota: " + err.Error())
 				}
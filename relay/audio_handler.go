@@ -104,7 +104,7 @@ func AudioHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 	}
 
-	resp, err := adaptor.DoRequest(c, relayInfo, ioReader)
+	resp, err := doRequestWithSpan(c, adaptor, relayInfo, ioReader)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeDoRequestFailed)
 	}
@@ -121,7 +121,7 @@ func AudioHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newAPIError := doResponseWithSpan(c, adaptor, httpResp, relayInfo)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
@@ -147,6 +147,10 @@ func startPingKeepAlive(c *gin.Context, pingInterval time.Duration) context.Canc
 			select {
 			// 发送 ping 数据
 			case <-ticker.C:
+				// 对冲请求决出胜者前不发送 ping
+				if helper.IsHedgePending(c) {
+					continue
+				}
 				if err := sendPingData(c, &pingMutex); err != nil {
 					if common2.DebugEnabled {
 						println("SSE ping error, stopping goroutine:", err.Error())
@@ -238,6 +242,11 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	// 对冲请求的尝试需要在竞速失败时取消上游请求
+	if helper.GetHedgeAttempt(c) != nil {
+		req = req.WithContext(c.Request.Context())
+	}
+	common2.InjectTraceHeaders(c.Request.Context(), req.Header)
 	resp, err := client.Do(req)
 
 	if err != nil {
@@ -457,7 +457,7 @@ func StreamResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse
 					signatureContent := "\n"
 					choice.Delta.ReasoningContent = &signatureContent
 				case "thinking_delta":
-					thinkingContent := claudeResponse.Delta.Thinking
+					thinkingContent := claudeResponse.Delta.GetThinking()
 					choice.Delta.ReasoningContent = &thinkingContent
 				}
 			}
@@ -493,7 +493,7 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse) *dto
 	var responseThinking string
 	if len(claudeResponse.Content) > 0 {
 		responseText = claudeResponse.Content[0].GetText()
-		responseThinking = claudeResponse.Content[0].Thinking
+		responseThinking = claudeResponse.Content[0].GetThinking()
 	}
 	tools := make([]dto.ToolCallResponse, 0)
 	thinkingContent := ""
@@ -525,7 +525,7 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse) *dto
 				})
 			case "thinking":
 				// 加密的不管， 只输出明文的推理过程
-				thinkingContent = message.Thinking
+				thinkingContent = message.GetThinking()
 			case "text":
 				responseText = message.GetText()
 			}
@@ -578,8 +578,8 @@ func FormatClaudeResponseInfo(requestMode int, claudeResponse *dto.ClaudeRespons
 			if claudeResponse.Delta.Text != nil {
 				claudeInfo.ResponseText.WriteString(*claudeResponse.Delta.Text)
 			}
-			if claudeResponse.Delta.Thinking != "" {
-				claudeInfo.ResponseText.WriteString(claudeResponse.Delta.Thinking)
+			if claudeResponse.Delta.Thinking != nil {
+				claudeInfo.ResponseText.WriteString(*claudeResponse.Delta.Thinking)
 			}
 		} else if claudeResponse.Type == "message_delta" {
 			// 最终的usage获取
@@ -683,17 +683,29 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 		Usage:        &dto.Usage{},
 	}
 	var err *types.NewAPIError
-	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+	var streamQuotaErr *types.NewAPIError
+	streamErr := helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+		textLen := claudeInfo.ResponseText.Len()
 		err = HandleStreamResponseData(c, info, claudeInfo, data, requestMode)
 		if err != nil {
 			return false
 		}
-		return true
+		streamQuotaErr = service.SettleStreamCompletionText(info, claudeInfo.ResponseText.String()[textLen:])
+		return streamQuotaErr == nil
 	})
+	if streamErr != nil {
+		return streamErr, nil
+	}
 	if err != nil {
 		return err, nil
 	}
 
+	if streamQuotaErr != nil {
+		// 额度不足时中途结束，已输出部分仍按实际用量计费
+		common.LogWarn(c, "stream terminated: "+streamQuotaErr.Error())
+		helper.StreamErrorData(c, info, streamQuotaErr)
+	}
+
 	HandleStreamFinalResponse(c, info, claudeInfo, requestMode)
 	return nil, claudeInfo.Usage
 }
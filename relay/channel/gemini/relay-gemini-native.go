@@ -69,8 +69,9 @@ func GeminiTextGenerationStreamHandler(c *gin.Context, info *relaycommon.RelayIn
 	helper.SetEventStreamHeaders(c)
 
 	responseText := strings.Builder{}
+	var streamQuotaErr *types.NewAPIError
 
-	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+	streamErr := helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		var geminiResponse GeminiChatResponse
 		err := common.UnmarshalJsonStr(data, &geminiResponse)
 		if err != nil {
@@ -111,8 +112,18 @@ func GeminiTextGenerationStreamHandler(c *gin.Context, info *relaycommon.RelayIn
 			common.LogError(c, err.Error())
 		}
 
-		return true
+		streamQuotaErr = settleStreamQuota(info, &geminiResponse)
+		return streamQuotaErr == nil
 	})
+	if streamErr != nil {
+		return nil, streamErr
+	}
+
+	if streamQuotaErr != nil {
+		// 额度不足时中途结束，已输出部分仍按实际用量计费
+		common.LogWarn(c, "stream terminated: "+streamQuotaErr.Error())
+		helper.StreamErrorData(c, info, streamQuotaErr)
+	}
 
 	if imageCount != 0 {
 		if usage.CompletionTokens == 0 {
@@ -0,0 +1,610 @@
+package gemini
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type geminiFunctionDeclaration struct {
+	Name                 string `json:"name"`
+	Description          string `json:"description,omitempty"`
+	Parameters           any    `json:"parameters,omitempty"`
+	ParametersJsonSchema any    `json:"parametersJsonSchema,omitempty"`
+}
+
+// GeminiToOpenAIRequest 将 Gemini 原生请求转换为 OpenAI 格式，用于非 Gemini 渠道处理 /v1beta 请求
+func GeminiToOpenAIRequest(geminiRequest *GeminiChatRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	config := geminiRequest.GenerationConfig
+	openAIRequest := &dto.GeneralOpenAIRequest{
+		Model:       info.UpstreamModelName,
+		Stream:      info.IsStream,
+		Temperature: config.Temperature,
+		TopP:        config.TopP,
+		TopK:        int(config.TopK),
+		MaxTokens:   config.MaxOutputTokens,
+		N:           config.CandidateCount,
+		Seed:        float64(config.Seed),
+	}
+	if info.IsStream && info.SupportStreamOptions {
+		openAIRequest.StreamOptions = &dto.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+	if len(config.StopSequences) > 0 {
+		openAIRequest.Stop = config.StopSequences
+	}
+	if config.ResponseMimeType == "application/json" {
+		if config.ResponseSchema != nil {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &dto.FormatJsonSchema{
+					Name:   "response",
+					Schema: geminiSchemaToJsonSchema(config.ResponseSchema),
+				},
+			}
+		} else {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		}
+	}
+
+	// Convert tools
+	for _, tool := range geminiRequest.Tools {
+		if tool.GoogleSearch != nil || tool.GoogleSearchRetrieval != nil {
+			openAIRequest.WebSearchOptions = &dto.WebSearchOptions{}
+		}
+		if tool.FunctionDeclarations == nil {
+			continue
+		}
+		declarations, err := common.Any2Type[[]geminiFunctionDeclaration](tool.FunctionDeclarations)
+		if err != nil {
+			return nil, fmt.Errorf("invalid function declarations: %w", err)
+		}
+		for _, declaration := range declarations {
+			parameters := declaration.ParametersJsonSchema
+			if parameters == nil && declaration.Parameters != nil {
+				parameters = geminiSchemaToJsonSchema(declaration.Parameters)
+			}
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:        declaration.Name,
+					Description: declaration.Description,
+					Parameters:  parameters,
+				},
+			})
+		}
+	}
+	if len(openAIRequest.Tools) > 0 && geminiRequest.ToolConfig != nil && geminiRequest.ToolConfig.FunctionCallingConfig != nil {
+		callingConfig := geminiRequest.ToolConfig.FunctionCallingConfig
+		switch strings.ToUpper(callingConfig.Mode) {
+		case "AUTO":
+			openAIRequest.ToolChoice = "auto"
+		case "NONE":
+			openAIRequest.ToolChoice = "none"
+		case "ANY":
+			if len(callingConfig.AllowedFunctionNames) == 1 {
+				openAIRequest.ToolChoice = map[string]any{
+					"type": "function",
+					"function": map[string]any{
+						"name": callingConfig.AllowedFunctionNames[0],
+					},
+				}
+			} else {
+				openAIRequest.ToolChoice = "required"
+			}
+		}
+	}
+
+	// Convert messages
+	messages := make([]dto.Message, 0, len(geminiRequest.Contents)+1)
+	if geminiRequest.SystemInstructions != nil {
+		var texts []string
+		for _, part := range geminiRequest.SystemInstructions.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			systemMessage := dto.Message{Role: "system"}
+			systemMessage.SetStringContent(strings.Join(texts, "\n"))
+			messages = append(messages, systemMessage)
+		}
+	}
+
+	// Gemini 的函数调用没有 id，按函数名依次匹配调用与结果
+	pendingCallIds := make(map[string][]string)
+	callCount := 0
+	for _, content := range geminiRequest.Contents {
+		message := dto.Message{Role: "user"}
+		if content.Role == "model" {
+			message.Role = "assistant"
+		}
+		mediaContents := make([]dto.MediaContent, 0, len(content.Parts))
+		var toolCalls []dto.ToolCallRequest
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				callCount++
+				callId := fmt.Sprintf("call_%d", callCount)
+				name := part.FunctionCall.FunctionName
+				pendingCallIds[name] = append(pendingCallIds[name], callId)
+				arguments := "{}"
+				if part.FunctionCall.Arguments != nil {
+					argumentsJson, err := common.Marshal(part.FunctionCall.Arguments)
+					if err != nil {
+						return nil, err
+					}
+					arguments = string(argumentsJson)
+				}
+				toolCalls = append(toolCalls, dto.ToolCallRequest{
+					ID:   callId,
+					Type: "function",
+					Function: dto.FunctionRequest{
+						Name:      name,
+						Arguments: arguments,
+					},
+				})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				callId := fmt.Sprintf("call_%s", name)
+				if ids := pendingCallIds[name]; len(ids) > 0 {
+					callId = ids[0]
+					pendingCallIds[name] = ids[1:]
+				}
+				response, err := common.Marshal(part.FunctionResponse.Response)
+				if err != nil {
+					return nil, err
+				}
+				toolMessage := dto.Message{
+					Role:       "tool",
+					ToolCallId: callId,
+				}
+				toolMessage.SetStringContent(string(response))
+				messages = append(messages, toolMessage)
+			case part.Thought:
+				// 思考内容无法回传给 OpenAI 格式的上游，直接丢弃
+			case part.Text != "":
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: part.Text,
+				})
+			case part.InlineData != nil:
+				mediaContents = append(mediaContents, geminiInlineDataToMediaContent(part.InlineData))
+			case part.FileData != nil:
+				mediaContents = append(mediaContents, geminiFileDataToMediaContent(part.FileData))
+			case part.ExecutableCode != nil:
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: "```" + part.ExecutableCode.Language + "\n" + part.ExecutableCode.Code + "\n```",
+				})
+			case part.CodeExecutionResult != nil:
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: "```output\n" + part.CodeExecutionResult.Output + "\n```",
+				})
+			}
+		}
+		if len(toolCalls) > 0 {
+			message.SetToolCalls(toolCalls)
+		}
+		if len(mediaContents) == 1 && mediaContents[0].Type == dto.ContentTypeText {
+			message.SetStringContent(mediaContents[0].Text)
+		} else if len(mediaContents) > 0 {
+			message.SetMediaContent(mediaContents)
+		}
+		if len(mediaContents) > 0 || len(toolCalls) > 0 {
+			messages = append(messages, message)
+		}
+	}
+	openAIRequest.Messages = messages
+	return openAIRequest, nil
+}
+
+// geminiSchemaToJsonSchema Gemini 的 Schema 类型为大写（如 OBJECT），转换为 JSON Schema 的小写类型
+func geminiSchemaToJsonSchema(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, value := range v {
+			if typeName, ok := value.(string); ok && key == "type" {
+				result[key] = strings.ToLower(typeName)
+				continue
+			}
+			result[key] = geminiSchemaToJsonSchema(value)
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, item := range v {
+			result = append(result, geminiSchemaToJsonSchema(item))
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+func geminiInlineDataToMediaContent(inlineData *GeminiInlineData) dto.MediaContent {
+	dataUrl := fmt.Sprintf("data:%s;base64,%s", inlineData.MimeType, inlineData.Data)
+	switch {
+	case strings.HasPrefix(inlineData.MimeType, "image/"):
+		return dto.MediaContent{
+			Type:     dto.ContentTypeImageURL,
+			ImageUrl: &dto.MessageImageUrl{Url: dataUrl},
+		}
+	case strings.HasPrefix(inlineData.MimeType, "audio/"):
+		format := strings.TrimPrefix(inlineData.MimeType, "audio/")
+		if format == "mpeg" {
+			format = "mp3"
+		}
+		return dto.MediaContent{
+			Type: dto.ContentTypeInputAudio,
+			InputAudio: &dto.MessageInputAudio{
+				Data:   inlineData.Data,
+				Format: format,
+			},
+		}
+	default:
+		return dto.MediaContent{
+			Type: dto.ContentTypeFile,
+			File: &dto.MessageFile{
+				FileName: "file",
+				FileData: dataUrl,
+			},
+		}
+	}
+}
+
+func geminiFileDataToMediaContent(fileData *GeminiFileData) dto.MediaContent {
+	if strings.HasPrefix(fileData.MimeType, "image/") {
+		return dto.MediaContent{
+			Type:     dto.ContentTypeImageURL,
+			ImageUrl: &dto.MessageImageUrl{Url: fileData.FileUri},
+		}
+	}
+	// OpenAI 格式不支持通过 URI 引用文件，以文本形式提供给模型
+	return dto.MediaContent{
+		Type: dto.ContentTypeText,
+		Text: "File: " + fileData.FileUri,
+	}
+}
+
+func finishReasonOpenAI2Gemini(reason string) *string {
+	var geminiReason string
+	switch reason {
+	case "":
+		return nil
+	case "stop", "tool_calls", "function_call":
+		geminiReason = "STOP"
+	case "length":
+		geminiReason = "MAX_TOKENS"
+	case "content_filter":
+		geminiReason = "SAFETY"
+	default:
+		geminiReason = "OTHER"
+	}
+	return &geminiReason
+}
+
+// usageOpenAI2Gemini Gemini 的 candidatesTokenCount 不包含思考 token
+func usageOpenAI2Gemini(usage *dto.Usage) GeminiUsageMetadata {
+	thoughtsTokens := usage.CompletionTokenDetails.ReasoningTokens
+	candidatesTokens := usage.CompletionTokens - thoughtsTokens
+	if candidatesTokens < 0 {
+		candidatesTokens = 0
+	}
+	return GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: candidatesTokens,
+		ThoughtsTokenCount:   thoughtsTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+	}
+}
+
+func toolCallArguments2Gemini(arguments string) any {
+	var args map[string]interface{}
+	if err := common.UnmarshalJsonStr(arguments, &args); err != nil || args == nil {
+		return map[string]interface{}{}
+	}
+	return args
+}
+
+// ResponseOpenAI2Gemini 将 OpenAI 格式的非流式响应转换为 Gemini 格式
+func ResponseOpenAI2Gemini(response *dto.OpenAITextResponse) *GeminiChatResponse {
+	geminiResponse := &GeminiChatResponse{
+		Candidates:    make([]GeminiChatCandidate, 0, len(response.Choices)),
+		UsageMetadata: usageOpenAI2Gemini(&response.Usage),
+	}
+	for _, choice := range response.Choices {
+		parts := make([]GeminiPart, 0)
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			parts = append(parts, GeminiPart{Text: text})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			parts = append(parts, GeminiPart{
+				FunctionCall: &FunctionCall{
+					FunctionName: toolCall.Function.Name,
+					Arguments:    toolCallArguments2Gemini(toolCall.Function.Arguments),
+				},
+			})
+		}
+		geminiResponse.Candidates = append(geminiResponse.Candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: finishReasonOpenAI2Gemini(choice.FinishReason),
+			Index:        int64(choice.Index),
+		})
+	}
+	return geminiResponse
+}
+
+// OpenAI2GeminiWriter 包装 gin.ResponseWriter，将渠道输出的 OpenAI 格式响应转换为 Gemini 格式后写给客户端。
+// 流式响应逐个分片转换，非流式响应在 Close 时统一转换
+type OpenAI2GeminiWriter struct {
+	gin.ResponseWriter
+	isStream bool
+	status   int
+	buffer   bytes.Buffer
+	// 按 choice 下标和工具调用下标累积分片输出的工具调用
+	toolCalls map[int]map[int]*dto.ToolCallResponse
+	// 已结束但尚未输出的分片，等待随后的 usage 分片一起输出
+	pending *GeminiChatResponse
+}
+
+func NewOpenAI2GeminiWriter(writer gin.ResponseWriter, isStream bool) *OpenAI2GeminiWriter {
+	return &OpenAI2GeminiWriter{
+		ResponseWriter: writer,
+		isStream:       isStream,
+		status:         http.StatusOK,
+		toolCalls:      make(map[int]map[int]*dto.ToolCallResponse),
+	}
+}
+
+func (w *OpenAI2GeminiWriter) WriteHeader(code int) {
+	if w.isStream {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *OpenAI2GeminiWriter) WriteHeaderNow() {
+	if w.isStream {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *OpenAI2GeminiWriter) Status() int {
+	if w.isStream {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *OpenAI2GeminiWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.isStream {
+		for {
+			line, err := w.buffer.ReadString('\n')
+			if err != nil {
+				// 不完整的行放回缓冲区等待后续数据
+				w.buffer.Reset()
+				w.buffer.WriteString(line)
+				break
+			}
+			w.handleStreamLine(strings.TrimRight(line, "\r\n"))
+		}
+	}
+	return len(data), nil
+}
+
+func (w *OpenAI2GeminiWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *OpenAI2GeminiWriter) handleStreamLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		// 保活注释原样输出
+		_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		return
+	}
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" {
+		return
+	}
+	if data == "[DONE]" {
+		w.flushPending()
+		return
+	}
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return
+	}
+	w.handleStreamResponse(&streamResponse)
+}
+
+func (w *OpenAI2GeminiWriter) handleStreamResponse(streamResponse *dto.ChatCompletionsStreamResponse) {
+	geminiResponse := &GeminiChatResponse{}
+	finished := false
+	for _, choice := range streamResponse.Choices {
+		parts := make([]GeminiPart, 0)
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		if text := choice.Delta.GetContentString(); text != "" {
+			parts = append(parts, GeminiPart{Text: text})
+		}
+		w.appendToolCalls(choice.Index, choice.Delta.ToolCalls)
+		var finishReason *string
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			// 工具调用的参数是分片输出的，而 Gemini 需要完整的 functionCall，因此在结束时一并输出
+			parts = append(parts, w.takeFunctionCalls(choice.Index)...)
+			finishReason = finishReasonOpenAI2Gemini(*choice.FinishReason)
+			finished = true
+		}
+		if len(parts) == 0 && finishReason == nil {
+			continue
+		}
+		geminiResponse.Candidates = append(geminiResponse.Candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: finishReason,
+			Index:        int64(choice.Index),
+		})
+	}
+
+	hasUsage := streamResponse.Usage != nil && (streamResponse.Usage.PromptTokens > 0 || streamResponse.Usage.CompletionTokens > 0)
+	if hasUsage {
+		geminiResponse.UsageMetadata = usageOpenAI2Gemini(streamResponse.Usage)
+	}
+	if w.pending != nil {
+		w.pending.Candidates = append(w.pending.Candidates, geminiResponse.Candidates...)
+		if hasUsage {
+			w.pending.UsageMetadata = geminiResponse.UsageMetadata
+			w.flushPending()
+		}
+		return
+	}
+	if len(geminiResponse.Candidates) == 0 && !hasUsage {
+		return
+	}
+	if finished && !hasUsage {
+		w.pending = geminiResponse
+		return
+	}
+	w.writeStreamResponse(geminiResponse)
+}
+
+func (w *OpenAI2GeminiWriter) appendToolCalls(choiceIndex int, toolCalls []dto.ToolCallResponse) {
+	for _, toolCall := range toolCalls {
+		toolCallIndex := 0
+		if toolCall.Index != nil {
+			toolCallIndex = *toolCall.Index
+		}
+		calls, ok := w.toolCalls[choiceIndex]
+		if !ok {
+			calls = make(map[int]*dto.ToolCallResponse)
+			w.toolCalls[choiceIndex] = calls
+		}
+		call, ok := calls[toolCallIndex]
+		if !ok {
+			call = &dto.ToolCallResponse{}
+			calls[toolCallIndex] = call
+		}
+		if toolCall.Function.Name != "" {
+			call.Function.Name = toolCall.Function.Name
+		}
+		call.Function.Arguments += toolCall.Function.Arguments
+	}
+}
+
+func (w *OpenAI2GeminiWriter) takeFunctionCalls(choiceIndex int) []GeminiPart {
+	calls := w.toolCalls[choiceIndex]
+	delete(w.toolCalls, choiceIndex)
+	indexes := make([]int, 0, len(calls))
+	for index := range calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	parts := make([]GeminiPart, 0, len(indexes))
+	for _, index := range indexes {
+		parts = append(parts, GeminiPart{
+			FunctionCall: &FunctionCall{
+				FunctionName: calls[index].Function.Name,
+				Arguments:    toolCallArguments2Gemini(calls[index].Function.Arguments),
+			},
+		})
+	}
+	return parts
+}
+
+func (w *OpenAI2GeminiWriter) flushPending() {
+	if w.pending == nil {
+		return
+	}
+	pending := w.pending
+	w.pending = nil
+	w.writeStreamResponse(pending)
+}
+
+func (w *OpenAI2GeminiWriter) writeStreamResponse(geminiResponse *GeminiChatResponse) {
+	jsonData, err := common.Marshal(geminiResponse)
+	if err != nil {
+		common.SysError("error marshalling gemini stream response: " + err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString("data: " + string(jsonData) + "\n\n")
+	w.ResponseWriter.Flush()
+}
+
+// Close 输出剩余的内容，非流式响应在此转换并写出
+func (w *OpenAI2GeminiWriter) Close() {
+	if w.isStream {
+		if w.buffer.Len() > 0 {
+			line := w.buffer.String()
+			w.buffer.Reset()
+			w.handleStreamLine(strings.TrimSpace(line))
+		}
+		// 上游未返回 finish_reason 时，补充输出已累积的工具调用
+		choiceIndexes := make([]int, 0, len(w.toolCalls))
+		for choiceIndex := range w.toolCalls {
+			choiceIndexes = append(choiceIndexes, choiceIndex)
+		}
+		sort.Ints(choiceIndexes)
+		for _, choiceIndex := range choiceIndexes {
+			if w.pending == nil {
+				w.pending = &GeminiChatResponse{}
+			}
+			w.pending.Candidates = append(w.pending.Candidates, GeminiChatCandidate{
+				Content: GeminiChatContent{
+					Role:  "model",
+					Parts: w.takeFunctionCalls(choiceIndex),
+				},
+				FinishReason: finishReasonOpenAI2Gemini("stop"),
+				Index:        int64(choiceIndex),
+			})
+		}
+		w.flushPending()
+		return
+	}
+
+	if w.buffer.Len() == 0 {
+		return
+	}
+	body := w.buffer.Bytes()
+	if w.status == http.StatusOK {
+		var openAIResponse dto.OpenAITextResponse
+		if err := common.Unmarshal(body, &openAIResponse); err == nil && openAIResponse.Error == nil {
+			if jsonData, err := common.Marshal(ResponseOpenAI2Gemini(&openAIResponse)); err == nil {
+				body = jsonData
+			}
+		}
+	}
+	w.ResponseWriter.Header().Del("Content-Length")
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
@@ -800,8 +800,9 @@ func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *
 	createAt := common.GetTimestamp()
 	var usage = &dto.Usage{}
 	var imageCount int
+	var streamQuotaErr *types.NewAPIError
 
-	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+	streamErr := helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		var geminiResponse GeminiChatResponse
 		err := common.UnmarshalJsonStr(data, &geminiResponse)
 		if err != nil {
@@ -837,8 +838,18 @@ func GeminiChatStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *
 			response := helper.GenerateStopResponse(id, createAt, info.UpstreamModelName, constant.FinishReasonStop)
 			helper.ObjectData(c, response)
 		}
-		return true
+		streamQuotaErr = settleStreamQuota(info, &geminiResponse)
+		return streamQuotaErr == nil
 	})
+	if streamErr != nil {
+		return nil, streamErr
+	}
+
+	if streamQuotaErr != nil {
+		// 额度不足时中途结束，已输出部分仍按实际用量计费
+		common.LogWarn(c, "stream terminated: "+streamQuotaErr.Error())
+		helper.StreamErrorData(c, info, streamQuotaErr)
+	}
 
 	var response *dto.ChatCompletionsStreamResponse
 
@@ -956,3 +967,20 @@ func GeminiEmbeddingHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *h
 	common.IOCopyBytesGracefully(c, resp, jsonResponse)
 	return usage, nil
 }
+
+// settleStreamQuota 按上游返回的累计补全 token 数中途扣费，未返回时统计补全文本
+func settleStreamQuota(info *relaycommon.RelayInfo, geminiResponse *GeminiChatResponse) *types.NewAPIError {
+	if info.StreamQuota == nil {
+		return nil
+	}
+	if completionTokens := geminiResponse.UsageMetadata.CandidatesTokenCount + geminiResponse.UsageMetadata.ThoughtsTokenCount; completionTokens > 0 {
+		return service.SettleStreamCompletionTokens(info, completionTokens)
+	}
+	var responseText strings.Builder
+	for _, candidate := range geminiResponse.Candidates {
+		for _, part := range candidate.Content.Parts {
+			responseText.WriteString(part.Text)
+		}
+	}
+	return service.SettleStreamCompletionText(info, responseText.String())
+}
@@ -174,9 +174,11 @@ func handleFinalResponse(c *gin.Context, info *relaycommon.RelayInfo, lastStream
 	case relaycommon.RelayFormatClaude:
 		info.ClaudeConvertInfo.Done = true
 		var streamResponse dto.ChatCompletionsStreamResponse
-		if err := json.Unmarshal(common.StringToByteSlice(lastStreamData), &streamResponse); err != nil {
-			common.SysError("error unmarshalling stream response: " + err.Error())
-			return
+		if lastStreamData != "" {
+			// 解析失败时仍需输出 message_delta 和 message_stop，保证 Claude 事件流完整
+			if err := json.Unmarshal(common.StringToByteSlice(lastStreamData), &streamResponse); err != nil {
+				common.SysError("error unmarshalling stream response: " + err.Error())
+			}
 		}
 
 		info.ClaudeConvertInfo.Usage = usage
@@ -136,19 +136,36 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 
 	var (
 		lastStreamData string
+		streamQuotaErr *types.NewAPIError
 	)
 
-	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+	streamErr := helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 		if lastStreamData != "" {
 			err := handleStreamFormat(c, info, lastStreamData, forceFormat, thinkToContent)
 			if err != nil {
 				common.SysError("error handling stream format: " + err.Error())
 			}
 		}
+		// 额度不足时超出额度的数据块不再输出，也不计入用量，此前的数据块均已输出
+		if streamQuotaErr = service.GetStreamQuotaError(info); streamQuotaErr != nil {
+			return false
+		}
 		lastStreamData = data
-		streamItems = append(streamItems, data)
+		if info.StreamQuota == nil {
+			streamItems = append(streamItems, data)
+			return true
+		}
+		// 中途扣费时逐块统计补全内容，结束时不再重复统计。后台扣费发现的额度不足在处理下一个数据块前检查
+		textLen := responseTextBuilder.Len()
+		if err := processTokens(info.RelayMode, []string{data}, &responseTextBuilder, &toolCount); err != nil {
+			common.SysError("error processing tokens: " + err.Error())
+		}
+		_ = service.SettleStreamCompletionText(info, responseTextBuilder.String()[textLen:])
 		return true
 	})
+	if streamErr != nil {
+		return nil, streamErr
+	}
 
 	// 处理最后的响应
 	shouldSendLastResp := true
@@ -157,13 +174,15 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 		common.SysError("error handling last response: " + err.Error())
 	}
 
-	if shouldSendLastResp && info.RelayFormat == relaycommon.RelayFormatOpenAI {
+	if shouldSendLastResp && streamQuotaErr == nil && info.RelayFormat == relaycommon.RelayFormatOpenAI {
 		_ = sendStreamData(c, info, lastStreamData, forceFormat, thinkToContent)
 	}
 
 	// 处理token计算
-	if err := processTokens(info.RelayMode, streamItems, &responseTextBuilder, &toolCount); err != nil {
-		common.SysError("error processing tokens: " + err.Error())
+	if len(streamItems) > 0 {
+		if err := processTokens(info.RelayMode, streamItems, &responseTextBuilder, &toolCount); err != nil {
+			common.SysError("error processing tokens: " + err.Error())
+		}
 	}
 
 	if !containStreamUsage {
@@ -177,6 +196,13 @@ func OaiStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Re
 		}
 	}
 
+	if streamQuotaErr != nil {
+		// 额度不足时中途结束，错误事件作为最后一帧，不再输出用量和结束标记，已输出部分仍按实际用量计费
+		common.LogWarn(c, "stream terminated: "+streamQuotaErr.Error())
+		helper.StreamErrorData(c, info, streamQuotaErr)
+		return usage, nil
+	}
+
 	handleFinalResponse(c, info, lastStreamData, responseId, createAt, model, systemFingerprint, usage, containStreamUsage)
 
 	return usage, nil
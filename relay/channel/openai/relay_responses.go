@@ -55,12 +55,19 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 
 	var usage = &dto.Usage{}
 	var responseTextBuilder strings.Builder
+	var streamQuotaErr *types.NewAPIError
 
-	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+	streamErr := helper.StreamScannerHandler(c, resp, info, func(data string) bool {
 
 		// 检查当前数据是否包含 completed 状态和 usage 信息
 		var streamResponse dto.ResponsesStreamResponse
 		if err := common.UnmarshalJsonStr(data, &streamResponse); err == nil {
+			if streamResponse.Type == dto.ResponsesStreamTypeOutputTextDelta {
+				// 额度不足时超出额度的数据块不再输出，也不计入用量
+				if streamQuotaErr = service.SettleStreamCompletionText(info, streamResponse.Delta); streamQuotaErr != nil {
+					return false
+				}
+			}
 			sendResponsesStreamData(c, streamResponse, data)
 			switch streamResponse.Type {
 			case "response.completed":
@@ -82,6 +89,9 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 		}
 		return true
 	})
+	if streamErr != nil {
+		return nil, streamErr
+	}
 
 	if usage.CompletionTokens == 0 {
 		// 计算输出文本的 token 数量
@@ -93,5 +103,11 @@ func OaiResponsesStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp
 		}
 	}
 
+	if streamQuotaErr != nil {
+		// 额度不足时中途结束，错误事件作为最后一帧，已输出部分仍按实际用量计费
+		common.LogWarn(c, "stream terminated: "+streamQuotaErr.Error())
+		helper.StreamErrorData(c, info, streamQuotaErr)
+	}
+
 	return usage, nil
 }
@@ -0,0 +1,151 @@
+package template
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"one-api/dto"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Adaptor 模板渠道，按渠道设置中的模板转换请求和响应，用于接入与 OpenAI 接口相近的服务
+type Adaptor struct {
+}
+
+func getSettings(info *relaycommon.RelayInfo) *dto.TemplateSettings {
+	if info.ChannelSetting.Template != nil {
+		return info.ChannelSetting.Template
+	}
+	return &dto.TemplateSettings{}
+}
+
+func getRequestPath(info *relaycommon.RelayInfo) string {
+	// Claude 格式的请求转换为 OpenAI 请求发送
+	if info.RelayFormat == relaycommon.RelayFormatClaude {
+		return "/v1/chat/completions"
+	}
+	return info.RequestURLPath
+}
+
+func newVariables(info *relaycommon.RelayInfo) map[string]any {
+	return map[string]any{
+		"base_url":     info.BaseUrl,
+		"api_key":      info.ApiKey,
+		"model":        info.UpstreamModelName,
+		"origin_model": info.OriginModelName,
+		"stream":       info.IsStream,
+		"request_path": getRequestPath(info),
+	}
+}
+
+func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
+}
+
+func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	settings := getSettings(info)
+	urlTemplate := settings.URL
+	if info.IsStream && settings.StreamURL != "" {
+		urlTemplate = settings.StreamURL
+	}
+	if urlTemplate == "" {
+		return relaycommon.GetFullRequestURL(info.BaseUrl, getRequestPath(info), info.ChannelType), nil
+	}
+	ctx := &renderContext{vars: newVariables(info)}
+	return ctx.renderText(urlTemplate), nil
+}
+
+// renderHeaders 渲染请求头模板，未设置时使用 Bearer 鉴权
+func renderHeaders(info *relaycommon.RelayInfo) map[string]string {
+	settings := getSettings(info)
+	if len(settings.Headers) == 0 {
+		return map[string]string{"Authorization": "Bearer " + info.ApiKey}
+	}
+	ctx := &renderContext{vars: newVariables(info)}
+	headers := make(map[string]string, len(settings.Headers))
+	for key, value := range settings.Headers {
+		if rendered := ctx.renderText(value); rendered != "" {
+			headers[key] = rendered
+		}
+	}
+	return headers
+}
+
+func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
+	channel.SetupApiRequestHeader(info, c, req)
+	for key, value := range renderHeaders(info) {
+		req.Set(key, value)
+	}
+	return nil
+}
+
+func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) (any, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+	settings := getSettings(info)
+	if settings.Request == nil {
+		return request, nil
+	}
+	ctx := &renderContext{root: request.ToMap(), vars: newVariables(info)}
+	body, _ := ctx.render(settings.Request)
+	return body, nil
+}
+
+func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	aiRequest, err := service.ClaudeToOpenAIRequest(*request, info)
+	if err != nil {
+		return nil, err
+	}
+	return a.ConvertOpenAIRequest(c, info, aiRequest)
+}
+
+func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest) (any, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	return channel.DoApiRequest(a, c, info, requestBody)
+}
+
+func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *types.NewAPIError) {
+	// 只支持对话请求，Claude 格式的请求已转换为 OpenAI 对话请求
+	if info.RelayMode != relayconstant.RelayModeChatCompletions && info.RelayFormat != relaycommon.RelayFormatClaude {
+		return nil, types.NewErrorWithStatusCode(errors.New("unsupported relay mode"), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+	}
+	if info.IsStream {
+		usage, err = templateStreamHandler(c, info, resp)
+	} else {
+		usage, err = templateHandler(c, info, resp)
+	}
+	return
+}
+
+func (a *Adaptor) GetModelList() []string {
+	return ModelList
+}
+
+func (a *Adaptor) GetChannelName() string {
+	return ChannelName
+}
@@ -0,0 +1,8 @@
+package template
+
+const (
+	ChannelName = "template"
+)
+
+// ModelList 模板渠道没有预设模型，由管理员在渠道中配置
+var ModelList = []string{}
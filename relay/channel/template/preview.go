@@ -0,0 +1,156 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/relay/helper"
+)
+
+const previewApiKey = "sk-preview"
+
+// ValidateSettings 检查模板渠道设置中的模板和路径
+func ValidateSettings(settings *dto.TemplateSettings) error {
+	if settings == nil {
+		return errors.New("模板渠道必须设置 template")
+	}
+	for name, text := range map[string]string{"url": settings.URL, "stream_url": settings.StreamURL} {
+		if err := validateText(text, false); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for key, value := range settings.Headers {
+		if err := validateText(value, false); err != nil {
+			return fmt.Errorf("headers.%s: %w", key, err)
+		}
+	}
+	templates := []struct {
+		name   string
+		tmpl   any
+		object bool
+	}{
+		{"request", settings.Request, false},
+		{"response", settings.Response, true},
+		{"stream_chunk", settings.StreamChunk, true},
+	}
+	for _, t := range templates {
+		if t.tmpl == nil {
+			continue
+		}
+		if _, ok := t.tmpl.(map[string]any); t.object && !ok {
+			return fmt.Errorf("%s: template must be a json object", t.name)
+		}
+		if err := validateTemplate(t.tmpl, true); err != nil {
+			return fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	paths := map[string]string{
+		"usage.prompt_tokens":     settings.Usage.PromptTokens,
+		"usage.completion_tokens": settings.Usage.CompletionTokens,
+		"usage.total_tokens":      settings.Usage.TotalTokens,
+		"error_message":           settings.ErrorMessage,
+	}
+	for name, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := validateExpression(path, true); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// PreviewRequest 用样例请求和样例上游响应检查模板的转换结果
+type PreviewRequest struct {
+	Settings     dto.TemplateSettings     `json:"settings"`
+	BaseURL      string                   `json:"base_url"`
+	Request      dto.GeneralOpenAIRequest `json:"request"`
+	Response     json.RawMessage          `json:"response,omitempty"`      // 样例上游响应
+	StreamChunks []string                 `json:"stream_chunks,omitempty"` // 样例上游流式响应，每项为一行
+}
+
+// PreviewResult 转换结果，响应和流式数据块为解析成 OpenAI 结构后再输出的内容
+type PreviewResult struct {
+	URL          string                               `json:"url"`
+	Headers      map[string]string                    `json:"headers"`
+	Body         any                                  `json:"body"`
+	Response     *dto.OpenAITextResponse              `json:"response,omitempty"`
+	StreamChunks []*dto.ChatCompletionsStreamResponse `json:"stream_chunks,omitempty"`
+}
+
+func Preview(input *PreviewRequest) (*PreviewResult, error) {
+	if err := ValidateSettings(&input.Settings); err != nil {
+		return nil, err
+	}
+	info := &relaycommon.RelayInfo{
+		BaseUrl:           input.BaseURL,
+		ApiKey:            previewApiKey,
+		OriginModelName:   input.Request.Model,
+		UpstreamModelName: input.Request.Model,
+		IsStream:          input.Request.Stream,
+		RelayMode:         relayconstant.RelayModeChatCompletions,
+		RequestURLPath:    "/v1/chat/completions",
+		ChannelSetting:    dto.ChannelSettings{Template: &input.Settings},
+	}
+	adaptor := &Adaptor{}
+	result := &PreviewResult{Headers: renderHeaders(info)}
+	var err error
+	if result.URL, err = adaptor.GetRequestURL(info); err != nil {
+		return nil, err
+	}
+	if result.Body, err = adaptor.ConvertOpenAIRequest(nil, info, &input.Request); err != nil {
+		return nil, err
+	}
+	t := &transformer{
+		settings: &input.Settings,
+		vars:     newVariables(info),
+		id:       "chatcmpl-preview",
+		model:    info.UpstreamModelName,
+		created:  common.GetTimestamp(),
+	}
+	if len(input.Response) > 0 {
+		converted, err := t.convertResponse(input.Response)
+		if err != nil {
+			return nil, fmt.Errorf("response: %w", err)
+		}
+		if err = remarshal(converted, &result.Response); err != nil {
+			return nil, fmt.Errorf("response: %w", err)
+		}
+	}
+	var usage *dto.Usage
+	for i, line := range input.StreamChunks {
+		data, ok := streamLineData(line)
+		if !ok {
+			continue
+		}
+		converted, chunkUsage, err := t.convertStreamChunk(data)
+		if err != nil {
+			return nil, fmt.Errorf("stream_chunks[%d]: %w", i, err)
+		}
+		if chunkUsage != nil {
+			usage = chunkUsage
+		}
+		var chunk *dto.ChatCompletionsStreamResponse
+		if err = remarshal(converted, &chunk); err != nil {
+			return nil, fmt.Errorf("stream_chunks[%d]: %w", i, err)
+		}
+		result.StreamChunks = append(result.StreamChunks, chunk)
+	}
+	if usage != nil {
+		result.StreamChunks = append(result.StreamChunks, helper.GenerateFinalUsageResponse(t.id, t.created, t.model, *usage))
+	}
+	return result, nil
+}
+
+func remarshal(from any, to any) error {
+	data, err := common.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return common.Unmarshal(data, to)
+}
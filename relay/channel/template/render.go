@@ -0,0 +1,339 @@
+package template
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 模板语法：
+//   - 字符串中的 {{表达式}} 会被替换为表达式的值。整个字符串只有一个表达式时保留值的原始类型，
+//     值不存在时省略该字段；嵌在文本中时按字符串拼接，值不存在时替换为空字符串
+//   - 表达式 $.a.b[0] 引用输入文档（请求模板为 OpenAI 请求，响应模板为上游响应），
+//     @.a 引用 $each 当前元素，其余为变量名，如 model、api_key、base_url
+//   - {"$each": "$.messages", "$template": {...}} 对数组中的每个元素渲染 $template，结果为数组
+//   - 数组下标可以为负数，-1 表示最后一个元素
+//
+// 表达式只是 JSONPath 的一个子集，不是完整的 JSONPath，也不是 JMESPath：仅支持 .key、[n] 和
+// ['key']/["key"]（键中含有 . 等字符时使用），不支持通配符、递归下降、切片、过滤和函数
+
+const (
+	eachKey         = "$each"
+	eachTemplateKey = "$template"
+)
+
+// 模板中可以使用的变量
+var templateVariables = map[string]bool{
+	"base_url":     true,
+	"api_key":      true,
+	"model":        true,
+	"origin_model": true,
+	"stream":       true,
+	"request_path": true,
+}
+
+var (
+	wholeExpressionRegex = regexp.MustCompile(`^\{\{\s*([^{}]+?)\s*\}\}$`)
+	expressionRegex      = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+)
+
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+type jsonPath struct {
+	root     string // $、@ 或变量名
+	segments []pathSegment
+}
+
+func parsePath(expr string) (*jsonPath, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, errors.New("empty expression")
+	}
+	path := &jsonPath{}
+	rest := ""
+	if expr[0] == '$' || expr[0] == '@' {
+		path.root = expr[:1]
+		rest = expr[1:]
+	} else {
+		end := strings.IndexAny(expr, ".[")
+		if end == -1 {
+			end = len(expr)
+		}
+		path.root = expr[:end]
+		rest = expr[end:]
+		if !templateVariables[path.root] {
+			return nil, fmt.Errorf("unknown variable %q", path.root)
+		}
+	}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid expression %q: empty key", expr)
+			}
+			path.segments = append(path.segments, pathSegment{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid expression %q: missing ]", expr)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path.segments = append(path.segments, pathSegment{key: inner[1 : len(inner)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expression %q: invalid index %q", expr, inner)
+			}
+			path.segments = append(path.segments, pathSegment{index: index, isIndex: true})
+		default:
+			return nil, fmt.Errorf("invalid expression %q", expr)
+		}
+	}
+	return path, nil
+}
+
+func (p *jsonPath) lookup(value any) (any, bool) {
+	for _, segment := range p.segments {
+		switch v := value.(type) {
+		case map[string]any:
+			if segment.isIndex {
+				return nil, false
+			}
+			next, ok := v[segment.key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []any:
+			if !segment.isIndex {
+				return nil, false
+			}
+			index := segment.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	if value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+type renderContext struct {
+	root    any
+	current any
+	vars    map[string]any
+}
+
+func (ctx *renderContext) evaluate(expr string) (any, bool) {
+	path, err := parsePath(expr)
+	if err != nil {
+		return nil, false
+	}
+	switch path.root {
+	case "$":
+		return path.lookup(ctx.root)
+	case "@":
+		return path.lookup(ctx.current)
+	default:
+		value, ok := ctx.vars[path.root]
+		if !ok {
+			return nil, false
+		}
+		return path.lookup(value)
+	}
+}
+
+// render 渲染模板，第二个返回值为 false 表示结果不存在，应省略
+func (ctx *renderContext) render(tmpl any) (any, bool) {
+	switch t := tmpl.(type) {
+	case string:
+		return ctx.renderString(t)
+	case map[string]any:
+		if source, ok := t[eachKey]; ok {
+			return ctx.renderEach(source, t[eachTemplateKey])
+		}
+		result := make(map[string]any, len(t))
+		for key, value := range t {
+			if rendered, ok := ctx.render(value); ok {
+				result[key] = rendered
+			}
+		}
+		return result, true
+	case []any:
+		result := make([]any, 0, len(t))
+		for _, value := range t {
+			if rendered, ok := ctx.render(value); ok {
+				result = append(result, rendered)
+			}
+		}
+		return result, true
+	default:
+		return tmpl, true
+	}
+}
+
+func (ctx *renderContext) renderString(tmpl string) (any, bool) {
+	if match := wholeExpressionRegex.FindStringSubmatch(tmpl); match != nil {
+		return ctx.evaluate(match[1])
+	}
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl, true
+	}
+	return expressionRegex.ReplaceAllStringFunc(tmpl, func(s string) string {
+		value, ok := ctx.evaluate(expressionRegex.FindStringSubmatch(s)[1])
+		if !ok {
+			return ""
+		}
+		return stringify(value)
+	}), true
+}
+
+func (ctx *renderContext) renderEach(source any, tmpl any) (any, bool) {
+	expr, _ := source.(string)
+	if match := wholeExpressionRegex.FindStringSubmatch(expr); match != nil {
+		expr = match[1]
+	}
+	value, ok := ctx.evaluate(expr)
+	if !ok {
+		return nil, false
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return nil, false
+	}
+	current := ctx.current
+	defer func() {
+		ctx.current = current
+	}()
+	result := make([]any, 0, len(items))
+	for _, item := range items {
+		ctx.current = item
+		if rendered, ok := ctx.render(tmpl); ok {
+			result = append(result, rendered)
+		}
+	}
+	return result, true
+}
+
+// renderText 渲染地址、请求头等字符串模板
+func (ctx *renderContext) renderText(tmpl string) string {
+	value, ok := ctx.renderString(tmpl)
+	if !ok {
+		return ""
+	}
+	return stringify(value)
+}
+
+func stringify(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func toInt(value any) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		return int(v), true
+	case int:
+		return v, true
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// validateTemplate 检查模板中的表达式和 $each 结构
+func validateTemplate(tmpl any, allowDocument bool) error {
+	switch t := tmpl.(type) {
+	case string:
+		return validateText(t, allowDocument)
+	case map[string]any:
+		if source, ok := t[eachKey]; ok {
+			expr, ok := source.(string)
+			if !ok {
+				return fmt.Errorf("%s must be a string", eachKey)
+			}
+			if match := wholeExpressionRegex.FindStringSubmatch(expr); match != nil {
+				expr = match[1]
+			}
+			if err := validateExpression(expr, allowDocument); err != nil {
+				return err
+			}
+			if _, ok := t[eachTemplateKey]; !ok {
+				return fmt.Errorf("%s requires %s", eachKey, eachTemplateKey)
+			}
+			return validateTemplate(t[eachTemplateKey], allowDocument)
+		}
+		for _, value := range t {
+			if err := validateTemplate(value, allowDocument); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, value := range t {
+			if err := validateTemplate(value, allowDocument); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateText(tmpl string, allowDocument bool) error {
+	for _, match := range expressionRegex.FindAllStringSubmatch(tmpl, -1) {
+		if err := validateExpression(match[1], allowDocument); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateExpression(expr string, allowDocument bool) error {
+	path, err := parsePath(expr)
+	if err != nil {
+		return err
+	}
+	if !allowDocument && (path.root == "$" || path.root == "@") {
+		return fmt.Errorf("expression %q can only use variables", expr)
+	}
+	return nil
+}
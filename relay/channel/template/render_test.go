@@ -0,0 +1,178 @@
+package template
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func mustJSON(t *testing.T, data string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("unmarshal %s: %v", data, err)
+	}
+	return value
+}
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		expr     string
+		root     string
+		segments []pathSegment
+		wantErr  bool
+	}{
+		{expr: "$", root: "$"},
+		{expr: "$.a.b", root: "$", segments: []pathSegment{{key: "a"}, {key: "b"}}},
+		{expr: "$.messages[-1].content", root: "$", segments: []pathSegment{{key: "messages"}, {index: -1, isIndex: true}, {key: "content"}}},
+		{expr: "$['a.b'][\"c d\"]", root: "$", segments: []pathSegment{{key: "a.b"}, {key: "c d"}}},
+		{expr: "@.content[ 0 ]", root: "@", segments: []pathSegment{{key: "content"}, {index: 0, isIndex: true}}},
+		{expr: "model", root: "model"},
+		{expr: "base_url.host", root: "base_url", segments: []pathSegment{{key: "host"}}},
+		{expr: "", wantErr: true},
+		{expr: "unknown.a", wantErr: true},
+		{expr: "$.a[0", wantErr: true},
+		{expr: "$.a[x]", wantErr: true},
+		{expr: "$..a", wantErr: true},
+		{expr: "$.a[*]", wantErr: true},
+		{expr: "$a", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			path, err := parsePath(tc.expr)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if path.root != tc.root || !reflect.DeepEqual(path.segments, tc.segments) {
+				t.Errorf("got root %q segments %+v, want root %q segments %+v", path.root, path.segments, tc.root, tc.segments)
+			}
+		})
+	}
+}
+
+func TestRenderExpressions(t *testing.T) {
+	ctx := &renderContext{
+		root: mustJSON(t, `{"items":[1,2,{"x":"last"}],"a.b":{"c":true},"n":3,"empty":null,"obj":{"k":"v"}}`),
+		vars: map[string]any{"model": "m1", "stream": false},
+	}
+	cases := []struct {
+		name    string
+		tmpl    string
+		want    any
+		missing bool
+	}{
+		{name: "whole expression keeps number", tmpl: "{{$.n}}", want: float64(3)},
+		{name: "whole expression keeps bool", tmpl: "{{ stream }}", want: false},
+		{name: "whole expression keeps object", tmpl: "{{$.obj}}", want: map[string]any{"k": "v"}},
+		{name: "negative index", tmpl: "{{$.items[-1].x}}", want: "last"},
+		{name: "negative index counts from end", tmpl: "{{$.items[-3]}}", want: float64(1)},
+		{name: "negative index out of range", tmpl: "{{$.items[-4]}}", missing: true},
+		{name: "index out of range", tmpl: "{{$.items[3]}}", missing: true},
+		{name: "index on object", tmpl: "{{$.obj[0]}}", missing: true},
+		{name: "key on array", tmpl: "{{$.items.x}}", missing: true},
+		{name: "quoted key", tmpl: "{{$['a.b'].c}}", want: true},
+		{name: "dotted key without quotes", tmpl: "{{$.a.b.c}}", missing: true},
+		{name: "null is missing", tmpl: "{{$.empty}}", missing: true},
+		{name: "missing key", tmpl: "{{$.nope}}", missing: true},
+		{name: "embedded", tmpl: "{{model}}/{{$.n}}/{{$['a.b'].c}}", want: "m1/3/true"},
+		{name: "embedded missing becomes empty", tmpl: "x-{{$.nope}}-{{$.empty}}", want: "x--"},
+		{name: "embedded object is json", tmpl: "obj={{$.obj}}", want: `obj={"k":"v"}`},
+		{name: "plain text", tmpl: "plain", want: "plain"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := ctx.renderString(tc.tmpl)
+			if tc.missing {
+				if ok {
+					t.Fatalf("expected missing, got %#v", got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("unexpected missing value")
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderOmitsMissingFields(t *testing.T) {
+	ctx := &renderContext{root: mustJSON(t, `{"a":1,"list":[{"v":1},{}]}`)}
+	tmpl := mustJSON(t, `{
+		"a": "{{$.a}}",
+		"b": "{{$.b}}",
+		"nested": {"c": "{{$.c}}"},
+		"array": ["{{$.a}}", "{{$.b}}", "const"],
+		"each": {"$each": "$.list", "$template": {"v": "{{@.v}}"}},
+		"each_missing": {"$each": "$.b", "$template": "{{@}}"},
+		"each_not_array": {"$each": "$.a", "$template": "{{@}}"}
+	}`)
+	got, ok := ctx.render(tmpl)
+	if !ok {
+		t.Fatal("render returned missing")
+	}
+	want := mustJSON(t, `{"a":1,"nested":{},"array":[1,"const"],"each":[{"v":1},{}]}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestRenderNestedEach(t *testing.T) {
+	ctx := &renderContext{
+		root: mustJSON(t, `{"prefix":"p","groups":[{"name":"g1","items":[{"id":1},{"id":2}]},{"name":"g2","items":[]},{"name":"g3"}]}`),
+		vars: map[string]any{"model": "m1"},
+	}
+	tmpl := mustJSON(t, `{"$each": "$.groups", "$template": {
+		"name": "{{@.name}}",
+		"ids": {"$each": "@.items", "$template": "{{$.prefix}}-{{model}}-{{@.id}}"},
+		"after": "{{@.name}}"
+	}}`)
+	got, ok := ctx.render(tmpl)
+	if !ok {
+		t.Fatal("render returned missing")
+	}
+	// 内层 $each 结束后 @ 恢复为外层元素，items 不存在时省略字段
+	want := mustJSON(t, `[
+		{"name":"g1","ids":["p-m1-1","p-m1-2"],"after":"g1"},
+		{"name":"g2","ids":[],"after":"g2"},
+		{"name":"g3","after":"g3"}
+	]`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if ctx.current != nil {
+		t.Errorf("current element leaked: %#v", ctx.current)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	cases := []struct {
+		name          string
+		tmpl          string
+		allowDocument bool
+		wantErr       bool
+	}{
+		{name: "valid", tmpl: `{"a":"{{$.a[-1]}}","b":{"$each":"{{$.list}}","$template":{"x":"{{@['k.v']}}"}}}`, allowDocument: true},
+		{name: "unknown variable", tmpl: `{"a":"{{foo}}"}`, allowDocument: true, wantErr: true},
+		{name: "document not allowed", tmpl: `"{{base_url}}/{{$.model}}"`, wantErr: true},
+		{name: "each without template", tmpl: `{"$each":"$.list"}`, allowDocument: true, wantErr: true},
+		{name: "each source not string", tmpl: `{"$each":1,"$template":"x"}`, allowDocument: true, wantErr: true},
+		{name: "invalid nested expression", tmpl: `[{"$each":"$.list","$template":{"x":"{{@.a[}}"}}]`, allowDocument: true, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateTemplate(mustJSON(t, tc.tmpl), tc.allowDocument)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"one-api/common/golden"
+	"one-api/dto"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 使用 go test ./relay/channel/template -update 重新生成 golden 文件
+const acmeTestdata = golden.Dir("testdata/acme")
+
+func unmarshalFixture(t *testing.T, name string, v any) {
+	t.Helper()
+	if err := json.Unmarshal(acmeTestdata.Read(t, name), v); err != nil {
+		t.Fatalf("unmarshal fixture %s: %v", name, err)
+	}
+}
+
+func loadAcmeSettings(t *testing.T) dto.TemplateSettings {
+	t.Helper()
+	var settings dto.TemplateSettings
+	unmarshalFixture(t, "settings.json", &settings)
+	if err := ValidateSettings(&settings); err != nil {
+		t.Fatalf("validate settings: %v", err)
+	}
+	return settings
+}
+
+// newTestTransformer 使用固定的 id 和时间，保证输出稳定
+func newTestTransformer(settings *dto.TemplateSettings) *transformer {
+	return &transformer{
+		settings: settings,
+		vars:     map[string]any{"model": "acme-large"},
+		id:       "chatcmpl-test",
+		model:    "acme-large",
+		created:  1700000000,
+	}
+}
+
+// TestRequestRoundTrip 把 OpenAI 请求渲染为上游请求，再用反向模板渲染回 OpenAI 请求，结果应与原请求一致
+func TestRequestRoundTrip(t *testing.T) {
+	settings := loadAcmeSettings(t)
+	var request dto.GeneralOpenAIRequest
+	unmarshalFixture(t, "request.json", &request)
+
+	for _, stream := range []bool{true, false} {
+		request.Stream = stream
+		result, err := Preview(&PreviewRequest{
+			Settings: settings,
+			BaseURL:  "https://api.acme.test",
+			Request:  request,
+		})
+		if err != nil {
+			t.Fatalf("preview: %v", err)
+		}
+		goldenName := "request.golden.json"
+		if !stream {
+			goldenName = "request_non_stream.golden.json"
+		}
+		acmeTestdata.Assert(t, goldenName, golden.MarshalJSON(t, result))
+
+		var inverse any
+		unmarshalFixture(t, "inverse.json", &inverse)
+		if err = validateTemplate(inverse, true); err != nil {
+			t.Fatalf("validate inverse template: %v", err)
+		}
+		body := mustJSON(t, string(golden.MarshalJSON(t, result.Body)))
+		rendered, ok := (&renderContext{root: body}).render(inverse)
+		if !ok {
+			t.Fatal("inverse template rendered nothing")
+		}
+		var roundTripped dto.GeneralOpenAIRequest
+		if err = remarshal(rendered, &roundTripped); err != nil {
+			t.Fatalf("decode round-tripped request: %v", err)
+		}
+		if got, want := roundTripped.ToMap(), request.ToMap(); !reflect.DeepEqual(got, want) {
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			t.Errorf("stream=%v round trip mismatch\n got: %s\nwant: %s", stream, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestConvertResponse(t *testing.T) {
+	settings := loadAcmeSettings(t)
+	tr := newTestTransformer(&settings)
+	result, err := tr.convertResponse(acmeTestdata.Read(t, "upstream_response.json"))
+	if err != nil {
+		t.Fatalf("convert response: %v", err)
+	}
+	acmeTestdata.Assert(t, "response.golden.json", golden.MarshalJSON(t, result))
+
+	var response dto.OpenAITextResponse
+	if err = remarshal(result, &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Usage.PromptTokens != 21 || response.Usage.CompletionTokens != 3 || response.Usage.TotalTokens != 24 {
+		t.Errorf("unexpected usage %+v", response.Usage)
+	}
+	if len(response.Choices) != 1 || response.Choices[0].Message.StringContent() != "Cat." {
+		t.Errorf("unexpected choices %+v", response.Choices)
+	}
+}
+
+func TestConvertResponseUpstreamError(t *testing.T) {
+	settings := loadAcmeSettings(t)
+	tr := newTestTransformer(&settings)
+	_, err := tr.convertResponse([]byte(`{"error":{"message":"quota exceeded"}}`))
+	var upstreamErr *upstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr.message != "quota exceeded" {
+		t.Fatalf("expected upstream error, got %v", err)
+	}
+}
+
+func TestExtractUsage(t *testing.T) {
+	cases := []struct {
+		name     string
+		usage    dto.TemplateUsage
+		upstream string
+		want     *dto.Usage
+	}{
+		{
+			name:     "prompt and completion",
+			usage:    dto.TemplateUsage{PromptTokens: "$.u.in", CompletionTokens: "$.u.out"},
+			upstream: `{"u":{"in":10,"out":5}}`,
+			want:     &dto.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		},
+		{
+			name:     "completion derived from total",
+			usage:    dto.TemplateUsage{PromptTokens: "$.u.in", TotalTokens: "$.u.total"},
+			upstream: `{"u":{"in":10,"total":"18"}}`,
+			want:     &dto.Usage{PromptTokens: 10, CompletionTokens: 8, TotalTokens: 18},
+		},
+		{
+			name:     "quoted key and negative index",
+			usage:    dto.TemplateUsage{PromptTokens: "$.meta['usage.v2'][-1].in"},
+			upstream: `{"meta":{"usage.v2":[{"in":1},{"in":7}]}}`,
+			want:     &dto.Usage{PromptTokens: 7, TotalTokens: 7},
+		},
+		{
+			name:     "missing usage",
+			usage:    dto.TemplateUsage{PromptTokens: "$.u.in"},
+			upstream: `{"text":"hi"}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newTestTransformer(&dto.TemplateSettings{Usage: tc.usage})
+			got := tr.extractUsage(mustJSON(t, tc.upstream))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func readTemplateStream(t *testing.T, tr *transformer, upstream string) []byte {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	reader := newStreamReader(c, io.NopCloser(strings.NewReader(upstream)), tr)
+	out, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	return out
+}
+
+// TestStreamReader 上游的 SSE 注释、事件行和 [DONE] 被忽略，每行一个 JSON 的数据块同样支持，
+// 用量取最后一次出现的值并在 [DONE] 前单独输出
+func TestStreamReader(t *testing.T) {
+	settings := loadAcmeSettings(t)
+	got := readTemplateStream(t, newTestTransformer(&settings), string(acmeTestdata.Read(t, "upstream_stream.txt")))
+	acmeTestdata.Assert(t, "stream.golden.sse", got)
+
+	if !bytes.HasSuffix(got, []byte("data: [DONE]\n\n")) {
+		t.Errorf("stream does not end with [DONE]")
+	}
+	if !bytes.Contains(got, []byte(`"usage":{"prompt_tokens":21,"completion_tokens":3,"total_tokens":24`)) {
+		t.Errorf("final usage chunk missing:\n%s", got)
+	}
+}
+
+// TestStreamReaderUpstreamError 数据块中出现错误信息时结束流，之后的数据块不再输出
+func TestStreamReaderUpstreamError(t *testing.T) {
+	settings := loadAcmeSettings(t)
+	upstream := strings.Join([]string{
+		`data: {"delta":{"text":"A"},"billing":{"tokens.in":5,"tokens.out":1}}`,
+		`data: {"error":{"message":"overloaded"}}`,
+		`data: {"delta":{"text":"B"}}`,
+	}, "\n")
+	got := string(readTemplateStream(t, newTestTransformer(&settings), upstream))
+	if strings.Count(got, "data: ") != 3 || strings.Contains(got, `"content":"B"`) {
+		t.Errorf("unexpected stream output:\n%s", got)
+	}
+	if !strings.Contains(got, `"prompt_tokens":5`) || !strings.HasSuffix(got, "data: [DONE]\n\n") {
+		t.Errorf("usage or [DONE] missing after upstream error:\n%s", got)
+	}
+}
@@ -0,0 +1,294 @@
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel/openai"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/types"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamError 上游在响应体中返回的错误
+type upstreamError struct {
+	message string
+}
+
+func (e *upstreamError) Error() string {
+	return e.message
+}
+
+// transformer 按模板把上游响应转换为 OpenAI 格式
+type transformer struct {
+	settings *dto.TemplateSettings
+	vars     map[string]any
+	id       string
+	model    string
+	created  int64
+}
+
+func newTransformer(c *gin.Context, info *relaycommon.RelayInfo) *transformer {
+	return &transformer{
+		settings: getSettings(info),
+		vars:     newVariables(info),
+		id:       helper.GetResponseID(c),
+		model:    info.UpstreamModelName,
+		created:  common.GetTimestamp(),
+	}
+}
+
+func (t *transformer) hasUsagePaths() bool {
+	usage := t.settings.Usage
+	return usage.PromptTokens != "" || usage.CompletionTokens != "" || usage.TotalTokens != ""
+}
+
+func (t *transformer) parse(data []byte) (any, error) {
+	var upstream any
+	if err := common.Unmarshal(data, &upstream); err != nil {
+		return nil, err
+	}
+	if t.settings.ErrorMessage != "" {
+		ctx := &renderContext{root: upstream, vars: t.vars}
+		if message, ok := ctx.evaluate(t.settings.ErrorMessage); ok && stringify(message) != "" {
+			return nil, &upstreamError{message: stringify(message)}
+		}
+	}
+	return upstream, nil
+}
+
+// convert 渲染模板，tmpl 为空时上游数据原样使用
+func (t *transformer) convert(upstream any, tmpl any, object string) (map[string]any, error) {
+	rendered := upstream
+	if tmpl != nil {
+		ctx := &renderContext{root: upstream, vars: t.vars}
+		rendered, _ = ctx.render(tmpl)
+	}
+	result, ok := rendered.(map[string]any)
+	if !ok {
+		return nil, errors.New("template result is not a json object")
+	}
+	defaults := map[string]any{
+		"id":      t.id,
+		"object":  object,
+		"created": t.created,
+		"model":   t.model,
+	}
+	for key, value := range defaults {
+		if _, ok := result[key]; !ok {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// extractUsage 按用量路径读取上游用量，都不存在时返回 nil
+func (t *transformer) extractUsage(upstream any) *dto.Usage {
+	ctx := &renderContext{root: upstream, vars: t.vars}
+	read := func(path string) (int, bool) {
+		if path == "" {
+			return 0, false
+		}
+		value, ok := ctx.evaluate(path)
+		if !ok {
+			return 0, false
+		}
+		return toInt(value)
+	}
+	promptTokens, hasPrompt := read(t.settings.Usage.PromptTokens)
+	completionTokens, hasCompletion := read(t.settings.Usage.CompletionTokens)
+	totalTokens, hasTotal := read(t.settings.Usage.TotalTokens)
+	if !hasPrompt && !hasCompletion && !hasTotal {
+		return nil
+	}
+	if !hasTotal {
+		totalTokens = promptTokens + completionTokens
+	} else if !hasCompletion {
+		completionTokens = totalTokens - promptTokens
+	}
+	return &dto.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      totalTokens,
+	}
+}
+
+// convertResponse 把非流式上游响应转换为 OpenAI 响应
+func (t *transformer) convertResponse(data []byte) (map[string]any, error) {
+	upstream, err := t.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	result, err := t.convert(upstream, t.settings.Response, "chat.completion")
+	if err != nil {
+		return nil, err
+	}
+	if usage := t.extractUsage(upstream); usage != nil {
+		result["usage"] = usage
+	}
+	return result, nil
+}
+
+// convertStreamChunk 把上游流式数据块转换为 OpenAI 流式数据块，同时返回数据块中的用量
+func (t *transformer) convertStreamChunk(data string) (map[string]any, *dto.Usage, error) {
+	upstream, err := t.parse([]byte(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := t.convert(upstream, t.settings.StreamChunk, "chat.completion.chunk")
+	if err != nil {
+		return nil, nil, err
+	}
+	if !t.hasUsagePaths() {
+		return result, nil, nil
+	}
+	// 用量统一在最后一个数据块中输出
+	delete(result, "usage")
+	return result, t.extractUsage(upstream), nil
+}
+
+// streamReader 逐行读取上游流式响应，输出 OpenAI 格式的 SSE 数据。
+// 支持 SSE 和每行一个 JSON 的格式，上游的 [DONE] 被忽略，结束时统一输出
+type streamReader struct {
+	c           *gin.Context
+	body        io.ReadCloser
+	scanner     *bufio.Scanner
+	transformer *transformer
+	buffer      bytes.Buffer
+	usage       *dto.Usage
+	done        bool
+}
+
+func newStreamReader(c *gin.Context, body io.ReadCloser, t *transformer) *streamReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, helper.InitialScannerBufferSize), helper.MaxScannerBufferSize)
+	scanner.Split(bufio.ScanLines)
+	return &streamReader{
+		c:           c,
+		body:        body,
+		scanner:     scanner,
+		transformer: t,
+	}
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for r.buffer.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		r.next()
+	}
+	return r.buffer.Read(p)
+}
+
+func (r *streamReader) Close() error {
+	return r.body.Close()
+}
+
+func (r *streamReader) next() {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			common.LogError(r.c, "error reading template stream: "+err.Error())
+		}
+		r.finish()
+		return
+	}
+	data, ok := streamLineData(r.scanner.Text())
+	if !ok {
+		return
+	}
+	chunk, usage, err := r.transformer.convertStreamChunk(data)
+	if err != nil {
+		var upstreamErr *upstreamError
+		if errors.As(err, &upstreamErr) {
+			common.LogError(r.c, "upstream stream error: "+upstreamErr.message)
+			r.finish()
+			return
+		}
+		common.SysError("error converting template stream chunk: " + err.Error())
+		return
+	}
+	if usage != nil {
+		r.usage = usage
+	}
+	r.write(chunk)
+}
+
+func (r *streamReader) write(chunk any) {
+	data, err := common.Marshal(chunk)
+	if err != nil {
+		common.SysError("error marshalling template stream chunk: " + err.Error())
+		return
+	}
+	r.buffer.WriteString("data: ")
+	r.buffer.Write(data)
+	r.buffer.WriteString("\n\n")
+}
+
+func (r *streamReader) finish() {
+	if r.done {
+		return
+	}
+	r.done = true
+	if r.usage != nil {
+		t := r.transformer
+		r.write(helper.GenerateFinalUsageResponse(t.id, t.created, t.model, *r.usage))
+	}
+	r.buffer.WriteString("data: [DONE]\n\n")
+}
+
+// streamLineData 取出一行中的数据，SSE 的注释、事件名等行返回 false
+func streamLineData(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, ":") {
+		return "", false
+	}
+	if strings.HasPrefix(line, "data:") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	} else if strings.HasPrefix(line, "event:") || strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "retry:") {
+		return "", false
+	}
+	if line == "" || line == "[DONE]" {
+		return "", false
+	}
+	return line, true
+}
+
+// templateHandler 把上游响应转换为 OpenAI 响应后交给 OpenAI 渠道处理，复用用量统计和格式转换
+func templateHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	t := newTransformer(c, info)
+	responseBody, err := io.ReadAll(resp.Body)
+	common.CloseResponseBodyGracefully(resp)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeReadResponseBodyFailed)
+	}
+	result, err := t.convertResponse(responseBody)
+	if err != nil {
+		var upstreamErr *upstreamError
+		if errors.As(err, &upstreamErr) {
+			return nil, types.NewOpenAIError(fmt.Errorf("upstream error: %s", upstreamErr.message), types.ErrorCodeBadResponse, http.StatusBadGateway)
+		}
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	convertedBody, err := common.Marshal(result)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(convertedBody))
+	resp.ContentLength = int64(len(convertedBody))
+	resp.Header.Set("Content-Type", "application/json")
+	return openai.OpenaiHandler(c, info, resp)
+}
+
+func templateStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	resp.Body = newStreamReader(c, resp.Body, newTransformer(c, info))
+	return openai.OaiStreamHandler(c, info, resp)
+}
@@ -16,6 +16,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func getAndValidateClaudeRequest(c *gin.Context) (textRequest *dto.ClaudeRequest, err error) {
@@ -46,13 +47,17 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	if textRequest.Stream {
 		relayInfo.IsStream = true
 	}
+	textRequest.ServiceTier = helper.ResolveServiceTier(relayInfo, textRequest.ServiceTier)
 
 	err = helper.ModelMappedHelper(c, relayInfo, textRequest)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError)
 	}
 
+	span := common.StartSpan(c, "relay.CountTokens")
 	promptTokens, err := getClaudePromptTokens(textRequest, relayInfo)
+	span.SetAttributes(attribute.Int("usage.prompt_tokens", promptTokens))
+	common.EndSpan(span, err)
 	// count messages token error 计算promptTokens错误
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -74,6 +79,8 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
 		}
 	}()
+	// 流式响应中途分段扣费
+	service.InitStreamQuota(relayInfo, priceData, preConsumedQuota)
 
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
@@ -123,7 +130,7 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	var httpResp *http.Response
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doRequestWithSpan(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -139,7 +146,7 @@ func ClaudeHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newAPIError := doResponseWithSpan(c, adaptor, httpResp, relayInfo)
 	//log.Printf("usage: %v", usage)
 	if newAPIError != nil {
 		// reset status code 重置状态码
@@ -0,0 +1,16 @@
+package common
+
+import "context"
+
+type batchContextKey struct{}
+
+// WithBatchId 标记由批处理后台任务发起的请求，使用请求上下文传递，客户端无法通过请求头伪造
+func WithBatchId(ctx context.Context, batchId string) context.Context {
+	return context.WithValue(ctx, batchContextKey{}, batchId)
+}
+
+// GetBatchId 获取请求所属的批处理 id，非批处理请求返回空字符串
+func GetBatchId(ctx context.Context) string {
+	batchId, _ := ctx.Value(batchContextKey{}).(string)
+	return batchId
+}
@@ -5,7 +5,11 @@ import (
 	"one-api/constant"
 	"one-api/dto"
 	relayconstant "one-api/relay/constant"
+	"one-api/setting/operation_setting"
+	"one-api/types"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -31,6 +35,18 @@ type ClaudeConvertInfo struct {
 	Usage            *dto.Usage
 	FinishReason     string
 	Done             bool
+	MessageStarted   bool
+	// 当前 tool_use 块对应的 OpenAI tool_calls 下标和 id
+	ToolCallIndex int
+	ToolCallId    string
+	HasToolUse    bool
+	// 请求中的停止序列及命中的停止序列
+	StopSequences []string
+	StopSequence  string
+	// 请求中包含网页搜索工具
+	WebSearch bool
+	// 已输出的文本，用于按 url_citation 的下标截取被引用的内容
+	OutputText strings.Builder
 }
 
 const (
@@ -59,15 +75,59 @@ type ResponsesUsageInfo struct {
 	BuiltInTools map[string]*BuildInToolInfo
 }
 
+// StreamQuotaInfo 流式响应中途分段扣费的状态
+type StreamQuotaInfo struct {
+	PromptQuota      float64 // 输入部分的预估额度
+	CompletionPrice  float64 // 每个补全 token 的额度
+	PreConsumedQuota int     // 请求开始时的预扣费额度
+	CompletionTokens int     // 截至目前统计到的补全 token 数
+	SettledTokens    int     // 上次结算时的补全 token 数
+	SettledQuota     int     // 流式过程中追加扣除的额度
+
+	// 追加扣费在后台执行，不阻塞数据块的输出，同一时间只有一次扣费
+	settling     atomic.Bool
+	settlingWait sync.WaitGroup
+	exhausted    atomic.Pointer[types.NewAPIError] // 额度或令牌预算已不足
+}
+
+// StartSettle 开始一次后台追加扣费，上一次扣费尚未结束时返回 false
+func (sq *StreamQuotaInfo) StartSettle() bool {
+	if !sq.settling.CompareAndSwap(false, true) {
+		return false
+	}
+	sq.settlingWait.Add(1)
+	return true
+}
+
+// FinishSettle 结束后台追加扣费，err 不为空表示额度已不足，后续数据块不再输出
+func (sq *StreamQuotaInfo) FinishSettle(err *types.NewAPIError) {
+	if err != nil {
+		sq.exhausted.Store(err)
+	}
+	sq.settling.Store(false)
+	sq.settlingWait.Done()
+}
+
+// ExhaustedError 返回后台追加扣费时发现的额度不足错误
+func (sq *StreamQuotaInfo) ExhaustedError() *types.NewAPIError {
+	return sq.exhausted.Load()
+}
+
 type RelayInfo struct {
-	ChannelType       int
-	ChannelId         int
-	TokenId           int
-	TokenKey          string
-	UserId            int
-	UsingGroup        string // 使用的分组
-	UserGroup         string // 用户所在分组
-	TokenUnlimited    bool
+	ChannelType    int
+	ChannelId      int
+	TokenId        int
+	TokenKey       string
+	UserId         int
+	UsingGroup     string // 使用的分组
+	UserGroup      string // 用户所在分组
+	TokenUnlimited bool
+	// 令牌是否启用了周期预算
+	TokenBudgetEnabled bool
+	// 已预占的令牌周期预算额度，结算时按实际用量核对
+	TokenBudgetReserved int
+	// 令牌所属的组织，非 0 时从组织钱包扣费
+	OrganizationId    int
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	isFirstResponse   bool
@@ -75,15 +135,19 @@ type RelayInfo struct {
 	ApiType           int
 	IsStream          bool
 	IsPlayground      bool
+	RequestId         string
 	UsePrice          bool
 	RelayMode         int
 	UpstreamModelName string
 	OriginModelName   string
+	FallbackFromModel string // 降级到备选模型时为客户端请求的模型
 	//RecodeModelName      string
 	RequestURLPath       string
 	ApiVersion           string
 	PromptTokens         int
 	ApiKey               string
+	ChannelIsMultiKey    bool // 多 Key 渠道
+	ChannelKeyIndex      int  // 多 Key 渠道中使用的 Key 下标
 	Organization         string
 	BaseUrl              string
 	SupportStreamOptions bool
@@ -97,6 +161,7 @@ type RelayInfo struct {
 	IsFirstRequest       bool
 	AudioUsage           bool
 	ReasoningEffort      string
+	ServiceTier          string // 请求的服务等级，用于分档计费
 	ChannelSetting       dto.ChannelSettings
 	ParamOverride        map[string]interface{}
 	UserSetting          dto.UserSetting
@@ -105,6 +170,16 @@ type RelayInfo struct {
 	RelayFormat          string
 	SendResponseCount    int
 	ChannelCreateTime    int64
+	// 命中响应缓存时按缓存命中倍率计费
+	ResponseCacheHit      bool
+	ResponseCacheHitRatio float64
+	// 流量镜像的影子请求只转发不计费
+	IsShadowRequest bool
+	// 批处理请求按批处理倍率计费
+	BatchId    string
+	BatchRatio float64
+	// 流式响应中途分段扣费的状态，未启用时为 nil
+	StreamQuota *StreamQuotaInfo
 	ThinkingContentInfo
 	*ClaudeConvertInfo
 	*RerankerInfo
@@ -227,24 +302,29 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 	apiType, _ := common.ChannelType2APIType(channelType)
 
 	info := &RelayInfo{
-		UserQuota:         common.GetContextKeyInt(c, constant.ContextKeyUserQuota),
-		UserEmail:         common.GetContextKeyString(c, constant.ContextKeyUserEmail),
-		isFirstResponse:   true,
-		RelayMode:         relayconstant.Path2RelayMode(c.Request.URL.Path),
-		BaseUrl:           common.GetContextKeyString(c, constant.ContextKeyChannelBaseUrl),
-		RequestURLPath:    c.Request.URL.String(),
-		ChannelType:       channelType,
-		ChannelId:         channelId,
-		TokenId:           tokenId,
-		TokenKey:          tokenKey,
-		UserId:            userId,
-		UsingGroup:        common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
-		UserGroup:         common.GetContextKeyString(c, constant.ContextKeyUserGroup),
-		TokenUnlimited:    tokenUnlimited,
-		StartTime:         startTime,
-		FirstResponseTime: startTime.Add(-time.Second),
-		OriginModelName:   common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
-		UpstreamModelName: common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		UserQuota:          common.GetContextKeyInt(c, constant.ContextKeyUserQuota),
+		UserEmail:          common.GetContextKeyString(c, constant.ContextKeyUserEmail),
+		isFirstResponse:    true,
+		RelayMode:          relayconstant.Path2RelayMode(c.Request.URL.Path),
+		BaseUrl:            common.GetContextKeyString(c, constant.ContextKeyChannelBaseUrl),
+		RequestURLPath:     c.Request.URL.String(),
+		ChannelType:        channelType,
+		ChannelId:          channelId,
+		TokenId:            tokenId,
+		TokenKey:           tokenKey,
+		UserId:             userId,
+		UsingGroup:         common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		UserGroup:          common.GetContextKeyString(c, constant.ContextKeyUserGroup),
+		TokenUnlimited:     tokenUnlimited,
+		TokenBudgetEnabled: common.GetContextKeyBool(c, constant.ContextKeyTokenBudgetEnabled),
+		OrganizationId:     common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId),
+		StartTime:          startTime,
+		RequestId:          c.GetString(common.RequestIdKey),
+		FirstResponseTime:  startTime.Add(-time.Second),
+		OriginModelName:    common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		UpstreamModelName:  common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		FallbackFromModel:  common.GetContextKeyString(c, constant.ContextKeyModelFallbackFrom),
+		IsShadowRequest:    common.GetContextKeyBool(c, constant.ContextKeyTrafficMirrorShadow),
 		//RecodeModelName:   c.GetString("original_model"),
 		IsModelMapped: false,
 		ApiType:       apiType,
@@ -253,6 +333,8 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		Organization:  c.GetString("channel_organization"),
 
 		ChannelCreateTime: c.GetInt64("channel_create_time"),
+		ChannelIsMultiKey: common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey),
+		ChannelKeyIndex:   common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex),
 		ParamOverride:     paramOverride,
 		RelayFormat:       RelayFormatOpenAI,
 		ThinkingContentInfo: ThinkingContentInfo{
@@ -277,6 +359,10 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 	if streamSupportedChannels[info.ChannelType] {
 		info.SupportStreamOptions = true
 	}
+	if batchId := GetBatchId(c.Request.Context()); batchId != "" {
+		info.BatchId = batchId
+		info.BatchRatio = operation_setting.GetBatchSetting().DiscountRatio
+	}
 
 	channelSetting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
 	if ok {
@@ -309,6 +395,23 @@ func (info *RelayInfo) HasSendResponse() bool {
 	return info.FirstResponseTime.After(info.StartTime)
 }
 
+// GetFirstResponseLatencyMs 返回首字响应时间（毫秒），非流式请求未记录首字时间时返回总耗时
+func (info *RelayInfo) GetFirstResponseLatencyMs() int64 {
+	if info.HasSendResponse() {
+		return info.FirstResponseTime.Sub(info.StartTime).Milliseconds()
+	}
+	return time.Since(info.StartTime).Milliseconds()
+}
+
+// GetStreamSettledQuota 等待后台追加扣费结束，返回流式过程中追加扣除的额度，结算和退还时需与预扣费额度一并计算
+func (info *RelayInfo) GetStreamSettledQuota() int {
+	if info.StreamQuota == nil {
+		return 0
+	}
+	info.StreamQuota.settlingWait.Wait()
+	return info.StreamQuota.SettledQuota
+}
+
 type TaskRelayInfo struct {
 	*RelayInfo
 	Action       string
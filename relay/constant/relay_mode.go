@@ -178,3 +178,31 @@ func Path2RelayVeo3(method, path string) int {
 	}
 	return relayMode
 }
+
+var relayModeNames = map[int]string{
+	RelayModeChatCompletions:    "chat_completions",
+	RelayModeCompletions:        "completions",
+	RelayModeEmbeddings:         "embeddings",
+	RelayModeModerations:        "moderations",
+	RelayModeImagesGenerations:  "images_generations",
+	RelayModeImagesEdits:        "images_edits",
+	RelayModeEdits:              "edits",
+	RelayModeAudioSpeech:        "audio_speech",
+	RelayModeAudioTranscription: "audio_transcription",
+	RelayModeAudioTranslation:   "audio_translation",
+	RelayModeRerank:             "rerank",
+	RelayModeResponses:          "responses",
+	RelayModeRealtime:           "realtime",
+	RelayModeGemini:             "gemini",
+}
+
+// RelayModeName 返回请求路径对应的中继模式名称，用于监控指标标签
+func RelayModeName(path string) string {
+	if strings.HasPrefix(path, "/v1/messages") {
+		return "claude_messages"
+	}
+	if name, ok := relayModeNames[Path2RelayMode(path)]; ok {
+		return name
+	}
+	return "unknown"
+}
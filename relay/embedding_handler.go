@@ -14,6 +14,7 @@ import (
 	"one-api/types"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func getEmbeddingPromptToken(embeddingRequest dto.EmbeddingRequest) int {
@@ -49,12 +50,18 @@ func EmbeddingHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return types.NewError(err, types.ErrorCodeInvalidRequest)
 	}
 
+	// 在模型映射之前计算缓存键，使不同渠道的相同请求共享缓存
+	responseCacheKey := getEmbeddingResponseCacheKey(c, relayInfo, embeddingRequest)
+
 	err = helper.ModelMappedHelper(c, relayInfo, embeddingRequest)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError)
 	}
 
+	span := common.StartSpan(c, "relay.CountTokens")
 	promptToken := getEmbeddingPromptToken(*embeddingRequest)
+	span.SetAttributes(attribute.Int("usage.prompt_tokens", promptToken))
+	span.End()
 	relayInfo.PromptTokens = promptToken
 
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, promptToken, 0)
@@ -72,6 +79,16 @@ func EmbeddingHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}()
 
+	// 缓存已在选择渠道前查询过，这里只捕获响应用于写入缓存
+	var captureWriter *service.ResponseCaptureWriter
+	if responseCacheKey != "" {
+		captureWriter = service.NewResponseCaptureWriter(c.Writer)
+		c.Writer = captureWriter
+		defer func() {
+			c.Writer = captureWriter.ResponseWriter
+		}()
+	}
+
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
 		return types.NewError(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), types.ErrorCodeInvalidApiType)
@@ -89,7 +106,7 @@ func EmbeddingHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	}
 	requestBody := bytes.NewBuffer(jsonData)
 	statusCodeMappingStr := c.GetString("status_code_mapping")
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doRequestWithSpan(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -105,12 +122,15 @@ func EmbeddingHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newAPIError := doResponseWithSpan(c, adaptor, httpResp, relayInfo)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
 		return newAPIError
 	}
+	if captureWriter != nil {
+		saveResponseCache(responseCacheKey, relayInfo, captureWriter, usage.(*dto.Usage))
+	}
 	postConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 	return nil
 }
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
 	"one-api/relay/channel/gemini"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
 	"one-api/setting"
@@ -105,6 +107,11 @@ func trimModelThinking(modelName string) string {
 	return modelName
 }
 
+// isGeminiNativeApiType 渠道是否支持 Gemini 原生格式，其他渠道需要经过 OpenAI 格式转换
+func isGeminiNativeApiType(apiType int) bool {
+	return apiType == constant.APITypeGemini || apiType == constant.APITypeVertexAi
+}
+
 func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	req, err := getAndValidateGeminiRequest(c)
 	if err != nil {
@@ -172,38 +179,74 @@ func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
 		}
 	}()
+	// 流式响应中途分段扣费
+	service.InitStreamQuota(relayInfo, priceData, preConsumedQuota)
 
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
 		return types.NewError(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), types.ErrorCodeInvalidApiType)
 	}
 
-	adaptor.Init(relayInfo)
+	var requestBody []byte
+	if isGeminiNativeApiType(relayInfo.ApiType) {
+		adaptor.Init(relayInfo)
 
-	// Clean up empty system instruction
-	if req.SystemInstructions != nil {
-		hasContent := false
-		for _, part := range req.SystemInstructions.Parts {
-			if part.Text != "" {
-				hasContent = true
-				break
+		// Clean up empty system instruction
+		if req.SystemInstructions != nil {
+			hasContent := false
+			for _, part := range req.SystemInstructions.Parts {
+				if part.Text != "" {
+					hasContent = true
+					break
+				}
+			}
+			if !hasContent {
+				req.SystemInstructions = nil
 			}
 		}
-		if !hasContent {
-			req.SystemInstructions = nil
+
+		requestBody, err = json.Marshal(req)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 		}
-	}
+	} else {
+		// 非 Gemini 渠道：与 Claude 格式一样先转换为 OpenAI 格式请求，
+		// 渠道输出的 OpenAI 格式响应再由 OpenAI2GeminiWriter 转换回 Gemini 格式
+		openAIRequest, err := gemini.GeminiToOpenAIRequest(req, relayInfo)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		}
+		relayInfo.RelayMode = relayconstant.RelayModeChatCompletions
+		relayInfo.RelayFormat = relaycommon.RelayFormatOpenAI
+		relayInfo.RequestURLPath = "/v1/chat/completions"
+		relayInfo.ShouldIncludeUsage = relayInfo.IsStream
+		adaptor.Init(relayInfo)
 
-	requestBody, err := json.Marshal(req)
-	if err != nil {
-		return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		convertedRequest, err := adaptor.ConvertOpenAIRequest(c, relayInfo, openAIRequest)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		}
+		requestBody, err = json.Marshal(convertedRequest)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
+		}
+
+		originWriter := c.Writer
+		geminiWriter := gemini.NewOpenAI2GeminiWriter(originWriter, relayInfo.IsStream)
+		c.Writer = geminiWriter
+		defer func() {
+			c.Writer = originWriter
+			if newAPIError == nil {
+				geminiWriter.Close()
+			}
+		}()
 	}
 
 	if common.DebugEnabled {
 		println("Gemini request body: %s", string(requestBody))
 	}
 
-	resp, err := adaptor.DoRequest(c, relayInfo, bytes.NewReader(requestBody))
+	resp, err := doRequestWithSpan(c, adaptor, relayInfo, bytes.NewReader(requestBody))
 	if err != nil {
 		common.LogError(c, "Do gemini request failed: "+err.Error())
 		return types.NewError(err, types.ErrorCodeDoRequestFailed)
@@ -223,7 +266,7 @@ func GeminiHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, openaiErr := adaptor.DoResponse(c, resp.(*http.Response), relayInfo)
+	usage, openaiErr := doResponseWithSpan(c, adaptor, resp.(*http.Response), relayInfo)
 	if openaiErr != nil {
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
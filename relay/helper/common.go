@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
+	relaycommon "one-api/relay/common"
 	"one-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -15,7 +16,7 @@ import (
 
 func SetEventStreamHeaders(c *gin.Context) {
 	// 检查是否已经设置过头部
-	if _, exists := c.Get("event_stream_headers_set"); exists {
+	if c.GetBool("event_stream_headers_set") {
 		return
 	}
 
@@ -29,6 +30,17 @@ func SetEventStreamHeaders(c *gin.Context) {
 	c.Set("event_stream_headers_set", true)
 }
 
+// ResetEventStreamHeaders 清除尚未发送的流式响应头，用于未输出任何数据时切换渠道重试
+func ResetEventStreamHeaders(c *gin.Context) {
+	if c.Writer.Written() {
+		return
+	}
+	for _, key := range []string{"Content-Type", "Cache-Control", "Connection", "Transfer-Encoding", "X-Accel-Buffering"} {
+		c.Writer.Header().Del(key)
+	}
+	c.Set("event_stream_headers_set", false)
+}
+
 func ClaudeData(c *gin.Context, resp dto.ClaudeResponse) error {
 	jsonData, err := json.Marshal(resp)
 	if err != nil {
@@ -165,3 +177,20 @@ func GenerateFinalUsageResponse(id string, createAt int64, model string, usage d
 		Usage:             &usage,
 	}
 }
+
+// StreamErrorData 按客户端请求的格式输出错误事件，用于流式响应中途结束
+func StreamErrorData(c *gin.Context, info *relaycommon.RelayInfo, newAPIError *types.NewAPIError) {
+	if info.RelayFormat == relaycommon.RelayFormatClaude {
+		claudeError := newAPIError.ToClaudeError()
+		_ = ClaudeData(c, dto.ClaudeResponse{Type: "error", Error: &claudeError})
+		return
+	}
+	if info.RelayFormat == relaycommon.RelayFormatOpenAIResponses {
+		openAIError := newAPIError.ToOpenAIError()
+		event := dto.ResponsesStreamResponse{Type: dto.ResponsesStreamTypeError, Code: openAIError.Code, Message: openAIError.Message}
+		data, _ := common.Marshal(event)
+		ResponseChunkData(c, event, string(data))
+		return
+	}
+	_ = ObjectData(c, gin.H{"error": newAPIError.ToOpenAIError()})
+}
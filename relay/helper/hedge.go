@@ -0,0 +1,242 @@
+package helper
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errHedgeLost = errors.New("hedge attempt lost the race")
+
+// HedgeRace 对冲请求的竞速状态：多个尝试并发请求不同渠道，
+// 第一个向客户端写出数据的尝试胜出，其余尝试被取消且不计费
+type HedgeRace struct {
+	mu       sync.Mutex
+	writer   gin.ResponseWriter
+	attempts []*HedgeAttempt
+	winner   *HedgeAttempt
+	decided  chan struct{}
+}
+
+// HedgeAttempt 对冲竞速中的一次尝试
+type HedgeAttempt struct {
+	race      *HedgeRace
+	writer    *hedgeWriter
+	cancel    context.CancelFunc
+	ChannelId int
+	IsHedge   bool
+}
+
+func NewHedgeRace(writer gin.ResponseWriter) *HedgeRace {
+	return &HedgeRace{
+		writer:  writer,
+		decided: make(chan struct{}),
+	}
+}
+
+// NewAttempt 基于原始上下文创建一个独立的尝试上下文，写出的数据在胜出前不会发送给客户端
+func (r *HedgeRace) NewAttempt(c *gin.Context, channelId int, isHedge bool) (*gin.Context, *HedgeAttempt) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	attempt := &HedgeAttempt{
+		race:      r,
+		cancel:    cancel,
+		ChannelId: channelId,
+		IsHedge:   isHedge,
+	}
+	attempt.writer = &hedgeWriter{
+		attempt: attempt,
+		header:  make(http.Header),
+		status:  http.StatusOK,
+		size:    -1,
+	}
+	hc := c.Copy()
+	hc.Request = c.Request.Clone(ctx)
+	hc.Writer = attempt.writer
+	common.SetContextKey(hc, constant.ContextKeyHedgeAttempt, attempt)
+
+	r.mu.Lock()
+	r.attempts = append(r.attempts, attempt)
+	r.mu.Unlock()
+	return hc, attempt
+}
+
+// Decided 有尝试胜出时关闭
+func (r *HedgeRace) Decided() <-chan struct{} {
+	return r.decided
+}
+
+func (r *HedgeRace) Winner() *HedgeAttempt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.winner
+}
+
+// claim 尝试成为胜出者，成功后将缓存的响应头写出并取消其余尝试
+func (r *HedgeRace) claim(attempt *HedgeAttempt) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.winner == nil {
+		r.winner = attempt
+		header := r.writer.Header()
+		for key, values := range attempt.writer.header {
+			header[key] = values
+		}
+		r.writer.WriteHeader(attempt.writer.status)
+		for _, other := range r.attempts {
+			if other != attempt {
+				other.cancel()
+			}
+		}
+		close(r.decided)
+	}
+	return r.winner == attempt
+}
+
+// LogInfo 记录到日志 Other 字段的对冲信息
+func (r *HedgeRace) LogInfo() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := make(map[string]interface{})
+	for _, attempt := range r.attempts {
+		if attempt.IsHedge {
+			info["hedge_channel_id"] = attempt.ChannelId
+		} else {
+			info["primary_channel_id"] = attempt.ChannelId
+		}
+	}
+	if r.winner != nil {
+		info["winner_channel_id"] = r.winner.ChannelId
+	}
+	return info
+}
+
+func (a *HedgeAttempt) Race() *HedgeRace {
+	return a.race
+}
+
+// Cancel 取消该尝试的上游请求
+func (a *HedgeAttempt) Cancel() {
+	a.cancel()
+}
+
+// SetChannelId 记录尝试选定的渠道，对冲请求在创建上下文之后才选择渠道
+func (a *HedgeAttempt) SetChannelId(channelId int) {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	a.ChannelId = channelId
+}
+
+// Discard 取消未发起请求的尝试并将其移出竞速
+func (a *HedgeAttempt) Discard() {
+	a.cancel()
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+	for i, attempt := range a.race.attempts {
+		if attempt == a {
+			a.race.attempts = append(a.race.attempts[:i], a.race.attempts[i+1:]...)
+			break
+		}
+	}
+}
+
+func GetHedgeAttempt(c *gin.Context) *HedgeAttempt {
+	attempt, _ := common.GetContextKeyType[*HedgeAttempt](c, constant.ContextKeyHedgeAttempt)
+	return attempt
+}
+
+// IsHedgeLoser 当前上下文是否为竞速失败的对冲尝试，失败的尝试不计费
+func IsHedgeLoser(c *gin.Context) bool {
+	attempt := GetHedgeAttempt(c)
+	if attempt == nil {
+		return false
+	}
+	winner := attempt.race.Winner()
+	return winner != nil && winner != attempt
+}
+
+// IsHedgePending 当前上下文是否为尚未决出胜者的对冲尝试，此时不发送 ping，避免 ping 抢占胜出
+func IsHedgePending(c *gin.Context) bool {
+	attempt := GetHedgeAttempt(c)
+	return attempt != nil && attempt.race.Winner() == nil
+}
+
+// hedgeWriter 在胜出前缓存响应头和状态码，首次写出数据时参与竞速
+type hedgeWriter struct {
+	attempt *HedgeAttempt
+	header  http.Header
+	status  int
+	size    int
+}
+
+func (w *hedgeWriter) isWinner() bool {
+	return w.attempt.race.Winner() == w.attempt
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.isWinner() {
+		return w.attempt.race.writer.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow 响应头延迟到胜出时写出
+func (w *hedgeWriter) WriteHeaderNow() {}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if !w.attempt.race.claim(w.attempt) {
+		return 0, errHedgeLost
+	}
+	if w.size < 0 {
+		w.size = 0
+	}
+	n, err := w.attempt.race.writer.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *hedgeWriter) Status() int {
+	return w.status
+}
+
+func (w *hedgeWriter) Size() int {
+	return w.size
+}
+
+func (w *hedgeWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.isWinner() {
+		w.attempt.race.writer.Flush()
+	}
+}
+
+func (w *hedgeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hedge writer does not support hijack")
+}
+
+func (w *hedgeWriter) CloseNotify() <-chan bool {
+	return w.attempt.race.writer.CloseNotify()
+}
+
+func (w *hedgeWriter) Pusher() http.Pusher {
+	return nil
+}
@@ -9,9 +9,18 @@ import (
 	"one-api/relay/common"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func ModelMappedHelper(c *gin.Context, info *common.RelayInfo, request any) error {
+	span := common2.StartSpan(c, "relay.ModelMapped", attribute.String("model.origin", info.OriginModelName))
+	err := modelMapped(c, info, request)
+	span.SetAttributes(attribute.String("model.upstream", info.UpstreamModelName), attribute.Bool("model.mapped", info.IsModelMapped))
+	common2.EndSpan(span, err)
+	return err
+}
+
+func modelMapped(c *gin.Context, info *common.RelayInfo, request any) error {
 	// map model name
 	modelMapping := c.GetString("model_mapping")
 	if modelMapping != "" && modelMapping != "{}" {
@@ -3,7 +3,9 @@ package helper
 import (
 	"fmt"
 	"one-api/common"
+	"one-api/dto"
 	relaycommon "one-api/relay/common"
+	"one-api/setting/model_setting"
 	"one-api/setting/ratio_setting"
 
 	"github.com/gin-gonic/gin"
@@ -31,6 +33,45 @@ func (p PriceData) ToSetting() string {
 	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, ShouldPreConsumedQuota: %d, ImageRatio: %f", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatioInfo.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.ShouldPreConsumedQuota, p.ImageRatio)
 }
 
+// ResolveServiceTier 确定请求使用的服务等级并记录到 info 用于分档计费。模型没有配置对应服务等级的
+// 分档计费规则时清空服务等级，避免用户指定更贵的上游服务等级而按默认价格计费；
+// 透传请求体时无法修改请求，按请求的服务等级计费
+func ResolveServiceTier(info *relaycommon.RelayInfo, serviceTier string) string {
+	if serviceTier != "" && !model_setting.GetGlobalSettings().PassThroughRequestEnabled &&
+		!ratio_setting.HasServiceTierPriceRule(info.OriginModelName, serviceTier) {
+		serviceTier = ""
+	}
+	info.ServiceTier = serviceTier
+	return serviceTier
+}
+
+// ApplyTieredPrice 按实际输入 token 数、服务等级和输入模态匹配分档计费规则，命中时用规则中的倍率覆盖对应倍率
+func (p *PriceData) ApplyTieredPrice(info *relaycommon.RelayInfo, promptTokens int, usage *dto.Usage) *ratio_setting.TieredPriceRule {
+	if p.UsePrice {
+		return nil
+	}
+	modality := ratio_setting.ModalityText
+	if usage.PromptTokensDetails.AudioTokens > 0 {
+		modality = ratio_setting.ModalityAudio
+	} else if usage.PromptTokensDetails.ImageTokens > 0 {
+		modality = ratio_setting.ModalityImage
+	}
+	rule, ok := ratio_setting.MatchTieredPriceRule(info.OriginModelName, promptTokens, info.ServiceTier, modality)
+	if !ok {
+		return nil
+	}
+	if rule.ModelRatio != nil {
+		p.ModelRatio = *rule.ModelRatio
+	}
+	if rule.CompletionRatio != nil {
+		p.CompletionRatio = *rule.CompletionRatio
+	}
+	if rule.CacheRatio != nil {
+		p.CacheRatio = *rule.CacheRatio
+	}
+	return rule
+}
+
 // HandleGroupRatio checks for "auto_group" in the context and updates the group ratio and relayInfo.UsingGroup if present
 func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) GroupRatioInfo {
 	groupRatioInfo := GroupRatioInfo{
@@ -94,7 +135,12 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		cacheRatio, _ = ratio_setting.GetCacheRatio(info.OriginModelName)
 		cacheCreationRatio, _ = ratio_setting.GetCreateCacheRatio(info.OriginModelName)
 		imageRatio, _ = ratio_setting.GetImageRatio(info.OriginModelName)
-		ratio := modelRatio * groupRatioInfo.GroupRatio
+		preConsumedRatio := modelRatio
+		// 预扣费按预估输入 token 数和服务等级匹配分档倍率，结算时再按实际用量重新匹配
+		if rule, ok := ratio_setting.MatchTieredPriceRule(info.OriginModelName, promptTokens, info.ServiceTier, ""); ok && rule.ModelRatio != nil {
+			preConsumedRatio = *rule.ModelRatio
+		}
+		ratio := preConsumedRatio * groupRatioInfo.GroupRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	} else {
 		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
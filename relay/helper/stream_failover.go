@@ -0,0 +1,156 @@
+package helper
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 匹配包含实际输出内容的数据块：文本、思考内容、工具调用参数等
+var meaningfulStreamDataRegex = regexp.MustCompile(`"(content|reasoning_content|reasoning|text|thinking|partial_json|arguments|delta)"\s*:\s*"[^"]|"(tool_calls|functionCall|function_call)"\s*:\s*[\[{]`)
+
+// streamFailover 在首个有效数据块到达前缓存上游输出，
+// 超时或收到错误事件时放弃本次响应，由上层切换渠道重试
+type streamFailover struct {
+	mu          sync.Mutex
+	started     bool
+	aborted     bool
+	err         *types.NewAPIError
+	buffered    []string
+	maxBuffered int
+	timer       *time.Timer
+}
+
+func newStreamFailover() *streamFailover {
+	setting := operation_setting.GetStreamFailoverSetting()
+	if !setting.Enabled || setting.FirstTokenTimeoutSeconds <= 0 {
+		return nil
+	}
+	maxBuffered := setting.MaxBufferedChunks
+	if maxBuffered <= 0 {
+		maxBuffered = 64
+	}
+	return &streamFailover{
+		maxBuffered: maxBuffered,
+		timer:       time.NewTimer(time.Duration(setting.FirstTokenTimeoutSeconds) * time.Second),
+	}
+}
+
+func (f *streamFailover) timeoutChan() <-chan time.Time {
+	if f == nil {
+		return nil
+	}
+	return f.timer.C
+}
+
+func (f *streamFailover) stop() {
+	if f != nil {
+		f.timer.Stop()
+	}
+}
+
+// isStarted 是否已经开始向客户端输出
+func (f *streamFailover) isStarted() bool {
+	if f == nil {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.started
+}
+
+// push 缓存一个数据块，返回需要立即输出的数据块；返回 nil 表示继续等待
+func (f *streamFailover) push(data string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.aborted {
+		return nil
+	}
+	f.buffered = append(f.buffered, data)
+	if !meaningfulStreamDataRegex.MatchString(data) && len(f.buffered) < f.maxBuffered {
+		return nil
+	}
+	return f.startLocked()
+}
+
+// flush 上游正常结束但没有有效数据块时，输出剩余缓存
+func (f *streamFailover) flush() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.aborted || f.started {
+		return nil
+	}
+	return f.startLocked()
+}
+
+func (f *streamFailover) startLocked() []string {
+	f.started = true
+	f.timer.Stop()
+	pending := f.buffered
+	f.buffered = nil
+	return pending
+}
+
+// abort 尚未输出时放弃本次响应，返回是否成功放弃
+func (f *streamFailover) abort(err *types.NewAPIError) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.started || f.aborted {
+		return false
+	}
+	f.aborted = true
+	f.err = err
+	f.buffered = nil
+	return true
+}
+
+func (f *streamFailover) getError() *types.NewAPIError {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func newFirstTokenTimeoutError() *types.NewAPIError {
+	timeout := operation_setting.GetStreamFailoverSetting().FirstTokenTimeoutSeconds
+	return types.WithOpenAIError(types.OpenAIError{
+		Message: fmt.Sprintf("upstream did not produce the first token within %d seconds", timeout),
+		Type:    "upstream_error",
+		Code:    string(types.ErrorCodeStreamFirstTokenTimeout),
+	}, http.StatusServiceUnavailable)
+}
+
+// detectStreamErrorEvent 检查数据块是否为上游错误事件（OpenAI error 对象或 Claude error 事件）
+func detectStreamErrorEvent(data string) *types.NewAPIError {
+	var event struct {
+		Type  string `json:"type"`
+		Error any    `json:"error"`
+	}
+	if err := common.UnmarshalJsonStr(data, &event); err != nil {
+		return nil
+	}
+	if event.Error == nil && event.Type != "error" {
+		return nil
+	}
+	message := "upstream returned an error event before the first token"
+	switch e := event.Error.(type) {
+	case string:
+		message = e
+	case map[string]any:
+		if msg, ok := e["message"].(string); ok && msg != "" {
+			message = msg
+		}
+	}
+	return types.WithOpenAIError(types.OpenAIError{
+		Message: message,
+		Type:    "upstream_error",
+		Code:    string(types.ErrorCodeStreamErrorEvent),
+	}, http.StatusBadGateway)
+}
@@ -10,6 +10,7 @@ import (
 	"one-api/constant"
 	relaycommon "one-api/relay/common"
 	"one-api/setting/operation_setting"
+	"one-api/types"
 	"strings"
 	"sync"
 	"time"
@@ -25,10 +26,13 @@ const (
 	DefaultPingInterval      = 10 * time.Second
 )
 
-func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, dataHandler func(data string) bool) {
+// StreamScannerHandler 逐行读取上游 SSE 数据并交给 dataHandler 处理。
+// 启用流式故障转移时，若首个有效数据块到达前超时或收到错误事件，
+// 返回错误且不向客户端写入任何数据，调用方可切换渠道重试
+func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, dataHandler func(data string) bool) *types.NewAPIError {
 
 	if resp == nil || dataHandler == nil {
-		return
+		return nil
 	}
 
 	// 确保响应体总是被关闭
@@ -51,6 +55,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		pingTicker *time.Ticker
 		writeMutex sync.Mutex     // Mutex to protect concurrent writes
 		wg         sync.WaitGroup // 用于等待所有 goroutine 退出
+		failover   = newStreamFailover()
 	)
 
 	generalSettings := operation_setting.GetGeneralSetting()
@@ -77,6 +82,7 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		common.SafeSendBool(stopChan, true)
 
 		ticker.Stop()
+		failover.stop()
 		if pingTicker != nil {
 			pingTicker.Stop()
 		}
@@ -129,6 +135,10 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			for {
 				select {
 				case <-pingTicker.C:
+					// 首个数据块输出前或对冲请求决出胜者前不发送 ping
+					if !failover.isStarted() || IsHedgePending(c) {
+						continue
+					}
 					// 使用超时机制防止写操作阻塞
 					done := make(chan error, 1)
 					go func() {
@@ -169,6 +179,31 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		})
 	}
 
+	// 处理单个数据块，返回 false 表示需要停止
+	handleData := func(data string) bool {
+		info.SetFirstResponseTime()
+
+		// 使用超时机制防止写操作阻塞
+		done := make(chan bool, 1)
+		go func() {
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			done <- dataHandler(data)
+		}()
+
+		select {
+		case success := <-done:
+			return success
+		case <-time.After(10 * time.Second):
+			common.LogError(c, "data handler timeout")
+			return false
+		case <-ctx.Done():
+			return false
+		case <-stopChan:
+			return false
+		}
+	}
+
 	// Scanner goroutine with improved error handling
 	wg.Add(1)
 	common.RelayCtxGo(ctx, func() {
@@ -211,27 +246,30 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 			data = strings.TrimLeft(data, " ")
 			data = strings.TrimSuffix(data, "\r")
 			if !strings.HasPrefix(data, "[DONE]") {
-				info.SetFirstResponseTime()
-
-				// 使用超时机制防止写操作阻塞
-				done := make(chan bool, 1)
-				go func() {
-					writeMutex.Lock()
-					defer writeMutex.Unlock()
-					done <- dataHandler(data)
-				}()
-
-				select {
-				case success := <-done:
-					if !success {
-						return
+				if failover != nil && !failover.isStarted() {
+					if apiErr := detectStreamErrorEvent(data); apiErr != nil {
+						if failover.abort(apiErr) {
+							common.LogError(c, "upstream error event before first token: "+apiErr.Error())
+							return
+						}
 					}
-				case <-time.After(10 * time.Second):
-					common.LogError(c, "data handler timeout")
-					return
-				case <-ctx.Done():
+					for _, pending := range failover.push(data) {
+						if !handleData(pending) {
+							return
+						}
+					}
+					continue
+				}
+				if !handleData(data) {
 					return
-				case <-stopChan:
+				}
+			}
+		}
+
+		// 上游结束时仍未出现有效数据块，输出剩余缓存
+		if failover != nil {
+			for _, pending := range failover.flush() {
+				if !handleData(pending) {
 					return
 				}
 			}
@@ -255,5 +293,27 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	case <-c.Request.Context().Done():
 		// 客户端断开连接
 		common.LogInfo(c, "client disconnected")
+	case <-failover.timeoutChan():
+		if failover.abort(newFirstTokenTimeoutError()) {
+			common.LogError(c, "first token timeout, aborting stream for failover")
+			// 关闭上游连接，让阻塞中的 scanner 立即退出
+			resp.Body.Close()
+		} else {
+			// 超时与首个数据块同时到达，继续等待正常结束
+			select {
+			case <-ticker.C:
+				common.LogError(c, "streaming timeout")
+			case <-stopChan:
+				common.LogInfo(c, "streaming finished")
+			case <-c.Request.Context().Done():
+				common.LogInfo(c, "client disconnected")
+			}
+		}
+	}
+
+	if apiErr := failover.getError(); apiErr != nil {
+		ResetEventStreamHeaders(c)
+		return apiErr
 	}
+	return nil
 }
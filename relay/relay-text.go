@@ -27,6 +27,7 @@ import (
 	"github.com/shopspring/decimal"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // shouldRefundForEmptyCompletion 检查是否应该为空补全退款
@@ -113,6 +114,7 @@ func getAndValidateTextRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo)
 		}
 	}
 	relayInfo.IsStream = textRequest.Stream
+	textRequest.ServiceTier = helper.ResolveServiceTier(relayInfo, textRequest.ServiceTier)
 	return textRequest, nil
 }
 
@@ -127,6 +129,12 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return types.NewError(err, types.ErrorCodeInvalidRequest)
 	}
 
+	// 在模型映射之前计算缓存键，使不同渠道的相同请求共享缓存，影子请求不写入缓存
+	var responseCacheKey string
+	if !relayInfo.IsShadowRequest {
+		responseCacheKey = getTextResponseCacheKey(c, relayInfo, textRequest)
+	}
+
 	if textRequest.WebSearchOptions != nil {
 		c.Set("chat_completion_web_search_context_size", textRequest.WebSearchOptions.SearchContextSize)
 	}
@@ -150,7 +158,10 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		promptTokens = value.(int)
 		relayInfo.PromptTokens = promptTokens
 	} else {
+		span := common.StartSpan(c, "relay.CountTokens")
 		promptTokens, err = getPromptTokens(textRequest, relayInfo)
+		span.SetAttributes(attribute.Int("usage.prompt_tokens", promptTokens))
+		common.EndSpan(span, err)
 		// count messages token error 计算promptTokens错误
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeCountTokenFailed)
@@ -163,16 +174,33 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return types.NewError(err, types.ErrorCodeModelPriceError)
 	}
 
-	// pre-consume quota 预消耗配额
-	preConsumedQuota, userQuota, newApiErr := preConsumeQuota(c, priceData.ShouldPreConsumedQuota, relayInfo)
-	if newApiErr != nil {
-		return newApiErr
-	}
-	defer func() {
+	// pre-consume quota 预消耗配额，流量镜像的影子请求不计费
+	var preConsumedQuota, userQuota int
+	var newApiErr *types.NewAPIError
+	if !relayInfo.IsShadowRequest {
+		preConsumedQuota, userQuota, newApiErr = preConsumeQuota(c, priceData.ShouldPreConsumedQuota, relayInfo)
 		if newApiErr != nil {
-			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
+			return newApiErr
 		}
-	}()
+		defer func() {
+			if newApiErr != nil {
+				returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
+			}
+		}()
+		// 流式响应中途分段扣费
+		service.InitStreamQuota(relayInfo, priceData, preConsumedQuota)
+	}
+
+	// 缓存已在选择渠道前查询过，这里只捕获响应用于写入缓存
+	var captureWriter *service.ResponseCaptureWriter
+	if responseCacheKey != "" {
+		captureWriter = service.NewResponseCaptureWriter(c.Writer)
+		c.Writer = captureWriter
+		defer func() {
+			c.Writer = captureWriter.ResponseWriter
+		}()
+	}
+
 	includeUsage := false
 	// 判断用户是否需要返回使用情况
 	if textRequest.StreamOptions != nil && textRequest.StreamOptions.IncludeUsage {
@@ -238,10 +266,11 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	}
 
 	var httpResp *http.Response
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doRequestWithSpan(c, adaptor, relayInfo, requestBody)
 
 	if err != nil {
-		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+		newApiErr = types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+		return newApiErr
 	}
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
@@ -257,12 +286,17 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newApiErr := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newApiErr := doResponseWithSpan(c, adaptor, httpResp, relayInfo)
 	if newApiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newApiErr, statusCodeMappingStr)
 		return newApiErr
 	}
+	// 流量镜像用于对比主请求的用量
+	common.SetContextKey(c, constant.ContextKeyRelayUsage, usage)
+	if relayInfo.IsShadowRequest {
+		return nil
+	}
 
 	// 检查是否需要对空补全的模型进行退款
 	if shouldRefundForEmptyCompletion(relayInfo.OriginModelName, usage) {
@@ -280,6 +314,10 @@ func TextHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		return nil
 	}
 
+	if captureWriter != nil {
+		saveResponseCache(responseCacheKey, relayInfo, captureWriter, usage.(*dto.Usage))
+	}
+
 	if strings.HasPrefix(relayInfo.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 	} else {
@@ -326,8 +364,29 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *types.NewAPIError) {
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	span := common.StartSpan(c, "relay.PreConsumeQuota", attribute.Int("quota.estimated", preConsumedQuota))
+	preConsumedQuota, userQuota, newAPIError := doPreConsumeQuota(c, preConsumedQuota, relayInfo)
+	if newAPIError != nil {
+		// 预占令牌预算后扣费失败时退还
+		service.SettleTokenBudget(relayInfo, 0)
+	}
+	span.SetAttributes(attribute.Int("quota.pre_consumed", preConsumedQuota))
+	endSpan(span, newAPIError)
+	return preConsumedQuota, userQuota, newAPIError
+}
+
+func doPreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *types.NewAPIError) {
+	if newAPIError := service.ReserveTPM(c, relayInfo); newAPIError != nil {
+		return 0, 0, newAPIError
+	}
+	if newAPIError := service.ReserveTokenBudget(relayInfo, preConsumedQuota); newAPIError != nil {
+		return 0, 0, newAPIError
+	}
+	userQuota, err := service.GetPayerQuota(relayInfo)
 	if err != nil {
+		if relayInfo.OrganizationId != 0 {
+			return 0, 0, types.NewErrorWithStatusCode(err, types.ErrorCodeInsufficientUserQuota, http.StatusForbidden)
+		}
 		return 0, 0, types.NewError(err, types.ErrorCodeQueryDataError)
 	}
 	if userQuota <= 0 {
@@ -368,8 +427,12 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		if err != nil {
 			return 0, 0, types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden)
 		}
-		err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+		err = service.ReservePayerQuota(relayInfo, preConsumedQuota)
 		if err != nil {
+			service.ReturnPreConsumedTokenQuota(relayInfo, preConsumedQuota)
+			if service.IsPayerQuotaExceeded(err) {
+				return 0, 0, types.NewErrorWithStatusCode(err, types.ErrorCodeInsufficientUserQuota, http.StatusForbidden)
+			}
 			return 0, 0, types.NewError(err, types.ErrorCodeUpdateDataError)
 		}
 	}
@@ -377,20 +440,32 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 }
 
 func returnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, userQuota int, preConsumedQuota int) {
-	if preConsumedQuota != 0 {
+	// 流式过程中追加扣除的额度与预扣费额度一并结算
+	preConsumedQuota += relayInfo.GetStreamSettledQuota()
+	relayInfoCopy := *relayInfo
+	relayInfo.TokenBudgetReserved = 0
+	if preConsumedQuota != 0 || relayInfoCopy.TokenBudgetReserved != 0 {
 		gopool.Go(func() {
-			relayInfoCopy := *relayInfo
-
-			err := service.PostConsumeQuota(&relayInfoCopy, -preConsumedQuota, 0, false)
-			if err != nil {
-				common.SysError("error return pre-consumed quota: " + err.Error())
+			if preConsumedQuota != 0 {
+				err := service.PostConsumeQuota(&relayInfoCopy, -preConsumedQuota, 0, false)
+				if err != nil {
+					common.SysError("error return pre-consumed quota: " + err.Error())
+				}
 			}
+			service.SettleTokenBudget(&relayInfoCopy, 0)
 		})
 	}
 }
 
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
+	span := common.StartSpan(ctx, "relay.PostConsumeQuota")
+	defer span.End()
+	// 流式过程中追加扣除的额度与预扣费额度一并结算
+	preConsumedQuota += relayInfo.GetStreamSettledQuota()
+	if service.SkipHedgeLoserQuota(ctx, relayInfo, preConsumedQuota) {
+		return
+	}
 	if usage == nil {
 		usage = &dto.Usage{
 			PromptTokens:     relayInfo.PromptTokens,
@@ -399,6 +474,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		}
 		extraContent += "（可能是请求出错）"
 	}
+	service.SettleTPM(ctx, usage.CompletionTokens)
+	// 按实际用量匹配分档计费规则
+	tieredPriceRule := priceData.ApplyTieredPrice(relayInfo, usage.PromptTokens, usage)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	cacheTokens := usage.PromptTokensDetails.CachedTokens
@@ -530,6 +608,17 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	// 添加 audio input 独立计费
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(audioInputQuota)
 
+	// 上游成本按折扣前的额度计算，批处理和缓存命中的折扣只作用于用户
+	listQuota := int(quotaCalculateDecimal.Round(0).IntPart())
+
+	quotaCalculateDecimal, discountContent := service.ApplyQuotaDiscount(relayInfo, quotaCalculateDecimal)
+	if discountContent != "" {
+		if extraContent != "" {
+			extraContent += "，"
+		}
+		extraContent += discountContent
+	}
+
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
 
@@ -539,18 +628,24 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	if tieredPriceRule != nil {
+		logContent += "，分档计费 " + tieredPriceRule.Describe()
+	}
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
 		// in this case, must be some error happened
 		// we cannot just return, because we may have to return the pre-consumed quota
 		quota = 0
+		listQuota = 0
 		logContent += fmt.Sprintf("（可能是上游超时）")
 		common.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, preConsumedQuota))
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		if !relayInfo.ResponseCacheHit {
+			model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		}
 	}
 
 	quotaDelta := quota - preConsumedQuota
@@ -560,6 +655,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			common.LogError(ctx, "error consuming token remain quota: "+err.Error())
 		}
 	}
+	service.SettleTokenBudget(relayInfo, quota)
 
 	logModel := modelName
 	if strings.HasPrefix(logModel, "gpt-4-gizmo") {
@@ -574,6 +670,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		logContent += ", " + extraContent
 	}
 	other := service.GenerateTextOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio, cacheTokens, cacheRatio, modelPrice, priceData.GroupRatioInfo.GroupSpecialRatio)
+	service.AppendTieredPriceInfo(other, tieredPriceRule)
 	if imageTokens != 0 {
 		other["image"] = true
 		other["image_ratio"] = imageRatio
@@ -608,6 +705,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		other["audio_input_token_count"] = audioTokens
 		other["audio_input_price"] = audioInputPrice
 	}
+	upstreamCost, hasUpstreamCost := service.GetUpstreamCost(relayInfo, listQuota, groupRatio)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
@@ -622,12 +720,20 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		IsStream:         relayInfo.IsStream,
 		Group:            relayInfo.UsingGroup,
 		Other:            other,
+		UpstreamCost:     upstreamCost,
+		HasUpstreamCost:  hasUpstreamCost,
 	})
 
+	service.RecordRelayUsageMetrics(relayInfo, promptTokens, completionTokens, quota)
+
+	// 命中响应缓存时没有请求上游，不计入渠道统计
+	if relayInfo.ResponseCacheHit {
+		return
+	}
 	frtValue := int64(0)
 	if v, ok := other["frt"].(float64); ok {
 		frtValue = int64(v)
 	}
 	model.RecordTimeoutStats(relayInfo.ChannelId, modelName, frtValue, int(useTimeSeconds))
-
+	service.RecordChannelRelaySuccess(relayInfo, promptTokens+completionTokens, quota)
 }
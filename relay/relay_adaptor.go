@@ -29,6 +29,7 @@ import (
 	"one-api/relay/channel/task/kling"
 	"one-api/relay/channel/task/suno"
 	"one-api/relay/channel/task/veo3"
+	"one-api/relay/channel/template"
 	"one-api/relay/channel/tencent"
 	"one-api/relay/channel/vertex"
 	"one-api/relay/channel/volcengine"
@@ -100,6 +101,8 @@ func GetAdaptor(apiType int) channel.Adaptor {
 		return &jimeng.Adaptor{}
 	case constant.APITypeFlux:
 		return &flux.Adaptor{}
+	case constant.APITypeTemplate:
+		return &template.Adaptor{}
 	}
 	return nil
 }
@@ -60,7 +60,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	} else {
 		ratio = modelPrice * groupRatio
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := service.GetPayerQuota(relayInfo.RelayInfo)
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 		return
@@ -126,6 +126,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
+			service.SettleTokenBudget(relayInfo.RelayInfo, quota)
 			if quota != 0 {
 				tokenName := c.GetString("token_name")
 				gRatio := groupRatio
@@ -139,16 +140,19 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 				if hasUserGroupRatio {
 					other["user_group_ratio"] = userGroupRatio
 				}
+				upstreamCost, hasUpstreamCost := service.GetUpstreamCost(relayInfo.RelayInfo, quota, gRatio)
 				model.RecordConsumeLog(c, relayInfo.UserId, model.RecordConsumeLogParams{
-					ChannelId: relayInfo.ChannelId,
-					ModelName: modelName,
-					TokenName: tokenName,
-					Quota:     quota,
-					Content:   logContent,
-					TokenId:   relayInfo.TokenId,
-					UserQuota: userQuota,
-					Group:     relayInfo.UsingGroup,
-					Other:     other,
+					ChannelId:       relayInfo.ChannelId,
+					ModelName:       modelName,
+					TokenName:       tokenName,
+					Quota:           quota,
+					Content:         logContent,
+					TokenId:         relayInfo.TokenId,
+					UserQuota:       userQuota,
+					Group:           relayInfo.UsingGroup,
+					Other:           other,
+					UpstreamCost:    upstreamCost,
+					HasUpstreamCost: hasUpstreamCost,
 				})
 				model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 				model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
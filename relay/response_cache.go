@@ -0,0 +1,148 @@
+package relay
+
+import (
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/setting"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServeResponseCache 在选择渠道和预扣费之前查询响应缓存，命中时直接返回缓存的响应并单独结算，
+// 返回是否已处理请求。未命中时由 TextHelper 和 EmbeddingHelper 请求上游并写入缓存
+func ServeResponseCache(c *gin.Context) bool {
+	if !service.ShouldUseResponseCache(c) {
+		return false
+	}
+	if common.GetContextKeyTime(c, constant.ContextKeyRequestStartTime).IsZero() {
+		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+	}
+	var relayInfo *relaycommon.RelayInfo
+	var key string
+	switch relayconstant.Path2RelayMode(c.Request.URL.Path) {
+	case relayconstant.RelayModeEmbeddings:
+		relayInfo = relaycommon.GenRelayInfoEmbedding(c)
+		var embeddingRequest *dto.EmbeddingRequest
+		if err := common.UnmarshalBodyReusable(c, &embeddingRequest); err != nil {
+			return false
+		}
+		if err := validateEmbeddingRequest(c, relayInfo, *embeddingRequest); err != nil {
+			return false
+		}
+		key = getEmbeddingResponseCacheKey(c, relayInfo, embeddingRequest)
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions,
+		relayconstant.RelayModeModerations, relayconstant.RelayModeEdits:
+		relayInfo = relaycommon.GenRelayInfo(c)
+		textRequest, err := getAndValidateTextRequest(c, relayInfo)
+		if err != nil {
+			return false
+		}
+		key = getTextResponseCacheKey(c, relayInfo, textRequest)
+	default:
+		return false
+	}
+	if key == "" {
+		return false
+	}
+	entry, ok := service.GetResponseCache(key)
+	if !ok {
+		return false
+	}
+	return serveResponseCacheHit(c, relayInfo, entry)
+}
+
+// serveResponseCacheHit 命中缓存的请求不选择渠道也不预扣费，按缓存的用量乘以命中倍率结算。
+// 额度、令牌预算不足或无法计价时返回 false，由正常流程处理并返回错误
+func serveResponseCacheHit(c *gin.Context, relayInfo *relaycommon.RelayInfo, entry *service.ResponseCacheEntry) bool {
+	userQuota, err := service.GetPayerQuota(relayInfo)
+	if err != nil || userQuota <= 0 {
+		return false
+	}
+	if !resolveResponseCacheGroup(c, relayInfo) {
+		return false
+	}
+	relayInfo.PromptTokens = entry.Usage.PromptTokens
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, entry.Usage.PromptTokens, entry.Usage.CompletionTokens)
+	if err != nil {
+		return false
+	}
+	relayInfo.UserQuota = userQuota
+	relayInfo.ResponseCacheHit = true
+	relayInfo.ResponseCacheHitRatio = service.GetResponseCacheHitRatio(relayInfo.OriginModelName)
+	// 按缓存用量的预估额度预占令牌预算，结算时按实际额度核对
+	budgetQuota := int(float64(priceData.ShouldPreConsumedQuota) * relayInfo.ResponseCacheHitRatio)
+	if newAPIError := service.ReserveTokenBudget(relayInfo, budgetQuota); newAPIError != nil {
+		return false
+	}
+	relayInfo.SetFirstResponseTime()
+	service.WriteResponseCache(c, entry)
+	postConsumeQuota(c, relayInfo, &entry.Usage, 0, userQuota, priceData, "")
+	return true
+}
+
+// resolveResponseCacheGroup 命中缓存时没有选择渠道，auto 分组按渠道选择的顺序取第一个有可用渠道的分组计费
+func resolveResponseCacheGroup(c *gin.Context, relayInfo *relaycommon.RelayInfo) bool {
+	if relayInfo.UsingGroup != "auto" {
+		return true
+	}
+	for _, group := range setting.AutoGroups {
+		if len(model.CacheGetSatisfiedChannels(group, relayInfo.OriginModelName)) > 0 {
+			c.Set("auto_group", group)
+			return true
+		}
+	}
+	return false
+}
+
+// getTextResponseCacheKey 计算对话请求的缓存键，不可缓存时返回空字符串
+func getTextResponseCacheKey(c *gin.Context, relayInfo *relaycommon.RelayInfo, textRequest *dto.GeneralOpenAIRequest) string {
+	if textRequest.Stream || !service.ShouldUseResponseCache(c) || !service.IsTemperatureCacheable(textRequest.Temperature) {
+		return ""
+	}
+	cacheRequest := *textRequest
+	cacheRequest.Model = relayInfo.OriginModelName
+	cacheRequest.StreamOptions = nil
+	cacheRequest.User = ""
+	key, err := service.GenerateResponseCacheKey(c, relayInfo.RelayMode, cacheRequest)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// getEmbeddingResponseCacheKey 计算嵌入请求的缓存键，不可缓存时返回空字符串
+func getEmbeddingResponseCacheKey(c *gin.Context, relayInfo *relaycommon.RelayInfo, embeddingRequest *dto.EmbeddingRequest) string {
+	if !service.ShouldUseResponseCache(c) {
+		return ""
+	}
+	cacheRequest := *embeddingRequest
+	cacheRequest.Model = relayInfo.OriginModelName
+	cacheRequest.User = ""
+	key, err := service.GenerateResponseCacheKey(c, relayInfo.RelayMode, cacheRequest)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// saveResponseCache 将成功的非流式响应写入缓存
+func saveResponseCache(key string, relayInfo *relaycommon.RelayInfo, captureWriter *service.ResponseCaptureWriter, usage *dto.Usage) {
+	if relayInfo.IsStream || usage == nil || usage.TotalTokens == 0 {
+		return
+	}
+	body := captureWriter.Body()
+	if body == nil {
+		return
+	}
+	service.SetResponseCache(key, service.ResponseCacheEntry{
+		Body:  append([]byte(nil), body...),
+		Usage: *usage,
+	})
+}
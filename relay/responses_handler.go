@@ -8,8 +8,10 @@ import (
 	"io"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
 	"one-api/setting"
@@ -47,6 +49,15 @@ func getInputTokens(req *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo
 	return inputTokens
 }
 
+// isResponsesNativeApiType 渠道是否原生支持 Responses API，其他渠道需要经过 Chat Completions 转换
+func isResponsesNativeApiType(apiType int) bool {
+	switch apiType {
+	case constant.APITypeOpenAI, constant.APITypeOpenRouter, constant.APITypeXinference:
+		return true
+	}
+	return false
+}
+
 func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	req, err := getAndValidateResponsesRequest(c)
 	if err != nil {
@@ -55,6 +66,7 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 	}
 
 	relayInfo := relaycommon.GenRelayInfoResponses(c, req)
+	req.ServiceTier = helper.ResolveServiceTier(relayInfo, req.ServiceTier)
 
 	if setting.ShouldCheckPromptSensitive() {
 		sensitiveWords, err := checkInputSensitive(req, relayInfo)
@@ -91,20 +103,54 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
 		}
 	}()
+	// 流式响应中途分段扣费
+	service.InitStreamQuota(relayInfo, priceData, preConsumedQuota)
+
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
 		return types.NewError(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), types.ErrorCodeInvalidApiType)
 	}
+
+	// 不支持 Responses API 的渠道：先转换为 Chat Completions 请求，
+	// 渠道输出的响应再由 OpenAI2ResponsesWriter 转换回 Responses 格式
+	var chatRequest *dto.GeneralOpenAIRequest
+	var responsesWriter *service.OpenAI2ResponsesWriter
+	if !isResponsesNativeApiType(relayInfo.ApiType) {
+		var history []dto.Message
+		if req.PreviousResponseID != "" {
+			var ok bool
+			history, ok = service.GetResponsesConversation(relayInfo.UserId, req.PreviousResponseID)
+			if !ok {
+				return types.NewErrorWithStatusCode(fmt.Errorf("previous response not found: %s", req.PreviousResponseID), types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+			}
+		}
+		var conversation []dto.Message
+		chatRequest, conversation, err = service.ResponsesToOpenAIRequest(req, history)
+		if err != nil {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest)
+		}
+		relayInfo.RelayMode = relayconstant.RelayModeChatCompletions
+		relayInfo.RelayFormat = relaycommon.RelayFormatOpenAI
+		relayInfo.RequestURLPath = "/v1/chat/completions"
+		relayInfo.ShouldIncludeUsage = relayInfo.IsStream
+		responsesWriter = service.NewOpenAI2ResponsesWriter(c.Writer, req, conversation, relayInfo)
+	}
+
 	adaptor.Init(relayInfo)
 	var requestBody io.Reader
-	if model_setting.GetGlobalSettings().PassThroughRequestEnabled {
+	if model_setting.GetGlobalSettings().PassThroughRequestEnabled && chatRequest == nil {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeReadRequestBodyFailed)
 		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
-		convertedRequest, err := adaptor.ConvertOpenAIResponsesRequest(c, relayInfo, *req)
+		var convertedRequest any
+		if chatRequest != nil {
+			convertedRequest, err = adaptor.ConvertOpenAIRequest(c, relayInfo, chatRequest)
+		} else {
+			convertedRequest, err = adaptor.ConvertOpenAIResponsesRequest(c, relayInfo, *req)
+		}
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed)
 		}
@@ -134,8 +180,19 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		requestBody = bytes.NewBuffer(jsonData)
 	}
 
+	if responsesWriter != nil {
+		originWriter := c.Writer
+		c.Writer = responsesWriter
+		defer func() {
+			c.Writer = originWriter
+			if newAPIError == nil {
+				responsesWriter.Close()
+			}
+		}()
+	}
+
 	var httpResp *http.Response
-	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	resp, err := doRequestWithSpan(c, adaptor, relayInfo, requestBody)
 	if err != nil {
 		return types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
 	}
@@ -153,7 +210,7 @@ func ResponsesHelper(c *gin.Context) (newAPIError *types.NewAPIError) {
 		}
 	}
 
-	usage, newAPIError := adaptor.DoResponse(c, httpResp, relayInfo)
+	usage, newAPIError := doResponseWithSpan(c, adaptor, httpResp, relayInfo)
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// endSpan 结束 span，单独处理 *types.NewAPIError，避免空指针被包装成非空 error
+func endSpan(span trace.Span, err *types.NewAPIError) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.Int("http.status_code", err.StatusCode), attribute.String("error.type", string(err.ErrorType)), attribute.String("error.code", string(err.GetErrorCode())))
+	}
+	span.End()
+}
+
+func upstreamSpanAttributes(info *relaycommon.RelayInfo) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("channel.id", info.ChannelId),
+		attribute.Int("channel.type", info.ChannelType),
+		attribute.String("model.upstream", info.UpstreamModelName),
+		attribute.Bool("relay.stream", info.IsStream),
+	}
+}
+
+func doRequestWithSpan(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	span := common.StartSpan(c, "adaptor.DoRequest", upstreamSpanAttributes(info)...)
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if httpResp, ok := resp.(*http.Response); ok && httpResp != nil {
+		span.SetAttributes(attribute.Int("http.status_code", httpResp.StatusCode))
+	}
+	common.EndSpan(span, err)
+	return resp, err
+}
+
+func doResponseWithSpan(c *gin.Context, adaptor channel.Adaptor, resp *http.Response, info *relaycommon.RelayInfo) (any, *types.NewAPIError) {
+	span := common.StartSpan(c, "adaptor.DoResponse", upstreamSpanAttributes(info)...)
+	usage, newAPIError := adaptor.DoResponse(c, resp, info)
+	if u, ok := usage.(*dto.Usage); ok && u != nil {
+		span.SetAttributes(attribute.Int("usage.prompt_tokens", u.PromptTokens), attribute.Int("usage.completion_tokens", u.CompletionTokens))
+	}
+	endSpan(span, newAPIError)
+	return usage, newAPIError
+}
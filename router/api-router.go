@@ -119,9 +119,15 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/fix", controller.FixChannelsAbilities)
 			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
 			channelRoute.POST("/fetch_models", controller.FetchModels)
+			channelRoute.POST("/template/preview", controller.PreviewTemplateChannel)
 			channelRoute.POST("/batch/tag", controller.BatchSetChannelTag)
 			channelRoute.GET("/tag/models", controller.GetTagModels)
 			channelRoute.POST("/copy/:id", controller.CopyChannel)
+			channelRoute.GET("/:id/keys", controller.GetChannelKeys)
+			channelRoute.POST("/:id/keys/retest", controller.RetestChannelKeys)
+			channelRoute.GET("/circuit_breakers", controller.GetCircuitBreakers)
+			channelRoute.POST("/circuit_breakers", controller.UpdateCircuitBreaker)
+			channelRoute.GET("/select_stats", controller.GetChannelSelectStats)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -129,11 +135,41 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
+			tokenRoute.GET("/:id/budget", controller.GetTokenBudget)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 			tokenRoute.POST("/batch", controller.DeleteTokenBatch)
 		}
+		organizationRoute := apiRouter.Group("/organization")
+		{
+			organizationRoute.GET("/all", middleware.AdminAuth(), controller.GetAllOrganizations)
+			organizationRoute.POST("/:id/manage", middleware.AdminAuth(), controller.ManageOrganization)
+		}
+		organizationRoute.Use(middleware.UserAuth())
+		{
+			organizationRoute.GET("/", controller.GetSelfOrganizations)
+			organizationRoute.POST("/", controller.CreateOrganization)
+			organizationRoute.POST("/join", controller.JoinOrganization)
+			organizationRoute.GET("/:id", controller.GetOrganization)
+			organizationRoute.PUT("/:id", controller.UpdateOrganization)
+			organizationRoute.DELETE("/:id", controller.DeleteOrganization)
+			organizationRoute.GET("/:id/member", controller.GetOrganizationMembers)
+			organizationRoute.PUT("/:id/member", controller.UpdateOrganizationMember)
+			organizationRoute.DELETE("/:id/member/:user_id", controller.RemoveOrganizationMember)
+			organizationRoute.GET("/:id/invitation", controller.GetOrganizationInvitations)
+			organizationRoute.POST("/:id/invitation", controller.InviteOrganizationMember)
+			organizationRoute.DELETE("/:id/invitation/:invitation_id", controller.RevokeOrganizationInvitation)
+			organizationRoute.POST("/:id/transfer", middleware.CriticalRateLimit(), controller.TransferOrganizationQuota)
+			organizationRoute.GET("/:id/usage", controller.GetOrganizationUsage)
+		}
+		ledgerRoute := apiRouter.Group("/ledger")
+		{
+			ledgerRoute.GET("/self", middleware.UserAuth(), controller.GetSelfQuotaLedgers)
+			ledgerRoute.GET("/", middleware.AdminAuth(), controller.GetQuotaLedgers)
+			ledgerRoute.GET("/reconcile", middleware.AdminAuth(), controller.GetLedgerReconcileReport)
+			ledgerRoute.POST("/reconcile", middleware.AdminAuth(), controller.ReconcileQuotaLedger)
+		}
 		redemptionRoute := apiRouter.Group("/redemption")
 		redemptionRoute.Use(middleware.AdminAuth())
 		{
@@ -157,6 +193,14 @@ func SetApiRouter(router *gin.Engine) {
 		dataRoute := apiRouter.Group("/data")
 		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
+		dataRoute.GET("/cost", middleware.AdminAuth(), controller.GetChannelCostReport)
+
+		mirrorRoute := apiRouter.Group("/traffic_mirror")
+		mirrorRoute.Use(middleware.AdminAuth())
+		{
+			mirrorRoute.GET("/logs", controller.GetTrafficMirrorLogs)
+			mirrorRoute.GET("/summary", controller.GetTrafficMirrorSummary)
+		}
 
 		logRoute.Use(middleware.CORS())
 		{
@@ -0,0 +1,15 @@
+package router
+
+import (
+	"one-api/common"
+	"one-api/controller"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetMetricsRouter(router *gin.Engine) {
+	if !common.MetricsEnabled {
+		return
+	}
+	router.GET("/metrics", controller.Metrics)
+}
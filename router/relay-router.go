@@ -12,6 +12,7 @@ func SetRelayRouter(router *gin.Engine) {
 	router.Use(middleware.CORS())
 	router.Use(middleware.DecompressRequestMiddleware())
 	router.Use(middleware.StatsMiddleware())
+	router.Use(middleware.Tracing())
 	router.Use(middleware.ErrorLogMiddleware())
 	// https://platform.openai.com/docs/api-reference/introduction
 	modelsRouter := router.Group("/v1/models")
@@ -28,12 +29,26 @@ func SetRelayRouter(router *gin.Engine) {
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.TokenAuth())
 	relayV1Router.Use(middleware.ModelRequestRateLimit())
+	relayV1Router.Use(middleware.ConcurrencyLimit())
 	{
 		// WebSocket 路由
 		wsRouter := relayV1Router.Group("")
 		wsRouter.Use(middleware.Distribute())
 		wsRouter.GET("/realtime", controller.WssRelay)
 	}
+	{
+		// 批处理路由，由网关自行执行，不需要分发渠道
+		batchRouter := relayV1Router.Group("")
+		batchRouter.GET("/files", controller.ListFiles)
+		batchRouter.POST("/files", controller.UploadFile)
+		batchRouter.DELETE("/files/:id", controller.DeleteFile)
+		batchRouter.GET("/files/:id", controller.RetrieveFile)
+		batchRouter.GET("/files/:id/content", controller.RetrieveFileContent)
+		batchRouter.POST("/batches", controller.CreateBatch)
+		batchRouter.GET("/batches", controller.ListBatches)
+		batchRouter.GET("/batches/:id", controller.RetrieveBatch)
+		batchRouter.POST("/batches/:id/cancel", controller.CancelBatch)
+	}
 	{
 		//http router
 		httpRouter := relayV1Router.Group("")
@@ -53,11 +68,6 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/audio/translations", controller.Relay)
 		httpRouter.POST("/audio/speech", controller.Relay)
 		httpRouter.POST("/responses", controller.Relay)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
 		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
@@ -87,6 +97,7 @@ func SetRelayRouter(router *gin.Engine) {
 	relayGeminiRouter := router.Group("/v1beta")
 	relayGeminiRouter.Use(middleware.TokenAuth())
 	relayGeminiRouter.Use(middleware.ModelRequestRateLimit())
+	relayGeminiRouter.Use(middleware.ConcurrencyLimit())
 	relayGeminiRouter.Use(middleware.Distribute())
 	{
 		// Gemini API 路径格式: /v1beta/models/{model_name}:{action}
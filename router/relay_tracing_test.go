@@ -0,0 +1,207 @@
+package router
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/controller"
+	"one-api/middleware"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting/ratio_setting"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	inboundTraceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	inboundSpanId  = "00f067aa0ba902b7"
+)
+
+// otlpCollector 接收 OTLP/HTTP 导出的 span
+type otlpCollector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (o *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var request coltracepb.ExportTraceServiceRequest
+	if err = proto.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	o.mu.Lock()
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			o.spans = append(o.spans, scopeSpans.Spans...)
+		}
+	}
+	o.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	data, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	_, _ = w.Write(data)
+}
+
+func (o *otlpCollector) spansByName() map[string]*tracepb.Span {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	result := make(map[string]*tracepb.Span, len(o.spans))
+	for _, span := range o.spans {
+		result[span.Name] = span
+	}
+	return result
+}
+
+func setupTracingTestRelay(t *testing.T, upstreamURL string) *gin.Engine {
+	t.Helper()
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	common.MemoryCacheEnabled = false
+	common.SQLitePath = filepath.Join(t.TempDir(), "tracing.db")
+	constant.StreamingTimeout = 30
+	if err := model.InitDB(); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	model.LOG_DB = model.DB
+	service.InitTokenEncoders()
+	service.InitHttpClient()
+	ratio_setting.InitRatioSettings()
+
+	user := &model.User{Username: "tracing", Password: "12345678", Quota: 100000000, Group: "default", Status: common.UserStatusEnabled, AffCode: "trace"}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	token := &model.Token{UserId: user.Id, Key: "tracingkey", Name: "tracing", Status: common.TokenStatusEnabled, UnlimitedQuota: true, ExpiredTime: -1}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	channel := &model.Channel{Type: constant.ChannelTypeOpenAI, Key: "sk-upstream", Status: common.ChannelStatusEnabled, Name: "upstream", Models: "gpt-4o", Group: "default", BaseURL: &upstreamURL}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatalf("create channel: %v", err)
+	}
+	if err := channel.AddAbilities(); err != nil {
+		t.Fatalf("add abilities: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(middleware.RequestId(), middleware.Tracing())
+	engine.POST("/v1/chat/completions", middleware.TokenAuth(), middleware.Distribute(), controller.Relay)
+	return engine
+}
+
+// runTracedRequest 开启追踪后发送一个携带 traceparent 的请求，返回导出的 span 和上游收到的 traceparent
+func runTracedRequest(t *testing.T, propagate bool) (map[string]*tracepb.Span, string) {
+	t.Helper()
+	collector := &otlpCollector{}
+	collectorServer := httptest.NewServer(collector)
+	defer collectorServer.Close()
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`)
+	}))
+	defer upstream.Close()
+
+	t.Setenv("OTEL_ENABLED", "true")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collectorServer.URL)
+	if propagate {
+		t.Setenv("OTEL_PROPAGATE_UPSTREAM", "true")
+	} else {
+		t.Setenv("OTEL_PROPAGATE_UPSTREAM", "")
+	}
+	shutdown, err := common.InitTracing()
+	if err != nil {
+		t.Fatalf("init tracing: %v", err)
+	}
+	defer func() {
+		common.TracingEnabled = false
+		common.TracingPropagateUpstream = false
+	}()
+
+	engine := setupTracingTestRelay(t, upstream.URL)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`))
+	req.Header.Set("Authorization", "Bearer sk-tracingkey")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+inboundTraceId+"-"+inboundSpanId+"-01")
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("relay status %d: %s", w.Code, w.Body.String())
+	}
+	// 关闭时导出所有已结束的 span
+	if err = shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown tracing: %v", err)
+	}
+	return collector.spansByName(), upstreamTraceparent
+}
+
+func requireSpan(t *testing.T, spans map[string]*tracepb.Span, name string, parent *tracepb.Span) *tracepb.Span {
+	t.Helper()
+	span, ok := spans[name]
+	if !ok {
+		names := make([]string, 0, len(spans))
+		for spanName := range spans {
+			names = append(names, spanName)
+		}
+		t.Fatalf("span %q not exported, got %v", name, names)
+	}
+	if traceId := hex.EncodeToString(span.TraceId); traceId != inboundTraceId {
+		t.Errorf("span %q trace id %s, want inbound trace id %s", name, traceId, inboundTraceId)
+	}
+	if parent != nil && hex.EncodeToString(span.ParentSpanId) != hex.EncodeToString(parent.SpanId) {
+		t.Errorf("span %q parent %x, want %q (%x)", name, span.ParentSpanId, parent.Name, parent.SpanId)
+	}
+	return span
+}
+
+func TestRelayTracing(t *testing.T) {
+	t.Run("spans and upstream propagation", func(t *testing.T) {
+		spans, upstreamTraceparent := runTracedRequest(t, true)
+
+		// 根 span 以请求头中的 traceparent 为父级
+		root := requireSpan(t, spans, "POST /v1/chat/completions", nil)
+		if parent := hex.EncodeToString(root.ParentSpanId); parent != inboundSpanId {
+			t.Errorf("root span parent %s, want inbound span id %s", parent, inboundSpanId)
+		}
+		requireSpan(t, spans, "middleware.TokenAuth", root)
+		requireSpan(t, spans, "middleware.Distribute", root)
+		attempt := requireSpan(t, spans, "relay.attempt", root)
+		requireSpan(t, spans, "relay.PreConsumeQuota", attempt)
+		requireSpan(t, spans, "adaptor.DoRequest", attempt)
+		requireSpan(t, spans, "adaptor.DoResponse", attempt)
+		requireSpan(t, spans, "relay.PostConsumeQuota", attempt)
+
+		// 上游请求以本次渠道尝试的 span 为父级
+		want := "00-" + inboundTraceId + "-" + hex.EncodeToString(attempt.SpanId) + "-01"
+		if upstreamTraceparent != want {
+			t.Errorf("upstream traceparent %q, want %q", upstreamTraceparent, want)
+		}
+	})
+
+	t.Run("no upstream propagation by default", func(t *testing.T) {
+		spans, upstreamTraceparent := runTracedRequest(t, false)
+		requireSpan(t, spans, "relay.attempt", nil)
+		if upstreamTraceparent != "" {
+			t.Errorf("upstream traceparent %q, want none", upstreamTraceparent)
+		}
+	})
+}
@@ -20,6 +20,7 @@ func formatNotifyType(channelId int, status int) string {
 func DisableChannel(channelError types.ChannelError, reason string) {
 	success := model.UpdateChannelStatus(channelError.ChannelId, channelError.UsingKey, common.ChannelStatusAutoDisabled, reason)
 	if success {
+		RecordChannelAutoDisabledMetrics(channelError.ChannelId)
 		subject := fmt.Sprintf("通道「%s」（#%d）已被禁用", channelError.ChannelName, channelError.ChannelId)
 		content := fmt.Sprintf("通道「%s」（#%d）已被禁用，原因：%s", channelError.ChannelName, channelError.ChannelId, reason)
 		NotifyRootUser(formatNotifyType(channelError.ChannelId, common.ChannelStatusAutoDisabled), subject, content)
@@ -87,6 +88,24 @@ func ShouldDisableChannel(channelType int, err *types.NewAPIError) bool {
 	return search
 }
 
+// ShouldCountCircuitBreakerFailure 判断错误是否由上游渠道引起，客户端请求错误不计入熔断
+func ShouldCountCircuitBreakerFailure(err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	if types.IsChannelError(err) {
+		return true
+	}
+	if types.IsLocalError(err) {
+		return err.GetErrorCode() == types.ErrorCodeDoRequestFailed
+	}
+	switch err.StatusCode {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout, http.StatusUnauthorized:
+		return true
+	}
+	return err.StatusCode/100 == 5
+}
+
 func ShouldEnableChannel(newAPIError *types.NewAPIError, status int) bool {
 	if !common.AutomaticEnableChannelEnabled {
 		return false
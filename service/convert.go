@@ -47,26 +47,60 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 	} else if len(claudeRequest.StopSequences) > 1 {
 		openAIRequest.Stop = claudeRequest.StopSequences
 	}
+	if info.ClaudeConvertInfo != nil {
+		info.ClaudeConvertInfo.StopSequences = claudeRequest.StopSequences
+	}
 
 	// Convert tools
-	tools, _ := common.Any2Type[[]dto.Tool](claudeRequest.Tools)
+	tools, _ := common.Any2Type[[]map[string]any](claudeRequest.Tools)
 	openAITools := make([]dto.ToolCallRequest, 0)
-	for _, claudeTool := range tools {
+	for _, tool := range tools {
+		toolType := common.Interface2String(tool["type"])
+		if strings.HasPrefix(toolType, "web_search") {
+			// Claude 服务端网页搜索工具转换为 OpenAI 的 web_search_options
+			webSearchTool, err := common.Any2Type[dto.ClaudeWebSearchTool](tool)
+			if err != nil {
+				return nil, fmt.Errorf("invalid web search tool: %w", err)
+			}
+			openAIRequest.WebSearchOptions = claudeWebSearchToolToOpenAI(webSearchTool)
+			if info.ClaudeConvertInfo != nil {
+				info.ClaudeConvertInfo.WebSearch = true
+			}
+			continue
+		}
+		claudeTool, err := common.Any2Type[dto.Tool](tool)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tool: %w", err)
+		}
+		parameters := claudeTool.InputSchema
+		if parameters == nil {
+			// bash、text_editor 等内置工具没有 input_schema
+			parameters = map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{},
+			}
+		}
 		openAITool := dto.ToolCallRequest{
 			Type: "function",
 			Function: dto.FunctionRequest{
 				Name:        claudeTool.Name,
 				Description: claudeTool.Description,
-				Parameters:  claudeTool.InputSchema,
+				Parameters:  parameters,
 			},
 		}
 		openAITools = append(openAITools, openAITool)
 	}
 	openAIRequest.Tools = openAITools
+	if len(openAITools) > 0 {
+		claudeToolChoiceToOpenAI(claudeRequest.ToolChoice, &openAIRequest)
+	}
 
 	// Convert messages
 	openAIMessages := make([]dto.Message, 0)
 
+	// OpenRouter 的 Claude 模型支持 cache_control，其他上游不识别该字段
+	keepCacheControl := isOpenRouter && strings.HasPrefix(info.UpstreamModelName, "anthropic/claude")
+
 	// Add system message if present
 	if claudeRequest.System != nil {
 		if claudeRequest.IsStringSystem() && claudeRequest.GetStringSystem() != "" {
@@ -81,8 +115,7 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 				openAIMessage := dto.Message{
 					Role: "system",
 				}
-				isOpenRouterClaude := isOpenRouter && strings.HasPrefix(info.UpstreamModelName, "anthropic/claude")
-				if isOpenRouterClaude {
+				if keepCacheControl {
 					systemMediaMessages := make([]dto.MediaContent, 0, len(systems))
 					for _, system := range systems {
 						message := dto.MediaContent{
@@ -111,72 +144,95 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 			Role: claudeMessage.Role,
 		}
 
-		//log.Printf("claudeMessage.Content: %v", claudeMessage.Content)
 		if claudeMessage.IsStringContent() {
 			openAIMessage.SetStringContent(claudeMessage.GetStringContent())
-		} else {
-			content, err := claudeMessage.ParseContent()
-			if err != nil {
-				return nil, err
-			}
-			contents := content
-			var toolCalls []dto.ToolCallRequest
-			mediaMessages := make([]dto.MediaContent, 0, len(contents))
-
-			for _, mediaMsg := range contents {
-				switch mediaMsg.Type {
-				case "text":
-					message := dto.MediaContent{
-						Type:         "text",
-						Text:         mediaMsg.GetText(),
-						CacheControl: mediaMsg.CacheControl,
-					}
-					mediaMessages = append(mediaMessages, message)
-				case "image":
-					// Handle image conversion (base64 to URL or keep as is)
-					imageData := fmt.Sprintf("data:%s;base64,%s", mediaMsg.Source.MediaType, mediaMsg.Source.Data)
-					//textContent += fmt.Sprintf("[Image: %s]", imageData)
-					mediaMessage := dto.MediaContent{
-						Type:     "image_url",
-						ImageUrl: &dto.MessageImageUrl{Url: imageData},
-					}
-					mediaMessages = append(mediaMessages, mediaMessage)
-				case "tool_use":
-					toolCall := dto.ToolCallRequest{
-						ID:   mediaMsg.Id,
-						Type: "function",
-						Function: dto.FunctionRequest{
-							Name:      mediaMsg.Name,
-							Arguments: toJSONString(mediaMsg.Input),
-						},
-					}
-					toolCalls = append(toolCalls, toolCall)
-				case "tool_result":
-					// Add tool result as a separate message
-					oaiToolMessage := dto.Message{
-						Role:       "tool",
-						Name:       &mediaMsg.Name,
-						ToolCallId: mediaMsg.ToolUseId,
-					}
-					//oaiToolMessage.SetStringContent(*mediaMsg.GetMediaContent().Text)
-					if mediaMsg.IsStringContent() {
-						oaiToolMessage.SetStringContent(mediaMsg.GetStringContent())
-					} else {
-						mediaContents := mediaMsg.ParseMediaContent()
-						encodeJson, _ := common.Marshal(mediaContents)
-						oaiToolMessage.SetStringContent(string(encodeJson))
+			openAIMessages = append(openAIMessages, openAIMessage)
+			continue
+		}
+		contents, err := claudeMessage.ParseContent()
+		if err != nil {
+			return nil, err
+		}
+		var toolCalls []dto.ToolCallRequest
+		mediaMessages := make([]dto.MediaContent, 0, len(contents))
+
+		for _, mediaMsg := range contents {
+			switch mediaMsg.Type {
+			case "text":
+				message := dto.MediaContent{
+					Type: "text",
+					Text: mediaMsg.GetText(),
+				}
+				if keepCacheControl {
+					message.CacheControl = mediaMsg.CacheControl
+				}
+				mediaMessages = append(mediaMessages, message)
+			case "image":
+				if mediaMessage := claudeImageToMediaContent(mediaMsg); mediaMessage != nil {
+					mediaMessages = append(mediaMessages, *mediaMessage)
+				}
+			case "document":
+				mediaMessages = append(mediaMessages, claudeDocumentToMediaContent(mediaMsg)...)
+			case "tool_use":
+				toolCall := dto.ToolCallRequest{
+					ID:   mediaMsg.Id,
+					Type: "function",
+					Function: dto.FunctionRequest{
+						Name:      mediaMsg.Name,
+						Arguments: toJSONString(mediaMsg.Input),
+					},
+				}
+				toolCalls = append(toolCalls, toolCall)
+			case "tool_result":
+				// tool 消息必须紧跟在包含 tool_calls 的 assistant 消息之后，因此先于本条消息的其余内容添加
+				oaiToolMessage := dto.Message{
+					Role:       "tool",
+					ToolCallId: mediaMsg.ToolUseId,
+				}
+				if mediaMsg.IsStringContent() {
+					oaiToolMessage.SetStringContent(mediaMsg.GetStringContent())
+				} else {
+					// tool 消息只支持文本，结果中的图片放到随后的 user 消息中
+					var texts []string
+					for _, resultContent := range mediaMsg.ParseMediaContent() {
+						switch resultContent.Type {
+						case "text":
+							texts = append(texts, resultContent.GetText())
+						case "image":
+							if mediaMessage := claudeImageToMediaContent(resultContent); mediaMessage != nil {
+								mediaMessages = append(mediaMessages, *mediaMessage)
+							}
+						}
 					}
-					openAIMessages = append(openAIMessages, oaiToolMessage)
+					oaiToolMessage.SetStringContent(strings.Join(texts, "\n"))
 				}
+				if mediaMsg.IsError != nil && *mediaMsg.IsError {
+					oaiToolMessage.SetStringContent("Error: " + oaiToolMessage.StringContent())
+				}
+				openAIMessages = append(openAIMessages, oaiToolMessage)
+			case "server_tool_use", "web_search_tool_result":
+				// 服务端网页搜索的调用和结果以文本形式保留在上下文中
+				text, err := claudeServerToolToText(mediaMsg)
+				if err != nil {
+					return nil, err
+				}
+				mediaMessages = append(mediaMessages, dto.MediaContent{
+					Type: "text",
+					Text: text,
+				})
+			case "thinking", "redacted_thinking":
+				// 思考内容无法回传给 OpenAI 格式的上游，直接丢弃
 			}
+		}
 
-			if len(toolCalls) > 0 {
-				openAIMessage.SetToolCalls(toolCalls)
-			}
-
-			if len(mediaMessages) > 0 && len(toolCalls) == 0 {
-				openAIMessage.SetMediaContent(mediaMessages)
-			}
+		if len(toolCalls) > 0 {
+			openAIMessage.SetToolCalls(toolCalls)
+		}
+		if len(mediaMessages) == 1 && mediaMessages[0].Type == "text" && mediaMessages[0].CacheControl == nil {
+			// 单个文本块使用字符串内容，兼容不支持数组内容的上游
+			openAIMessage.SetStringContent(mediaMessages[0].Text)
+		} else if len(mediaMessages) > 0 {
+			openAIMessage.SetMediaContent(mediaMessages)
 		}
 		if len(openAIMessage.ParseContent()) > 0 || len(openAIMessage.ToolCalls) > 0 {
 			openAIMessages = append(openAIMessages, openAIMessage)
@@ -188,6 +244,160 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 	return &openAIRequest, nil
 }
 
+// claudeServerToolToText 把服务端网页搜索的调用和结果转换为文本。
+// 其他服务端工具（如代码执行）的记录无法在 OpenAI 格式的上游还原，返回错误而不是静默丢弃
+func claudeServerToolToText(mediaMsg dto.ClaudeMediaMessage) (string, error) {
+	if mediaMsg.Type == "server_tool_use" {
+		if mediaMsg.Name != "web_search" {
+			return "", fmt.Errorf("server tool %s is not supported by OpenAI compatible upstream", mediaMsg.Name)
+		}
+		input, _ := mediaMsg.Input.(map[string]any)
+		return fmt.Sprintf("Web search: %s", common.Interface2String(input["query"])), nil
+	}
+	if searchError, err := common.Any2Type[dto.ClaudeWebSearchResult](mediaMsg.Content); err == nil && searchError.Type == "web_search_tool_result_error" {
+		return fmt.Sprintf("Web search error: %s", searchError.ErrorCode), nil
+	}
+	results, err := common.Any2Type[[]dto.ClaudeWebSearchResult](mediaMsg.Content)
+	if err != nil {
+		return "", fmt.Errorf("invalid web_search_tool_result content: %w", err)
+	}
+	if len(results) == 0 {
+		return "Web search returned no results", nil
+	}
+	lines := make([]string, 0, len(results)+1)
+	lines = append(lines, "Web search results:")
+	for _, result := range results {
+		line := fmt.Sprintf("- %s (%s)", result.Title, result.Url)
+		if result.PageAge != "" {
+			line += " " + result.PageAge
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func claudeWebSearchToolToOpenAI(tool dto.ClaudeWebSearchTool) *dto.WebSearchOptions {
+	options := &dto.WebSearchOptions{}
+	if tool.UserLocation != nil {
+		userLocation, err := common.Marshal(map[string]any{
+			"type": "approximate",
+			"approximate": map[string]any{
+				"city":     tool.UserLocation.City,
+				"region":   tool.UserLocation.Region,
+				"country":  tool.UserLocation.Country,
+				"timezone": tool.UserLocation.Timezone,
+			},
+		})
+		if err == nil {
+			options.UserLocation = userLocation
+		}
+	}
+	return options
+}
+
+func claudeToolChoiceToOpenAI(toolChoice any, openAIRequest *dto.GeneralOpenAIRequest) {
+	if toolChoice == nil {
+		return
+	}
+	claudeToolChoice, err := common.Any2Type[dto.ClaudeToolChoice](toolChoice)
+	if err != nil {
+		return
+	}
+	switch claudeToolChoice.Type {
+	case "auto":
+		openAIRequest.ToolChoice = "auto"
+	case "any":
+		openAIRequest.ToolChoice = "required"
+	case "none":
+		openAIRequest.ToolChoice = "none"
+	case "tool":
+		openAIRequest.ToolChoice = map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": claudeToolChoice.Name,
+			},
+		}
+	}
+	if claudeToolChoice.DisableParallelToolUse {
+		openAIRequest.ParallelTooCalls = common.GetPointer(false)
+	}
+}
+
+func claudeImageToMediaContent(mediaMsg dto.ClaudeMediaMessage) *dto.MediaContent {
+	if mediaMsg.Source == nil {
+		return nil
+	}
+	var url string
+	switch mediaMsg.Source.Type {
+	case "url":
+		url = mediaMsg.Source.Url
+	case "base64":
+		url = fmt.Sprintf("data:%s;base64,%s", mediaMsg.Source.MediaType, common.Interface2String(mediaMsg.Source.Data))
+	default:
+		return nil
+	}
+	return &dto.MediaContent{
+		Type:     dto.ContentTypeImageURL,
+		ImageUrl: &dto.MessageImageUrl{Url: url},
+	}
+}
+
+func claudeDocumentToMediaContent(mediaMsg dto.ClaudeMediaMessage) []dto.MediaContent {
+	if mediaMsg.Source == nil {
+		return nil
+	}
+	var prefix string
+	if mediaMsg.Title != "" {
+		prefix += mediaMsg.Title + "\n"
+	}
+	if mediaMsg.Context != "" {
+		prefix += mediaMsg.Context + "\n"
+	}
+	switch mediaMsg.Source.Type {
+	case "base64":
+		fileName := mediaMsg.Title
+		if fileName == "" {
+			fileName = "document.pdf"
+		}
+		return []dto.MediaContent{{
+			Type: dto.ContentTypeFile,
+			File: &dto.MessageFile{
+				FileName: fileName,
+				FileData: fmt.Sprintf("data:%s;base64,%s", mediaMsg.Source.MediaType, common.Interface2String(mediaMsg.Source.Data)),
+			},
+		}}
+	case "text":
+		return []dto.MediaContent{{
+			Type: dto.ContentTypeText,
+			Text: prefix + common.Interface2String(mediaMsg.Source.Data),
+		}}
+	case "content":
+		blocks, _ := common.Any2Type[[]dto.ClaudeMediaMessage](mediaMsg.Source.Content)
+		mediaContents := make([]dto.MediaContent, 0, len(blocks))
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: block.GetText(),
+				})
+			case "image":
+				if mediaContent := claudeImageToMediaContent(block); mediaContent != nil {
+					mediaContents = append(mediaContents, *mediaContent)
+				}
+			}
+		}
+		return mediaContents
+	case "url":
+		// OpenAI 格式不支持通过 URL 引用文档，以文本形式提供给模型
+		return []dto.MediaContent{{
+			Type: dto.ContentTypeText,
+			Text: prefix + "Document: " + mediaMsg.Source.Url,
+		}}
+	}
+	return nil
+}
+
 func OpenAIErrorToClaudeError(openAIError *dto.OpenAIErrorWithStatusCode) *dto.ClaudeErrorWithStatusCode {
 	claudeError := dto.ClaudeError{
 		Type:    "new_api_error",
@@ -217,9 +427,43 @@ func generateStopBlock(index int) *dto.ClaudeResponse {
 	}
 }
 
+// stopClaudeContentBlock 结束当前打开的内容块
+func stopClaudeContentBlock(convertInfo *relaycommon.ClaudeConvertInfo) []*dto.ClaudeResponse {
+	if convertInfo.LastMessagesType == relaycommon.LastMessageTypeNone {
+		return nil
+	}
+	resp := generateStopBlock(convertInfo.Index)
+	convertInfo.Index++
+	convertInfo.LastMessagesType = relaycommon.LastMessageTypeNone
+	return []*dto.ClaudeResponse{resp}
+}
+
+// startClaudeContentBlock 结束当前内容块并开始新的内容块，Claude 的内容块必须依次输出，不能交错
+func startClaudeContentBlock(convertInfo *relaycommon.ClaudeConvertInfo, messageType string, block *dto.ClaudeMediaMessage) []*dto.ClaudeResponse {
+	claudeResponses := stopClaudeContentBlock(convertInfo)
+	resp := &dto.ClaudeResponse{
+		Type:         "content_block_start",
+		ContentBlock: block,
+	}
+	resp.SetIndex(convertInfo.Index)
+	convertInfo.LastMessagesType = messageType
+	return append(claudeResponses, resp)
+}
+
+func generateBlockDelta(convertInfo *relaycommon.ClaudeConvertInfo, delta *dto.ClaudeMediaMessage) *dto.ClaudeResponse {
+	resp := &dto.ClaudeResponse{
+		Type:  "content_block_delta",
+		Delta: delta,
+	}
+	resp.SetIndex(convertInfo.Index)
+	return resp
+}
+
 func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []*dto.ClaudeResponse {
 	var claudeResponses []*dto.ClaudeResponse
-	if info.SendResponseCount == 1 {
+	convertInfo := info.ClaudeConvertInfo
+	if !convertInfo.MessageStarted {
+		convertInfo.MessageStarted = true
 		msg := &dto.ClaudeMediaMessage{
 			Id:    openAIResponse.Id,
 			Model: openAIResponse.Model,
@@ -235,151 +479,125 @@ func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamRespon
 			Type:    "message_start",
 			Message: msg,
 		})
-		claudeResponses = append(claudeResponses)
-		//claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-		//	Type: "ping",
-		//})
-		if openAIResponse.IsToolCall() {
-			resp := &dto.ClaudeResponse{
-				Type: "content_block_start",
-				ContentBlock: &dto.ClaudeMediaMessage{
-					Id:   openAIResponse.GetFirstToolCall().ID,
-					Type: "tool_use",
-					Name: openAIResponse.GetFirstToolCall().Function.Name,
-				},
-			}
-			resp.SetIndex(0)
-			claudeResponses = append(claudeResponses, resp)
-		} else {
-			//resp := &dto.ClaudeResponse{
-			//	Type: "content_block_start",
-			//	ContentBlock: &dto.ClaudeMediaMessage{
-			//		Type: "text",
-			//		Text: common.GetPointer[string](""),
-			//	},
-			//}
-			//resp.SetIndex(0)
-			//claudeResponses = append(claudeResponses, resp)
-		}
-		return claudeResponses
-	}
-
-	if len(openAIResponse.Choices) == 0 {
-		// no choices
-		// TODO: handle this case
-		return claudeResponses
-	} else {
+	}
+
+	if len(openAIResponse.Choices) > 0 {
+		// Claude 只有一个回复，n > 1 时只取第一个
 		chosenChoice := openAIResponse.Choices[0]
-		if chosenChoice.FinishReason != nil && *chosenChoice.FinishReason != "" {
-			// should be done
-			info.FinishReason = *chosenChoice.FinishReason
-			return claudeResponses
-		}
-		if info.Done {
-			claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-			oaiUsage := info.ClaudeConvertInfo.Usage
-			if oaiUsage != nil {
-				claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-					Type: "message_delta",
-					Usage: &dto.ClaudeUsage{
-						InputTokens:              oaiUsage.PromptTokens,
-						OutputTokens:             oaiUsage.CompletionTokens,
-						CacheCreationInputTokens: oaiUsage.PromptTokensDetails.CachedCreationTokens,
-						CacheReadInputTokens:     oaiUsage.PromptTokensDetails.CachedTokens,
-					},
-					Delta: &dto.ClaudeMediaMessage{
-						StopReason: common.GetPointer[string](stopReasonOpenAI2Claude(info.FinishReason)),
-					},
-				})
+		delta := chosenChoice.Delta
+
+		if reasoning := delta.GetReasoningContent(); reasoning != "" {
+			if convertInfo.LastMessagesType != relaycommon.LastMessageTypeThinking {
+				claudeResponses = append(claudeResponses, startClaudeContentBlock(convertInfo, relaycommon.LastMessageTypeThinking, &dto.ClaudeMediaMessage{
+					Type:     "thinking",
+					Thinking: common.GetPointer[string](""),
+				})...)
 			}
-			claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-				Type: "message_stop",
-			})
-		} else {
-			var claudeResponse dto.ClaudeResponse
-			var isEmpty bool
-			claudeResponse.Type = "content_block_delta"
-			if len(chosenChoice.Delta.ToolCalls) > 0 {
-				if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeTools {
-					claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-					info.ClaudeConvertInfo.Index++
-					claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-						Index: &info.ClaudeConvertInfo.Index,
-						Type:  "content_block_start",
-						ContentBlock: &dto.ClaudeMediaMessage{
-							Id:    openAIResponse.GetFirstToolCall().ID,
-							Type:  "tool_use",
-							Name:  openAIResponse.GetFirstToolCall().Function.Name,
-							Input: map[string]interface{}{},
-						},
-					})
+			claudeResponses = append(claudeResponses, generateBlockDelta(convertInfo, &dto.ClaudeMediaMessage{
+				Type:     "thinking_delta",
+				Thinking: common.GetPointer[string](reasoning),
+			}))
+		}
+
+		if textContent := delta.GetContentString(); textContent != "" {
+			if convertInfo.LastMessagesType != relaycommon.LastMessageTypeText {
+				claudeResponses = append(claudeResponses, startClaudeContentBlock(convertInfo, relaycommon.LastMessageTypeText, &dto.ClaudeMediaMessage{
+					Type: "text",
+					Text: common.GetPointer[string](""),
+				})...)
+			}
+			convertInfo.OutputText.WriteString(textContent)
+			claudeResponses = append(claudeResponses, generateBlockDelta(convertInfo, &dto.ClaudeMediaMessage{
+				Type: "text_delta",
+				Text: common.GetPointer[string](textContent),
+			}))
+		}
+
+		if len(delta.Annotations) > 0 {
+			outputText := []rune(convertInfo.OutputText.String())
+			for _, annotation := range delta.Annotations {
+				citation := annotationToClaudeCitation(annotation, outputText)
+				if citation == nil {
+					continue
 				}
-				info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeTools
-				// tools delta
-				claudeResponse.Delta = &dto.ClaudeMediaMessage{
-					Type:        "input_json_delta",
-					PartialJson: &chosenChoice.Delta.ToolCalls[0].Function.Arguments,
+				// citations_delta 只能出现在 text 块中
+				if convertInfo.LastMessagesType != relaycommon.LastMessageTypeText {
+					claudeResponses = append(claudeResponses, startClaudeContentBlock(convertInfo, relaycommon.LastMessageTypeText, &dto.ClaudeMediaMessage{
+						Type: "text",
+						Text: common.GetPointer[string](""),
+					})...)
 				}
-			} else {
-				reasoning := chosenChoice.Delta.GetReasoningContent()
-				textContent := chosenChoice.Delta.GetContentString()
-				if reasoning != "" || textContent != "" {
-					if reasoning != "" {
-						if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeThinking {
-							//info.ClaudeConvertInfo.Index++
-							claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-								Index: &info.ClaudeConvertInfo.Index,
-								Type:  "content_block_start",
-								ContentBlock: &dto.ClaudeMediaMessage{
-									Type:     "thinking",
-									Thinking: "",
-								},
-							})
-						}
-						info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeThinking
-						// text delta
-						claudeResponse.Delta = &dto.ClaudeMediaMessage{
-							Type:     "thinking_delta",
-							Thinking: reasoning,
-						}
-					} else {
-						if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeText {
-							if info.LastMessagesType == relaycommon.LastMessageTypeThinking || info.LastMessagesType == relaycommon.LastMessageTypeTools {
-								claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-								info.ClaudeConvertInfo.Index++
-							}
-							claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-								Index: &info.ClaudeConvertInfo.Index,
-								Type:  "content_block_start",
-								ContentBlock: &dto.ClaudeMediaMessage{
-									Type: "text",
-									Text: common.GetPointer[string](""),
-								},
-							})
-						}
-						info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeText
-						// text delta
-						claudeResponse.Delta = &dto.ClaudeMediaMessage{
-							Type: "text_delta",
-							Text: common.GetPointer[string](textContent),
-						}
-					}
-				} else {
-					isEmpty = true
+				claudeResponses = append(claudeResponses, generateBlockDelta(convertInfo, &dto.ClaudeMediaMessage{
+					Type:     "citations_delta",
+					Citation: citation,
+				}))
+			}
+		}
+
+		for _, toolCall := range delta.ToolCalls {
+			toolCallIndex := 0
+			if toolCall.Index != nil {
+				toolCallIndex = *toolCall.Index
+			}
+			// 下标或 id 变化表示开始新的工具调用，部分上游会在每个分片中重复相同的 id
+			if convertInfo.LastMessagesType != relaycommon.LastMessageTypeTools ||
+				toolCallIndex != convertInfo.ToolCallIndex ||
+				(toolCall.ID != "" && toolCall.ID != convertInfo.ToolCallId) {
+				toolCallId := toolCall.ID
+				if toolCallId == "" {
+					toolCallId = "toolu_" + common.GetRandomString(24)
 				}
+				claudeResponses = append(claudeResponses, startClaudeContentBlock(convertInfo, relaycommon.LastMessageTypeTools, &dto.ClaudeMediaMessage{
+					Id:    toolCallId,
+					Type:  "tool_use",
+					Name:  toolCall.Function.Name,
+					Input: map[string]interface{}{},
+				})...)
+				convertInfo.ToolCallIndex = toolCallIndex
+				convertInfo.ToolCallId = toolCallId
+				convertInfo.HasToolUse = true
 			}
-			claudeResponse.Index = &info.ClaudeConvertInfo.Index
-			if !isEmpty {
-				claudeResponses = append(claudeResponses, &claudeResponse)
+			if toolCall.Function.Arguments != "" {
+				claudeResponses = append(claudeResponses, generateBlockDelta(convertInfo, &dto.ClaudeMediaMessage{
+					Type:        "input_json_delta",
+					PartialJson: common.GetPointer[string](toolCall.Function.Arguments),
+				}))
 			}
 		}
+
+		if chosenChoice.FinishReason != nil && *chosenChoice.FinishReason != "" {
+			convertInfo.FinishReason = *chosenChoice.FinishReason
+			convertInfo.StopSequence = matchedStopSequence(chosenChoice.StopReason, convertInfo.StopSequences)
+		}
+	}
+
+	if convertInfo.Done {
+		claudeResponses = append(claudeResponses, stopClaudeContentBlock(convertInfo)...)
+		usage := convertInfo.Usage
+		if usage == nil {
+			usage = &dto.Usage{PromptTokens: info.PromptTokens}
+		}
+		stopReason := stopReasonOpenAI2Claude(convertInfo.FinishReason, convertInfo.StopSequence, convertInfo.HasToolUse)
+		delta := &dto.ClaudeMediaMessage{
+			StopReason: common.GetPointer[string](stopReason),
+		}
+		if convertInfo.StopSequence != "" {
+			delta.StopSequence = common.GetPointer[string](convertInfo.StopSequence)
+		}
+		claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+			Type:  "message_delta",
+			Usage: openAIUsageToClaudeUsage(usage, convertInfo),
+			Delta: delta,
+		})
+		claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+			Type: "message_stop",
+		})
 	}
 
 	return claudeResponses
 }
 
 func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relaycommon.RelayInfo) *dto.ClaudeResponse {
-	var stopReason string
 	contents := make([]dto.ClaudeMediaMessage, 0)
 	claudeResponse := &dto.ClaudeResponse{
 		Id:    openAIResponse.Id,
@@ -387,45 +605,145 @@ func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relayco
 		Role:  "assistant",
 		Model: openAIResponse.Model,
 	}
-	for _, choice := range openAIResponse.Choices {
-		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
-		claudeContent := dto.ClaudeMediaMessage{}
-		if choice.FinishReason == "tool_calls" {
-			claudeContent.Type = "tool_use"
-			claudeContent.Id = choice.Message.ToolCallId
-			claudeContent.Name = choice.Message.ParseToolCalls()[0].Function.Name
-			var mapParams map[string]interface{}
-			if err := json.Unmarshal([]byte(choice.Message.ParseToolCalls()[0].Function.Arguments), &mapParams); err == nil {
-				claudeContent.Input = mapParams
-			} else {
-				claudeContent.Input = choice.Message.ParseToolCalls()[0].Function.Arguments
+	convertInfo := info.ClaudeConvertInfo
+	if convertInfo == nil {
+		convertInfo = &relaycommon.ClaudeConvertInfo{}
+	}
+	if len(openAIResponse.Choices) > 0 {
+		// Claude 只有一个回复，n > 1 时只取第一个
+		choice := openAIResponse.Choices[0]
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			contents = append(contents, dto.ClaudeMediaMessage{
+				Type:     "thinking",
+				Thinking: common.GetPointer[string](reasoning),
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			claudeContent := dto.ClaudeMediaMessage{Type: "text"}
+			claudeContent.SetText(text)
+			outputText := []rune(text)
+			citations := make([]*dto.ClaudeWebSearchCitation, 0, len(choice.Message.Annotations))
+			for _, annotation := range choice.Message.Annotations {
+				if citation := annotationToClaudeCitation(annotation, outputText); citation != nil {
+					citations = append(citations, citation)
+				}
 			}
-		} else {
-			claudeContent.Type = "text"
-			claudeContent.SetText(choice.Message.StringContent())
+			if len(citations) > 0 {
+				claudeContent.Citations = citations
+			}
+			contents = append(contents, claudeContent)
+		}
+		toolCalls := choice.Message.ParseToolCalls()
+		for _, toolCall := range toolCalls {
+			contents = append(contents, dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    toolCall.ID,
+				Name:  toolCall.Function.Name,
+				Input: parseToolArguments(toolCall.Function.Arguments),
+			})
+		}
+		stopSequence := matchedStopSequence(choice.StopReason, convertInfo.StopSequences)
+		claudeResponse.StopReason = stopReasonOpenAI2Claude(choice.FinishReason, stopSequence, len(toolCalls) > 0)
+		if stopSequence != "" {
+			claudeResponse.StopSequence = common.GetPointer[string](stopSequence)
 		}
-		contents = append(contents, claudeContent)
 	}
-	claudeResponse.Content = contents
-	claudeResponse.StopReason = stopReason
-	claudeResponse.Usage = &dto.ClaudeUsage{
-		InputTokens:  openAIResponse.PromptTokens,
-		OutputTokens: openAIResponse.CompletionTokens,
+	if len(contents) == 0 {
+		// content 为空时会被省略，补充一个空文本块
+		emptyContent := dto.ClaudeMediaMessage{Type: "text"}
+		emptyContent.SetText("")
+		contents = append(contents, emptyContent)
 	}
+	claudeResponse.Content = contents
+	claudeResponse.Usage = openAIUsageToClaudeUsage(&openAIResponse.Usage, convertInfo)
 
 	return claudeResponse
 }
 
-func stopReasonOpenAI2Claude(reason string) string {
+// openAIUsageToClaudeUsage Claude 的 input_tokens 不包含缓存命中的 token
+func openAIUsageToClaudeUsage(usage *dto.Usage, convertInfo *relaycommon.ClaudeConvertInfo) *dto.ClaudeUsage {
+	cachedTokens := usage.PromptTokensDetails.CachedTokens
+	inputTokens := usage.PromptTokens - cachedTokens
+	if inputTokens < 0 {
+		inputTokens = 0
+	}
+	claudeUsage := &dto.ClaudeUsage{
+		InputTokens:              inputTokens,
+		OutputTokens:             usage.CompletionTokens,
+		CacheCreationInputTokens: usage.PromptTokensDetails.CachedCreationTokens,
+		CacheReadInputTokens:     cachedTokens,
+	}
+	if convertInfo.WebSearch {
+		claudeUsage.ServerToolUse = &dto.ClaudeServerToolUse{WebSearchRequests: 1}
+	}
+	return claudeUsage
+}
+
+// annotationToClaudeCitation 将 url_citation 转换为 Claude 的网页搜索结果引用，被引用的文本按字符下标截取
+func annotationToClaudeCitation(annotation dto.Annotation, outputText []rune) *dto.ClaudeWebSearchCitation {
+	if annotation.Type != "url_citation" || annotation.UrlCitation == nil {
+		return nil
+	}
+	urlCitation := annotation.UrlCitation
+	citation := &dto.ClaudeWebSearchCitation{
+		Type:  "web_search_result_location",
+		Url:   urlCitation.Url,
+		Title: urlCitation.Title,
+	}
+	if urlCitation.StartIndex >= 0 && urlCitation.StartIndex < urlCitation.EndIndex && urlCitation.EndIndex <= len(outputText) {
+		citation.CitedText = string(outputText[urlCitation.StartIndex:urlCitation.EndIndex])
+	}
+	return citation
+}
+
+func parseToolArguments(arguments string) any {
+	if strings.TrimSpace(arguments) == "" {
+		return map[string]interface{}{}
+	}
+	var mapParams map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &mapParams); err == nil {
+		return mapParams
+	}
+	return arguments
+}
+
+// matchedStopSequence vLLM 等上游在 stop_reason 中返回命中的停止序列
+func matchedStopSequence(stopReason any, stopSequences []string) string {
+	matched, ok := stopReason.(string)
+	if !ok || matched == "" {
+		return ""
+	}
+	for _, stopSequence := range stopSequences {
+		if stopSequence == matched {
+			return matched
+		}
+	}
+	return ""
+}
+
+func stopReasonOpenAI2Claude(reason string, stopSequence string, hasToolUse bool) string {
 	switch reason {
-	case "stop":
+	case "stop", "":
+		if stopSequence != "" {
+			return "stop_sequence"
+		}
+		// 部分上游发起工具调用时 finish_reason 仍为 stop
+		if hasToolUse {
+			return "tool_use"
+		}
 		return "end_turn"
 	case "stop_sequence":
 		return "stop_sequence"
-	case "max_tokens":
+	case "max_tokens", "length":
 		return "max_tokens"
-	case "tool_calls":
+	case "tool_calls", "function_call":
 		return "tool_use"
+	case "content_filter":
+		return "refusal"
 	default:
 		return reason
 	}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"one-api/common/golden"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+	"testing"
+)
+
+// 使用 go test ./service -run Claude -update 重新生成 golden 文件
+const claudeConvertTestdata = golden.Dir("testdata/claude_convert")
+
+func newClaudeConvertInfo(channelType int, originModel string, upstreamModel string, promptTokens int) *relaycommon.RelayInfo {
+	info := &relaycommon.RelayInfo{
+		OriginModelName:   originModel,
+		UpstreamModelName: upstreamModel,
+		PromptTokens:      promptTokens,
+		RelayFormat:       relaycommon.RelayFormatClaude,
+		ClaudeConvertInfo: &relaycommon.ClaudeConvertInfo{
+			LastMessagesType: relaycommon.LastMessageTypeNone,
+		},
+	}
+	info.ChannelType = channelType
+	return info
+}
+
+func TestClaudeToOpenAIRequestGolden(t *testing.T) {
+	cases := []struct {
+		name          string
+		channelType   int
+		originModel   string
+		upstreamModel string
+	}{
+		// OpenRouter 上的 Claude 模型：思考转换为 reasoning，保留 cache_control，丢弃历史思考块
+		{"request_thinking_openrouter", constant.ChannelTypeOpenRouter, "claude-sonnet-4", "anthropic/claude-sonnet-4"},
+		// 其他上游：请求模型带 -thinking 后缀时追加到上游模型
+		{"request_thinking_suffix", constant.ChannelTypeOpenAI, "claude-3-7-sonnet-thinking", "claude-3-7-sonnet"},
+		// 工具、网页搜索、tool_choice、停止序列，以及包含图片的 tool_result
+		{"request_tools", constant.ChannelTypeOpenAI, "gpt-4o", "gpt-4o"},
+		// base64、纯文本、内容块和 URL 四种来源的 document 块
+		{"request_documents", constant.ChannelTypeOpenAI, "gpt-4o", "gpt-4o"},
+		// 服务端网页搜索的调用、结果和错误结果以文本保留在 assistant 消息中
+		{"request_server_tools", constant.ChannelTypeOpenAI, "gpt-4o", "gpt-4o"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var claudeRequest dto.ClaudeRequest
+			if err := json.Unmarshal(claudeConvertTestdata.Read(t, tc.name+".json"), &claudeRequest); err != nil {
+				t.Fatalf("unmarshal request: %v", err)
+			}
+			info := newClaudeConvertInfo(tc.channelType, tc.originModel, tc.upstreamModel, 0)
+			openAIRequest, err := ClaudeToOpenAIRequest(claudeRequest, info)
+			if err != nil {
+				t.Fatalf("convert: %v", err)
+			}
+			claudeConvertTestdata.Assert(t, tc.name+".golden.json", golden.MarshalJSON(t, map[string]any{
+				"request":        openAIRequest,
+				"stop_sequences": info.ClaudeConvertInfo.StopSequences,
+				"web_search":     info.ClaudeConvertInfo.WebSearch,
+			}))
+		})
+	}
+}
+
+func TestClaudeToOpenAIRequestRejectsUnsupportedServerTool(t *testing.T) {
+	var claudeRequest dto.ClaudeRequest
+	request := `{"model":"gpt-4o","messages":[{"role":"assistant","content":[{"type":"server_tool_use","id":"srvtoolu_01","name":"code_execution","input":{"code":"print(1)"}}]}]}`
+	if err := json.Unmarshal([]byte(request), &claudeRequest); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	info := newClaudeConvertInfo(constant.ChannelTypeOpenAI, "gpt-4o", "gpt-4o", 0)
+	if _, err := ClaudeToOpenAIRequest(claudeRequest, info); err == nil || !strings.Contains(err.Error(), "code_execution") {
+		t.Fatalf("expected unsupported server tool error, got %v", err)
+	}
+}
+
+// replayOpenAIStream 按 OaiStreamHandler 的方式回放 OpenAI 流：最后一个数据块在结束时与最终用量一起处理
+func replayOpenAIStream(t *testing.T, sse []byte, info *relaycommon.RelayInfo) []byte {
+	t.Helper()
+	var chunks []string
+	scanner := bufio.NewScanner(bytes.NewReader(sse))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		chunks = append(chunks, data)
+	}
+	if len(chunks) == 0 {
+		t.Fatal("no stream chunks in fixture")
+	}
+
+	var out bytes.Buffer
+	write := func(responses []*dto.ClaudeResponse) {
+		for _, resp := range responses {
+			data, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatalf("marshal claude response: %v", err)
+			}
+			fmt.Fprintf(&out, "event: %s\ndata: %s\n\n", resp.Type, data)
+		}
+	}
+	usage := &dto.Usage{PromptTokens: info.PromptTokens}
+	for i, data := range chunks {
+		var streamResponse dto.ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(data), &streamResponse); err != nil {
+			t.Fatalf("unmarshal chunk %d: %v", i, err)
+		}
+		if streamResponse.Usage != nil {
+			usage = streamResponse.Usage
+			info.ClaudeConvertInfo.Usage = streamResponse.Usage
+		}
+		if i == len(chunks)-1 {
+			info.ClaudeConvertInfo.Done = true
+			info.ClaudeConvertInfo.Usage = usage
+		}
+		write(StreamResponseOpenAI2Claude(&streamResponse, info))
+	}
+	return out.Bytes()
+}
+
+func TestStreamResponseOpenAI2ClaudeGolden(t *testing.T) {
+	cases := []struct {
+		name          string
+		stopSequences []string
+		webSearch     bool
+	}{
+		// 思考块在前，文本块在后；缓存命中的 token 从 input_tokens 中扣除
+		{name: "stream_thinking"},
+		// 下标或 id 变化时开始新的 tool_use 块，重复的 id 不会开始新块
+		{name: "stream_tool_calls"},
+		// url_citation 转换为 citations_delta，网页搜索请求计入 server_tool_use
+		{name: "stream_citations", webSearch: true},
+		// stop_reason 命中请求中的停止序列
+		{name: "stream_stop_sequence", stopSequences: []string{"END", "###"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := newClaudeConvertInfo(constant.ChannelTypeOpenAI, "claude", "gpt", 7)
+			info.ClaudeConvertInfo.StopSequences = tc.stopSequences
+			info.ClaudeConvertInfo.WebSearch = tc.webSearch
+			got := replayOpenAIStream(t, claudeConvertTestdata.Read(t, tc.name+".sse"), info)
+			claudeConvertTestdata.Assert(t, tc.name+".golden.sse", got)
+		})
+	}
+}
+
+func TestResponseOpenAI2ClaudeGolden(t *testing.T) {
+	cases := []struct {
+		name          string
+		stopSequences []string
+		webSearch     bool
+	}{
+		// 思考、带引用的文本、工具调用（包括空参数）以及缓存命中用量
+		{name: "response_full", webSearch: true},
+		{name: "response_stop_sequence", stopSequences: []string{"END", "###"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var openAIResponse dto.OpenAITextResponse
+			if err := json.Unmarshal(claudeConvertTestdata.Read(t, tc.name+".json"), &openAIResponse); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			info := newClaudeConvertInfo(constant.ChannelTypeOpenAI, "claude", "gpt", 0)
+			info.ClaudeConvertInfo.StopSequences = tc.stopSequences
+			info.ClaudeConvertInfo.WebSearch = tc.webSearch
+			claudeConvertTestdata.Assert(t, tc.name+".golden.json", golden.MarshalJSON(t, ResponseOpenAI2Claude(&openAIResponse, info)))
+		})
+	}
+}
+
+func TestOpenAIUsageToClaudeUsage(t *testing.T) {
+	cases := []struct {
+		name      string
+		usage     dto.Usage
+		webSearch bool
+		want      dto.ClaudeUsage
+	}{
+		{
+			name:  "no cache",
+			usage: dto.Usage{PromptTokens: 100, CompletionTokens: 20},
+			want:  dto.ClaudeUsage{InputTokens: 100, OutputTokens: 20},
+		},
+		{
+			name:  "cached tokens are subtracted",
+			usage: dto.Usage{PromptTokens: 100, CompletionTokens: 20, PromptTokensDetails: dto.InputTokenDetails{CachedTokens: 80, CachedCreationTokens: 10}},
+			want:  dto.ClaudeUsage{InputTokens: 20, OutputTokens: 20, CacheReadInputTokens: 80, CacheCreationInputTokens: 10},
+		},
+		{
+			name:  "cached tokens exceeding prompt tokens clamp to zero",
+			usage: dto.Usage{PromptTokens: 50, CompletionTokens: 1, PromptTokensDetails: dto.InputTokenDetails{CachedTokens: 64}},
+			want:  dto.ClaudeUsage{InputTokens: 0, OutputTokens: 1, CacheReadInputTokens: 64},
+		},
+		{
+			name:      "web search request",
+			usage:     dto.Usage{PromptTokens: 10, CompletionTokens: 5},
+			webSearch: true,
+			want:      dto.ClaudeUsage{InputTokens: 10, OutputTokens: 5, ServerToolUse: &dto.ClaudeServerToolUse{WebSearchRequests: 1}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := openAIUsageToClaudeUsage(&tc.usage, &relaycommon.ClaudeConvertInfo{WebSearch: tc.webSearch})
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tc.want)
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"one-api/dto"
 	relaycommon "one-api/relay/common"
 	"one-api/relay/helper"
+	"one-api/setting/ratio_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,6 +29,20 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if relayInfo.FallbackFromModel != "" {
+		other["fallback_from_model"] = relayInfo.FallbackFromModel
+	}
+	if relayInfo.ResponseCacheHit {
+		other["response_cache_hit"] = true
+		other["cache_hit_ratio"] = relayInfo.ResponseCacheHitRatio
+	}
+	if relayInfo.BatchId != "" {
+		other["batch_id"] = relayInfo.BatchId
+		other["batch_ratio"] = relayInfo.BatchRatio
+	}
+	if attempt := helper.GetHedgeAttempt(ctx); attempt != nil {
+		other["hedge"] = attempt.Race().LogInfo()
+	}
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	isMultiKey := common.GetContextKeyBool(ctx, constant.ContextKeyChannelIsMultiKey)
@@ -35,6 +50,9 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		adminInfo["is_multi_key"] = true
 		adminInfo["multi_key_index"] = common.GetContextKeyInt(ctx, constant.ContextKeyChannelMultiKeyIndex)
 	}
+	if common.GetContextKeyBool(ctx, constant.ContextKeyStickyRoutingHit) {
+		adminInfo["sticky_routing"] = true
+	}
 	other["admin_info"] = adminInfo
 	return other
 }
@@ -72,6 +90,15 @@ func GenerateClaudeOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	return info
 }
 
+// AppendTieredPriceInfo 记录命中的分档计费规则
+func AppendTieredPriceInfo(other map[string]interface{}, rule *ratio_setting.TieredPriceRule) {
+	if rule == nil {
+		return
+	}
+	other["tiered_price"] = true
+	other["tiered_price_rule"] = rule
+}
+
 func GenerateMjOtherInfo(priceData helper.PerCallPriceData) map[string]interface{} {
 	other := make(map[string]interface{})
 	other["model_price"] = priceData.ModelPrice
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"one-api/common"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const metricsNamespace = "new_api"
+
+var (
+	relayRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_requests_total",
+		Help:      "Number of relay attempts sent to upstream channels, by response status code.",
+	}, []string{"channel", "model", "group", "relay_mode", "status_code"})
+
+	relayErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_errors_total",
+		Help:      "Number of failed relay attempts, by response status code and error type.",
+	}, []string{"channel", "model", "group", "relay_mode", "status_code", "error_type"})
+
+	relayRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_retries_total",
+		Help:      "Number of relay retries on another channel.",
+	}, []string{"model", "group", "relay_mode"})
+
+	relayFirstResponseSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_first_response_seconds",
+		Help:      "Time from request start to the first response byte of successful relays.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 8, 13, 20, 30, 60},
+	}, []string{"channel", "model", "group", "relay_mode"})
+
+	relayDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_duration_seconds",
+		Help:      "Total duration of successful relays.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 600},
+	}, []string{"channel", "model", "group", "relay_mode"})
+
+	relayPromptTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_prompt_tokens_total",
+		Help:      "Number of billed prompt tokens.",
+	}, []string{"channel", "model", "group", "relay_mode"})
+
+	relayCompletionTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_completion_tokens_total",
+		Help:      "Number of billed completion tokens.",
+	}, []string{"channel", "model", "group", "relay_mode"})
+
+	relayQuotaTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "relay_quota_total",
+		Help:      "Quota consumed by relays.",
+	}, []string{"channel", "model", "group", "relay_mode"})
+
+	channelAutoDisabledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "channel_auto_disabled_total",
+		Help:      "Number of times a channel was automatically disabled.",
+	}, []string{"channel"})
+)
+
+// MetricsRegistry 独立的指标注册表，避免与第三方库的默认注册表冲突
+var MetricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	MetricsRegistry.MustRegister(
+		relayRequestsTotal,
+		relayErrorsTotal,
+		relayRetriesTotal,
+		relayFirstResponseSeconds,
+		relayDurationSeconds,
+		relayPromptTokensTotal,
+		relayCompletionTokensTotal,
+		relayQuotaTotal,
+		channelAutoDisabledTotal,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterGaugeFunc 注册一个在采集时计算的指标，例如活跃连接数
+func RegisterGaugeFunc(name, help string, function func() float64) {
+	MetricsRegistry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      name,
+		Help:      help,
+	}, function))
+}
+
+// RecordRelayAttemptMetrics 记录一次上游请求尝试，errorType 为空表示成功
+func RecordRelayAttemptMetrics(channelId int, modelName, group, relayMode string, statusCode int, errorType string) {
+	if !common.MetricsEnabled {
+		return
+	}
+	channel := strconv.Itoa(channelId)
+	code := strconv.Itoa(statusCode)
+	relayRequestsTotal.WithLabelValues(channel, modelName, group, relayMode, code).Inc()
+	if errorType != "" {
+		relayErrorsTotal.WithLabelValues(channel, modelName, group, relayMode, code, errorType).Inc()
+	}
+}
+
+func RecordRelayRetryMetrics(modelName, group, relayMode string) {
+	if !common.MetricsEnabled {
+		return
+	}
+	relayRetriesTotal.WithLabelValues(modelName, group, relayMode).Inc()
+}
+
+// RecordRelayUsageMetrics 在计费完成后记录延迟、token 用量和消耗的额度
+func RecordRelayUsageMetrics(relayInfo *relaycommon.RelayInfo, promptTokens, completionTokens, quota int) {
+	if !common.MetricsEnabled {
+		return
+	}
+	path, _, _ := strings.Cut(relayInfo.RequestURLPath, "?")
+	labels := []string{strconv.Itoa(relayInfo.ChannelId), relayInfo.OriginModelName, relayInfo.UsingGroup, relayconstant.RelayModeName(path)}
+	relayFirstResponseSeconds.WithLabelValues(labels...).Observe(float64(relayInfo.GetFirstResponseLatencyMs()) / 1000)
+	relayDurationSeconds.WithLabelValues(labels...).Observe(time.Since(relayInfo.StartTime).Seconds())
+	relayPromptTokensTotal.WithLabelValues(labels...).Add(float64(promptTokens))
+	relayCompletionTokensTotal.WithLabelValues(labels...).Add(float64(completionTokens))
+	relayQuotaTotal.WithLabelValues(labels...).Add(float64(quota))
+}
+
+func RecordChannelAutoDisabledMetrics(channelId int) {
+	if !common.MetricsEnabled {
+		return
+	}
+	channelAutoDisabledTotal.WithLabelValues(strconv.Itoa(channelId)).Inc()
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"one-api/common"
+	"one-api/constant"
+	relayconstant "one-api/relay/constant"
+	"one-api/setting/operation_setting"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IsModelFallbackPath 只有对话、补全和 Claude 消息请求可以降级到其他模型，请求格式由各渠道适配器转换
+func IsModelFallbackPath(path string) bool {
+	if strings.HasPrefix(path, "/v1/messages") {
+		return true
+	}
+	relayMode := relayconstant.Path2RelayMode(path)
+	return relayMode == relayconstant.RelayModeChatCompletions || relayMode == relayconstant.RelayModeCompletions
+}
+
+// GetModelFallbackChain 返回请求模型及其备选模型，令牌的降级链优先于分组设置，令牌无权访问的备选模型被跳过
+func GetModelFallbackChain(c *gin.Context, group string, modelName string) []string {
+	chain := []string{modelName}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return chain
+	}
+	var fallbacks []string
+	tokenChains, _ := common.GetContextKeyType[map[string][]string](c, constant.ContextKeyTokenModelFallback)
+	if tokenChain, ok := tokenChains[modelName]; ok {
+		fallbacks = tokenChain
+	} else {
+		fallbacks = operation_setting.GetModelFallbackSetting().GetChain(group, modelName)
+	}
+	modelLimitEnabled := common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled)
+	modelLimit, _ := common.GetContextKeyType[map[string]bool](c, constant.ContextKeyTokenModelLimit)
+	visited := map[string]bool{modelName: true}
+	for _, fallback := range fallbacks {
+		if fallback == "" || visited[fallback] {
+			continue
+		}
+		if modelLimitEnabled && !modelLimit[fallback] {
+			continue
+		}
+		visited[fallback] = true
+		chain = append(chain, fallback)
+	}
+	return chain
+}
+
+// SetModelFallback 记录降级前客户端请求的模型，并在响应头中返回实际使用的模型
+func SetModelFallback(c *gin.Context, requestModel string, modelName string) {
+	common.SetContextKey(c, constant.ContextKeyModelFallbackFrom, requestModel)
+	c.Writer.Header().Set("X-Fallback-Model", modelName)
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+)
+
+// GetPayerQuota 获取本次请求付费方的剩余额度：组织令牌为成员可用的组织钱包额度，否则为用户额度
+func GetPayerQuota(relayInfo *relaycommon.RelayInfo) (int, error) {
+	if relayInfo.OrganizationId != 0 {
+		return model.GetOrganizationAvailableQuota(relayInfo.OrganizationId, relayInfo.UserId)
+	}
+	return model.GetUserQuota(relayInfo.UserId, false)
+}
+
+// DecreasePayerQuota 从付费方扣除额度
+func DecreasePayerQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.OrganizationId != 0 {
+		return model.DecreaseOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, quota, consumeLedgerReason(relayInfo))
+	}
+	return model.DecreaseUserQuota(relayInfo.UserId, quota, consumeLedgerReason(relayInfo))
+}
+
+// ReservePayerQuota 预扣付费方额度，组织令牌在扣费的同一更新中校验成员消费上限和组织余额
+func ReservePayerQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.OrganizationId != 0 {
+		return model.ReserveOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, quota, consumeLedgerReason(relayInfo))
+	}
+	return model.DecreaseUserQuota(relayInfo.UserId, quota, consumeLedgerReason(relayInfo))
+}
+
+// IsPayerQuotaExceeded 判断预扣失败是否因为组织余额不足或超出成员消费上限
+func IsPayerQuotaExceeded(err error) bool {
+	return errors.Is(err, model.ErrOrganizationSpendCapExceeded) || errors.Is(err, model.ErrOrganizationQuotaNotEnough)
+}
+
+// IncreasePayerQuota 退还额度给付费方
+func IncreasePayerQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.OrganizationId != 0 {
+		return model.DecreaseOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, -quota, refundLedgerReason(relayInfo))
+	}
+	return model.IncreaseUserQuota(relayInfo.UserId, quota, false, refundLedgerReason(relayInfo))
+}
@@ -28,13 +28,14 @@ type TokenDetails struct {
 }
 
 type QuotaInfo struct {
-	InputDetails  TokenDetails
-	OutputDetails TokenDetails
-	ModelName     string
-	UsePrice      bool
-	ModelPrice    float64
-	ModelRatio    float64
-	GroupRatio    float64
+	InputDetails    TokenDetails
+	OutputDetails   TokenDetails
+	ModelName       string
+	UsePrice        bool
+	ModelPrice      float64
+	ModelRatio      float64
+	CompletionRatio float64
+	GroupRatio      float64
 }
 
 func calculateAudioQuota(info QuotaInfo) int {
@@ -47,7 +48,7 @@ func calculateAudioQuota(info QuotaInfo) int {
 		return int(quota.IntPart())
 	}
 
-	completionRatio := decimal.NewFromFloat(ratio_setting.GetCompletionRatio(info.ModelName))
+	completionRatio := decimal.NewFromFloat(info.CompletionRatio)
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(info.ModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(info.ModelName))
 
@@ -80,7 +81,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := GetPayerQuota(relayInfo)
 	if err != nil {
 		return err
 	}
@@ -97,6 +98,11 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	audioOutTokens := usage.OutputTokenDetails.AudioTokens
 	groupRatio := ratio_setting.GetGroupRatio(relayInfo.UsingGroup)
 	modelRatio, _, _ := ratio_setting.GetModelRatio(modelName)
+	priceData := helper.PriceData{
+		ModelRatio:      modelRatio,
+		CompletionRatio: ratio_setting.GetCompletionRatio(modelName),
+	}
+	applyRealtimeTieredPrice(&priceData, relayInfo, usage)
 
 	autoGroup, exists := ctx.Get("auto_group")
 	if exists {
@@ -120,10 +126,11 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   relayInfo.UsePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: actualGroupRatio,
+		ModelName:       modelName,
+		UsePrice:        relayInfo.UsePrice,
+		ModelRatio:      priceData.ModelRatio,
+		CompletionRatio: priceData.CompletionRatio,
+		GroupRatio:      actualGroupRatio,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -136,17 +143,41 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", common.FormatQuota(token.RemainQuota), common.FormatQuota(quota))
 	}
 
+	if newAPIError := ReserveTokenBudget(relayInfo, quota); newAPIError != nil {
+		return newAPIError
+	}
 	err = PostConsumeQuota(relayInfo, quota, 0, false)
 	if err != nil {
+		SettleTokenBudget(relayInfo, 0)
 		return err
 	}
+	SettleTokenBudget(relayInfo, quota)
 	common.LogInfo(ctx, "realtime streaming consume quota success, quota: "+fmt.Sprintf("%d", quota))
 	return nil
 }
 
+// ApplyQuotaDiscount 按响应缓存命中倍率和批处理倍率折算向用户收取的额度，返回折算后的额度和日志说明。
+// 上游成本应按折扣前的额度计算
+func ApplyQuotaDiscount(relayInfo *relaycommon.RelayInfo, quota decimal.Decimal) (decimal.Decimal, string) {
+	var contents []string
+	// 命中响应缓存时按缓存命中倍率计费
+	if relayInfo.ResponseCacheHit {
+		quota = quota.Mul(decimal.NewFromFloat(relayInfo.ResponseCacheHitRatio))
+		contents = append(contents, fmt.Sprintf("命中响应缓存，缓存命中倍率 %.2f", relayInfo.ResponseCacheHitRatio))
+	}
+	// 批处理请求按批处理倍率计费
+	if relayInfo.BatchId != "" {
+		quota = quota.Mul(decimal.NewFromFloat(relayInfo.BatchRatio))
+		contents = append(contents, fmt.Sprintf("批处理请求，批处理倍率 %.2f", relayInfo.BatchRatio))
+	}
+	return quota, strings.Join(contents, "，")
+}
+
 func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelName string,
 	usage *dto.RealtimeUsage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 
+	SettleTPM(ctx, usage.OutputTokens)
+
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.InputTokenDetails.TextTokens
 	textOutTokens := usage.OutputTokenDetails.TextTokens
@@ -158,6 +189,10 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	completionRatio := decimal.NewFromFloat(ratio_setting.GetCompletionRatio(modelName))
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(relayInfo.OriginModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(modelName))
+	tieredPriceRule := applyRealtimeTieredPrice(&priceData, relayInfo, usage)
+	if tieredPriceRule != nil && tieredPriceRule.CompletionRatio != nil {
+		completionRatio = decimal.NewFromFloat(*tieredPriceRule.CompletionRatio)
+	}
 
 	modelRatio := priceData.ModelRatio
 	groupRatio := priceData.GroupRatioInfo.GroupRatio
@@ -173,10 +208,11 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:       modelName,
+		UsePrice:        usePrice,
+		ModelRatio:      modelRatio,
+		CompletionRatio: completionRatio.InexactFloat64(),
+		GroupRatio:      groupRatio,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -209,6 +245,8 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	}
 	other := GenerateWssOtherInfo(ctx, relayInfo, usage, modelRatio, groupRatio,
 		completionRatio.InexactFloat64(), audioRatio.InexactFloat64(), audioCompletionRatio.InexactFloat64(), modelPrice, priceData.GroupRatioInfo.GroupSpecialRatio)
+	AppendTieredPriceInfo(other, tieredPriceRule)
+	upstreamCost, hasUpstreamCost := GetUpstreamCost(relayInfo, quota, groupRatio)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     usage.InputTokens,
@@ -223,11 +261,65 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 		IsStream:         relayInfo.IsStream,
 		Group:            relayInfo.UsingGroup,
 		Other:            other,
+		UpstreamCost:     upstreamCost,
+		HasUpstreamCost:  hasUpstreamCost,
 	})
+	RecordRelayUsageMetrics(relayInfo, usage.InputTokens, usage.OutputTokens, quota)
+	RecordChannelRelaySuccess(relayInfo, usage.InputTokens+usage.OutputTokens, quota)
+}
+
+// applyRealtimeTieredPrice 按实时请求的用量匹配分档计费规则
+func applyRealtimeTieredPrice(priceData *helper.PriceData, relayInfo *relaycommon.RelayInfo, usage *dto.RealtimeUsage) *ratio_setting.TieredPriceRule {
+	return priceData.ApplyTieredPrice(relayInfo, usage.InputTokens, &dto.Usage{
+		PromptTokens:        usage.InputTokens,
+		PromptTokensDetails: usage.InputTokenDetails,
+	})
+}
+
+// RecordChannelRelaySuccess 请求成功结算后更新渠道健康统计，所有结算路径共用，
+// 保证半开的熔断器在音频和实时请求成功时同样能够关闭，自适应选择的统计不会只记录失败，
+// 多 Key 渠道的单 Key TPM 统计不会漏记
+func RecordChannelRelaySuccess(relayInfo *relaycommon.RelayInfo, tokens int, quota int) {
+	model.RecordChannelSelectSuccess(relayInfo.ChannelId, relayInfo.OriginModelName, relayInfo.GetFirstResponseLatencyMs())
+	if relayInfo.ChannelIsMultiKey {
+		model.RecordChannelKeyUsage(relayInfo.ChannelId, relayInfo.ApiKey, tokens, quota)
+	}
+	model.RecordCircuitBreakerSuccess(relayInfo.ChannelId, relayInfo.OriginModelName)
+}
+
+// SkipHedgeLoserQuota 对冲请求中竞速失败的尝试不计费，退还预扣费额度
+func SkipHedgeLoserQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, preConsumedQuota int) bool {
+	if !helper.IsHedgeLoser(ctx) {
+		return false
+	}
+	if preConsumedQuota != 0 {
+		err := PostConsumeQuota(relayInfo, -preConsumedQuota, 0, false)
+		if err != nil {
+			common.SysError("error return pre-consumed quota: " + err.Error())
+		}
+	}
+	SettleTokenBudget(relayInfo, 0)
+	return true
 }
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
+	span := common.StartSpan(ctx, "relay.PostConsumeQuota")
+	defer span.End()
+	// 流式过程中追加扣除的额度与预扣费额度一并结算
+	preConsumedQuota += relayInfo.GetStreamSettledQuota()
+	if SkipHedgeLoserQuota(ctx, relayInfo, preConsumedQuota) {
+		return
+	}
+
+	SettleTPM(ctx, usage.CompletionTokens)
+
+	// 按实际用量匹配分档计费规则，输入 token 数包含缓存读写部分
+	tieredPromptTokens := usage.PromptTokens
+	if relayInfo.ChannelType != constant.ChannelTypeOpenRouter {
+		tieredPromptTokens += usage.PromptTokensDetails.CachedTokens + usage.PromptTokensDetails.CachedCreationTokens
+	}
+	tieredPriceRule := priceData.ApplyTieredPrice(relayInfo, tieredPromptTokens, usage)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
@@ -271,16 +363,29 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		calculateQuota = 1
 	}
 
-	quota := int(calculateQuota)
+	// 上游成本按折扣前的额度计算
+	listQuota := int(calculateQuota)
+	discountQuota, discountContent := ApplyQuotaDiscount(relayInfo, decimal.NewFromInt(int64(listQuota)))
+	quota := int(discountQuota.Round(0).IntPart())
 
 	totalTokens := promptTokens + completionTokens
 
 	var logContent string
+	if tieredPriceRule != nil {
+		logContent = "分档计费 " + tieredPriceRule.Describe()
+	}
+	if discountContent != "" {
+		if logContent != "" {
+			logContent += "，"
+		}
+		logContent += discountContent
+	}
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
 		// in this case, must be some error happened
 		// we cannot just return, because we may have to return the pre-consumed quota
 		quota = 0
+		listQuota = 0
 		logContent += fmt.Sprintf("（可能是上游出错）")
 		common.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, preConsumedQuota))
@@ -296,9 +401,12 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			common.LogError(ctx, "error consuming token remain quota: "+err.Error())
 		}
 	}
+	SettleTokenBudget(relayInfo, quota)
 
 	other := GenerateClaudeOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio,
 		cacheTokens, cacheRatio, cacheCreationTokens, cacheCreationRatio, modelPrice, priceData.GroupRatioInfo.GroupSpecialRatio)
+	AppendTieredPriceInfo(other, tieredPriceRule)
+	upstreamCost, hasUpstreamCost := GetUpstreamCost(relayInfo, listQuota, groupRatio)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
@@ -313,8 +421,11 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		IsStream:         relayInfo.IsStream,
 		Group:            relayInfo.UsingGroup,
 		Other:            other,
+		UpstreamCost:     upstreamCost,
+		HasUpstreamCost:  hasUpstreamCost,
 	})
-
+	RecordRelayUsageMetrics(relayInfo, promptTokens, completionTokens, quota)
+	RecordChannelRelaySuccess(relayInfo, promptTokens+completionTokens, quota)
 }
 
 func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData helper.PriceData) int {
@@ -340,6 +451,15 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData helper.PriceData
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
+	span := common.StartSpan(ctx, "relay.PostConsumeQuota")
+	defer span.End()
+	// 流式过程中追加扣除的额度与预扣费额度一并结算
+	preConsumedQuota += relayInfo.GetStreamSettledQuota()
+	if SkipHedgeLoserQuota(ctx, relayInfo, preConsumedQuota) {
+		return
+	}
+
+	SettleTPM(ctx, usage.CompletionTokens)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
@@ -352,6 +472,10 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	completionRatio := decimal.NewFromFloat(ratio_setting.GetCompletionRatio(relayInfo.OriginModelName))
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(relayInfo.OriginModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(relayInfo.OriginModelName))
+	tieredPriceRule := priceData.ApplyTieredPrice(relayInfo, usage.PromptTokens, usage)
+	if tieredPriceRule != nil && tieredPriceRule.CompletionRatio != nil {
+		completionRatio = decimal.NewFromFloat(*tieredPriceRule.CompletionRatio)
+	}
 
 	modelRatio := priceData.ModelRatio
 	groupRatio := priceData.GroupRatioInfo.GroupRatio
@@ -367,13 +491,17 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  relayInfo.OriginModelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:       relayInfo.OriginModelName,
+		UsePrice:        usePrice,
+		ModelRatio:      modelRatio,
+		CompletionRatio: completionRatio.InexactFloat64(),
+		GroupRatio:      groupRatio,
 	}
 
-	quota := calculateAudioQuota(quotaInfo)
+	// 上游成本按折扣前的额度计算
+	listQuota := calculateAudioQuota(quotaInfo)
+	discountQuota, discountContent := ApplyQuotaDiscount(relayInfo, decimal.NewFromInt(int64(listQuota)))
+	quota := int(discountQuota.Round(0).IntPart())
 
 	totalTokens := usage.TotalTokens
 	var logContent string
@@ -389,6 +517,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		// in this case, must be some error happened
 		// we cannot just return, because we may have to return the pre-consumed quota
 		quota = 0
+		listQuota = 0
 		logContent += fmt.Sprintf("（可能是上游超时）")
 		common.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, relayInfo.OriginModelName, preConsumedQuota))
@@ -404,13 +533,19 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			common.LogError(ctx, "error consuming token remain quota: "+err.Error())
 		}
 	}
+	SettleTokenBudget(relayInfo, quota)
 
 	logModel := relayInfo.OriginModelName
 	if extraContent != "" {
 		logContent += ", " + extraContent
 	}
+	if discountContent != "" {
+		logContent += "，" + discountContent
+	}
 	other := GenerateAudioOtherInfo(ctx, relayInfo, usage, modelRatio, groupRatio,
 		completionRatio.InexactFloat64(), audioRatio.InexactFloat64(), audioCompletionRatio.InexactFloat64(), modelPrice, priceData.GroupRatioInfo.GroupSpecialRatio)
+	AppendTieredPriceInfo(other, tieredPriceRule)
+	upstreamCost, hasUpstreamCost := GetUpstreamCost(relayInfo, listQuota, groupRatio)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     usage.PromptTokens,
@@ -425,7 +560,11 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		IsStream:         relayInfo.IsStream,
 		Group:            relayInfo.UsingGroup,
 		Other:            other,
+		UpstreamCost:     upstreamCost,
+		HasUpstreamCost:  hasUpstreamCost,
 	})
+	RecordRelayUsageMetrics(relayInfo, usage.PromptTokens, usage.CompletionTokens, quota)
+	RecordChannelRelaySuccess(relayInfo, usage.PromptTokens+usage.CompletionTokens, quota)
 }
 
 func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
@@ -445,19 +584,29 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", common.FormatQuota(token.RemainQuota), common.FormatQuota(quota))
 	}
-	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, consumeLedgerReason(relayInfo))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// ReturnPreConsumedTokenQuota 付费方扣费失败时归还已预扣的令牌额度
+func ReturnPreConsumedTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) {
+	if relayInfo.IsPlayground || quota <= 0 {
+		return
+	}
+	if err := model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, refundLedgerReason(relayInfo)); err != nil {
+		common.SysError("failed to return token quota: " + err.Error())
+	}
+}
+
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
 	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
+		err = DecreasePayerQuota(relayInfo, quota)
 	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
+		err = IncreasePayerQuota(relayInfo, -quota)
 	}
 	if err != nil {
 		return err
@@ -465,9 +614,9 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 
 	if !relayInfo.IsPlayground {
 		if quota > 0 {
-			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota, consumeLedgerReason(relayInfo))
 		} else {
-			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota, refundLedgerReason(relayInfo))
 		}
 		if err != nil {
 			return err
@@ -508,3 +657,12 @@ func checkAndSendQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preCon
 		}
 	})
 }
+
+// consumeLedgerReason 请求扣费的账本记录，以请求 id 关联消费日志
+func consumeLedgerReason(relayInfo *relaycommon.RelayInfo) model.LedgerReason {
+	return model.LedgerReason{Type: model.LedgerTypeConsume, Reference: relayInfo.RequestId, Remark: relayInfo.OriginModelName}
+}
+
+func refundLedgerReason(relayInfo *relaycommon.RelayInfo) model.LedgerReason {
+	return model.LedgerReason{Type: model.LedgerTypeRefund, Reference: relayInfo.RequestId, Remark: relayInfo.OriginModelName}
+}
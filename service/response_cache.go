@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/setting/operation_setting"
+	"one-api/setting/ratio_setting"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseCacheEntry 缓存的上游响应及其用量，命中时按用量乘以缓存命中倍率计费
+type ResponseCacheEntry struct {
+	Body  []byte    `json:"body"`
+	Usage dto.Usage `json:"usage"`
+}
+
+var responseMemoryCache = common.NewTTLCache[ResponseCacheEntry]()
+
+// ShouldUseResponseCache 是否对当前请求启用响应缓存，令牌可以单独关闭
+func ShouldUseResponseCache(c *gin.Context) bool {
+	if !operation_setting.GetResponseCacheSetting().Enabled {
+		return false
+	}
+	return !common.GetContextKeyBool(c, constant.ContextKeyTokenResponseCacheDisabled)
+}
+
+// IsTemperatureCacheable 仅缓存 temperature 为 0 的请求（可关闭该限制）
+func IsTemperatureCacheable(temperature *float64) bool {
+	if !operation_setting.GetResponseCacheSetting().ZeroTemperatureOnly {
+		return true
+	}
+	return temperature != nil && *temperature == 0
+}
+
+// GenerateResponseCacheKey 对请求做规范化后计算哈希，缓存按用户隔离
+func GenerateResponseCacheKey(c *gin.Context, relayMode int, request any) (string, error) {
+	jsonData, err := common.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	// 反序列化后重新序列化，消除字段顺序和空白字符的差异
+	var normalized any
+	if err = common.Unmarshal(jsonData, &normalized); err != nil {
+		return "", err
+	}
+	jsonData, err = common.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(jsonData)
+	return fmt.Sprintf("response_cache:%d:%d:%s", c.GetInt("id"), relayMode, hex.EncodeToString(hash[:])), nil
+}
+
+func GetResponseCache(key string) (*ResponseCacheEntry, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return nil, false
+		}
+		var entry ResponseCacheEntry
+		if err = common.UnmarshalJsonStr(value, &entry); err != nil {
+			return nil, false
+		}
+		return &entry, true
+	}
+	entry, ok := responseMemoryCache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func SetResponseCache(key string, entry ResponseCacheEntry) {
+	setting := operation_setting.GetResponseCacheSetting()
+	ttl := time.Duration(setting.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	if common.RedisEnabled {
+		jsonData, err := common.Marshal(entry)
+		if err != nil {
+			return
+		}
+		if err = common.RedisSet(key, string(jsonData), ttl); err != nil {
+			common.SysError("failed to set response cache: " + err.Error())
+		}
+		return
+	}
+	responseMemoryCache.Set(key, entry, ttl, setting.MaxEntries)
+}
+
+// GetResponseCacheHitRatio 命中响应缓存时的计费倍率
+func GetResponseCacheHitRatio(modelName string) float64 {
+	if ratio, ok := ratio_setting.GetCacheHitRatio(modelName); ok {
+		return ratio
+	}
+	return operation_setting.GetResponseCacheSetting().DefaultHitRatio
+}
+
+// WriteResponseCache 将缓存的响应写回客户端
+func WriteResponseCache(c *gin.Context, entry *ResponseCacheEntry) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("X-Response-Cache", "HIT")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, _ = c.Writer.Write(entry.Body)
+}
+
+// ResponseCaptureWriter 包装 gin.ResponseWriter 以捕获响应内容用于写入缓存，超过大小上限时放弃捕获
+type ResponseCaptureWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func NewResponseCaptureWriter(writer gin.ResponseWriter) *ResponseCaptureWriter {
+	return &ResponseCaptureWriter{
+		ResponseWriter: writer,
+		limit:          operation_setting.GetResponseCacheSetting().MaxBodyBytes,
+	}
+}
+
+func (w *ResponseCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *ResponseCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *ResponseCaptureWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.limit > 0 && w.body.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+// Body 返回捕获的响应内容，超出大小上限或状态码非 200 时返回 nil
+func (w *ResponseCaptureWriter) Body() []byte {
+	if w.overflow || w.Status() != http.StatusOK || w.body.Len() == 0 {
+		return nil
+	}
+	return w.body.Bytes()
+}
@@ -0,0 +1,867 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responsesInputItem Responses API input 数组中的一项，message、function_call、function_call_output 等类型共用
+type responsesInputItem struct {
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	CallId    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Output    json.RawMessage `json:"output"`
+}
+
+type responsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Refusal  string `json:"refusal"`
+	ImageUrl string `json:"image_url"`
+	Detail   string `json:"detail"`
+	FileId   string `json:"file_id"`
+	FileData string `json:"file_data"`
+	Filename string `json:"filename"`
+}
+
+type responsesTextFormat struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Schema      any    `json:"schema"`
+	Strict      any    `json:"strict"`
+}
+
+type responsesTextConfig struct {
+	Format *responsesTextFormat `json:"format"`
+}
+
+// ResponsesToOpenAIRequest 将 Responses API 请求转换为 Chat Completions 请求，history 为 previous_response_id 对应的对话记录。
+// 同时返回不含 instructions 的完整对话，响应结束后与输出一起保存
+func ResponsesToOpenAIRequest(request *dto.OpenAIResponsesRequest, history []dto.Message) (*dto.GeneralOpenAIRequest, []dto.Message, error) {
+	openAIRequest := &dto.GeneralOpenAIRequest{
+		Model:       request.Model,
+		Stream:      request.Stream,
+		MaxTokens:   request.MaxOutputTokens,
+		TopP:        request.TopP,
+		User:        request.User,
+		ServiceTier: request.ServiceTier,
+	}
+	if request.Stream {
+		openAIRequest.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	if request.Temperature != 0 {
+		openAIRequest.Temperature = common.GetPointer(request.Temperature)
+	}
+	if request.ParallelToolCalls {
+		openAIRequest.ParallelTooCalls = common.GetPointer(true)
+	}
+	if request.Reasoning != nil && request.Reasoning.Effort != "" {
+		openAIRequest.ReasoningEffort = request.Reasoning.Effort
+	}
+
+	for _, tool := range request.Tools {
+		toolType, _ := tool["type"].(string)
+		switch toolType {
+		case "function":
+			name, _ := tool["name"].(string)
+			description, _ := tool["description"].(string)
+			parameters := tool["parameters"]
+			if parameters == nil {
+				parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:        name,
+					Description: description,
+					Parameters:  parameters,
+				},
+			})
+		case dto.BuildInToolWebSearchPreview, "web_search":
+			searchContextSize, _ := tool["search_context_size"].(string)
+			webSearchOptions := &dto.WebSearchOptions{
+				SearchContextSize: searchContextSize,
+			}
+			if userLocation, ok := tool["user_location"]; ok && userLocation != nil {
+				webSearchOptions.UserLocation, _ = common.Marshal(userLocation)
+			}
+			openAIRequest.WebSearchOptions = webSearchOptions
+		default:
+			// 文件搜索、MCP 等内置工具无法通过 Chat Completions 实现，直接丢弃
+		}
+	}
+	if len(openAIRequest.Tools) > 0 {
+		openAIRequest.ToolChoice = responsesToolChoiceToOpenAI(request.ToolChoice)
+	}
+
+	if len(request.Text) > 0 {
+		var textConfig responsesTextConfig
+		if err := common.Unmarshal(request.Text, &textConfig); err == nil && textConfig.Format != nil {
+			switch textConfig.Format.Type {
+			case "json_schema":
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{
+					Type: "json_schema",
+					JsonSchema: &dto.FormatJsonSchema{
+						Name:        textConfig.Format.Name,
+						Description: textConfig.Format.Description,
+						Schema:      textConfig.Format.Schema,
+						Strict:      textConfig.Format.Strict,
+					},
+				}
+			case "json_object":
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+			}
+		}
+	}
+
+	inputMessages, err := responsesInputToMessages(request.Input)
+	if err != nil {
+		return nil, nil, err
+	}
+	conversation := make([]dto.Message, 0, len(history)+len(inputMessages))
+	conversation = append(conversation, history...)
+	conversation = append(conversation, inputMessages...)
+
+	// instructions 只作用于本次请求，不随 previous_response_id 延续
+	messages := make([]dto.Message, 0, len(conversation)+1)
+	if instructions := responsesInstructions(request); instructions != "" {
+		systemMessage := dto.Message{Role: "system"}
+		systemMessage.SetStringContent(instructions)
+		messages = append(messages, systemMessage)
+	}
+	openAIRequest.Messages = append(messages, conversation...)
+	return openAIRequest, conversation, nil
+}
+
+func responsesInstructions(request *dto.OpenAIResponsesRequest) string {
+	var instructions string
+	if len(request.Instructions) > 0 {
+		_ = common.Unmarshal(request.Instructions, &instructions)
+	}
+	return instructions
+}
+
+func responsesToolChoiceToOpenAI(toolChoice json.RawMessage) any {
+	if len(toolChoice) == 0 {
+		return nil
+	}
+	var choice string
+	if err := common.Unmarshal(toolChoice, &choice); err == nil {
+		return choice
+	}
+	var choiceMap map[string]any
+	if err := common.Unmarshal(toolChoice, &choiceMap); err != nil {
+		return nil
+	}
+	if choiceType, _ := choiceMap["type"].(string); choiceType == "function" {
+		return map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": choiceMap["name"],
+			},
+		}
+	}
+	// 指定内置工具时退化为由模型自行选择
+	return "auto"
+}
+
+func responsesInputToMessages(input json.RawMessage) ([]dto.Message, error) {
+	var text string
+	if err := common.Unmarshal(input, &text); err == nil {
+		message := dto.Message{Role: "user"}
+		message.SetStringContent(text)
+		return []dto.Message{message}, nil
+	}
+	var items []responsesInputItem
+	if err := common.Unmarshal(input, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	messages := make([]dto.Message, 0, len(items))
+	for _, item := range items {
+		switch item.Type {
+		case "", "message":
+			role := item.Role
+			if role == "developer" {
+				// 大部分非 OpenAI 上游不支持 developer 角色
+				role = "system"
+			}
+			message := dto.Message{Role: role}
+			if err := setResponsesMessageContent(&message, item.Content); err != nil {
+				return nil, err
+			}
+			messages = append(messages, message)
+		case "function_call":
+			toolCall := dto.ToolCallRequest{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			// 连续的 function_call 与之前的 assistant 文本合并为同一条 assistant 消息
+			if n := len(messages); n > 0 && messages[n-1].Role == "assistant" {
+				messages[n-1].SetToolCalls(append(messages[n-1].ParseToolCalls(), toolCall))
+				continue
+			}
+			message := dto.Message{Role: "assistant"}
+			message.SetNullContent()
+			message.SetToolCalls([]dto.ToolCallRequest{toolCall})
+			messages = append(messages, message)
+		case "function_call_output":
+			message := dto.Message{
+				Role:       "tool",
+				ToolCallId: item.CallId,
+			}
+			if err := setResponsesMessageContent(&message, item.Output); err != nil {
+				return nil, err
+			}
+			if !message.IsStringContent() {
+				// tool 消息只支持文本
+				var texts []string
+				for _, content := range message.ParseContent() {
+					if content.Type == dto.ContentTypeText {
+						texts = append(texts, content.Text)
+					}
+				}
+				message.SetStringContent(strings.Join(texts, "\n"))
+			}
+			messages = append(messages, message)
+		default:
+			// reasoning、item_reference 以及内置工具的调用记录无法回传给上游，直接丢弃
+		}
+	}
+	return messages, nil
+}
+
+func setResponsesMessageContent(message *dto.Message, content json.RawMessage) error {
+	if len(content) == 0 {
+		message.SetStringContent("")
+		return nil
+	}
+	var text string
+	if err := common.Unmarshal(content, &text); err == nil {
+		message.SetStringContent(text)
+		return nil
+	}
+	var parts []responsesContentPart
+	if err := common.Unmarshal(content, &parts); err != nil {
+		return fmt.Errorf("invalid message content: %w", err)
+	}
+	mediaContents := make([]dto.MediaContent, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "summary_text":
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: part.Text,
+			})
+		case "refusal":
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: part.Refusal,
+			})
+		case "input_image":
+			if part.ImageUrl == "" {
+				// 仅有 file_id 的图片无法在其他渠道读取
+				continue
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeImageURL,
+				ImageUrl: &dto.MessageImageUrl{
+					Url:    part.ImageUrl,
+					Detail: part.Detail,
+				},
+			})
+		case "input_file":
+			if part.FileData == "" && part.FileId == "" {
+				continue
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeFile,
+				File: &dto.MessageFile{
+					FileName: part.Filename,
+					FileData: part.FileData,
+					FileId:   part.FileId,
+				},
+			})
+		}
+	}
+	if len(mediaContents) == 1 && mediaContents[0].Type == dto.ContentTypeText {
+		// 单个文本块使用字符串内容，兼容不支持数组内容的上游
+		message.SetStringContent(mediaContents[0].Text)
+		return nil
+	}
+	message.SetMediaContent(mediaContents)
+	return nil
+}
+
+// usageOpenAI2Responses 补充 Responses API 使用的 input_tokens、output_tokens 字段
+func usageOpenAI2Responses(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	responsesUsage := *usage
+	responsesUsage.InputTokens = usage.PromptTokens
+	responsesUsage.OutputTokens = usage.CompletionTokens
+	responsesUsage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	responsesUsage.InputTokensDetails = &dto.InputTokenDetails{
+		CachedTokens: usage.PromptTokensDetails.CachedTokens,
+	}
+	return &responsesUsage
+}
+
+// responsesOutputToMessage 将输出项还原为 assistant 消息，保存到对话记录中
+func responsesOutputToMessage(output []dto.ResponsesOutput) (dto.Message, bool) {
+	message := dto.Message{Role: "assistant"}
+	var texts []string
+	var toolCalls []dto.ToolCallRequest
+	for _, item := range output {
+		switch item.Type {
+		case "message":
+			for _, content := range item.Content {
+				texts = append(texts, content.Text)
+			}
+		case "function_call":
+			toolCalls = append(toolCalls, dto.ToolCallRequest{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			})
+		}
+	}
+	if len(texts) == 0 && len(toolCalls) == 0 {
+		return message, false
+	}
+	if len(texts) > 0 {
+		message.SetStringContent(strings.Join(texts, ""))
+	} else {
+		message.SetNullContent()
+	}
+	if len(toolCalls) > 0 {
+		message.SetToolCalls(toolCalls)
+	}
+	return message, true
+}
+
+// OpenAI2ResponsesWriter 包装 gin.ResponseWriter，将渠道输出的 Chat Completions 格式响应转换为 Responses API 格式后写给客户端，
+// 响应结束后保存对话记录以支持 previous_response_id
+type OpenAI2ResponsesWriter struct {
+	gin.ResponseWriter
+	isStream bool
+	status   int
+	buffer   bytes.Buffer
+
+	request      *dto.OpenAIResponsesRequest
+	modelName    string
+	userId       int
+	conversation []byte
+	responseId   string
+	createdAt    int
+
+	started        bool
+	finished       bool
+	sequenceNumber int
+	output         []*dto.ResponsesOutput
+	reasoningItem  *dto.ResponsesOutput
+	reasoningText  strings.Builder
+	messageItem    *dto.ResponsesOutput
+	outputText     strings.Builder
+	// 按工具调用下标记录正在输出的 function_call
+	toolCallItems map[int]*dto.ResponsesOutput
+	finishReason  string
+	usage         *dto.Usage
+}
+
+func NewOpenAI2ResponsesWriter(writer gin.ResponseWriter, request *dto.OpenAIResponsesRequest, conversation []dto.Message, info *relaycommon.RelayInfo) *OpenAI2ResponsesWriter {
+	// 先序列化保存，避免后续适配器转换请求时修改消息内容
+	conversationData, _ := common.Marshal(conversation)
+	return &OpenAI2ResponsesWriter{
+		ResponseWriter: writer,
+		isStream:       info.IsStream,
+		status:         http.StatusOK,
+		request:        request,
+		modelName:      info.OriginModelName,
+		userId:         info.UserId,
+		conversation:   conversationData,
+		responseId:     "resp_" + common.GetUUID(),
+		createdAt:      int(common.GetTimestamp()),
+		toolCallItems:  make(map[int]*dto.ResponsesOutput),
+	}
+}
+
+func (w *OpenAI2ResponsesWriter) WriteHeader(code int) {
+	if w.isStream {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *OpenAI2ResponsesWriter) WriteHeaderNow() {
+	if w.isStream {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *OpenAI2ResponsesWriter) Status() int {
+	if w.isStream {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *OpenAI2ResponsesWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.isStream {
+		for {
+			line, err := w.buffer.ReadString('\n')
+			if err != nil {
+				// 不完整的行放回缓冲区等待后续数据
+				w.buffer.Reset()
+				w.buffer.WriteString(line)
+				break
+			}
+			w.handleStreamLine(strings.TrimRight(line, "\r\n"))
+		}
+	}
+	return len(data), nil
+}
+
+func (w *OpenAI2ResponsesWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *OpenAI2ResponsesWriter) handleStreamLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		// 保活注释原样输出
+		_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		return
+	}
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" {
+		return
+	}
+	if data == "[DONE]" {
+		w.finish()
+		return
+	}
+	if strings.Contains(data, `"error"`) {
+		// 流中途结束（如额度不足）时以 error 事件作为最后一帧，不再输出 response.completed
+		var errorResponse struct {
+			Error *types.OpenAIError `json:"error"`
+		}
+		if err := common.UnmarshalJsonStr(data, &errorResponse); err == nil && errorResponse.Error != nil {
+			w.start()
+			w.finished = true
+			w.writeEvent(&dto.ResponsesStreamResponse{Type: dto.ResponsesStreamTypeError, Code: errorResponse.Error.Code, Message: errorResponse.Error.Message})
+			return
+		}
+	}
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return
+	}
+	w.start()
+	w.handleStreamResponse(&streamResponse)
+}
+
+func (w *OpenAI2ResponsesWriter) handleStreamResponse(streamResponse *dto.ChatCompletionsStreamResponse) {
+	if streamResponse.Usage != nil && (streamResponse.Usage.PromptTokens > 0 || streamResponse.Usage.CompletionTokens > 0) {
+		w.usage = streamResponse.Usage
+	}
+	for _, choice := range streamResponse.Choices {
+		// Responses API 只有一个输出
+		if choice.Index != 0 {
+			continue
+		}
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			if w.reasoningItem == nil {
+				w.closeMessage()
+				w.reasoningItem = &dto.ResponsesOutput{
+					Type:   "reasoning",
+					ID:     "rs_" + common.GetUUID(),
+					Status: "in_progress",
+				}
+				w.addItem(w.reasoningItem)
+			}
+			w.reasoningText.WriteString(reasoning)
+			w.writeEvent(&dto.ResponsesStreamResponse{
+				Type:         dto.ResponsesStreamTypeReasoningSummaryDelta,
+				ItemId:       w.reasoningItem.ID,
+				OutputIndex:  common.GetPointer(w.outputIndex(w.reasoningItem)),
+				SummaryIndex: common.GetPointer(0),
+				Delta:        reasoning,
+			})
+		}
+		if text := choice.Delta.GetContentString(); text != "" {
+			if w.messageItem == nil {
+				w.closeReasoning()
+				w.messageItem = &dto.ResponsesOutput{
+					Type:   "message",
+					ID:     "msg_" + common.GetUUID(),
+					Status: "in_progress",
+					Role:   "assistant",
+				}
+				index := w.addItem(w.messageItem)
+				w.writeEvent(&dto.ResponsesStreamResponse{
+					Type:         dto.ResponsesStreamTypeContentPartAdded,
+					ItemId:       w.messageItem.ID,
+					OutputIndex:  common.GetPointer(index),
+					ContentIndex: common.GetPointer(0),
+					Part:         &dto.ResponsesOutputContent{Type: "output_text", Annotations: []interface{}{}},
+				})
+			}
+			w.outputText.WriteString(text)
+			w.writeEvent(&dto.ResponsesStreamResponse{
+				Type:         dto.ResponsesStreamTypeOutputTextDelta,
+				ItemId:       w.messageItem.ID,
+				OutputIndex:  common.GetPointer(w.outputIndex(w.messageItem)),
+				ContentIndex: common.GetPointer(0),
+				Delta:        text,
+			})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			toolCallIndex := 0
+			if toolCall.Index != nil {
+				toolCallIndex = *toolCall.Index
+			}
+			item, ok := w.toolCallItems[toolCallIndex]
+			if !ok {
+				w.closeReasoning()
+				w.closeMessage()
+				callId := toolCall.ID
+				if callId == "" {
+					callId = "call_" + common.GetUUID()
+				}
+				item = &dto.ResponsesOutput{
+					Type:   "function_call",
+					ID:     "fc_" + common.GetUUID(),
+					Status: "in_progress",
+					CallId: callId,
+					Name:   toolCall.Function.Name,
+				}
+				w.toolCallItems[toolCallIndex] = item
+				w.addItem(item)
+			} else if item.Name == "" {
+				item.Name = toolCall.Function.Name
+			}
+			if toolCall.Function.Arguments != "" {
+				item.Arguments += toolCall.Function.Arguments
+				w.writeEvent(&dto.ResponsesStreamResponse{
+					Type:        dto.ResponsesStreamTypeFunctionArgumentsDelta,
+					ItemId:      item.ID,
+					OutputIndex: common.GetPointer(w.outputIndex(item)),
+					Delta:       toolCall.Function.Arguments,
+				})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.finishReason = *choice.FinishReason
+		}
+	}
+}
+
+// start 输出 response.created 和 response.in_progress 事件
+func (w *OpenAI2ResponsesWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	response := w.newResponse()
+	response.Status = "in_progress"
+	response.IncompleteDetails = nil
+	response.Usage = nil
+	w.writeEvent(&dto.ResponsesStreamResponse{Type: dto.ResponsesStreamTypeCreated, Response: response})
+	w.writeEvent(&dto.ResponsesStreamResponse{Type: dto.ResponsesStreamTypeInProgress, Response: response})
+}
+
+func (w *OpenAI2ResponsesWriter) addItem(item *dto.ResponsesOutput) int {
+	index := len(w.output)
+	w.output = append(w.output, item)
+	w.writeEvent(&dto.ResponsesStreamResponse{
+		Type:        dto.ResponsesOutputTypeItemAdded,
+		OutputIndex: common.GetPointer(index),
+		Item:        item,
+	})
+	return index
+}
+
+func (w *OpenAI2ResponsesWriter) outputIndex(item *dto.ResponsesOutput) int {
+	for index, outputItem := range w.output {
+		if outputItem == item {
+			return index
+		}
+	}
+	return 0
+}
+
+func (w *OpenAI2ResponsesWriter) closeReasoning() {
+	if w.reasoningItem == nil {
+		return
+	}
+	item := w.reasoningItem
+	w.reasoningItem = nil
+	text := w.reasoningText.String()
+	w.reasoningText.Reset()
+	index := w.outputIndex(item)
+	w.writeEvent(&dto.ResponsesStreamResponse{
+		Type:         dto.ResponsesStreamTypeReasoningSummaryDone,
+		ItemId:       item.ID,
+		OutputIndex:  common.GetPointer(index),
+		SummaryIndex: common.GetPointer(0),
+		Text:         text,
+	})
+	item.Status = "completed"
+	item.Summary = []dto.ResponsesOutputContent{{Type: "summary_text", Text: text}}
+	w.writeEvent(&dto.ResponsesStreamResponse{
+		Type:        dto.ResponsesOutputTypeItemDone,
+		OutputIndex: common.GetPointer(index),
+		Item:        item,
+	})
+}
+
+func (w *OpenAI2ResponsesWriter) closeMessage() {
+	if w.messageItem == nil {
+		return
+	}
+	item := w.messageItem
+	w.messageItem = nil
+	text := w.outputText.String()
+	w.outputText.Reset()
+	index := w.outputIndex(item)
+	part := dto.ResponsesOutputContent{Type: "output_text", Text: text, Annotations: []interface{}{}}
+	w.writeEvent(&dto.ResponsesStreamResponse{
+		Type:         dto.ResponsesStreamTypeOutputTextDone,
+		ItemId:       item.ID,
+		OutputIndex:  common.GetPointer(index),
+		ContentIndex: common.GetPointer(0),
+		Text:         text,
+	})
+	w.writeEvent(&dto.ResponsesStreamResponse{
+		Type:         dto.ResponsesStreamTypeContentPartDone,
+		ItemId:       item.ID,
+		OutputIndex:  common.GetPointer(index),
+		ContentIndex: common.GetPointer(0),
+		Part:         &part,
+	})
+	item.Status = "completed"
+	item.Content = []dto.ResponsesOutputContent{part}
+	w.writeEvent(&dto.ResponsesStreamResponse{
+		Type:        dto.ResponsesOutputTypeItemDone,
+		OutputIndex: common.GetPointer(index),
+		Item:        item,
+	})
+}
+
+func (w *OpenAI2ResponsesWriter) closeToolCalls() {
+	for index, item := range w.output {
+		if item.Type != "function_call" || item.Status != "in_progress" {
+			continue
+		}
+		w.writeEvent(&dto.ResponsesStreamResponse{
+			Type:        dto.ResponsesStreamTypeFunctionArgumentsDone,
+			ItemId:      item.ID,
+			OutputIndex: common.GetPointer(index),
+			Arguments:   item.Arguments,
+		})
+		item.Status = "completed"
+		w.writeEvent(&dto.ResponsesStreamResponse{
+			Type:        dto.ResponsesOutputTypeItemDone,
+			OutputIndex: common.GetPointer(index),
+			Item:        item,
+		})
+	}
+	w.toolCallItems = make(map[int]*dto.ResponsesOutput)
+}
+
+// finish 结束所有输出项并输出 response.completed 事件
+func (w *OpenAI2ResponsesWriter) finish() {
+	if w.finished {
+		return
+	}
+	w.start()
+	w.finished = true
+	w.closeReasoning()
+	w.closeMessage()
+	w.closeToolCalls()
+	response := w.newResponse()
+	eventType := dto.ResponsesStreamTypeCompleted
+	if response.Status == "incomplete" {
+		eventType = dto.ResponsesStreamTypeIncomplete
+	}
+	w.writeEvent(&dto.ResponsesStreamResponse{Type: eventType, Response: response})
+	w.saveConversation(response.Output)
+}
+
+func (w *OpenAI2ResponsesWriter) writeEvent(event *dto.ResponsesStreamResponse) {
+	event.SequenceNumber = w.sequenceNumber
+	w.sequenceNumber++
+	jsonData, err := common.Marshal(event)
+	if err != nil {
+		common.SysError("error marshalling responses stream event: " + err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString("event: " + event.Type + "\ndata: " + string(jsonData) + "\n\n")
+	w.ResponseWriter.Flush()
+}
+
+// newResponse 根据请求参数和当前的输出项构造 Responses API 响应对象
+func (w *OpenAI2ResponsesWriter) newResponse() *dto.OpenAIResponsesResponse {
+	output := make([]dto.ResponsesOutput, 0, len(w.output))
+	for _, item := range w.output {
+		output = append(output, *item)
+	}
+	toolChoice := "auto"
+	if len(w.request.ToolChoice) > 0 {
+		_ = common.Unmarshal(w.request.ToolChoice, &toolChoice)
+	}
+	tools := w.request.Tools
+	if tools == nil {
+		tools = make([]map[string]any, 0)
+	}
+	truncation := w.request.Truncation
+	if truncation == "" {
+		truncation = "disabled"
+	}
+	var user json.RawMessage
+	if w.request.User != "" {
+		user, _ = common.Marshal(w.request.User)
+	}
+	response := &dto.OpenAIResponsesResponse{
+		ID:                 w.responseId,
+		Object:             "response",
+		CreatedAt:          w.createdAt,
+		Status:             "completed",
+		Instructions:       responsesInstructions(w.request),
+		MaxOutputTokens:    int(w.request.MaxOutputTokens),
+		Model:              w.modelName,
+		Output:             output,
+		ParallelToolCalls:  w.request.ParallelToolCalls,
+		PreviousResponseID: w.request.PreviousResponseID,
+		Reasoning:          w.request.Reasoning,
+		Store:              operation_setting.GetResponsesConversionSetting().StoreTTLSeconds > 0,
+		Temperature:        w.request.Temperature,
+		ToolChoice:         toolChoice,
+		Tools:              tools,
+		TopP:               w.request.TopP,
+		Truncation:         truncation,
+		Usage:              usageOpenAI2Responses(w.usage),
+		User:               user,
+		Metadata:           w.request.Metadata,
+	}
+	switch w.finishReason {
+	case "length":
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.IncompleteDetails{Reason: "max_output_tokens"}
+	case "content_filter":
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.IncompleteDetails{Reason: "content_filter"}
+	}
+	return response
+}
+
+func (w *OpenAI2ResponsesWriter) saveConversation(output []dto.ResponsesOutput) {
+	var messages []dto.Message
+	if err := common.Unmarshal(w.conversation, &messages); err != nil {
+		return
+	}
+	if message, ok := responsesOutputToMessage(output); ok {
+		messages = append(messages, message)
+	}
+	SaveResponsesConversation(w.userId, w.responseId, messages)
+}
+
+// Close 输出剩余的内容，非流式响应在此转换并写出
+func (w *OpenAI2ResponsesWriter) Close() {
+	if w.isStream {
+		if w.buffer.Len() > 0 {
+			line := w.buffer.String()
+			w.buffer.Reset()
+			w.handleStreamLine(strings.TrimSpace(line))
+		}
+		// 上游未返回 [DONE] 时在此结束
+		w.finish()
+		return
+	}
+
+	if w.buffer.Len() == 0 {
+		return
+	}
+	body := w.buffer.Bytes()
+	if w.status == http.StatusOK {
+		var openAIResponse dto.OpenAITextResponse
+		if err := common.Unmarshal(body, &openAIResponse); err == nil && openAIResponse.Error == nil {
+			response := w.convertResponse(&openAIResponse)
+			if jsonData, err := common.Marshal(response); err == nil {
+				body = jsonData
+				w.saveConversation(response.Output)
+			}
+		}
+	}
+	w.ResponseWriter.Header().Del("Content-Length")
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+func (w *OpenAI2ResponsesWriter) convertResponse(openAIResponse *dto.OpenAITextResponse) *dto.OpenAIResponsesResponse {
+	w.usage = &openAIResponse.Usage
+	if len(openAIResponse.Choices) > 0 {
+		choice := openAIResponse.Choices[0]
+		w.finishReason = choice.FinishReason
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			w.output = append(w.output, &dto.ResponsesOutput{
+				Type:    "reasoning",
+				ID:      "rs_" + common.GetUUID(),
+				Status:  "completed",
+				Summary: []dto.ResponsesOutputContent{{Type: "summary_text", Text: reasoning}},
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			w.output = append(w.output, &dto.ResponsesOutput{
+				Type:    "message",
+				ID:      "msg_" + common.GetUUID(),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []dto.ResponsesOutputContent{{Type: "output_text", Text: text, Annotations: []interface{}{}}},
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			w.output = append(w.output, &dto.ResponsesOutput{
+				Type:      "function_call",
+				ID:        "fc_" + common.GetUUID(),
+				Status:    "completed",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+	}
+	return w.newResponse()
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/setting/operation_setting"
+	"time"
+)
+
+var responsesMemoryStore = common.NewTTLCache[[]byte]()
+
+// 对话记录按用户隔离，避免通过 previous_response_id 读取他人的对话
+func responsesStoreKey(userId int, responseId string) string {
+	return fmt.Sprintf("responses_store:%d:%s", userId, responseId)
+}
+
+// GetResponsesConversation 读取 previous_response_id 对应的对话记录（不含 instructions）
+func GetResponsesConversation(userId int, responseId string) ([]dto.Message, bool) {
+	key := responsesStoreKey(userId, responseId)
+	var data []byte
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return nil, false
+		}
+		data = []byte(value)
+	} else {
+		var ok bool
+		if data, ok = responsesMemoryStore.Get(key); !ok {
+			return nil, false
+		}
+	}
+	var messages []dto.Message
+	if err := common.Unmarshal(data, &messages); err != nil {
+		return nil, false
+	}
+	return messages, true
+}
+
+// SaveResponsesConversation 保存本次响应结束后的完整对话记录，供后续请求通过 previous_response_id 引用
+func SaveResponsesConversation(userId int, responseId string, messages []dto.Message) {
+	setting := operation_setting.GetResponsesConversionSetting()
+	ttl := time.Duration(setting.StoreTTLSeconds) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	data, err := common.Marshal(messages)
+	if err != nil {
+		common.SysError("failed to marshal responses conversation: " + err.Error())
+		return
+	}
+	key := responsesStoreKey(userId, responseId)
+	if common.RedisEnabled {
+		if err = common.RedisSet(key, string(data), ttl); err != nil {
+			common.SysError("failed to save responses conversation: " + err.Error())
+		}
+		return
+	}
+	responsesMemoryStore.Set(key, data, ttl, setting.MaxStoreEntries)
+}
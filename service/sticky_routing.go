@@ -0,0 +1,238 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StickyRoutingBinding 粘性路由绑定的渠道和密钥
+type StickyRoutingBinding struct {
+	ChannelId int    `json:"channel_id"`
+	KeyIndex  int    `json:"key_index"`
+	Group     string `json:"group"` // 实际选中的分组，auto 分组时与请求分组不同
+}
+
+var stickyRoutingMemory = common.NewTTLCache[StickyRoutingBinding]()
+
+// 计算前缀时读取的字段：系统提示词和工具定义
+var stickyRoutingSystemFields = []string{"system", "instructions", "systemInstruction", "system_instruction", "tools"}
+
+// 计算前缀时读取的消息列表字段：OpenAI/Claude 的 messages、Responses 的 input、Gemini 的 contents
+var stickyRoutingMessageFields = []string{"messages", "input", "contents"}
+
+// getStickyRoutingHash 计算请求的粘性路由哈希，优先使用会话请求头，否则使用系统提示词加前 N 条消息。
+// 无法计算时返回空字符串，结果缓存在上下文中
+func getStickyRoutingHash(c *gin.Context) string {
+	if hash, ok := common.GetContextKey(c, constant.ContextKeyStickyRoutingHash); ok {
+		return hash.(string)
+	}
+	hash := computeStickyRoutingHash(c)
+	common.SetContextKey(c, constant.ContextKeyStickyRoutingHash, hash)
+	return hash
+}
+
+func computeStickyRoutingHash(c *gin.Context) string {
+	setting := operation_setting.GetStickyRoutingSetting()
+	if setting.SessionHeader != "" {
+		if session := c.GetHeader(setting.SessionHeader); session != "" {
+			// 会话标识由客户端生成，始终按用户隔离
+			return hashStickyRoutingPrefix(fmt.Sprintf("session:%d:%s", c.GetInt("id"), session))
+		}
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil || len(requestBody) == 0 {
+		return ""
+	}
+	var request map[string]any
+	if err = common.Unmarshal(requestBody, &request); err != nil {
+		return ""
+	}
+	prefix := make(map[string]any)
+	for _, field := range stickyRoutingSystemFields {
+		if value, ok := request[field]; ok {
+			prefix[field] = value
+		}
+	}
+	for _, field := range stickyRoutingMessageFields {
+		if messages, ok := request[field].([]any); ok {
+			prefix[field] = stablePrefixMessages(messages, setting.PrefixMessages)
+			break
+		}
+	}
+	if len(prefix) == 0 {
+		return ""
+	}
+	data, err := common.Marshal(prefix)
+	if err != nil {
+		return ""
+	}
+	if !setting.ShareAcrossUsers {
+		return hashStickyRoutingPrefix(fmt.Sprintf("prefix:%d:%s", c.GetInt("id"), data))
+	}
+	return hashStickyRoutingPrefix("prefix:" + string(data))
+}
+
+// stablePrefixMessages 返回开头的 system/developer 消息和之后的前 n 条消息
+func stablePrefixMessages(messages []any, n int) []any {
+	end := 0
+	for end < len(messages) {
+		message, ok := messages[end].(map[string]any)
+		if !ok {
+			break
+		}
+		role, _ := message["role"].(string)
+		if role != "system" && role != "developer" {
+			break
+		}
+		end++
+	}
+	end += n
+	if end > len(messages) {
+		end = len(messages)
+	}
+	return messages[:end]
+}
+
+func hashStickyRoutingPrefix(prefix string) string {
+	hash := sha256.Sum256([]byte(prefix))
+	return hex.EncodeToString(hash[:])
+}
+
+func stickyRoutingKey(group string, modelName string, hash string) string {
+	return fmt.Sprintf("sticky_routing:%s:%s:%s", group, modelName, hash)
+}
+
+// GetStickyChannel 返回当前请求绑定的渠道及实际分组。绑定的渠道已被禁用、临时禁用或熔断时返回 false，
+// 由调用方按常规策略重新选择，选中后绑定会被覆盖
+func GetStickyChannel(c *gin.Context, group string, modelName string) (*model.Channel, string, bool) {
+	if !operation_setting.GetStickyRoutingSetting().Enabled {
+		return nil, "", false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return nil, "", false
+	}
+	hash := getStickyRoutingHash(c)
+	if hash == "" {
+		return nil, "", false
+	}
+	binding, ok := getStickyRoutingBinding(stickyRoutingKey(group, modelName, hash))
+	if !ok {
+		return nil, "", false
+	}
+	if !model.IsChannelSatisfied(binding.Group, modelName, binding.ChannelId) {
+		if common.DebugEnabled {
+			common.SysLog(fmt.Sprintf("粘性路由绑定的渠道 #%d 不可用，重新选择渠道", binding.ChannelId))
+		}
+		return nil, "", false
+	}
+	channel, err := model.CacheGetChannel(binding.ChannelId)
+	if err != nil {
+		return nil, "", false
+	}
+	if group == "auto" {
+		c.Set("auto_group", binding.Group)
+	}
+	common.SetContextKey(c, constant.ContextKeyStickyRoutingBinding, binding)
+	return channel, binding.Group, true
+}
+
+// GetStickyChannelKey 返回绑定的密钥。只在命中绑定后的首次选择中生效，重试时按渠道的多密钥策略重新选择
+func GetStickyChannelKey(c *gin.Context, channel *model.Channel) (string, int, bool) {
+	value, ok := common.GetContextKey(c, constant.ContextKeyStickyRoutingBinding)
+	if !ok || value == nil {
+		return "", 0, false
+	}
+	common.SetContextKey(c, constant.ContextKeyStickyRoutingBinding, nil)
+	binding := value.(*StickyRoutingBinding)
+	if binding.ChannelId != channel.Id {
+		return "", 0, false
+	}
+	key, ok := channel.GetEnabledKeyByIndex(binding.KeyIndex)
+	if !ok {
+		return "", 0, false
+	}
+	return key, binding.KeyIndex, true
+}
+
+// PrepareStickyRouting 记录当前请求选中的渠道和密钥，请求成功后才由 BindStickyRouting 写入绑定
+func PrepareStickyRouting(c *gin.Context, channelId int, keyIndex int) {
+	if !operation_setting.GetStickyRoutingSetting().Enabled {
+		return
+	}
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	selectGroup := group
+	if autoGroup := c.GetString("auto_group"); group == "auto" && autoGroup != "" {
+		selectGroup = autoGroup
+	}
+	common.SetContextKey(c, constant.ContextKeyStickyRoutingPending, &StickyRoutingBinding{
+		ChannelId: channelId,
+		KeyIndex:  keyIndex,
+		Group:     selectGroup,
+	})
+}
+
+// BindStickyRouting 请求成功后绑定实际处理请求的渠道和密钥，并刷新有效期。失败的渠道不会被绑定
+func BindStickyRouting(c *gin.Context, modelName string) {
+	if !operation_setting.GetStickyRoutingSetting().Enabled {
+		return
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return
+	}
+	binding, ok := common.GetContextKeyType[*StickyRoutingBinding](c, constant.ContextKeyStickyRoutingPending)
+	if !ok || binding == nil {
+		return
+	}
+	hash := getStickyRoutingHash(c)
+	if hash == "" {
+		return
+	}
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	setStickyRoutingBinding(stickyRoutingKey(group, modelName, hash), binding)
+}
+
+func getStickyRoutingBinding(key string) (*StickyRoutingBinding, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return nil, false
+		}
+		var binding StickyRoutingBinding
+		if err = common.UnmarshalJsonStr(value, &binding); err != nil {
+			return nil, false
+		}
+		return &binding, true
+	}
+	binding, ok := stickyRoutingMemory.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return &binding, true
+}
+
+func setStickyRoutingBinding(key string, binding *StickyRoutingBinding) {
+	setting := operation_setting.GetStickyRoutingSetting()
+	ttl := time.Duration(setting.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	if common.RedisEnabled {
+		jsonData, err := common.Marshal(binding)
+		if err != nil {
+			return
+		}
+		if err = common.RedisSet(key, string(jsonData), ttl); err != nil {
+			common.SysError("failed to set sticky routing binding: " + err.Error())
+		}
+		return
+	}
+	stickyRoutingMemory.Set(key, *binding, ttl, setting.MaxEntries)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting/operation_setting"
+	"one-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// InitStreamQuota 预扣费后记录流式中途扣费所需的计费参数，按次计费的模型无需中途扣费
+func InitStreamQuota(relayInfo *relaycommon.RelayInfo, priceData helper.PriceData, preConsumedQuota int) {
+	if !operation_setting.GetStreamQuotaSetting().Enabled || !relayInfo.IsStream || priceData.UsePrice {
+		return
+	}
+	// 按预估输入 token 数匹配分档倍率
+	priceData.ApplyTieredPrice(relayInfo, relayInfo.PromptTokens, &dto.Usage{})
+	ratio := priceData.ModelRatio * priceData.GroupRatioInfo.GroupRatio
+	relayInfo.StreamQuota = &relaycommon.StreamQuotaInfo{
+		PromptQuota:      float64(relayInfo.PromptTokens) * ratio,
+		CompletionPrice:  priceData.CompletionRatio * ratio,
+		PreConsumedQuota: preConsumedQuota,
+	}
+}
+
+// GetStreamQuotaError 返回流式中途扣费发现的额度不足错误，调用方应输出错误事件并结束流
+func GetStreamQuotaError(relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	if relayInfo.StreamQuota == nil {
+		return nil
+	}
+	return relayInfo.StreamQuota.ExhaustedError()
+}
+
+// SettleStreamCompletionText 累计新输出的补全内容的 token 数，达到结算间隔时在后台追加扣费。
+// 返回错误表示此前的追加扣费发现额度或令牌预算已不足，调用方应输出错误事件并结束流
+func SettleStreamCompletionText(relayInfo *relaycommon.RelayInfo, text string) *types.NewAPIError {
+	if relayInfo.StreamQuota == nil {
+		return nil
+	}
+	if newAPIError := relayInfo.StreamQuota.ExhaustedError(); newAPIError != nil {
+		return newAPIError
+	}
+	if text == "" {
+		return nil
+	}
+	relayInfo.StreamQuota.CompletionTokens += CountTextToken(text, relayInfo.UpstreamModelName)
+	settleStreamQuota(relayInfo)
+	return nil
+}
+
+// SettleStreamCompletionTokens 上游在流中返回累计补全 token 数时按其结算
+func SettleStreamCompletionTokens(relayInfo *relaycommon.RelayInfo, completionTokens int) *types.NewAPIError {
+	if relayInfo.StreamQuota == nil {
+		return nil
+	}
+	if newAPIError := relayInfo.StreamQuota.ExhaustedError(); newAPIError != nil {
+		return newAPIError
+	}
+	if completionTokens > relayInfo.StreamQuota.CompletionTokens {
+		relayInfo.StreamQuota.CompletionTokens = completionTokens
+	}
+	settleStreamQuota(relayInfo)
+	return nil
+}
+
+// settleStreamQuota 达到结算间隔时在后台追加扣费，扣费需要多次读写数据库或 Redis，不在数据块处理中同步执行。
+// 上一次扣费尚未结束时跳过，由之后的数据块再次触发
+func settleStreamQuota(relayInfo *relaycommon.RelayInfo) {
+	streamQuota := relayInfo.StreamQuota
+	interval := operation_setting.GetStreamQuotaSetting().SettleIntervalTokens
+	if streamQuota.CompletionTokens-streamQuota.SettledTokens < interval {
+		return
+	}
+	if !streamQuota.StartSettle() {
+		return
+	}
+	streamQuota.SettledTokens = streamQuota.CompletionTokens
+	estimatedQuota := int(streamQuota.PromptQuota + float64(streamQuota.CompletionTokens)*streamQuota.CompletionPrice)
+	gopool.Go(func() {
+		streamQuota.FinishSettle(debitStreamQuota(relayInfo, estimatedQuota))
+	})
+}
+
+// debitStreamQuota 按截至目前的预估额度补扣超出预扣费和已追加扣费的部分，
+// 请求结束后仍由 postConsumeQuota 按实际用量多退少补
+func debitStreamQuota(relayInfo *relaycommon.RelayInfo, estimatedQuota int) *types.NewAPIError {
+	streamQuota := relayInfo.StreamQuota
+	quota := estimatedQuota - streamQuota.PreConsumedQuota - streamQuota.SettledQuota
+	if quota <= 0 {
+		return nil
+	}
+	payerQuota, err := GetPayerQuota(relayInfo)
+	if err != nil {
+		// 查询失败时不中断输出，由请求结束后的结算兜底
+		common.SysError(fmt.Sprintf("failed to get quota of user %d during streaming: %s", relayInfo.UserId, err.Error()))
+		return nil
+	}
+	if payerQuota < quota {
+		return types.NewErrorWithStatusCode(fmt.Errorf("user quota is not enough to continue streaming, user quota: %s, need quota: %s",
+			common.FormatQuota(payerQuota), common.FormatQuota(quota)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden)
+	}
+	// 令牌预算按预估总额度补足预占，预扣费时已预占的部分不重复预占
+	budgetQuota := estimatedQuota - relayInfo.TokenBudgetReserved
+	if newAPIError := ReserveTokenBudget(relayInfo, budgetQuota); newAPIError != nil {
+		return newAPIError
+	}
+	if err = PreConsumeTokenQuota(relayInfo, quota); err != nil {
+		releaseStreamTokenBudget(relayInfo, budgetQuota)
+		return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden)
+	}
+	if err = ReservePayerQuota(relayInfo, quota); err != nil {
+		ReturnPreConsumedTokenQuota(relayInfo, quota)
+		releaseStreamTokenBudget(relayInfo, budgetQuota)
+		if IsPayerQuotaExceeded(err) {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeInsufficientUserQuota, http.StatusForbidden)
+		}
+		common.SysError(fmt.Sprintf("failed to decrease quota of user %d during streaming: %s", relayInfo.UserId, err.Error()))
+		return nil
+	}
+	streamQuota.SettledQuota += quota
+	return nil
+}
+
+// releaseStreamTokenBudget 追加扣费失败时退还本次预占的令牌预算
+func releaseStreamTokenBudget(relayInfo *relaycommon.RelayInfo, quota int) {
+	if quota <= 0 || relayInfo.TokenBudgetReserved < quota {
+		return
+	}
+	relayInfo.TokenBudgetReserved -= quota
+	RecordTokenBudgetUsage(relayInfo, -quota)
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/types"
+	"time"
+)
+
+// ReserveTokenBudget 按预估额度预占令牌当前周期的预算，预算不足时返回错误。
+// 预占的额度记录在 relayInfo 中，结算时由 SettleTokenBudget 按实际用量多退少补
+func ReserveTokenBudget(relayInfo *relaycommon.RelayInfo, quota int) *types.NewAPIError {
+	if !relayInfo.TokenBudgetEnabled || relayInfo.IsPlayground || quota <= 0 {
+		return nil
+	}
+	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError)
+	}
+	if !token.BudgetEnabled {
+		return nil
+	}
+	if token.BudgetResetTime <= common.GetTimestamp() {
+		// 定时任务尚未重置时在请求中重置
+		if err = model.ResetTokenBudget(token); err != nil {
+			return types.NewError(err, types.ErrorCodeUpdateDataError)
+		}
+	}
+	modelName := ""
+	modelQuota, ok := token.GetBudgetModelQuotas()[relayInfo.OriginModelName]
+	if ok {
+		modelName = relayInfo.OriginModelName
+	}
+	exceeded, ok, err := model.ReserveTokenBudget(token.Id, quota, token.BudgetQuota, modelName, modelQuota)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeUpdateDataError)
+	}
+	if ok {
+		relayInfo.TokenBudgetReserved += quota
+		return nil
+	}
+	// 预算不足时查询用量用于提示，查询失败不影响拒绝请求
+	usage, _ := model.GetTokenBudgetUsage(token.Id)
+	resetTime := time.Unix(token.BudgetResetTime, 0).Format("2006-01-02 15:04:05")
+	if exceeded == "" {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌本周期预算已用尽：已用 %s，预算 %s，将于 %s 重置",
+			common.FormatQuota(usage[""]), common.FormatQuota(token.BudgetQuota), resetTime),
+			types.ErrorCodeTokenBudgetExhausted, http.StatusForbidden)
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("令牌本周期模型 %s 的预算已用尽：已用 %s，预算 %s，将于 %s 重置",
+		exceeded, common.FormatQuota(usage[exceeded]), common.FormatQuota(modelQuota), resetTime),
+		types.ErrorCodeTokenBudgetExhausted, http.StatusForbidden)
+}
+
+// SettleTokenBudget 按实际用量核对预占的预算，多退少补；请求失败或退款时 quota 传 0 退还全部预占
+func SettleTokenBudget(relayInfo *relaycommon.RelayInfo, quota int) {
+	reserved := relayInfo.TokenBudgetReserved
+	relayInfo.TokenBudgetReserved = 0
+	if !relayInfo.TokenBudgetEnabled || relayInfo.IsPlayground {
+		return
+	}
+	if quota < 0 {
+		quota = 0
+	}
+	if quota != reserved {
+		RecordTokenBudgetUsage(relayInfo, quota-reserved)
+	}
+}
+
+// RecordTokenBudgetUsage 累计令牌当前周期的用量，quota 为负数时退还，设置了模型预算的模型同时单独累计
+func RecordTokenBudgetUsage(relayInfo *relaycommon.RelayInfo, quota int) {
+	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get token %d for budget usage: %s", relayInfo.TokenId, err.Error()))
+		return
+	}
+	models := make([]string, 0, 1)
+	if _, ok := token.GetBudgetModelQuotas()[relayInfo.OriginModelName]; ok {
+		models = append(models, relayInfo.OriginModelName)
+	}
+	if err = model.IncreaseTokenBudgetUsage(relayInfo.TokenId, quota, models...); err != nil {
+		common.SysError(fmt.Sprintf("failed to record budget usage of token %d: %s", relayInfo.TokenId, err.Error()))
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/limiter"
+	"one-api/constant"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting"
+	"one-api/types"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tpmLimitWindowSeconds = 60
+	// 并发记录的过期时间，防止进程异常退出后名额无法释放
+	concurrencyLimitStaleSeconds = 3600
+	// 当前请求的 TPM 累计状态，重试时不再重复累计
+	tpmLimitReservedKey = "tpm_limit_reserved"
+)
+
+// tpmReservation 请求级别的 TPM 累计状态，以指针保存在上下文中，
+// 对冲请求复制出的各个尝试共享同一份状态
+type tpmReservation struct {
+	mutex    sync.Mutex
+	reserved bool
+	settled  bool
+}
+
+// rateLimitTarget 一个限流对象（令牌或用户）
+type rateLimitTarget struct {
+	key   string
+	name  string
+	limit int
+}
+
+type inMemoryTPMLimiter struct {
+	mutex sync.Mutex
+	// key -> 秒级时间戳 -> token 数
+	buckets     map[string]map[int64]int
+	concurrency map[string]int
+}
+
+var tpmMemoryLimiter = &inMemoryTPMLimiter{
+	buckets:     make(map[string]map[int64]int),
+	concurrency: make(map[string]int),
+}
+
+func getTPMLimit(c *gin.Context) setting.TPMLimit {
+	group := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+	}
+	return setting.GetTPMLimit(group)
+}
+
+// isTPMLimitExempt 批处理请求由批处理并发数控制，不受 TPM 和并发限制
+func isTPMLimitExempt(c *gin.Context) bool {
+	return !setting.TPMLimitEnabled || relaycommon.GetBatchId(c.Request.Context()) != ""
+}
+
+func buildRateLimitTargets(c *gin.Context, kind string, tokenLimit int, userLimit int) []rateLimitTarget {
+	targets := make([]rateLimitTarget, 0, 2)
+	if tokenLimit > 0 {
+		targets = append(targets, rateLimitTarget{
+			key:   fmt.Sprintf("%s:token:%d", kind, c.GetInt("token_id")),
+			name:  "令牌",
+			limit: tokenLimit,
+		})
+	}
+	if userLimit > 0 {
+		targets = append(targets, rateLimitTarget{
+			key:   fmt.Sprintf("%s:user:%d", kind, c.GetInt("id")),
+			name:  "用户",
+			limit: userLimit,
+		})
+	}
+	return targets
+}
+
+func splitRateLimitTargets(targets []rateLimitTarget) ([]string, []int) {
+	keys := make([]string, 0, len(targets))
+	limits := make([]int, 0, len(targets))
+	for _, target := range targets {
+		keys = append(keys, target.key)
+		limits = append(limits, target.limit)
+	}
+	return keys, limits
+}
+
+// AcquireConcurrency 占用令牌和用户的并发名额，返回的 release 在请求结束后释放名额
+func AcquireConcurrency(c *gin.Context) (func(), *types.NewAPIError) {
+	noop := func() {}
+	if isTPMLimitExempt(c) {
+		return noop, nil
+	}
+	limit := getTPMLimit(c)
+	targets := buildRateLimitTargets(c, "concurrencyLimit", limit.TokenConcurrency, limit.UserConcurrency)
+	if len(targets) == 0 {
+		return noop, nil
+	}
+	keys, limits := splitRateLimitTargets(targets)
+
+	exceeded := -1
+	var release func()
+	if common.RedisEnabled {
+		member := common.GetUUID()
+		var err error
+		exceeded, err = limiter.AcquireConcurrency(context.Background(), common.RDB, keys, limits, member, concurrencyLimitStaleSeconds)
+		if err != nil {
+			return noop, types.NewError(err, types.ErrorCodeRateLimitCheckFailed)
+		}
+		release = func() {
+			if err := limiter.ReleaseConcurrency(context.Background(), common.RDB, keys, member); err != nil {
+				common.SysError("failed to release concurrency limit: " + err.Error())
+			}
+		}
+	} else {
+		exceeded = tpmMemoryLimiter.acquire(keys, limits)
+		release = func() {
+			tpmMemoryLimiter.release(keys)
+		}
+	}
+	if exceeded >= 0 {
+		target := targets[exceeded]
+		return noop, types.NewErrorWithStatusCode(fmt.Errorf("%s并发请求数已达上限：最多同时进行%d个请求", target.name, target.limit), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests)
+	}
+	return release, nil
+}
+
+// InitTPMReservation 在复制上下文之前创建累计状态，使对冲请求的各个尝试只累计和结算一次
+func InitTPMReservation(c *gin.Context) {
+	if getTPMReservation(c) == nil {
+		c.Set(tpmLimitReservedKey, &tpmReservation{})
+	}
+}
+
+func getTPMReservation(c *gin.Context) *tpmReservation {
+	value, ok := c.Get(tpmLimitReservedKey)
+	if !ok {
+		return nil
+	}
+	reservation, _ := value.(*tpmReservation)
+	return reservation
+}
+
+// ReserveTPM 按预估的输入 token 数检查并累计令牌和用户最近一分钟的 token 数
+func ReserveTPM(c *gin.Context, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	if isTPMLimitExempt(c) {
+		return nil
+	}
+	InitTPMReservation(c)
+	reservation := getTPMReservation(c)
+	reservation.mutex.Lock()
+	defer reservation.mutex.Unlock()
+	if reservation.reserved {
+		return nil
+	}
+	limit := getTPMLimit(c)
+	targets := buildRateLimitTargets(c, "tpmLimit", limit.TokenTPM, limit.UserTPM)
+	if len(targets) == 0 {
+		return nil
+	}
+	exceeded, err := consumeTPM(targets, relayInfo.PromptTokens, true)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeRateLimitCheckFailed)
+	}
+	if exceeded >= 0 {
+		target := targets[exceeded]
+		return types.NewErrorWithStatusCode(fmt.Errorf("%s已达到 TPM 限制：每分钟最多%d tokens，本次请求预估输入%d tokens", target.name, target.limit, relayInfo.PromptTokens), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests)
+	}
+	reservation.reserved = true
+	return nil
+}
+
+// SettleTPM 请求结束后累计实际的输出 token 数，只计入已通过 ReserveTPM 的请求，
+// 对冲请求中竞速失败的尝试不计入
+func SettleTPM(c *gin.Context, completionTokens int) {
+	if completionTokens <= 0 || helper.IsHedgeLoser(c) {
+		return
+	}
+	reservation := getTPMReservation(c)
+	if reservation == nil {
+		return
+	}
+	reservation.mutex.Lock()
+	defer reservation.mutex.Unlock()
+	if !reservation.reserved || reservation.settled {
+		return
+	}
+	reservation.settled = true
+	limit := getTPMLimit(c)
+	targets := buildRateLimitTargets(c, "tpmLimit", limit.TokenTPM, limit.UserTPM)
+	if len(targets) == 0 {
+		return
+	}
+	if _, err := consumeTPM(targets, completionTokens, false); err != nil {
+		common.LogError(c, "failed to settle tpm limit: "+err.Error())
+	}
+}
+
+func consumeTPM(targets []rateLimitTarget, amount int, check bool) (int, error) {
+	keys, limits := splitRateLimitTargets(targets)
+	if common.RedisEnabled {
+		return limiter.ConsumeTokens(context.Background(), common.RDB, keys, limits, amount, tpmLimitWindowSeconds, check)
+	}
+	return tpmMemoryLimiter.consume(keys, limits, amount, check), nil
+}
+
+func (l *inMemoryTPMLimiter) consume(keys []string, limits []int, amount int, check bool) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now().Unix()
+	for i, key := range keys {
+		used := 0
+		for second, tokens := range l.buckets[key] {
+			if second <= now-tpmLimitWindowSeconds {
+				delete(l.buckets[key], second)
+			} else {
+				used += tokens
+			}
+		}
+		if check && used+amount > limits[i] {
+			return i
+		}
+	}
+	for _, key := range keys {
+		if l.buckets[key] == nil {
+			l.buckets[key] = make(map[int64]int)
+		}
+		l.buckets[key][now] += amount
+	}
+	return -1
+}
+
+func (l *inMemoryTPMLimiter) acquire(keys []string, limits []int) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i, key := range keys {
+		if l.concurrency[key] >= limits[i] {
+			return i
+		}
+	}
+	for _, key := range keys {
+		l.concurrency[key]++
+	}
+	return -1
+}
+
+func (l *inMemoryTPMLimiter) release(keys []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, key := range keys {
+		l.concurrency[key]--
+		if l.concurrency[key] <= 0 {
+			delete(l.concurrency, key)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"net/http/httptest"
+	"one-api/common"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/setting"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func tpmUsed(key string) int {
+	tpmMemoryLimiter.mutex.Lock()
+	defer tpmMemoryLimiter.mutex.Unlock()
+	used := 0
+	for _, tokens := range tpmMemoryLimiter.buckets[key] {
+		used += tokens
+	}
+	return used
+}
+
+// TestTPMHedgeAttemptsShareReservation 对冲请求的主请求和对冲请求只累计一次输入 token，
+// 竞速失败的尝试不结算输出 token
+func TestTPMHedgeAttemptsShareReservation(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	setting.TPMLimitEnabled = true
+	setting.TokenTPMLimit = 1000
+	defer func() {
+		common.RedisEnabled = redisEnabled
+		setting.TPMLimitEnabled = false
+		setting.TokenTPMLimit = 0
+	}()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	c.Set("token_id", 9527)
+	key := "tpmLimit:token:9527"
+
+	InitTPMReservation(c)
+	race := helper.NewHedgeRace(c.Writer)
+	primaryCtx, _ := race.NewAttempt(c, 1, false)
+	hedgeCtx, _ := race.NewAttempt(c, 2, true)
+	info := &relaycommon.RelayInfo{PromptTokens: 100}
+	for _, ctx := range []*gin.Context{primaryCtx, hedgeCtx, c} {
+		if err := ReserveTPM(ctx, info); err != nil {
+			t.Fatalf("reserve: %v", err)
+		}
+	}
+	if used := tpmUsed(key); used != 100 {
+		t.Fatalf("reserved %d tokens, want 100", used)
+	}
+
+	// 对冲请求先输出数据胜出，主请求竞速失败
+	if _, err := hedgeCtx.Writer.Write([]byte("data")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	SettleTPM(primaryCtx, 50)
+	SettleTPM(hedgeCtx, 20)
+	SettleTPM(hedgeCtx, 20)
+	if used := tpmUsed(key); used != 120 {
+		t.Errorf("settled to %d tokens, want 120", used)
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"one-api/common"
+	relaycommon "one-api/relay/common"
+)
+
+// GetUpstreamCost 按渠道的上游成本设置计算本次请求的成本（额度单位），第二个返回值表示是否设置了成本。
+// 成本比例以不含分组倍率的用户价格为基准，quota 应为批处理等折扣前的额度；命中响应缓存的请求没有请求上游，成本为 0
+func GetUpstreamCost(relayInfo *relaycommon.RelayInfo, quota int, groupRatio float64) (int, bool) {
+	if relayInfo.ResponseCacheHit {
+		return 0, true
+	}
+	setting := relayInfo.ChannelSetting
+	// 优先使用上游实际模型的成本设置
+	modelNames := []string{relayInfo.UpstreamModelName, relayInfo.OriginModelName}
+	for _, modelName := range modelNames {
+		if price, ok := setting.ModelCostPrice[modelName]; ok {
+			return int(price * common.QuotaPerUnit), true
+		}
+	}
+	costRatio := setting.CostRatio
+	found := costRatio > 0
+	for _, modelName := range modelNames {
+		if ratio, ok := setting.ModelCostRatio[modelName]; ok {
+			costRatio = ratio
+			found = true
+			break
+		}
+	}
+	if !found || groupRatio <= 0 {
+		return 0, false
+	}
+	return int(float64(quota) / groupRatio * costRatio), true
+}
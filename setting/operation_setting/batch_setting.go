@@ -0,0 +1,36 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type BatchSetting struct {
+	Enabled bool `json:"enabled"`
+	// 批处理请求的计费倍率，在正常计费的基础上相乘
+	DiscountRatio float64 `json:"discount_ratio"`
+	// 单个批处理同时执行的请求数
+	Concurrency int `json:"concurrency"`
+	// 后台任务检查待处理批处理的间隔（秒）
+	PollIntervalSeconds int `json:"poll_interval_seconds"`
+	// 上传文件的大小上限（字节），文件内容保存在数据库中，不宜设置过大
+	MaxFileBytes int `json:"max_file_bytes"`
+	// 单个批处理的请求数上限
+	MaxRequestsPerBatch int `json:"max_requests_per_batch"`
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:             false,
+	DiscountRatio:       0.5,
+	Concurrency:         4,
+	PollIntervalSeconds: 10,
+	MaxFileBytes:        8 << 20,
+	MaxRequestsPerBatch: 50000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch", &batchSetting)
+}
+
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
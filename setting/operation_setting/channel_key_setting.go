@@ -0,0 +1,23 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ChannelKeySetting 多 Key 渠道的 Key 管理配置
+type ChannelKeySetting struct {
+	// 重新测试被自动禁用的 Key 的间隔（分钟），测试通过的 Key 会被重新启用，为 0 时不自动测试
+	RetestIntervalMinutes int `json:"retest_interval_minutes"`
+}
+
+// 默认配置
+var channelKeySetting = ChannelKeySetting{
+	RetestIntervalMinutes: 10,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_key", &channelKeySetting)
+}
+
+func GetChannelKeySetting() *ChannelKeySetting {
+	return &channelKeySetting
+}
@@ -0,0 +1,54 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ChannelQueueSetting 渠道 RPM 达到上限时的排队配置
+type ChannelQueueSetting struct {
+	// 关闭时渠道 RPM 达到上限立即返回 429
+	Enabled bool `json:"enabled"`
+	// 默认最长排队时间（秒），为 0 时不排队
+	MaxWaitSeconds int `json:"max_wait_seconds"`
+	// 分组 -> 最长排队时间（秒），优先于默认值
+	GroupMaxWaitSeconds map[string]int `json:"group_max_wait_seconds"`
+	// 单个渠道的最大排队请求数
+	MaxQueueSize int `json:"max_queue_size"`
+	// 分组 -> 该分组在单个渠道上的最大排队请求数
+	GroupMaxQueueSize map[string]int `json:"group_max_queue_size"`
+	// 排队期间是否尝试转到同分组下有余量的其他渠道
+	SpillOverEnabled bool `json:"spill_over_enabled"`
+	// 检查渠道是否有余量的间隔（毫秒）
+	PollIntervalMilliseconds int `json:"poll_interval_milliseconds"`
+}
+
+// 默认配置
+var channelQueueSetting = ChannelQueueSetting{
+	Enabled:                  false,
+	MaxWaitSeconds:           10,
+	GroupMaxWaitSeconds:      map[string]int{},
+	MaxQueueSize:             100,
+	GroupMaxQueueSize:        map[string]int{},
+	SpillOverEnabled:         true,
+	PollIntervalMilliseconds: 200,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_queue", &channelQueueSetting)
+}
+
+func GetChannelQueueSetting() *ChannelQueueSetting {
+	return &channelQueueSetting
+}
+
+// GetMaxWaitSeconds 获取分组的最长排队时间
+func (s *ChannelQueueSetting) GetMaxWaitSeconds(group string) int {
+	if seconds, ok := s.GroupMaxWaitSeconds[group]; ok {
+		return seconds
+	}
+	return s.MaxWaitSeconds
+}
+
+// GetGroupMaxQueueSize 获取分组在单个渠道上的最大排队请求数，0 表示只受渠道上限约束
+func (s *ChannelQueueSetting) GetGroupMaxQueueSize(group string) int {
+	return s.GroupMaxQueueSize[group]
+}
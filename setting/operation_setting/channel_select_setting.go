@@ -0,0 +1,72 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// 渠道选择策略
+const (
+	ChannelSelectStrategyWeight           = "weight"            // 静态权重（默认）
+	ChannelSelectStrategyEWMALatency      = "ewma_latency"      // 按首字延迟 EWMA 及错误率动态加权
+	ChannelSelectStrategyLeastOutstanding = "least_outstanding" // 选择进行中请求最少的渠道
+)
+
+type ChannelSelectSetting struct {
+	// 默认策略，未匹配分组或标签时使用
+	DefaultStrategy string `json:"default_strategy"`
+	// 分组 -> 策略，优先级高于标签
+	GroupStrategy map[string]string `json:"group_strategy"`
+	// 渠道标签 -> 策略
+	TagStrategy map[string]string `json:"tag_strategy"`
+	// EWMA 平滑系数，取值 (0, 1]，越大越偏向最近的请求
+	EWMAAlpha float64 `json:"ewma_alpha"`
+	// 错误率惩罚系数，代价 = 延迟 * (1 + 错误率 * 系数)
+	ErrorPenalty float64 `json:"error_penalty"`
+	// 429 比例惩罚系数
+	RateLimitPenalty float64 `json:"rate_limit_penalty"`
+}
+
+// 默认配置
+var channelSelectSetting = ChannelSelectSetting{
+	DefaultStrategy:  ChannelSelectStrategyWeight,
+	GroupStrategy:    map[string]string{},
+	TagStrategy:      map[string]string{},
+	EWMAAlpha:        0.3,
+	ErrorPenalty:     5,
+	RateLimitPenalty: 3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_select", &channelSelectSetting)
+}
+
+func GetChannelSelectSetting() *ChannelSelectSetting {
+	return &channelSelectSetting
+}
+
+// IsValidChannelSelectStrategy 检查策略名称是否合法
+func IsValidChannelSelectStrategy(strategy string) bool {
+	switch strategy {
+	case ChannelSelectStrategyWeight, ChannelSelectStrategyEWMALatency, ChannelSelectStrategyLeastOutstanding:
+		return true
+	}
+	return false
+}
+
+// GetStrategy 按分组、标签的顺序解析选择策略
+func (s *ChannelSelectSetting) GetStrategy(group string, tags []string) string {
+	if strategy, ok := s.GroupStrategy[group]; ok && IsValidChannelSelectStrategy(strategy) {
+		return strategy
+	}
+	for _, tag := range tags {
+		if tag == "" {
+			continue
+		}
+		if strategy, ok := s.TagStrategy[tag]; ok && IsValidChannelSelectStrategy(strategy) {
+			return strategy
+		}
+	}
+	if IsValidChannelSelectStrategy(s.DefaultStrategy) {
+		return s.DefaultStrategy
+	}
+	return ChannelSelectStrategyWeight
+}
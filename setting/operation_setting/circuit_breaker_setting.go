@@ -0,0 +1,45 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type CircuitBreakerSetting struct {
+	Enabled bool `json:"enabled"`
+	// 连续失败次数达到该值时熔断，0 表示不按连续失败熔断
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// 统计窗口内错误率达到该值时熔断，0 表示不按错误率熔断
+	ErrorRatioThreshold float64 `json:"error_ratio_threshold"`
+	// 按错误率熔断所需的最少请求数
+	MinRequests int `json:"min_requests"`
+	// 错误率统计窗口（秒）
+	WindowSeconds int `json:"window_seconds"`
+	// 熔断后保持打开的时长（秒），到期后进入半开状态
+	OpenSeconds int `json:"open_seconds"`
+	// 半开状态下同时放行的真实请求数
+	HalfOpenMaxProbes int `json:"half_open_max_probes"`
+	// 半开状态下连续成功多少次后关闭熔断
+	HalfOpenSuccesses int `json:"half_open_successes"`
+	// 半开状态下是否使用渠道测试请求主动探测
+	SyntheticProbeEnabled bool `json:"synthetic_probe_enabled"`
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:               false,
+	ConsecutiveFailures:   5,
+	ErrorRatioThreshold:   0.5,
+	MinRequests:           20,
+	WindowSeconds:         60,
+	OpenSeconds:           30,
+	HalfOpenMaxProbes:     1,
+	HalfOpenSuccesses:     3,
+	SyntheticProbeEnabled: false,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
@@ -0,0 +1,43 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type HedgeSetting struct {
+	Enabled bool `json:"enabled"`
+	// 首个渠道在该时长（毫秒）内未输出首个 token 时，向第二个渠道发起对冲请求
+	DelayMs int `json:"delay_ms"`
+	// 启用对冲请求的分组，令牌也可以单独开启
+	Groups []string `json:"groups"`
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled: false,
+	DelayMs: 2000,
+	Groups:  []string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge", &hedgeSetting)
+}
+
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+// IsHedgeEnabledFor 判断指定分组或令牌是否启用对冲请求
+func (s *HedgeSetting) IsHedgeEnabledFor(group string, tokenHedgeEnabled bool) bool {
+	if !s.Enabled || s.DelayMs <= 0 {
+		return false
+	}
+	if tokenHedgeEnabled {
+		return true
+	}
+	for _, g := range s.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ModelFallbackGroupAll 对所有分组生效的降级链
+const ModelFallbackGroupAll = "*"
+
+type ModelFallbackSetting struct {
+	// 分组 -> 模型 -> 备选模型列表。请求模型的渠道都失败后按顺序改用备选模型，分组 "*" 对所有分组生效
+	GroupChains map[string]map[string][]string `json:"group_chains"`
+}
+
+// 默认配置
+var modelFallbackSetting = ModelFallbackSetting{
+	GroupChains: map[string]map[string][]string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback", &modelFallbackSetting)
+}
+
+func GetModelFallbackSetting() *ModelFallbackSetting {
+	return &modelFallbackSetting
+}
+
+// GetChain 返回分组下模型的备选模型列表，分组未设置时使用 "*" 的设置
+func (s *ModelFallbackSetting) GetChain(group string, modelName string) []string {
+	if chains, ok := s.GroupChains[group]; ok {
+		if chain, ok := chains[modelName]; ok {
+			return chain
+		}
+	}
+	return s.GroupChains[ModelFallbackGroupAll][modelName]
+}
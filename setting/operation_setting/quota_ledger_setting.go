@@ -0,0 +1,23 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// QuotaLedgerSetting 额度账本对账配置
+type QuotaLedgerSetting struct {
+	// 自动对账间隔（分钟），为 0 时不自动对账
+	ReconcileIntervalMinutes int `json:"reconcile_interval_minutes"`
+}
+
+// 默认配置
+var quotaLedgerSetting = QuotaLedgerSetting{
+	ReconcileIntervalMinutes: 60,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("quota_ledger", &quotaLedgerSetting)
+}
+
+func GetQuotaLedgerSetting() *QuotaLedgerSetting {
+	return &quotaLedgerSetting
+}
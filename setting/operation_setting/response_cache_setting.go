@@ -0,0 +1,36 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type ResponseCacheSetting struct {
+	Enabled bool `json:"enabled"`
+	// 缓存有效期（秒）
+	TTLSeconds int `json:"ttl_seconds"`
+	// 未启用 Redis 时内存缓存的最大条目数
+	MaxEntries int `json:"max_entries"`
+	// 超过该大小（字节）的响应不缓存
+	MaxBodyBytes int `json:"max_body_bytes"`
+	// 仅缓存 temperature 为 0 的对话请求，避免固定住随机采样的结果
+	ZeroTemperatureOnly bool `json:"zero_temperature_only"`
+	// 命中缓存时的默认计费倍率，可在 CacheHitRatio 中按模型单独设置
+	DefaultHitRatio float64 `json:"default_hit_ratio"`
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:             false,
+	TTLSeconds:          3600,
+	MaxEntries:          10000,
+	MaxBodyBytes:        1 << 20,
+	ZeroTemperatureOnly: true,
+	DefaultHitRatio:     0.1,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
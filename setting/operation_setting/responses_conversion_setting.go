@@ -0,0 +1,26 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ResponsesConversionSetting 不支持 Responses API 的渠道经由 Chat Completions 转换时的配置
+type ResponsesConversionSetting struct {
+	// 对话记录保存时间（秒），用于支持 previous_response_id，为 0 时不保存
+	StoreTTLSeconds int `json:"store_ttl_seconds"`
+	// 未启用 Redis 时内存中保存的最大对话数
+	MaxStoreEntries int `json:"max_store_entries"`
+}
+
+// 默认配置
+var responsesConversionSetting = ResponsesConversionSetting{
+	StoreTTLSeconds: 86400,
+	MaxStoreEntries: 10000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("responses_conversion", &responsesConversionSetting)
+}
+
+func GetResponsesConversionSetting() *ResponsesConversionSetting {
+	return &responsesConversionSetting
+}
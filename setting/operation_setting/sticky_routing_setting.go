@@ -0,0 +1,36 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type StickyRoutingSetting struct {
+	Enabled bool `json:"enabled"`
+	// 绑定有效期（秒），每次命中后重新计时
+	TTLSeconds int `json:"ttl_seconds"`
+	// 参与计算前缀哈希的消息数（不含开头的 system/developer 消息）
+	PrefixMessages int `json:"prefix_messages"`
+	// 会话请求头，请求携带该头时按会话绑定，不再计算前缀
+	SessionHeader string `json:"session_header"`
+	// 相同前缀的请求跨用户共享绑定，公共系统提示词可以命中同一个上游缓存
+	ShareAcrossUsers bool `json:"share_across_users"`
+	// 未启用 Redis 时内存中保存的最大绑定数
+	MaxEntries int `json:"max_entries"`
+}
+
+// 默认配置
+var stickyRoutingSetting = StickyRoutingSetting{
+	Enabled:          false,
+	TTLSeconds:       300,
+	PrefixMessages:   1,
+	SessionHeader:    "X-Session-Id",
+	ShareAcrossUsers: false,
+	MaxEntries:       100000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("sticky_routing", &stickyRoutingSetting)
+}
+
+func GetStickyRoutingSetting() *StickyRoutingSetting {
+	return &stickyRoutingSetting
+}
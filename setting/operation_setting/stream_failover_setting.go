@@ -0,0 +1,28 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type StreamFailoverSetting struct {
+	// 启用后，流式响应在首个有效数据块到达前缓存上游输出，超时或收到错误事件时切换渠道重试
+	Enabled bool `json:"enabled"`
+	// 首个有效数据块的等待时间（秒）
+	FirstTokenTimeoutSeconds int `json:"first_token_timeout_seconds"`
+	// 首个有效数据块到达前最多缓存的数据块数量，超过后直接开始输出
+	MaxBufferedChunks int `json:"max_buffered_chunks"`
+}
+
+// 默认配置
+var streamFailoverSetting = StreamFailoverSetting{
+	Enabled:                  false,
+	FirstTokenTimeoutSeconds: 30,
+	MaxBufferedChunks:        64,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("stream_failover", &streamFailoverSetting)
+}
+
+func GetStreamFailoverSetting() *StreamFailoverSetting {
+	return &streamFailoverSetting
+}
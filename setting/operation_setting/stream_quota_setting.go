@@ -0,0 +1,25 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+type StreamQuotaSetting struct {
+	// 启用后，流式响应在输出过程中按已输出的补全 token 数分段扣费，余额或令牌预算不足时结束流
+	Enabled bool `json:"enabled"`
+	// 每输出多少补全 token 结算一次
+	SettleIntervalTokens int `json:"settle_interval_tokens"`
+}
+
+// 默认配置
+var streamQuotaSetting = StreamQuotaSetting{
+	Enabled:              false,
+	SettleIntervalTokens: 1000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("stream_quota", &streamQuotaSetting)
+}
+
+func GetStreamQuotaSetting() *StreamQuotaSetting {
+	return &streamQuotaSetting
+}
@@ -0,0 +1,46 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// TrafficMirrorTarget 影子渠道。影子渠道需处于启用状态，可设置为没有用户使用的分组，避免承接正式流量
+type TrafficMirrorTarget struct {
+	ChannelId int `json:"channel_id"`
+	// 镜像的请求比例，取值 0-100
+	Percent float64 `json:"percent"`
+	// 只镜像这些模型的请求，为空时镜像影子渠道支持的所有模型
+	Models []string `json:"models"`
+	// 每分钟最多镜像的请求数，0 表示不限制
+	MaxRPM int `json:"max_rpm"`
+}
+
+type TrafficMirrorSetting struct {
+	Enabled bool                  `json:"enabled"`
+	Targets []TrafficMirrorTarget `json:"targets"`
+	// 同时进行的影子请求上限，0 表示不限制
+	MaxConcurrent int `json:"max_concurrent"`
+	// 影子请求超时时间（秒）
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// 记录主请求和影子请求的输出并计算相似度
+	RecordOutput bool `json:"record_output"`
+	// 每个输出最多记录的字符数
+	MaxOutputChars int `json:"max_output_chars"`
+}
+
+// 默认配置
+var trafficMirrorSetting = TrafficMirrorSetting{
+	Enabled:        false,
+	Targets:        []TrafficMirrorTarget{},
+	MaxConcurrent:  10,
+	TimeoutSeconds: 120,
+	RecordOutput:   false,
+	MaxOutputChars: 2000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("traffic_mirror", &trafficMirrorSetting)
+}
+
+func GetTrafficMirrorSetting() *TrafficMirrorSetting {
+	return &trafficMirrorSetting
+}
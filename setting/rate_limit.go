@@ -62,3 +62,69 @@ func CheckModelRequestRateLimitGroup(jsonStr string) error {
 
 	return nil
 }
+
+// TPM 与并发请求数限制，数值为 0 表示不限制
+var TPMLimitEnabled = false
+var TokenTPMLimit = 0
+var UserTPMLimit = 0
+var TokenConcurrencyLimit = 0
+var UserConcurrencyLimit = 0
+var TPMLimitGroup = map[string]TPMLimit{}
+var TPMLimitMutex sync.RWMutex
+
+type TPMLimit struct {
+	TokenTPM         int `json:"token_tpm"`
+	UserTPM          int `json:"user_tpm"`
+	TokenConcurrency int `json:"token_concurrency"`
+	UserConcurrency  int `json:"user_concurrency"`
+}
+
+func TPMLimitGroup2JSONString() string {
+	TPMLimitMutex.RLock()
+	defer TPMLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(TPMLimitGroup)
+	if err != nil {
+		common.SysError("error marshalling tpm limit group: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateTPMLimitGroupByJSONString(jsonStr string) error {
+	TPMLimitMutex.Lock()
+	defer TPMLimitMutex.Unlock()
+
+	TPMLimitGroup = make(map[string]TPMLimit)
+	return json.Unmarshal([]byte(jsonStr), &TPMLimitGroup)
+}
+
+// GetTPMLimit 获取分组的 TPM 与并发限制，分组未单独配置时使用全局配置
+func GetTPMLimit(group string) TPMLimit {
+	TPMLimitMutex.RLock()
+	defer TPMLimitMutex.RUnlock()
+
+	if limit, found := TPMLimitGroup[group]; found {
+		return limit
+	}
+	return TPMLimit{
+		TokenTPM:         TokenTPMLimit,
+		UserTPM:          UserTPMLimit,
+		TokenConcurrency: TokenConcurrencyLimit,
+		UserConcurrency:  UserConcurrencyLimit,
+	}
+}
+
+func CheckTPMLimitGroup(jsonStr string) error {
+	checkTPMLimitGroup := make(map[string]TPMLimit)
+	err := json.Unmarshal([]byte(jsonStr), &checkTPMLimitGroup)
+	if err != nil {
+		return err
+	}
+	for group, limit := range checkTPMLimitGroup {
+		if limit.TokenTPM < 0 || limit.UserTPM < 0 || limit.TokenConcurrency < 0 || limit.UserConcurrency < 0 {
+			return fmt.Errorf("group %s has negative tpm limit values", group)
+		}
+	}
+
+	return nil
+}
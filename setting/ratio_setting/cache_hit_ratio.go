@@ -0,0 +1,41 @@
+package ratio_setting
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"sync"
+)
+
+// 命中响应缓存时的计费倍率，未配置的模型使用 response_cache.default_hit_ratio
+var cacheHitRatioMap = map[string]float64{}
+var cacheHitRatioMapMutex sync.RWMutex
+
+// CacheHitRatio2JSONString converts the cache hit ratio map to a JSON string
+func CacheHitRatio2JSONString() string {
+	cacheHitRatioMapMutex.RLock()
+	defer cacheHitRatioMapMutex.RUnlock()
+	jsonBytes, err := json.Marshal(cacheHitRatioMap)
+	if err != nil {
+		common.SysError("error marshalling cache hit ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// UpdateCacheHitRatioByJSONString updates the cache hit ratio map from a JSON string
+func UpdateCacheHitRatioByJSONString(jsonStr string) error {
+	cacheHitRatioMapMutex.Lock()
+	defer cacheHitRatioMapMutex.Unlock()
+	cacheHitRatioMap = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &cacheHitRatioMap)
+}
+
+// GetCacheHitRatio returns the cache hit ratio for a model
+func GetCacheHitRatio(name string) (float64, bool) {
+	cacheHitRatioMapMutex.RLock()
+	defer cacheHitRatioMapMutex.RUnlock()
+	ratio, ok := cacheHitRatioMap[name]
+	if !ok {
+		return 1, false
+	}
+	return ratio, true
+}
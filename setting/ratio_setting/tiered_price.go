@@ -0,0 +1,149 @@
+package ratio_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"strings"
+	"sync"
+)
+
+// 输入模态
+const (
+	ModalityText  = "text"
+	ModalityImage = "image"
+	ModalityAudio = "audio"
+)
+
+// TieredPriceRule 分档计费规则。同一模型的规则按顺序匹配，使用第一条满足全部条件的规则，
+// 未设置的条件不参与匹配；命中后用规则中设置的倍率覆盖模型的默认倍率
+type TieredPriceRule struct {
+	Name            string   `json:"name,omitempty"`
+	MinPromptTokens int      `json:"min_prompt_tokens,omitempty"` // 输入 token 数大于该值时匹配
+	ServiceTier     string   `json:"service_tier,omitempty"`      // 请求的服务等级，如 flex、priority
+	Modality        string   `json:"modality,omitempty"`          // 输入模态：text、image、audio
+	ModelRatio      *float64 `json:"model_ratio,omitempty"`
+	CompletionRatio *float64 `json:"completion_ratio,omitempty"`
+	CacheRatio      *float64 `json:"cache_ratio,omitempty"`
+}
+
+// Describe 返回规则条件的描述，用于消费日志
+func (r *TieredPriceRule) Describe() string {
+	conditions := make([]string, 0, 3)
+	if r.MinPromptTokens > 0 {
+		conditions = append(conditions, fmt.Sprintf("输入 > %d tokens", r.MinPromptTokens))
+	}
+	if r.ServiceTier != "" {
+		conditions = append(conditions, "服务等级 "+r.ServiceTier)
+	}
+	if r.Modality != "" {
+		conditions = append(conditions, "输入模态 "+r.Modality)
+	}
+	description := strings.Join(conditions, "，")
+	if r.Name != "" {
+		description = r.Name + "（" + description + "）"
+	}
+	return description
+}
+
+func (r *TieredPriceRule) match(promptTokens int, serviceTier string, modality string) bool {
+	if r.MinPromptTokens > 0 && promptTokens <= r.MinPromptTokens {
+		return false
+	}
+	if r.ServiceTier != "" && r.ServiceTier != serviceTier {
+		return false
+	}
+	if r.Modality != "" && r.Modality != modality {
+		return false
+	}
+	return true
+}
+
+var tieredPriceMap = map[string][]TieredPriceRule{}
+var tieredPriceMapMutex sync.RWMutex
+
+// TieredPrice2JSONString converts the tiered price map to a JSON string
+func TieredPrice2JSONString() string {
+	tieredPriceMapMutex.RLock()
+	defer tieredPriceMapMutex.RUnlock()
+	jsonBytes, err := json.Marshal(tieredPriceMap)
+	if err != nil {
+		common.SysError("error marshalling tiered price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// UpdateTieredPriceByJSONString updates the tiered price map from a JSON string
+func UpdateTieredPriceByJSONString(jsonStr string) error {
+	tieredPriceMapMutex.Lock()
+	defer tieredPriceMapMutex.Unlock()
+	tieredPriceMap = make(map[string][]TieredPriceRule)
+	return json.Unmarshal([]byte(jsonStr), &tieredPriceMap)
+}
+
+func CheckTieredPrice(jsonStr string) error {
+	checkTieredPrice := make(map[string][]TieredPriceRule)
+	err := json.Unmarshal([]byte(jsonStr), &checkTieredPrice)
+	if err != nil {
+		return err
+	}
+	for name, rules := range checkTieredPrice {
+		for i, rule := range rules {
+			if rule.MinPromptTokens == 0 && rule.ServiceTier == "" && rule.Modality == "" {
+				return fmt.Errorf("模型 %s 的第 %d 条分档规则没有设置任何条件", name, i+1)
+			}
+			if rule.MinPromptTokens < 0 {
+				return fmt.Errorf("模型 %s 的第 %d 条分档规则输入 token 数不能为负数", name, i+1)
+			}
+			switch rule.Modality {
+			case "", ModalityText, ModalityImage, ModalityAudio:
+			default:
+				return fmt.Errorf("模型 %s 的第 %d 条分档规则输入模态无效：%s", name, i+1, rule.Modality)
+			}
+			for _, ratio := range []*float64{rule.ModelRatio, rule.CompletionRatio, rule.CacheRatio} {
+				if ratio != nil && *ratio < 0 {
+					return fmt.Errorf("模型 %s 的第 %d 条分档规则倍率不能为负数", name, i+1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetTieredPriceRules returns a copy of the tiered price rules of a model
+func GetTieredPriceRules(name string) []TieredPriceRule {
+	tieredPriceMapMutex.RLock()
+	defer tieredPriceMapMutex.RUnlock()
+	rules, ok := tieredPriceMap[name]
+	if !ok {
+		return nil
+	}
+	copyRules := make([]TieredPriceRule, len(rules))
+	copy(copyRules, rules)
+	return copyRules
+}
+
+// MatchTieredPriceRule 返回第一条匹配的分档规则，modality 为空时设置了模态条件的规则不匹配
+func MatchTieredPriceRule(name string, promptTokens int, serviceTier string, modality string) (*TieredPriceRule, bool) {
+	tieredPriceMapMutex.RLock()
+	defer tieredPriceMapMutex.RUnlock()
+	for _, rule := range tieredPriceMap[name] {
+		if rule.match(promptTokens, serviceTier, modality) {
+			matched := rule
+			return &matched, true
+		}
+	}
+	return nil, false
+}
+
+// HasServiceTierPriceRule 模型是否配置了指定服务等级的分档计费规则
+func HasServiceTierPriceRule(name string, serviceTier string) bool {
+	tieredPriceMapMutex.RLock()
+	defer tieredPriceMapMutex.RUnlock()
+	for _, rule := range tieredPriceMap[name] {
+		if rule.ServiceTier == serviceTier {
+			return true
+		}
+	}
+	return false
+}
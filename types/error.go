@@ -58,10 +58,12 @@ const (
 	ErrorCodeAccessDenied          ErrorCode = "access_denied"
 
 	// response error
-	ErrorCodeReadResponseBodyFailed ErrorCode = "read_response_body_failed"
-	ErrorCodeBadResponseStatusCode  ErrorCode = "bad_response_status_code"
-	ErrorCodeBadResponse            ErrorCode = "bad_response"
-	ErrorCodeBadResponseBody        ErrorCode = "bad_response_body"
+	ErrorCodeReadResponseBodyFailed  ErrorCode = "read_response_body_failed"
+	ErrorCodeBadResponseStatusCode   ErrorCode = "bad_response_status_code"
+	ErrorCodeBadResponse             ErrorCode = "bad_response"
+	ErrorCodeBadResponseBody         ErrorCode = "bad_response_body"
+	ErrorCodeStreamFirstTokenTimeout ErrorCode = "stream_first_token_timeout"
+	ErrorCodeStreamErrorEvent        ErrorCode = "stream_error_event"
 
 	// sql error
 	ErrorCodeQueryDataError  ErrorCode = "query_data_error"
@@ -70,6 +72,11 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+	ErrorCodeTokenBudgetExhausted       ErrorCode = "token_budget_exhausted"
+
+	// rate limit error
+	ErrorCodeRateLimitExceeded    ErrorCode = "rate_limit_exceeded"
+	ErrorCodeRateLimitCheckFailed ErrorCode = "rate_limit_check_failed"
 )
 
 type NewAPIError struct {